@@ -4,11 +4,18 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/Pigmice2733/peregrine-backend/internal/config"
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/notify"
+	"github.com/Pigmice2733/peregrine-backend/internal/oidc"
 	"github.com/Pigmice2733/peregrine-backend/internal/refresh"
 	"github.com/Pigmice2733/peregrine-backend/internal/server"
 	"github.com/Pigmice2733/peregrine-backend/internal/store"
@@ -19,12 +26,14 @@ import (
 func main() {
 	flag.Usage = func() {
 		fmt.Printf("Usage: %s [config path]\n", os.Args[0])
+		fmt.Printf("       %s simulate [flags] [config path] [source event key]\n", os.Args[0])
+		fmt.Printf("       %s refresh [config path] [event key]\n", os.Args[0])
 	}
 
 	flag.Parse()
 
 	args := flag.Args()
-	if len(args) != 1 {
+	if len(args) < 1 {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -39,29 +48,174 @@ func main() {
 		}
 	}()
 
-	if err := run(ctx, args[0]); err != nil {
-		fmt.Printf("got error: %v\n", err)
+	var err error
+	switch {
+	case args[0] == "simulate":
+		err = runSimulate(ctx, args[1:])
+	case args[0] == "refresh":
+		err = runRefresh(ctx, args[1:])
+	case len(args) == 1:
+		err = run(ctx, args[0])
+	default:
+		flag.Usage()
 		os.Exit(1)
 	}
-}
 
-func run(ctx context.Context, configPath string) error {
-	c, err := config.Open(configPath)
 	if err != nil {
-		return fmt.Errorf("unable to open config: %w", err)
-	}
-
-	tba := &tba.Service{
-		URL:    c.TBA.URL,
-		APIKey: c.TBA.APIKey,
+		fmt.Printf("got error: %v\n", err)
+		os.Exit(1)
 	}
+}
 
+func newLogger(c config.Config) *logrus.Logger {
 	logger := logrus.New()
 	logger.SetLevel(c.Server.LogLevel)
 	if c.Server.LogJSON {
 		logger.Formatter = &logrus.JSONFormatter{}
 	}
 
+	return logger
+}
+
+func newTBA(c config.Config) *tba.Service {
+	var transport http.RoundTripper
+	switch c.TBA.Mode {
+	case config.TBAModeRecord:
+		transport = &tba.RecordingTransport{Dir: c.TBA.RecordDir}
+	case config.TBAModeReplay:
+		transport = &tba.ReplayTransport{Dir: c.TBA.RecordDir}
+	}
+
+	return &tba.Service{
+		URL:               c.TBA.URL,
+		APIKey:            c.TBA.APIKey,
+		RequestsPerSecond: c.TBA.RequestsPerSecond,
+		Burst:             c.TBA.Burst,
+		MaxRetries:        c.TBA.MaxRetries,
+		BreakerThreshold:  c.TBA.BreakerThreshold,
+		BreakerCooldown:   c.TBA.BreakerCooldown.Duration,
+		Transport:         transport,
+	}
+}
+
+func newKeySet(c config.Config) (*ihttp.KeySet, error) {
+	var keys []ihttp.Key
+	for _, k := range c.Server.JWTKeys {
+		key, err := ihttp.LoadKey(k.ID, k.PrivateKeyFile, k.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return ihttp.NewKeySet(c.Server.JWTSecret, keys, c.Server.JWTSigningKey)
+}
+
+func newNotifier(c config.Config, logger *logrus.Logger) notify.Notifier {
+	switch c.Notify.Mode {
+	case config.NotifyModeFile:
+		return &notify.File{Path: c.Notify.File}
+	case config.NotifyModeSMTP:
+		return notify.SMTP{
+			Addr:     net.JoinHostPort(c.Notify.SMTP.Host, strconv.Itoa(c.Notify.SMTP.Port)),
+			Username: c.Notify.SMTP.Username,
+			Password: c.Notify.SMTP.Password,
+			From:     c.Notify.SMTP.From,
+		}
+	default:
+		return notify.Log{Logger: logger}
+	}
+}
+
+func newOIDCProviders(c config.Config) map[string]server.OIDCProvider {
+	providers := make(map[string]server.OIDCProvider)
+	for _, p := range c.OIDC {
+		providers[p.ID] = server.OIDCProvider{
+			Provider: &oidc.Provider{
+				Issuer:       p.Issuer,
+				ClientID:     p.ClientID,
+				ClientSecret: p.ClientSecret,
+				Scopes:       p.Scopes,
+				Client:       &http.Client{Timeout: time.Second * 10},
+			},
+			Name:         p.Name,
+			RedirectURLs: p.RedirectURLs,
+			Domains:      p.Domains,
+		}
+	}
+
+	return providers
+}
+
+// newRealmQuotas converts the configured default quotas, where zero means
+// unlimited, to store quotas.
+func newRealmQuotas(c config.Config) store.RealmQuotas {
+	limit := func(n int) *int {
+		if n == 0 {
+			return nil
+		}
+		return &n
+	}
+
+	return store.RealmQuotas{
+		Users:         limit(c.Realms.Quotas.Users),
+		CustomEvents:  limit(c.Realms.Quotas.CustomEvents),
+		ReportsPerDay: limit(c.Realms.Quotas.ReportsPerDay),
+	}
+}
+
+func newRefresher(c config.Config, tba *tba.Service, sto *store.Service, logger *logrus.Logger) *refresh.Service {
+	return &refresh.Service{
+		TBA:    tba,
+		Store:  sto,
+		Logger: logger,
+		Year:   c.Year,
+
+		EventsInterval: c.Refresh.EventsInterval.Duration,
+		ActiveInterval: c.Refresh.ActiveInterval.Duration,
+		TeamsInterval:  c.Refresh.TeamsInterval.Duration,
+		Events:         c.Refresh.Events,
+		Districts:      c.Refresh.Districts,
+	}
+}
+
+// purgeDeleted permanently removes reports, users, and custom events once
+// they've been deleted for longer than the configured retention period. It
+// purges once at startup and then every purge interval until ctx is canceled.
+func purgeDeleted(ctx context.Context, c config.Config, sto *store.Service, logger *logrus.Logger) {
+	ticker := time.NewTicker(c.Deletion.PurgeInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		counts, err := sto.PurgeDeleted(ctx, time.Now().Add(-c.Deletion.Retention.Duration))
+		if err != nil {
+			logger.WithError(err).Error("purging deleted data")
+		} else if counts != (store.PurgeCounts{}) {
+			logger.WithFields(logrus.Fields{
+				"reports": counts.Reports,
+				"events":  counts.Events,
+				"users":   counts.Users,
+			}).Info("purged deleted data")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func run(ctx context.Context, configPath string) error {
+	c, err := config.Open(configPath)
+	if err != nil {
+		return fmt.Errorf("unable to open config: %w", err)
+	}
+
+	tba := newTBA(c)
+	logger := newLogger(c)
+
 	logger.Info("connecting to postgres")
 	sto, err := store.New(ctx, c.DSN, logger)
 	if err != nil {
@@ -70,19 +224,28 @@ func run(ctx context.Context, configPath string) error {
 	defer sto.Close()
 	logger.Info("connected to postgres")
 
+	sto.DefaultQuotas = newRealmQuotas(c)
+
 	// The cool, refreshing taste of Pepsi.
-	refresher := &refresh.Service{
-		TBA:    tba,
-		Store:  sto,
-		Logger: logger,
-		Year:   c.Year,
+	refresher := newRefresher(c, tba, sto, logger)
+
+	keys, err := newKeySet(c)
+	if err != nil {
+		return fmt.Errorf("unable to load JWT keys: %w", err)
 	}
 
 	s := &server.Server{
-		TBA:    tba,
-		Store:  sto,
-		Logger: logger,
-		Server: c.Server,
+		TBA:       tba,
+		Store:     sto,
+		Refresher: refresher,
+		Keys:      keys,
+		Notifier:  newNotifier(c, logger),
+		OIDC:      newOIDCProviders(c),
+		Logger:    logger,
+		Server:    c.Server,
+
+		PasswordResetURL: c.Notify.PasswordResetURL,
+		Realms:           c.Realms,
 	}
 
 	updateCtx, updateCancel := context.WithCancel(ctx)
@@ -91,6 +254,7 @@ func run(ctx context.Context, configPath string) error {
 	}()
 
 	go refresher.Run(updateCtx)
+	go purgeDeleted(updateCtx, c, sto, logger)
 
 	if err := s.Run(ctx); err != nil {
 		err = fmt.Errorf("error running server: %w", err)
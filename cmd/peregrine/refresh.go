@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/config"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+)
+
+// runRefresh immediately refreshes a single event from TBA and prints a summary
+// of what changed.
+func runRefresh(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		fmt.Printf("Usage: %s refresh [config path] [event key]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	configPath, eventKey := args[0], args[1]
+
+	c, err := config.Open(configPath)
+	if err != nil {
+		return fmt.Errorf("unable to open config: %w", err)
+	}
+
+	logger := newLogger(c)
+
+	sto, err := store.New(ctx, c.DSN, logger)
+	if err != nil {
+		return fmt.Errorf("opening postgres server: %w", err)
+	}
+	defer sto.Close()
+
+	summary, err := newRefresher(c, newTBA(c), sto, logger).RefreshEvent(ctx, eventKey)
+	if err != nil {
+		return fmt.Errorf("unable to refresh event: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summary)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/config"
+	"github.com/Pigmice2733/peregrine-backend/internal/simulate"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+)
+
+// runSimulate replays a past event as if it were happening live. Run it alongside
+// the server, pointed at the same database.
+func runSimulate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	speed := fs.Float64("speed", 10, "how many times faster than real time to replay the event")
+	lead := fs.Duration("lead", time.Minute, "how long after revealing the schedule to play the first match")
+	target := fs.String("target", "", "key of the simulated event (default: source event key with a sim suffix)")
+	realm := fs.Int64("realm", 0, "realm to read the source event from and create the simulated event in (default: none)")
+	fs.Usage = func() {
+		fmt.Printf("Usage: %s simulate [flags] [config path] [source event key]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	configPath, sourceEventKey := fs.Arg(0), fs.Arg(1)
+
+	c, err := config.Open(configPath)
+	if err != nil {
+		return fmt.Errorf("unable to open config: %w", err)
+	}
+
+	logger := newLogger(c)
+
+	sto, err := store.New(ctx, c.DSN, logger)
+	if err != nil {
+		return fmt.Errorf("opening postgres server: %w", err)
+	}
+	defer sto.Close()
+
+	simulator := &simulate.Service{
+		Store:          sto,
+		Logger:         logger,
+		SourceEventKey: sourceEventKey,
+		TargetEventKey: *target,
+		Speed:          *speed,
+		Lead:           *lead,
+	}
+
+	if simulator.TargetEventKey == "" {
+		simulator.TargetEventKey = sourceEventKey + "sim"
+	}
+
+	if *realm != 0 {
+		simulator.RealmID = realm
+	}
+
+	return simulator.Run(ctx)
+}
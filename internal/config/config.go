@@ -4,32 +4,277 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/Pigmice2733/peregrine-backend/internal/refresh"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/go-playground/validator.v9"
 )
 
+// Duration is a time.Duration that is marshalled to and from JSON as a string
+// such as "15m" or "30s".
+type Duration struct {
+	time.Duration
+}
+
+// MarshalJSON returns the duration formatted as a JSON string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON parses a JSON string such as "15m" into the duration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = parsed
+	return nil
+}
+
 // Server holds information about the peregrine backend HTTP server.
 type Server struct {
-	Listen    string       `json:"listen" validate:"required"`
-	Origin    string       `json:"origin" validate:"required"`
-	LogLevel  logrus.Level `json:"logLevel"`
-	LogJSON   bool         `json:"logJSON"`
-	JWTSecret string       `json:"jwtSecret" validate:"required,min=32"`
+	Listen   string       `json:"listen" validate:"required"`
+	Origin   string       `json:"origin" validate:"required"`
+	LogLevel logrus.Level `json:"logLevel"`
+	LogJSON  bool         `json:"logJSON"`
+
+	// TrustProxy should be set when the server is behind a reverse proxy, so
+	// the client IP is read from the X-Forwarded-For header it sets.
+	TrustProxy bool `json:"trustProxy"`
+
+	// JWTSecret is used to sign tokens with HS256 if there are no JWTKeys with
+	// private keys. If it's set alongside JWTKeys, HS256 tokens are still
+	// accepted, which allows moving from a secret to keys without logging
+	// everyone out.
+	JWTSecret string `json:"jwtSecret" validate:"omitempty,min=32"`
+
+	// JWTKeys are RSA or Ed25519 keys used to sign and verify tokens. Tokens
+	// are signed with the key with ID JWTSigningKey (or the first key with a
+	// private key), and verified with whichever key their kid header names.
+	// Keep an old key around with just its public key while rotating it out.
+	JWTKeys       []JWTKey `json:"jwtKeys" validate:"dive"`
+	JWTSigningKey string   `json:"jwtSigningKey"`
+}
+
+// JWTKey holds the paths to PEM files for a token signing key.
+type JWTKey struct {
+	ID             string `json:"id" validate:"required"`
+	PrivateKeyFile string `json:"privateKeyFile" validate:"required_without=PublicKeyFile"`
+	PublicKeyFile  string `json:"publicKeyFile"`
+}
+
+// TBA holds information about how to connect to The Blue Alliance API and how
+// hard we're allowed to hit it.
+type TBA struct {
+	URL    string `json:"url" validate:"required"`
+	APIKey string `json:"apiKey" validate:"required"`
+
+	// RequestsPerSecond and Burst configure the token bucket used to limit
+	// requests to TBA. A requestsPerSecond of 0 disables limiting.
+	RequestsPerSecond float64 `json:"requestsPerSecond" validate:"gte=0"`
+	Burst             int     `json:"burst" validate:"gte=0"`
+
+	// MaxRetries is how many times a request that got a 429 or 5xx is retried
+	// (with exponential backoff) before giving up. 0 disables retries.
+	MaxRetries int `json:"maxRetries" validate:"gte=0"`
+
+	// BreakerThreshold is the number of consecutive failed requests after which
+	// requests to TBA are paused for BreakerCooldown. 0 disables the breaker.
+	BreakerThreshold int      `json:"breakerThreshold" validate:"gte=0"`
+	BreakerCooldown  Duration `json:"breakerCooldown"`
+
+	// Mode is one of "live" (the default), "record" to additionally write every
+	// TBA response to RecordDir, or "replay" to serve previously recorded
+	// responses from RecordDir instead of talking to TBA.
+	Mode      string `json:"mode" validate:"omitempty,oneof=live record replay"`
+	RecordDir string `json:"recordDir"`
+}
+
+// TBA client modes.
+const (
+	TBAModeLive   = "live"
+	TBAModeRecord = "record"
+	TBAModeReplay = "replay"
+)
+
+// Refresh holds information about how often data is pulled from TBA, and for
+// which events.
+type Refresh struct {
+	// EventsInterval is how often every event in scope is refreshed,
+	// ActiveInterval is how often events happening right now are refreshed, and
+	// TeamsInterval is how often the list of all teams is refreshed.
+	EventsInterval Duration `json:"eventsInterval"`
+	ActiveInterval Duration `json:"activeInterval"`
+	TeamsInterval  Duration `json:"teamsInterval"`
+
+	// Events and Districts restrict refreshing to events with the given keys
+	// or in the given districts (e.g. "pnw"). If both are empty every event in
+	// the year is refreshed.
+	Events    []string `json:"events"`
+	Districts []string `json:"districts"`
+}
+
+// Notify holds information about how messages such as password reset links
+// are delivered to users.
+type Notify struct {
+	// Mode is one of "log" (the default) to log messages instead of delivering
+	// them, "file" to append them to File, or "smtp" to email them.
+	Mode string `json:"mode" validate:"omitempty,oneof=log file smtp"`
+	File string `json:"file"`
+	SMTP SMTP   `json:"smtp"`
+
+	// PasswordResetURL is the address of the page users reset their password
+	// on. Reset tokens are added to it as the "token" query parameter.
+	PasswordResetURL string `json:"passwordResetURL"`
+}
+
+// SMTP holds information about how to connect to an SMTP server.
+type SMTP struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+// OIDCProvider holds information about an OpenID Connect provider, such as
+// Google, that users can log in with.
+type OIDCProvider struct {
+	// ID identifies the provider in URLs and linked identities, so it
+	// shouldn't change once users have logged in with it.
+	ID           string   `json:"id" validate:"required,alphanum"`
+	Name         string   `json:"name" validate:"required"`
+	Issuer       string   `json:"issuer" validate:"required,url"`
+	ClientID     string   `json:"clientId" validate:"required"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+
+	// RedirectURLs are the frontend pages the provider is allowed to send
+	// users back to after they log in.
+	RedirectURLs []string `json:"redirectURLs" validate:"required,min=1,dive,url"`
+
+	// Domains decide which realm people logging in for the first time are
+	// added to, based on their email's domain. People whose domain isn't
+	// listed can't sign up with the provider.
+	Domains []OIDCDomain `json:"domains" validate:"dive"`
 }
 
+// OIDCDomain adds people with emails at Domain to a realm when they first log
+// in with an OpenID Connect provider. They're verified right away if Verified
+// is set, and otherwise wait for approval.
+type OIDCDomain struct {
+	Domain   string `json:"domain" validate:"required"`
+	RealmID  int64  `json:"realmId" validate:"required"`
+	Verified bool   `json:"verified"`
+}
+
+// Realms holds the rules for who can create realms, and how much each realm
+// can store.
+type Realms struct {
+	// CreationPolicy is one of "superadmin" (the default) to only let
+	// super-admins create realms, "token" to also let anyone with a realm
+	// token from a super-admin create one, or "open" to let anyone create a
+	// realm after solving a proof-of-work challenge.
+	CreationPolicy string `json:"creationPolicy" validate:"omitempty,oneof=superadmin token open"`
+
+	// ProofOfWorkDifficulty is how many leading zero bits the hash of a
+	// solved challenge needs. Each extra bit doubles the work, and 0 turns
+	// proof-of-work off.
+	ProofOfWorkDifficulty int `json:"proofOfWorkDifficulty" validate:"gte=0,lte=32"`
+
+	// Quotas are the limits for realms that don't have their own.
+	Quotas RealmQuotas `json:"quotas"`
+}
+
+// RealmQuotas limits how much a realm can store. Zero means unlimited.
+type RealmQuotas struct {
+	// Users counts users whose home realm it is along with other members.
+	Users         int `json:"users" validate:"gte=0"`
+	CustomEvents  int `json:"customEvents" validate:"gte=0"`
+	ReportsPerDay int `json:"reportsPerDay" validate:"gte=0"`
+}
+
+// Deletion holds how long deleted reports, users, and custom events can be
+// restored before they're purged for good.
+type Deletion struct {
+	Retention Duration `json:"retention"`
+
+	// PurgeInterval is how often deleted data older than Retention is purged.
+	PurgeInterval Duration `json:"purgeInterval"`
+}
+
+// Realm creation policies.
+const (
+	RealmCreationSuperAdmin = "superadmin"
+	RealmCreationToken      = "token"
+	RealmCreationOpen       = "open"
+)
+
+// Notify modes.
+const (
+	NotifyModeLog  = "log"
+	NotifyModeFile = "file"
+	NotifyModeSMTP = "smtp"
+)
+
 // Config holds information about how the peregrine backend is configured.
 type Config struct {
-	Server Server `json:"server" validate:"dive"`
-	Year   int    `json:"year" validate:"required"`
-	TBA    struct {
-		URL    string `validate:"required"`
-		APIKey string `validate:"required"`
-	} `json:"tba"`
-	DSN string `json:"dsn" validate:"required"`
+	Server   Server         `json:"server" validate:"dive"`
+	Year     int            `json:"year" validate:"required"`
+	TBA      TBA            `json:"tba"`
+	Refresh  Refresh        `json:"refresh"`
+	Notify   Notify         `json:"notify"`
+	OIDC     []OIDCProvider `json:"oidc" validate:"dive"`
+	Realms   Realms         `json:"realms"`
+	Deletion Deletion       `json:"deletion"`
+	DSN      string         `json:"dsn" validate:"required"`
+}
+
+// Default returns the configuration used for any optional values a config file
+// leaves out. Values that are set explicitly, including zeros, are kept, so for
+// example a maxRetries of 0 disables retries.
+func Default() Config {
+	return Config{
+		TBA: TBA{
+			RequestsPerSecond: 5,
+			Burst:             10,
+			MaxRetries:        3,
+			BreakerThreshold:  5,
+			BreakerCooldown:   Duration{time.Minute * 2},
+			Mode:              TBAModeLive,
+		},
+		Refresh: Refresh{
+			EventsInterval: Duration{refresh.DefaultEventsInterval},
+			ActiveInterval: Duration{refresh.DefaultActiveInterval},
+			TeamsInterval:  Duration{refresh.DefaultTeamsInterval},
+		},
+		Notify: Notify{
+			Mode: NotifyModeLog,
+			SMTP: SMTP{Port: 587},
+		},
+		Realms: Realms{
+			CreationPolicy:        RealmCreationSuperAdmin,
+			ProofOfWorkDifficulty: 20,
+		},
+		Deletion: Deletion{
+			Retention:     Duration{time.Hour * 24 * 30},
+			PurgeInterval: Duration{time.Hour},
+		},
+	}
 }
 
-// Open parses and validates the JSON config at the given path.
+// defaultOIDCScopes are requested from OIDC providers that don't set scopes.
+var defaultOIDCScopes = []string{"email", "profile"}
+
+// Open parses and validates the JSON config at the given path. Optional values
+// that are left out are filled in from Default.
 func Open(path string) (Config, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -37,15 +282,53 @@ func Open(path string) (Config, error) {
 	}
 	defer f.Close()
 
-	var c Config
+	c := Default()
 	if err := json.NewDecoder(f).Decode(&c); err != nil {
 		return Config{}, fmt.Errorf("unable to unmarshal file: %w", err)
 	}
 
+	for i := range c.OIDC {
+		if c.OIDC[i].Scopes == nil {
+			c.OIDC[i].Scopes = defaultOIDCScopes
+		}
+	}
+
 	validate := validator.New()
 	if err := validate.Struct(c); err != nil {
 		return Config{}, fmt.Errorf("config loaded from %q fails to validate: %w", path, err)
 	}
 
+	if c.Server.JWTSecret == "" && len(c.Server.JWTKeys) == 0 {
+		return Config{}, fmt.Errorf("config loaded from %q needs a jwtSecret or jwtKeys", path)
+	}
+
+	if c.TBA.Mode != TBAModeLive && c.TBA.RecordDir == "" {
+		return Config{}, fmt.Errorf("config loaded from %q sets TBA mode %q but no recordDir", path, c.TBA.Mode)
+	}
+
+	if c.Deletion.Retention.Duration < 0 || c.Deletion.PurgeInterval.Duration <= 0 {
+		return Config{}, fmt.Errorf("config loaded from %q sets a negative deletion retention or a non-positive purge interval", path)
+	}
+
+	if c.Refresh.EventsInterval.Duration <= 0 || c.Refresh.ActiveInterval.Duration <= 0 || c.Refresh.TeamsInterval.Duration <= 0 {
+		return Config{}, fmt.Errorf("config loaded from %q sets a non-positive refresh interval", path)
+	}
+
+	oidcIDs := make(map[string]bool)
+	for _, p := range c.OIDC {
+		if oidcIDs[p.ID] {
+			return Config{}, fmt.Errorf("config loaded from %q has more than one OIDC provider with id %q", path, p.ID)
+		}
+		oidcIDs[p.ID] = true
+	}
+
+	if c.Notify.Mode == NotifyModeFile && c.Notify.File == "" {
+		return Config{}, fmt.Errorf("config loaded from %q sets notify mode %q but no file", path, c.Notify.Mode)
+	}
+
+	if c.Notify.Mode == NotifyModeSMTP && (c.Notify.SMTP.Host == "" || c.Notify.SMTP.From == "") {
+		return Config{}, fmt.Errorf("config loaded from %q sets notify mode %q but no smtp host or from address", path, c.Notify.Mode)
+	}
+
 	return c, nil
 }
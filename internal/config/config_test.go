@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const required = `"year": 2020, "dsn": "postgres://", "server": {"listen": ":8080", "origin": "*", "jwtSecret": "01234567890123456789012345678901"}, "tba": {"url": "https://tba", "apiKey": "key"`
+
+	testCases := []struct {
+		name  string
+		json  string
+		check func(t *testing.T, c Config)
+	}{
+		{
+			name: "omitted values use defaults",
+			json: `{` + required + `}}`,
+			check: func(t *testing.T, c Config) {
+				if c.TBA.MaxRetries != 3 || c.TBA.BreakerThreshold != 5 || c.TBA.RequestsPerSecond != 5 {
+					t.Errorf("expected default TBA settings but got %+v", c.TBA)
+				}
+				if c.Realms.ProofOfWorkDifficulty != 20 {
+					t.Errorf("expected default proof of work difficulty but got %d", c.Realms.ProofOfWorkDifficulty)
+				}
+				if c.Refresh.EventsInterval.Duration != time.Minute*15 {
+					t.Errorf("expected default events interval but got %v", c.Refresh.EventsInterval)
+				}
+				if len(c.OIDC) != 0 {
+					t.Errorf("expected no OIDC providers but got %v", c.OIDC)
+				}
+			},
+		},
+		{
+			name: "explicit zeros are kept",
+			json: `{` + required + `, "maxRetries": 0, "breakerThreshold": 0, "requestsPerSecond": 0}, "realms": {"proofOfWorkDifficulty": 0}}`,
+			check: func(t *testing.T, c Config) {
+				if c.TBA.MaxRetries != 0 || c.TBA.BreakerThreshold != 0 || c.TBA.RequestsPerSecond != 0 {
+					t.Errorf("expected zeroed TBA settings but got %+v", c.TBA)
+				}
+				if c.TBA.Burst != 10 {
+					t.Errorf("expected default burst but got %d", c.TBA.Burst)
+				}
+				if c.Realms.ProofOfWorkDifficulty != 0 {
+					t.Errorf("expected proof of work to be disabled but got %d", c.Realms.ProofOfWorkDifficulty)
+				}
+				if c.Realms.CreationPolicy != RealmCreationSuperAdmin {
+					t.Errorf("expected default creation policy but got %q", c.Realms.CreationPolicy)
+				}
+			},
+		},
+	}
+
+	for i, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("config%d.json", i))
+			if err := ioutil.WriteFile(path, []byte(tt.json), 0600); err != nil {
+				t.Fatalf("unable to write config: %v", err)
+			}
+
+			c, err := Open(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestOpenRejectsZeroIntervals(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatalf("unable to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(`{"year": 2020, "dsn": "postgres://", "server": {"listen": ":8080", "origin": "*", "jwtSecret": "01234567890123456789012345678901"}, "tba": {"url": "https://tba", "apiKey": "key"}, "refresh": {"activeInterval": "0s"}}`)
+	f.Close()
+	if err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+
+	if _, err := Open(f.Name()); err == nil {
+		t.Errorf("expected an error for a zero refresh interval but got none")
+	}
+}
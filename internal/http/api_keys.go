@@ -0,0 +1,49 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+)
+
+// APIKeyPrefix starts every API key, so they can be told apart from JWTs in
+// the Authorization header.
+const APIKeyPrefix = "pgk_"
+
+// APIKeyUser looks up an API key by its hash and records that it was used.
+type APIKeyUser interface {
+	UseAPIKey(ctx context.Context, hash string) (store.APIKey, error)
+}
+
+// GenerateAPIKey returns a new random API key, the prefix of it that can be
+// shown to identify it, and the hash of it that should be stored.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("unable to generate api key: %w", err)
+	}
+
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return key, key[:len(APIKeyPrefix)+8], HashAPIKey(key), nil
+}
+
+// HashAPIKey hashes an API key for storage. Keys are long and random, so
+// unlike passwords a fast hash is fine.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetAPIKey retrieves the API key the request was authenticated with from the
+// http context, if any.
+func GetAPIKey(r *http.Request) (store.APIKey, bool) {
+	key, ok := r.Context().Value(keyAPIKeyContext).(store.APIKey)
+	return key, ok
+}
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/lib/pq"
+)
+
+type mockAPIKeys map[string]store.APIKey
+
+func (m mockAPIKeys) UseAPIKey(ctx context.Context, hash string) (store.APIKey, error) {
+	key, ok := m[hash]
+	if !ok {
+		return key, store.ErrNoResults{}
+	}
+
+	return key, nil
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("unable to generate api key: %v", err)
+	}
+
+	if !strings.HasPrefix(key, APIKeyPrefix) || !strings.HasPrefix(key, prefix) || len(prefix) >= len(key) {
+		t.Errorf("unexpected key %q with prefix %q", key, prefix)
+	}
+
+	if hash != HashAPIKey(key) || hash == key {
+		t.Errorf("expected hash to be the hash of the key")
+	}
+
+	other, _, _, err := GenerateAPIKey()
+	if err != nil || other == key {
+		t.Errorf("expected keys to be unique")
+	}
+}
+
+func TestAPIKeyACL(t *testing.T) {
+	readKey, writeKey, adminKey := "pgk_read", "pgk_write", "pgk_admin"
+	demotedKey, unverifiedKey := "pgk_demoted", "pgk_unverified"
+	admin := store.Roles{IsVerified: true, IsAdmin: true}
+
+	keys := mockAPIKeys{
+		HashAPIKey(readKey):       {ID: 1, RealmID: 2, CreatedBy: 3, Scopes: pq.StringArray{store.ScopeReadReports, store.ScopeReadStats}, CreatorRoles: admin},
+		HashAPIKey(writeKey):      {ID: 4, RealmID: 2, CreatedBy: 3, Scopes: pq.StringArray{store.ScopeWriteReports}, CreatorRoles: admin},
+		HashAPIKey(adminKey):      {ID: 5, RealmID: 2, CreatedBy: 3, Scopes: pq.StringArray{store.ScopeAdmin}, CreatorRoles: admin},
+		HashAPIKey(demotedKey):    {ID: 6, RealmID: 2, CreatedBy: 3, Scopes: pq.StringArray{store.ScopeAdmin}, CreatorRoles: store.Roles{IsVerified: true}, CreatorPermissions: pq.StringArray{store.PermSchemasCreate}},
+		HashAPIKey(unverifiedKey): {ID: 7, RealmID: 2, CreatedBy: 3, Scopes: pq.StringArray{store.ScopeWriteReports}},
+	}
+
+	keySet, err := NewKeySet("a-very-secret-secret-that-is-long", nil, "")
+	if err != nil {
+		t.Fatalf("unable to create key set: %v", err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if realmID, err := GetRealmID(r); err != nil || realmID != 2 {
+			t.Errorf("expected realm 2 to be set from the api key")
+		}
+
+		if subject, err := GetSubject(r); err != nil || subject != 3 {
+			t.Errorf("expected subject to be the key's creator")
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	testCases := []struct {
+		name    string
+		key     string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{
+			name:    "unknown key",
+			key:     "pgk_unknown",
+			handler: ACL(ok, Access{}),
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name:    "public route",
+			key:     writeKey,
+			handler: ACL(ok, Access{}),
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "logged in route without scopes",
+			key:     adminKey,
+			handler: ACL(ok, Access{LoggedIn: true}),
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "matching scope",
+			key:     readKey,
+			handler: ACL(ok, Access{Scopes: []string{store.ScopeReadStats}}),
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "missing scope",
+			key:     readKey,
+			handler: ACL(ok, Access{Permission: store.PermReportsWrite, Scopes: []string{store.ScopeWriteReports}}),
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "verified with scope",
+			key:     writeKey,
+			handler: ACL(ok, Access{Permission: store.PermReportsWrite, Scopes: []string{store.ScopeWriteReports}}),
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "admin route without admin scope",
+			key:     writeKey,
+			handler: ACL(ok, Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin, store.ScopeWriteReports}}),
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "admin scope grants all scopes",
+			key:     adminKey,
+			handler: ACL(ok, Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeWriteReports}}),
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "admin scope from demoted creator",
+			key:     demotedKey,
+			handler: ACL(ok, Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}}),
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "admin scope limited to creator's role permissions",
+			key:     demotedKey,
+			handler: ACL(ok, Access{Permission: store.PermSchemasCreate, Scopes: []string{store.ScopeAdmin}}),
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "write scope from unverified creator",
+			key:     unverifiedKey,
+			handler: ACL(ok, Access{Permission: store.PermReportsWrite, Scopes: []string{store.ScopeWriteReports}}),
+			want:    http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.key)
+			w := httptest.NewRecorder()
+
+			Auth(tt.handler, keySet, keys, nil).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("expected status %d but got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
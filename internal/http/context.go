@@ -15,18 +15,39 @@ const (
 	keyRolesContext   contextKey = "peregrine_roles"
 	keySubjectContext contextKey = "peregrine_subject"
 	keyRealmContext   contextKey = "peregrine_realm"
+	keySessionContext contextKey = "peregrine_session"
+	keyAPIKeyContext  contextKey = "peregrine_api_key"
+	keyPermsContext   contextKey = "peregrine_permissions"
 )
 
-// Claims holds the standard jwt claims, peregrine roles, and realm id.
+// Token types, so an access token and a refresh token can't be used in place
+// of each other.
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// Claims holds the standard jwt claims, peregrine roles, the permissions of the
+// user's realm role, realm id, and the id of the session the token was issued
+// for. Type is always TokenTypeAccess.
 type Claims struct {
-	Roles   store.Roles `json:"peregrineRoles"`
-	RealmID int64       `json:"peregrineRealm"`
+	Type        string      `json:"peregrineType"`
+	Roles       store.Roles `json:"peregrineRoles"`
+	Permissions []string    `json:"peregrinePermissions,omitempty"`
+	RealmID     int64       `json:"peregrineRealm"`
+	SessionID   int64       `json:"peregrineSession,omitempty"`
 	jwt.StandardClaims
 }
 
-// RefreshClaims holds the standard jwt claims plus
+// RefreshClaims holds the standard jwt claims plus when the user's password was
+// last changed, the session and generation the refresh token belongs to, and
+// the user's active realm. Type is always TokenTypeRefresh.
 type RefreshClaims struct {
-	PasswordChanged int64 `json:"peregrinePasswordChanged"`
+	Type            string `json:"peregrineType"`
+	PasswordChanged int64  `json:"peregrinePasswordChanged"`
+	SessionID       int64  `json:"peregrineSession"`
+	Generation      int64  `json:"peregrineGeneration"`
+	RealmID         int64  `json:"peregrineRealm,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -65,6 +86,16 @@ func GetRoles(r *http.Request) store.Roles {
 	return roles
 }
 
+// GetPermissions retrieves every permission the user has from the http context.
+func GetPermissions(r *http.Request) store.PermissionSet {
+	perms, ok := r.Context().Value(keyPermsContext).(store.PermissionSet)
+	if !ok {
+		return store.PermissionSet{}
+	}
+
+	return perms
+}
+
 // GetRealmID retrieves the ID of the user's realm from the http context.
 func GetRealmID(r *http.Request) (int64, error) {
 	contextRealm := r.Context().Value(keyRealmContext)
@@ -78,3 +109,19 @@ func GetRealmID(r *http.Request) (int64, error) {
 	}
 	return realmID, nil
 }
+
+// GetSessionID retrieves the ID of the session the user's access token was
+// issued for from the http context.
+func GetSessionID(r *http.Request) (int64, error) {
+	contextSession := r.Context().Value(keySessionContext)
+	if contextSession == nil {
+		return 0, errors.New("no session set on context")
+	}
+
+	sessionID, ok := contextSession.(int64)
+	if !ok || sessionID == 0 {
+		return 0, errors.New("got invalid session")
+	}
+
+	return sessionID, nil
+}
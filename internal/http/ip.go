@@ -0,0 +1,27 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the IP address a request came from. If trustProxy is set
+// the last address in the X-Forwarded-For header is used, since that's the
+// one added by the reverse proxy in front of the server and can't be spoofed
+// by clients.
+func ClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		trustProxy bool
+		want       string
+	}{
+		{name: "remote addr", remoteAddr: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "ipv6 remote addr", remoteAddr: "[::1]:5000", want: "::1"},
+		{name: "untrusted forwarded for", remoteAddr: "10.0.0.1:5000", forwarded: "1.2.3.4", want: "10.0.0.1"},
+		{name: "trusted forwarded for", remoteAddr: "10.0.0.1:5000", forwarded: "1.2.3.4", trustProxy: true, want: "1.2.3.4"},
+		{name: "spoofed forwarded for", remoteAddr: "10.0.0.1:5000", forwarded: "6.6.6.6, 1.2.3.4", trustProxy: true, want: "1.2.3.4"},
+		{name: "trusted without forwarded for", remoteAddr: "10.0.0.1:5000", trustProxy: true, want: "10.0.0.1"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if got := ClientIP(r, tt.trustProxy); got != tt.want {
+				t.Errorf("expected %q but got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package http
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Key is an asymmetric key used to sign or verify tokens. Keys without a
+// private key can only verify tokens, which is useful while rotating keys out.
+type Key struct {
+	ID      string
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// LoadKey loads an RSA or Ed25519 key from PEM files. If privateKeyFile is
+// set the public key is derived from it, otherwise only publicKeyFile is read
+// and the key can only be used to verify tokens.
+func LoadKey(id, privateKeyFile, publicKeyFile string) (Key, error) {
+	key := Key{ID: id}
+
+	if privateKeyFile != "" {
+		block, err := readPEM(privateKeyFile)
+		if err != nil {
+			return key, err
+		}
+
+		var private interface{}
+		if private, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			if private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return key, fmt.Errorf("unable to parse private key %q: %w", privateKeyFile, err)
+			}
+		}
+
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			key.Private, key.Public = private, &private.PublicKey
+		case ed25519.PrivateKey:
+			key.Private, key.Public = private, private.Public()
+		default:
+			return key, fmt.Errorf("private key %q must be an RSA or Ed25519 key", privateKeyFile)
+		}
+
+		return key, nil
+	}
+
+	block, err := readPEM(publicKeyFile)
+	if err != nil {
+		return key, err
+	}
+
+	var public interface{}
+	if public, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		if public, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return key, fmt.Errorf("unable to parse public key %q: %w", publicKeyFile, err)
+		}
+	}
+
+	switch public.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		key.Public = public
+	default:
+		return key, fmt.Errorf("public key %q must be an RSA or Ed25519 key", publicKeyFile)
+	}
+
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %q", path)
+	}
+
+	return block, nil
+}
+
+func signingMethod(public crypto.PublicKey) jwt.SigningMethod {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		return SigningMethodEdDSA
+	}
+
+	return nil
+}
+
+// KeySet signs tokens with a single key and verifies tokens signed by any of
+// its keys, picked by the token's kid header. If the set has an HMAC secret,
+// tokens without a kid are verified with it, and if there is no asymmetric
+// signing key tokens are signed with it (without a kid).
+type KeySet struct {
+	secret  []byte
+	signing *Key
+	keys    map[string]Key
+}
+
+// NewKeySet creates a key set. The key with ID signingKeyID is used to sign
+// tokens. If signingKeyID is empty the first key with a private key is used,
+// or the secret if there are none.
+func NewKeySet(secret string, keys []Key, signingKeyID string) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]Key)}
+	if secret != "" {
+		ks.secret = []byte(secret)
+	}
+
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("keys must have an ID")
+		}
+
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+
+		if signingMethod(key.Public) == nil {
+			return nil, fmt.Errorf("key %q must be an RSA or Ed25519 key", key.ID)
+		}
+
+		ks.keys[key.ID] = key
+
+		if ks.signing == nil && signingKeyID == "" && key.Private != nil {
+			k := key
+			ks.signing = &k
+		}
+	}
+
+	if signingKeyID != "" {
+		key, ok := ks.keys[signingKeyID]
+		if !ok || key.Private == nil {
+			return nil, fmt.Errorf("signing key %q not found or has no private key", signingKeyID)
+		}
+
+		ks.signing = &key
+	}
+
+	if ks.signing == nil && ks.secret == nil {
+		return nil, errors.New("key set needs either a secret or a private key to sign tokens with")
+	}
+
+	return ks, nil
+}
+
+// Sign returns a signed token with the given claims.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.secret)
+	}
+
+	token := jwt.NewWithClaims(signingMethod(ks.signing.Public), claims)
+	token.Header["kid"] = ks.signing.ID
+
+	return token.SignedString(ks.signing.Private)
+}
+
+// Keyfunc returns the key to verify the given token with. It's meant to be
+// passed to jwt.Parse. Tokens must be signed with the algorithm matching
+// their key, so e.g. an RSA public key can never be used as an HMAC secret.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || ks.secret == nil {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return ks.secret, nil
+	}
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+
+	if token.Method.Alg() != signingMethod(key.Public).Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %q", token.Header["alg"], kid)
+	}
+
+	return key.Public, nil
+}
+
+// JWK is a JSON Web Key as described in RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys in the set, so other services can verify
+// tokens. The HMAC secret is never included.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+
+	for _, key := range ks.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: signingMethod(key.Public).Alg()}
+
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+
+	return jwks
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestKeySet(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate RSA key: %v", err)
+	}
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate Ed25519 key: %v", err)
+	}
+
+	oldKey := Key{ID: "old", Private: rsaKey, Public: &rsaKey.PublicKey}
+	newKey := Key{ID: "new", Private: edPrivate, Public: edPublic}
+	secret := "a-very-secret-secret-that-is-long"
+
+	claims := jwt.StandardClaims{Subject: "1"}
+
+	sign := func(t *testing.T, keys *KeySet) string {
+		token, err := keys.Sign(claims)
+		if err != nil {
+			t.Fatalf("unable to sign token: %v", err)
+		}
+
+		return token
+	}
+
+	verify := func(keys *KeySet, token string) error {
+		_, err := jwt.ParseWithClaims(token, &jwt.StandardClaims{}, keys.Keyfunc)
+		return err
+	}
+
+	t.Run("sign with each key type", func(t *testing.T) {
+		for _, key := range []Key{oldKey, newKey} {
+			keys, err := NewKeySet("", []Key{key}, "")
+			if err != nil {
+				t.Fatalf("unable to create key set: %v", err)
+			}
+
+			token := sign(t, keys)
+
+			parsed, err := jwt.Parse(token, keys.Keyfunc)
+			if err != nil {
+				t.Fatalf("unable to verify token signed with %q: %v", key.ID, err)
+			}
+
+			if kid := parsed.Header["kid"]; kid != key.ID {
+				t.Errorf("expected kid %q but got %v", key.ID, kid)
+			}
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		before, err := NewKeySet(secret, []Key{oldKey}, "")
+		if err != nil {
+			t.Fatalf("unable to create key set: %v", err)
+		}
+
+		oldToken := sign(t, before)
+
+		verifyOnly := Key{ID: oldKey.ID, Public: oldKey.Public}
+		after, err := NewKeySet(secret, []Key{verifyOnly, newKey}, "new")
+		if err != nil {
+			t.Fatalf("unable to create key set: %v", err)
+		}
+
+		if err := verify(after, oldToken); err != nil {
+			t.Errorf("expected token signed with the rotated out key to verify: %v", err)
+		}
+
+		if err := verify(after, sign(t, after)); err != nil {
+			t.Errorf("expected token signed with the new key to verify: %v", err)
+		}
+
+		if err := verify(before, sign(t, after)); err == nil {
+			t.Errorf("expected token signed with an unknown key to fail verification")
+		}
+	})
+
+	t.Run("secret", func(t *testing.T) {
+		legacy, err := NewKeySet(secret, nil, "")
+		if err != nil {
+			t.Fatalf("unable to create key set: %v", err)
+		}
+
+		token := sign(t, legacy)
+
+		withKeys, err := NewKeySet(secret, []Key{newKey}, "")
+		if err != nil {
+			t.Fatalf("unable to create key set: %v", err)
+		}
+
+		if err := verify(withKeys, token); err != nil {
+			t.Errorf("expected HS256 token to verify while the secret is configured: %v", err)
+		}
+
+		keysOnly, err := NewKeySet("", []Key{newKey}, "")
+		if err != nil {
+			t.Fatalf("unable to create key set: %v", err)
+		}
+
+		if err := verify(keysOnly, token); err == nil {
+			t.Errorf("expected HS256 token to fail verification without a secret")
+		}
+	})
+
+	t.Run("algorithm confusion", func(t *testing.T) {
+		keys, err := NewKeySet(secret, []Key{oldKey}, "")
+		if err != nil {
+			t.Fatalf("unable to create key set: %v", err)
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = oldKey.ID
+		forged, err := token.SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("unable to sign token: %v", err)
+		}
+
+		if err := verify(keys, forged); err == nil {
+			t.Errorf("expected HS256 token with an RSA kid to fail verification")
+		}
+	})
+
+	t.Run("invalid key sets", func(t *testing.T) {
+		if _, err := NewKeySet("", nil, ""); err == nil {
+			t.Errorf("expected an error for a key set that can't sign")
+		}
+
+		if _, err := NewKeySet("", []Key{{ID: "pub", Public: edPublic}}, "pub"); err == nil {
+			t.Errorf("expected an error for a signing key without a private key")
+		}
+
+		if _, err := NewKeySet("", []Key{newKey, newKey}, ""); err == nil {
+			t.Errorf("expected an error for duplicate key IDs")
+		}
+	})
+
+	t.Run("jwks", func(t *testing.T) {
+		keys, err := NewKeySet(secret, []Key{oldKey, newKey}, "new")
+		if err != nil {
+			t.Fatalf("unable to create key set: %v", err)
+		}
+
+		jwks := keys.JWKS()
+		if len(jwks.Keys) != 2 {
+			t.Fatalf("expected 2 keys but got %d", len(jwks.Keys))
+		}
+
+		if k := jwks.Keys[0]; k.KeyID != "new" || k.KeyType != "OKP" || k.Algorithm != "EdDSA" || k.Curve != "Ed25519" || k.X == "" {
+			t.Errorf("unexpected Ed25519 JWK: %+v", k)
+		}
+
+		if k := jwks.Keys[1]; k.KeyID != "old" || k.KeyType != "RSA" || k.Algorithm != "RS256" || k.N == "" || k.E != "AQAB" {
+			t.Errorf("unexpected RSA JWK: %+v", k)
+		}
+	})
+}
@@ -2,11 +2,13 @@ package http
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/sirupsen/logrus"
 )
@@ -88,6 +90,10 @@ func Log(next http.Handler, l *logrus.Logger) http.HandlerFunc {
 			fields["realmId"] = realm
 		}
 
+		if key, ok := GetAPIKey(r); ok {
+			fields["apiKeyId"] = key.ID
+		}
+
 		withFields := l.WithFields(fields)
 		if rr.code >= 200 && rr.code < 300 {
 			withFields.Info("got request")
@@ -99,8 +105,20 @@ func Log(next http.Handler, l *logrus.Logger) http.HandlerFunc {
 	}
 }
 
-// Auth returns a middleware used for jwt authentication.
-func Auth(next http.Handler, secret string) http.Handler {
+// SessionChecker checks whether a session is still active, i.e. it hasn't
+// expired or been revoked.
+type SessionChecker interface {
+	SessionActive(ctx context.Context, id int64) (bool, error)
+}
+
+// Auth returns a middleware used for authentication. JWTs are verified with
+// the given key set, must be access tokens, and must belong to a session that
+// sessions reports as active. API keys (which start with APIKeyPrefix) are
+// looked up with apiKeys. Requests made with an API key act as the user who
+// created it within the key's realm, with the permissions of a verified user
+// (or an admin with the admin scope), and are further limited to the key's
+// scopes by ACL.
+func Auth(next http.Handler, keys *KeySet, apiKeys APIKeyUser, sessions SessionChecker) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Authorization") == "" {
 			next.ServeHTTP(w, r)
@@ -108,13 +126,12 @@ func Auth(next http.Handler, secret string) http.Handler {
 		}
 
 		ss := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		token, err := jwt.ParseWithClaims(ss, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
+		if strings.HasPrefix(ss, APIKeyPrefix) {
+			authAPIKey(next, apiKeys, ss, w, r)
+			return
+		}
 
-			return []byte(secret), nil
-		})
+		token, err := jwt.ParseWithClaims(ss, &Claims{}, keys.Keyfunc)
 		if err != nil {
 			Error(w, http.StatusUnauthorized)
 			return
@@ -126,23 +143,72 @@ func Auth(next http.Handler, secret string) http.Handler {
 		}
 
 		claims, ok := token.Claims.(*Claims)
-		if !ok {
+		if !ok || claims.Type != TokenTypeAccess || claims.SessionID == 0 || sessions == nil {
+			Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		active, err := sessions.SessionActive(r.Context(), claims.SessionID)
+		if err != nil {
+			Error(w, http.StatusInternalServerError)
+			return
+		} else if !active {
 			Error(w, http.StatusUnauthorized)
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), keyRolesContext, claims.Roles)
+		ctx = context.WithValue(ctx, keyPermsContext, store.NewPermissionSet(claims.Roles, claims.Permissions))
 		ctx = context.WithValue(ctx, keySubjectContext, claims.Subject)
 		ctx = context.WithValue(ctx, keyRealmContext, claims.RealmID)
+		ctx = context.WithValue(ctx, keySessionContext, claims.SessionID)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+func authAPIKey(next http.Handler, apiKeys APIKeyUser, ss string, w http.ResponseWriter, r *http.Request) {
+	if apiKeys == nil {
+		Error(w, http.StatusUnauthorized)
+		return
+	}
+
+	key, err := apiKeys.UseAPIKey(r.Context(), HashAPIKey(ss))
+	if errors.Is(err, store.ErrNoResults{}) {
+		Error(w, http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		Error(w, http.StatusInternalServerError)
+		return
+	}
+
+	roles, perms := key.Access()
+
+	ctx := context.WithValue(r.Context(), keyRolesContext, roles)
+	ctx = context.WithValue(ctx, keyPermsContext, perms)
+	ctx = context.WithValue(ctx, keySubjectContext, strconv.FormatInt(key.CreatedBy, 10))
+	ctx = context.WithValue(ctx, keyRealmContext, key.RealmID)
+	ctx = context.WithValue(ctx, keyAPIKeyContext, key)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Access describes who can use a route.
+type Access struct {
+	// LoggedIn requires the request to be authenticated.
+	LoggedIn bool
+	// Permission requires the user to have a permission, and implies LoggedIn.
+	Permission string
+	// Scopes are the API key scopes that can use the route. If there are none
+	// API keys can only use the route if it doesn't require being logged in.
+	Scopes []string
+}
+
 // ACL returns a middleware that must be used inside of an Auth middleware for
-// checking user roles. The SuperOrAdmin requirement will be satisfied by any
-// user who is either a SuperAdmin or a realm Admin.
-func ACL(next http.HandlerFunc, requireSuperOrAdmin, requireVerified, requireLoggedIn bool) http.HandlerFunc {
+// checking that the user can access a route.
+func ACL(next http.HandlerFunc, access Access) http.HandlerFunc {
+	requireLoggedIn := access.LoggedIn || access.Permission != ""
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		_, err := GetSubject(r)
 		if err != nil && requireLoggedIn {
@@ -150,8 +216,12 @@ func ACL(next http.HandlerFunc, requireSuperOrAdmin, requireVerified, requireLog
 			return
 		}
 
-		roles := GetRoles(r)
-		if (requireSuperOrAdmin && !roles.IsSuperAdmin && !roles.IsAdmin) || (requireVerified && !roles.IsVerified) {
+		if access.Permission != "" && !GetPermissions(r).Has(access.Permission) {
+			Error(w, http.StatusForbidden)
+			return
+		}
+
+		if key, ok := GetAPIKey(r); ok && !scopesAllowed(key, access.Scopes, requireLoggedIn) {
 			Error(w, http.StatusForbidden)
 			return
 		}
@@ -159,3 +229,17 @@ func ACL(next http.HandlerFunc, requireSuperOrAdmin, requireVerified, requireLog
 		next(w, r)
 	}
 }
+
+func scopesAllowed(key store.APIKey, scopes []string, requireLoggedIn bool) bool {
+	if len(scopes) == 0 {
+		return !requireLoggedIn
+	}
+
+	for _, scope := range scopes {
+		if key.HasScope(scope) {
+			return true
+		}
+	}
+
+	return false
+}
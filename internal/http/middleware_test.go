@@ -0,0 +1,191 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestACLPermissions(t *testing.T) {
+	keys, err := NewKeySet("a-very-secret-secret-that-is-long", nil, "")
+	if err != nil {
+		t.Fatalf("unable to create key set: %v", err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	testCases := []struct {
+		name        string
+		roles       store.Roles
+		permissions []string
+		loggedOut   bool
+		access      Access
+		want        int
+	}{
+		{
+			name:   "public",
+			access: Access{},
+			want:   http.StatusNoContent,
+		},
+		{
+			name:      "logged out",
+			loggedOut: true,
+			access:    Access{LoggedIn: true},
+			want:      http.StatusUnauthorized,
+		},
+		{
+			name:      "logged out with permission",
+			loggedOut: true,
+			access:    Access{Permission: store.PermReportsWrite},
+			want:      http.StatusUnauthorized,
+		},
+		{
+			name:   "unverified",
+			access: Access{Permission: store.PermReportsWrite},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "verified",
+			roles:  store.Roles{IsVerified: true},
+			access: Access{Permission: store.PermReportsWrite},
+			want:   http.StatusNoContent,
+		},
+		{
+			name:   "verified without permission",
+			roles:  store.Roles{IsVerified: true},
+			access: Access{Permission: store.PermReportsDeleteAny},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:        "realm role",
+			roles:       store.Roles{IsVerified: true},
+			permissions: []string{store.PermReportsEditAny, store.PermReportsDeleteAny},
+			access:      Access{Permission: store.PermReportsDeleteAny},
+			want:        http.StatusNoContent,
+		},
+		{
+			name:   "admin",
+			roles:  store.Roles{IsAdmin: true},
+			access: Access{Permission: store.PermUsersManage},
+			want:   http.StatusNoContent,
+		},
+		{
+			name:   "super-admin",
+			roles:  store.Roles{IsSuperAdmin: true},
+			access: Access{Permission: store.PermRealmManage},
+			want:   http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if !tt.loggedOut {
+				token, err := keys.Sign(&Claims{
+					StandardClaims: jwt.StandardClaims{
+						ExpiresAt: time.Now().Add(time.Minute).Unix(),
+						Subject:   "1",
+					},
+					Type:        TokenTypeAccess,
+					Roles:       tt.roles,
+					Permissions: tt.permissions,
+					RealmID:     2,
+					SessionID:   1,
+				})
+				if err != nil {
+					t.Fatalf("unable to sign token: %v", err)
+				}
+
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			w := httptest.NewRecorder()
+			Auth(ACL(ok, tt.access), keys, nil, activeSessions{1: true}).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("expected status %d but got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+type activeSessions map[int64]bool
+
+func (s activeSessions) SessionActive(ctx context.Context, id int64) (bool, error) {
+	return s[id], nil
+}
+
+func TestAuthTokens(t *testing.T) {
+	keys, err := NewKeySet("a-very-secret-secret-that-is-long", nil, "")
+	if err != nil {
+		t.Fatalf("unable to create key set: %v", err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	standard := jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		Subject:   "1",
+	}
+
+	testCases := []struct {
+		name   string
+		claims jwt.Claims
+		want   int
+	}{
+		{
+			name:   "access token",
+			claims: &Claims{StandardClaims: standard, Type: TokenTypeAccess, RealmID: 2, SessionID: 1},
+			want:   http.StatusNoContent,
+		},
+		{
+			name:   "refresh token",
+			claims: &RefreshClaims{StandardClaims: standard, Type: TokenTypeRefresh, RealmID: 2, SessionID: 1},
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "untyped token",
+			claims: &Claims{StandardClaims: standard, RealmID: 2, SessionID: 1},
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "revoked session",
+			claims: &Claims{StandardClaims: standard, Type: TokenTypeAccess, RealmID: 2, SessionID: 2},
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "no session",
+			claims: &Claims{StandardClaims: standard, Type: TokenTypeAccess, RealmID: 2},
+			want:   http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := keys.Sign(tt.claims)
+			if err != nil {
+				t.Fatalf("unable to sign token: %v", err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+
+			Auth(ACL(ok, Access{LoggedIn: true}), keys, nil, activeSessions{1: true}).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("expected status %d but got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
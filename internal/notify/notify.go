@@ -0,0 +1,120 @@
+// Package notify delivers messages such as password reset links to users.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Message is a message to a single user.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users.
+type Notifier interface {
+	Notify(ctx context.Context, m Message) error
+}
+
+// Log is a Notifier that logs messages instead of delivering them, for
+// development and testing.
+type Log struct {
+	Logger *logrus.Logger
+}
+
+// Notify logs the message.
+func (l Log) Notify(ctx context.Context, m Message) error {
+	l.Logger.WithFields(logrus.Fields{
+		"to":      m.To,
+		"subject": m.Subject,
+	}).Info(m.Body)
+
+	return nil
+}
+
+// File is a Notifier that appends messages to a file instead of delivering
+// them, for testing.
+type File struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Notify appends the message to the file.
+func (f *File) Notify(ctx context.Context, m Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open notification file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(formatMessage("", m, time.Now())); err != nil {
+		return fmt.Errorf("unable to write notification: %w", err)
+	}
+
+	return nil
+}
+
+// SMTP is a Notifier that emails messages.
+type SMTP struct {
+	// Addr is the host:port of the SMTP server.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// Notify emails the message.
+func (s SMTP) Notify(ctx context.Context, m Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	if err := smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, formatMessage(s.From, m, time.Now())); err != nil {
+		return fmt.Errorf("unable to send email: %w", err)
+	}
+
+	return nil
+}
+
+// formatMessage formats a message as a plain text email.
+func formatMessage(from string, m Message, date time.Time) []byte {
+	var b strings.Builder
+
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+
+	fmt.Fprintf(&b, "To: %s\r\n", stripNewlines(m.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", stripNewlines(m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
+
+// stripNewlines keeps header values from injecting extra headers.
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatMessage(t *testing.T) {
+	date := time.Date(2020, time.January, 4, 15, 0, 0, 0, time.UTC)
+
+	got := string(formatMessage("peregrine@example.com", Message{
+		To:      "scout@example.com\r\nBcc: everyone@example.com",
+		Subject: "Reset your password",
+		Body:    "Hi\nReset it here",
+	}, date))
+
+	want := "From: peregrine@example.com\r\n" +
+		"To: scout@example.comBcc: everyone@example.com\r\n" +
+		"Subject: Reset your password\r\n" +
+		"Date: Sat, 04 Jan 2020 15:00:00 +0000\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"Hi\r\nReset it here\r\n"
+
+	if got != want {
+		t.Errorf("expected message:\n%q\nbut got:\n%q", want, got)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &File{Path: filepath.Join(dir, "messages.txt")}
+
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := f.Notify(context.Background(), Message{To: to, Subject: "hi", Body: "hello"}); err != nil {
+			t.Fatalf("unable to notify: %v", err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		t.Fatalf("unable to read messages: %v", err)
+	}
+
+	for _, to := range []string{"To: a@example.com", "To: b@example.com"} {
+		if !strings.Contains(string(b), to) {
+			t.Errorf("expected messages to contain %q", to)
+		}
+	}
+}
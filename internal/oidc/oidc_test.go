@@ -0,0 +1,116 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/oidc"
+	"github.com/Pigmice2733/peregrine-backend/internal/oidc/oidctest"
+)
+
+func TestProvider(t *testing.T) {
+	mock, err := oidctest.NewProvider("peregrine", "client-secret")
+	if err != nil {
+		t.Fatalf("unable to start mock provider: %v", err)
+	}
+	defer mock.Close()
+
+	identity := oidctest.Identity{Subject: "1234", Email: "franklin@example.com", EmailVerified: true, GivenName: "Franklin", FamilyName: "Harding"}
+	redirectURI := "https://peregrine.example.com/login"
+	ctx := context.Background()
+
+	login := func(t *testing.T, p *oidc.Provider) (code, verifier, nonce string) {
+		verifier, err := oidc.GenerateVerifier()
+		if err != nil {
+			t.Fatalf("unable to generate verifier: %v", err)
+		}
+
+		authURL, err := p.AuthCodeURL(ctx, redirectURI, "state", "nonce", verifier)
+		if err != nil {
+			t.Fatalf("unable to get auth code url: %v", err)
+		}
+
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatalf("unable to parse auth code url: %v", err)
+		}
+
+		q := u.Query()
+		if q.Get("code_challenge") != oidc.Challenge(verifier) || q.Get("code_challenge_method") != "S256" || q.Get("scope") != "openid email profile" {
+			t.Errorf("unexpected auth code url %q", authURL)
+		}
+
+		code, err = mock.Authorize(authURL, identity)
+		if err != nil {
+			t.Fatalf("unable to authorize: %v", err)
+		}
+
+		return code, verifier, "nonce"
+	}
+
+	t.Run("exchange", func(t *testing.T) {
+		p := mock.OIDCProvider()
+		code, verifier, nonce := login(t, p)
+
+		token, err := p.Exchange(ctx, code, redirectURI, verifier, nonce)
+		if err != nil {
+			t.Fatalf("unable to exchange code: %v", err)
+		}
+
+		if token.Subject != identity.Subject || token.Email != identity.Email || !bool(token.EmailVerified) || token.GivenName != identity.GivenName || token.FamilyName != identity.FamilyName {
+			t.Errorf("unexpected id token %+v", token)
+		}
+	})
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		p := mock.OIDCProvider()
+		code, _, nonce := login(t, p)
+
+		if _, err := p.Exchange(ctx, code, redirectURI, "wrong", nonce); err == nil {
+			t.Errorf("expected exchange with the wrong verifier to fail")
+		}
+	})
+
+	t.Run("code reuse", func(t *testing.T) {
+		p := mock.OIDCProvider()
+		code, verifier, nonce := login(t, p)
+
+		if _, err := p.Exchange(ctx, code, redirectURI, verifier, nonce); err != nil {
+			t.Fatalf("unable to exchange code: %v", err)
+		}
+
+		if _, err := p.Exchange(ctx, code, redirectURI, verifier, nonce); err == nil {
+			t.Errorf("expected exchanging a code twice to fail")
+		}
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		p := mock.OIDCProvider()
+		code, verifier, _ := login(t, p)
+
+		if _, err := p.Exchange(ctx, code, redirectURI, verifier, "other"); !errors.Is(err, oidc.ErrInvalidToken) {
+			t.Errorf("expected invalid token error but got %v", err)
+		}
+	})
+
+	t.Run("wrong client", func(t *testing.T) {
+		other := mock.OIDCProvider()
+		other.ClientID = "someone-else"
+
+		code, verifier, nonce := login(t, mock.OIDCProvider())
+		if _, err := other.Exchange(ctx, code, redirectURI, verifier, nonce); err == nil {
+			t.Errorf("expected exchange by another client to fail")
+		}
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		p := mock.OIDCProvider()
+		p.Issuer = mock.URL + "/"
+
+		if _, err := p.AuthCodeURL(ctx, redirectURI, "state", "nonce", "verifier"); err == nil {
+			t.Errorf("expected discovery with a mismatched issuer to fail")
+		}
+	})
+}
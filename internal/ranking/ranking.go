@@ -0,0 +1,280 @@
+// Package ranking computes qualification rankings from stored match scores, for
+// events that don't get rankings from TBA. How ranking points are earned and
+// how ties are broken changes every season, so each season's rules are looked
+// up by year, falling back to rules that only count wins and ties.
+package ranking
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+)
+
+// RankingScore is the name of the sort order holding a team's average ranking
+// points per match, matching the name TBA uses.
+const RankingScore = "Ranking Score"
+
+// sortOrderPrecision is the number of decimal places sort orders are shown with.
+const sortOrderPrecision = 2
+
+// Rules are how a season's qualification rankings are decided.
+type Rules struct {
+	// WinRP and TieRP are the ranking points an alliance earns for winning or
+	// tying a match.
+	WinRP int
+	TieRP int
+
+	// BonusRP are score breakdown fields that each earn an alliance one more
+	// ranking point when they're true.
+	BonusRP []string
+
+	// Tiebreakers break ties in ranking score, in order.
+	Tiebreakers []Tiebreaker
+}
+
+// Tiebreaker is a ranking tiebreaker averaged over the matches a team played.
+// Its value for an alliance in a match is the sum of the given score breakdown
+// fields, or the alliance's score if there are none.
+type Tiebreaker struct {
+	Name   string
+	Fields []string
+}
+
+// DefaultRules are used for seasons without their own rules.
+var DefaultRules = Rules{
+	WinRP:       2,
+	TieRP:       1,
+	Tiebreakers: []Tiebreaker{{Name: "Match Points"}},
+}
+
+// seasons holds each season's rules by year. To support a new season, add its
+// bonus ranking point flags and tiebreakers as named in TBA score breakdowns.
+var seasons = map[int]Rules{
+	2018: {
+		WinRP:   2,
+		TieRP:   1,
+		BonusRP: []string{"autoQuestRankingPoint", "faceTheBossRankingPoint"},
+		Tiebreakers: []Tiebreaker{
+			{Name: "Park/Climb Points", Fields: []string{"endgamePoints"}},
+			{Name: "Auto", Fields: []string{"autoPoints"}},
+			{Name: "Ownership", Fields: []string{"autoOwnershipPoints", "teleopOwnershipPoints"}},
+			{Name: "Vault", Fields: []string{"vaultPoints"}},
+		},
+	},
+	2019: {
+		WinRP:   2,
+		TieRP:   1,
+		BonusRP: []string{"completeRocketRankingPoint", "habDockingRankingPoint"},
+		Tiebreakers: []Tiebreaker{
+			{Name: "Cargo", Fields: []string{"cargoPoints"}},
+			{Name: "Hatch Panel", Fields: []string{"hatchPanelPoints"}},
+			{Name: "HAB Climb", Fields: []string{"habClimbPoints"}},
+			{Name: "Sandstorm Bonus", Fields: []string{"sandStormBonusPoints"}},
+		},
+	},
+	2020: {
+		WinRP:   2,
+		TieRP:   1,
+		BonusRP: []string{"shieldEnergizedRankingPoint", "shieldOperationalRankingPoint"},
+		Tiebreakers: []Tiebreaker{
+			{Name: "Auto", Fields: []string{"autoPoints"}},
+			{Name: "Endgame", Fields: []string{"endgamePoints"}},
+			{Name: "Teleop Cell + CPanel", Fields: []string{"teleopCellPoints", "controlPanelPoints"}},
+		},
+	},
+}
+
+// RulesForYear returns the ranking rules for the given season.
+func RulesForYear(year int) Rules {
+	if rules, ok := seasons[year]; ok {
+		return rules
+	}
+	return DefaultRules
+}
+
+// record is a team's running totals over the matches it has played.
+type record struct {
+	key         string
+	rp          int
+	wins        int
+	losses      int
+	ties        int
+	played      int
+	tiebreakers []float64
+}
+
+func (r record) average(total float64) float64 {
+	if r.played == 0 {
+		return 0
+	}
+	return total / float64(r.played)
+}
+
+// Compute ranks the teams in an event's qualification matches. Only matches
+// with both scores count, but every team in the schedule is ranked. Teams are
+// ordered by ranking score, then each tiebreaker, and then team number. If no
+// qualification matches have been scheduled, nil is returned.
+func Compute(eventKey string, matches []store.Match, rules Rules) []store.EventTeam {
+	records := make(map[string]*record)
+	get := func(team string) *record {
+		if r, ok := records[team]; ok {
+			return r
+		}
+		r := &record{key: team, tiebreakers: make([]float64, len(rules.Tiebreakers))}
+		records[team] = r
+		return r
+	}
+
+	for _, m := range matches {
+		if !strings.HasPrefix(m.Key, "qm") || m.TBADeleted {
+			continue
+		}
+
+		for _, team := range append(append([]string{}, m.RedAlliance...), m.BlueAlliance...) {
+			get(team)
+		}
+
+		if m.RedScore == nil || m.BlueScore == nil {
+			continue
+		}
+
+		alliances := []struct {
+			teams           []string
+			score, opponent int
+			breakdown       store.ScoreBreakdown
+		}{
+			{m.RedAlliance, *m.RedScore, *m.BlueScore, m.RedScoreBreakdown},
+			{m.BlueAlliance, *m.BlueScore, *m.RedScore, m.BlueScoreBreakdown},
+		}
+
+		for _, alliance := range alliances {
+			rp := bonusRP(alliance.breakdown, rules.BonusRP)
+			if alliance.score > alliance.opponent {
+				rp += rules.WinRP
+			} else if alliance.score == alliance.opponent {
+				rp += rules.TieRP
+			}
+
+			for _, team := range alliance.teams {
+				r := get(team)
+				r.played++
+				r.rp += rp
+
+				switch {
+				case alliance.score > alliance.opponent:
+					r.wins++
+				case alliance.score < alliance.opponent:
+					r.losses++
+				default:
+					r.ties++
+				}
+
+				for i, tiebreaker := range rules.Tiebreakers {
+					r.tiebreakers[i] += tiebreakerValue(alliance.breakdown, alliance.score, tiebreaker)
+				}
+			}
+		}
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	sorted := make([]*record, 0, len(records))
+	for _, r := range records {
+		sorted = append(sorted, r)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.average(float64(a.rp)) != b.average(float64(b.rp)) {
+			return a.average(float64(a.rp)) > b.average(float64(b.rp))
+		}
+		for k := range rules.Tiebreakers {
+			if a.average(a.tiebreakers[k]) != b.average(b.tiebreakers[k]) {
+				return a.average(a.tiebreakers[k]) > b.average(b.tiebreakers[k])
+			}
+		}
+		if na, nb := teamNumber(a.key), teamNumber(b.key); na != nb {
+			return na < nb
+		}
+		return a.key < b.key
+	})
+
+	teams := make([]store.EventTeam, 0, len(sorted))
+	for i, r := range sorted {
+		rank := i + 1
+		rankingScore := r.average(float64(r.rp))
+		wins, losses, ties, played, dqs := r.wins, r.losses, r.ties, r.played, 0
+
+		sortOrders := store.SortOrders{{Name: RankingScore, Value: rankingScore, Precision: sortOrderPrecision}}
+		for k, tiebreaker := range rules.Tiebreakers {
+			sortOrders = append(sortOrders, store.SortOrder{
+				Name:      tiebreaker.Name,
+				Value:     r.average(r.tiebreakers[k]),
+				Precision: sortOrderPrecision,
+			})
+		}
+
+		teams = append(teams, store.EventTeam{
+			Key:           r.key,
+			EventKey:      eventKey,
+			Rank:          &rank,
+			RankingScore:  &rankingScore,
+			Wins:          &wins,
+			Losses:        &losses,
+			Ties:          &ties,
+			DQs:           &dqs,
+			MatchesPlayed: &played,
+			SortOrders:    sortOrders,
+		})
+	}
+
+	return teams
+}
+
+// bonusRP counts the bonus ranking point flags set in a score breakdown.
+func bonusRP(breakdown store.ScoreBreakdown, fields []string) int {
+	rp := 0
+	for _, field := range fields {
+		switch v := breakdown[field].(type) {
+		case bool:
+			if v {
+				rp++
+			}
+		case float64:
+			if v != 0 {
+				rp++
+			}
+		}
+	}
+	return rp
+}
+
+// tiebreakerValue is the value of a tiebreaker for an alliance in a single
+// match. Fields missing from the breakdown count as zero.
+func tiebreakerValue(breakdown store.ScoreBreakdown, score int, tiebreaker Tiebreaker) float64 {
+	if len(tiebreaker.Fields) == 0 {
+		return float64(score)
+	}
+
+	value := 0.0
+	for _, field := range tiebreaker.Fields {
+		if v, ok := breakdown[field].(float64); ok {
+			value += v
+		}
+	}
+	return value
+}
+
+// teamNumber returns the number of a team key like "frc2733". Keys that don't
+// have a number sort last.
+func teamNumber(key string) int {
+	n, err := strconv.Atoi(strings.TrimRight(strings.TrimPrefix(key, "frc"), "ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+	if err != nil {
+		return int(^uint(0) >> 1)
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+package ranking
+
+import (
+	"testing"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newInt(i int) *int { return &i }
+
+func newFloat64(f float64) *float64 { return &f }
+
+func TestCompute(t *testing.T) {
+	rules := Rules{
+		WinRP:       2,
+		TieRP:       1,
+		BonusRP:     []string{"rocketRP", "climbRP"},
+		Tiebreakers: []Tiebreaker{{Name: "Cargo", Fields: []string{"cargoPoints"}}},
+	}
+
+	matches := []store.Match{
+		{
+			Key:                "qm1",
+			RedAlliance:        []string{"frc1", "frc2"},
+			BlueAlliance:       []string{"frc3", "frc4"},
+			RedScore:           newInt(50),
+			BlueScore:          newInt(40),
+			RedScoreBreakdown:  store.ScoreBreakdown{"rocketRP": true, "cargoPoints": 30.0},
+			BlueScoreBreakdown: store.ScoreBreakdown{"climbRP": true, "cargoPoints": 10.0},
+		},
+		{
+			Key:                "qm2",
+			RedAlliance:        []string{"frc1", "frc3"},
+			BlueAlliance:       []string{"frc2", "frc4"},
+			RedScore:           newInt(20),
+			BlueScore:          newInt(20),
+			RedScoreBreakdown:  store.ScoreBreakdown{"cargoPoints": 6.0},
+			BlueScoreBreakdown: store.ScoreBreakdown{"cargoPoints": 12.0},
+		},
+		{
+			Key:          "qm3",
+			RedAlliance:  []string{"frc1", "frc5"},
+			BlueAlliance: []string{"frc2", "frc3"},
+		},
+		{
+			Key:          "sf1m1",
+			RedAlliance:  []string{"frc1", "frc2"},
+			BlueAlliance: []string{"frc3", "frc4"},
+			RedScore:     newInt(0),
+			BlueScore:    newInt(100),
+		},
+		{
+			Key:          "qm4",
+			RedAlliance:  []string{"frc1", "frc2"},
+			BlueAlliance: []string{"frc3", "frc4"},
+			RedScore:     newInt(0),
+			BlueScore:    newInt(100),
+			TBADeleted:   true,
+		},
+	}
+
+	team := func(key string, rank int, rs float64, w, l, ties, played int, cargo float64) store.EventTeam {
+		return store.EventTeam{
+			Key:           key,
+			EventKey:      "2020orscrim",
+			Rank:          newInt(rank),
+			RankingScore:  newFloat64(rs),
+			Wins:          newInt(w),
+			Losses:        newInt(l),
+			Ties:          newInt(ties),
+			DQs:           newInt(0),
+			MatchesPlayed: newInt(played),
+			SortOrders: store.SortOrders{
+				{Name: RankingScore, Value: rs, Precision: 2},
+				{Name: "Cargo", Value: cargo, Precision: 2},
+			},
+		}
+	}
+
+	want := []store.EventTeam{
+		team("frc2", 1, 2, 1, 0, 1, 2, 21),
+		team("frc1", 2, 2, 1, 0, 1, 2, 18),
+		team("frc4", 3, 1, 0, 1, 1, 2, 11),
+		team("frc3", 4, 1, 0, 1, 1, 2, 8),
+		team("frc5", 5, 0, 0, 0, 0, 0, 0),
+	}
+
+	got := Compute("2020orscrim", matches, rules)
+	if !cmp.Equal(got, want) {
+		t.Errorf("unexpected rankings: %s", cmp.Diff(want, got))
+	}
+}
+
+func TestComputeNoMatches(t *testing.T) {
+	if got := Compute("2020orscrim", nil, DefaultRules); got != nil {
+		t.Errorf("expected no rankings but got %v", got)
+	}
+}
+
+func TestComputeDefaultRules(t *testing.T) {
+	matches := []store.Match{
+		{Key: "qm1", RedAlliance: []string{"frc254"}, BlueAlliance: []string{"frc2733"}, RedScore: newInt(10), BlueScore: newInt(10)},
+		{Key: "qm2", RedAlliance: []string{"frc1425"}, BlueAlliance: []string{"frc2990"}, RedScore: newInt(10), BlueScore: newInt(10)},
+	}
+
+	got := Compute("2021orscrim", matches, RulesForYear(2021))
+
+	var order []string
+	for _, team := range got {
+		order = append(order, team.Key)
+	}
+
+	if want := []string{"frc254", "frc1425", "frc2733", "frc2990"}; !cmp.Equal(order, want) {
+		t.Errorf("expected ties broken by team number but got %v", order)
+	}
+}
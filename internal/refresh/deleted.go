@@ -0,0 +1,77 @@
+package refresh
+
+import (
+	"context"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+)
+
+const (
+	// deleteAfterMisses is how many refreshes in a row an event has to be
+	// missing from TBA before it is marked as deleted.
+	deleteAfterMisses = 2
+
+	// If more than maxMissingFraction of the year's events (and more than
+	// maxMissingAlways of them) vanish from TBA at once the response was
+	// probably truncated or TBA is having a bad day, so nothing is marked.
+	maxMissingFraction = 0.1
+	maxMissingAlways   = 3
+)
+
+// markDeletedEvents marks events that are no longer on TBA as deleted, given
+// the full list of events TBA just returned for the year.
+func (s *Service) markDeletedEvents(ctx context.Context, events []store.Event) {
+	present := make([]string, 0, len(events))
+	for _, event := range events {
+		present = append(present, event.Key)
+	}
+
+	known, err := s.Store.GetTBAEventKeys(ctx, s.Year)
+	if err != nil {
+		s.Logger.WithError(err).Error("unable to get known TBA events")
+		return
+	}
+
+	if missing := missingKeys(known, present); !safeToMarkDeleted(len(present), len(known), len(missing)) {
+		s.Logger.WithField("present", len(present)).
+			WithField("known", len(known)).
+			WithField("missing", len(missing)).
+			Warn("not marking deleted events, TBA events response looks truncated")
+		return
+	}
+
+	deleted, err := s.Store.MarkEventsDeleted(ctx, s.Year, present, deleteAfterMisses)
+	if err != nil {
+		s.Logger.WithError(err).Error("unable to mark deleted events")
+		return
+	}
+
+	if len(deleted) > 0 {
+		s.Logger.WithField("events", deleted).Warn("marked events deleted from TBA")
+	}
+}
+
+// missingKeys returns the keys in known that are not in present.
+func missingKeys(known, present []string) []string {
+	isPresent := make(map[string]bool)
+	for _, key := range present {
+		isPresent[key] = true
+	}
+
+	var missing []string
+	for _, key := range known {
+		if !isPresent[key] {
+			missing = append(missing, key)
+		}
+	}
+
+	return missing
+}
+
+func safeToMarkDeleted(present, known, missing int) bool {
+	if present == 0 {
+		return false
+	}
+
+	return missing <= maxMissingAlways || float64(missing) <= float64(known)*maxMissingFraction
+}
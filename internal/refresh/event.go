@@ -0,0 +1,122 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/Pigmice2733/peregrine-backend/internal/tba"
+)
+
+// EventSummary describes what changed when an event was refreshed on demand.
+type EventSummary struct {
+	EventKey string   `json:"eventKey"`
+	New      []string `json:"new"`
+	Updated  []string `json:"updated"`
+	Deleted  []string `json:"deleted"`
+	Rankings int      `json:"rankings"`
+}
+
+// RefreshEvent immediately fetches the event info, matches, and rankings for a
+// single event from TBA and stores them, ignoring any cached ETags so score
+// corrections are always picked up. If TBA doesn't know about the event an
+// error wrapping tba.ErrNotFound is returned.
+func (s *Service) RefreshEvent(ctx context.Context, eventKey string) (EventSummary, error) {
+	ctx = tba.WithoutCache(ctx)
+
+	summary := EventSummary{
+		EventKey: eventKey,
+		New:      make([]string, 0),
+		Updated:  make([]string, 0),
+		Deleted:  make([]string, 0),
+	}
+
+	event, err := s.TBA.GetEvent(ctx, eventKey)
+	if err != nil {
+		s.status.failure(StageEvents, err)
+		return summary, fmt.Errorf("unable to get event from TBA: %w", err)
+	}
+
+	if err := s.Store.EventsUpsert(ctx, []store.Event{event}); err != nil {
+		s.status.failure(StageEvents, err)
+		return summary, fmt.Errorf("unable to upsert event: %w", err)
+	}
+	s.status.success(StageEvents, 1)
+
+	matches, err := s.TBA.GetMatches(ctx, eventKey)
+	if err != nil {
+		s.status.failure(StageMatches, err)
+		return summary, fmt.Errorf("unable to get matches from TBA: %w", err)
+	}
+
+	existing, err := s.Store.GetMatchesForRealm(ctx, eventKey, nil, true, nil)
+	if err != nil {
+		s.status.failure(StageMatches, err)
+		return summary, fmt.Errorf("unable to get existing matches: %w", err)
+	}
+
+	summary.New, summary.Updated, summary.Deleted = diffMatches(existing, matches)
+
+	if err := s.Store.UpdateTBAMatches(ctx, matches); err != nil {
+		s.status.failure(StageMatches, err)
+		return summary, fmt.Errorf("unable to upsert matches: %w", err)
+	}
+
+	if err := s.Store.MarkMatchesDeleted(ctx, eventKey, matches); err != nil {
+		s.status.failure(StageMatches, err)
+		return summary, fmt.Errorf("unable to mark deleted matches: %w", err)
+	}
+	s.status.success(StageMatches, len(matches))
+
+	rankings, err := s.TBA.GetTeamRankings(ctx, eventKey)
+	if err != nil {
+		s.status.failure(StageRankings, err)
+		return summary, fmt.Errorf("unable to get rankings from TBA: %w", err)
+	}
+
+	if err := s.Store.EventTeamsUpsert(ctx, rankings); err != nil {
+		s.status.failure(StageRankings, err)
+		return summary, fmt.Errorf("unable to upsert rankings: %w", err)
+	}
+	s.status.success(StageRankings, len(rankings))
+
+	summary.Rankings = len(rankings)
+
+	return summary, nil
+}
+
+// diffMatches compares the matches currently stored for an event with the
+// matches just retrieved from TBA, and returns the keys of matches that are
+// new, that changed, and that TBA no longer has.
+func diffMatches(existing, fetched []store.Match) (added, updated, deleted []string) {
+	added, updated, deleted = make([]string, 0), make([]string, 0), make([]string, 0)
+
+	stored := make(map[string]store.Match)
+	for _, m := range existing {
+		stored[m.Key] = m
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range fetched {
+		seen[m.Key] = true
+
+		old, ok := stored[m.Key]
+		if !ok {
+			added = append(added, m.Key)
+		} else if old.TBADeleted || matchChanged(old, m) {
+			updated = append(updated, m.Key)
+		}
+	}
+
+	for _, m := range existing {
+		if !seen[m.Key] && !m.TBADeleted {
+			deleted = append(deleted, m.Key)
+		}
+	}
+
+	return added, updated, deleted
+}
+
+func matchChanged(a, b store.Match) bool {
+	return len(store.MatchChanges(a, b)) > 0
+}
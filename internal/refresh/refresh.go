@@ -5,6 +5,7 @@ package refresh
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/Pigmice2733/peregrine-backend/internal/store"
@@ -12,12 +13,92 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Default intervals used when a Service's intervals are unset.
+const (
+	DefaultEventsInterval = time.Minute * 15
+	DefaultActiveInterval = time.Second * 30
+	DefaultTeamsInterval  = time.Hour * 24
+)
+
 // Service updates the store by polling TBA for the current year.
 type Service struct {
 	TBA    *tba.Service
 	Store  *store.Service
 	Logger *logrus.Logger
 	Year   int
+
+	// EventsInterval is how often all events for the year (and their matches
+	// and rankings) are refreshed. ActiveInterval is how often matches and
+	// rankings for events happening right now are refreshed. TeamsInterval is
+	// how often the list of all teams is refreshed.
+	EventsInterval time.Duration
+	ActiveInterval time.Duration
+	TeamsInterval  time.Duration
+
+	// Events and Districts restrict refreshing to events with the given keys or
+	// in the given districts (by abbreviation, e.g. "pnw"). If both are empty
+	// every event in the year is refreshed.
+	Events    []string
+	Districts []string
+
+	status statusTracker
+}
+
+// Status returns the health of each stage of the refresh pipeline.
+func (s *Service) Status() Status {
+	return s.status.snapshot()
+}
+
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+
+	return d
+}
+
+// scoped returns whether refreshing is restricted to specific events or districts.
+func (s *Service) scoped() bool {
+	return len(s.Events) > 0 || len(s.Districts) > 0
+}
+
+// filterEvents returns the events that are in the configured scope.
+func (s *Service) filterEvents(events []store.Event) []store.Event {
+	if !s.scoped() {
+		return events
+	}
+
+	var filtered []store.Event
+	for _, event := range events {
+		if s.eventInScope(event) {
+			filtered = append(filtered, event)
+		}
+	}
+
+	return filtered
+}
+
+// eventInScope returns whether the event is in the configured scope.
+func (s *Service) eventInScope(event store.Event) bool {
+	if !s.scoped() {
+		return true
+	}
+
+	for _, key := range s.Events {
+		if event.Key == key {
+			return true
+		}
+	}
+
+	if event.District != nil {
+		for _, district := range s.Districts {
+			if strings.EqualFold(*event.District, district) {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 type eventMatches struct {
@@ -26,21 +107,19 @@ type eventMatches struct {
 }
 
 // Run starts the TBA updater service that will:
-// * Update all events for the configured year, including matches, and rankings, every 15 minutes.
-// * Update all teams every day.
-// * Update all active event matches and rankings every 15 seconds.
+// * Update all events for the configured year, including matches, and rankings, every EventsInterval.
+// * Update all teams every TeamsInterval.
+// * Update all active event matches and rankings every ActiveInterval.
 func (s *Service) Run(ctx context.Context) {
-	const (
-		eventsInterval = time.Minute * 15
-		activeInterval = time.Second * 30
-		teamsInterval  = time.Hour * 24
-	)
+	eventsInterval := durationOrDefault(s.EventsInterval, DefaultEventsInterval)
+	activeInterval := durationOrDefault(s.ActiveInterval, DefaultActiveInterval)
+	teamsInterval := durationOrDefault(s.TeamsInterval, DefaultTeamsInterval)
 
 	events := make(chan []store.Event)
 	storeEvents := make(chan []store.Event)
 	matchEvents := make(chan string)
 	rankingEvents := make(chan string)
-	activeEvents := make(chan string)
+	activeEvents := make(chan []string)
 
 	go func() {
 		defer func() {
@@ -51,10 +130,14 @@ func (s *Service) Run(ctx context.Context) {
 
 		for {
 			select {
-			case event := <-activeEvents:
-				matchEvents <- event
-				rankingEvents <- event
+			case eventKeys := <-activeEvents:
+				s.status.startCycle(StageMatches, StageRankings)
+				for _, event := range eventKeys {
+					matchEvents <- event
+					rankingEvents <- event
+				}
 			case eventGroup := <-events:
+				s.status.startCycle(StageEvents, StageMatches, StageRankings)
 				storeEvents <- eventGroup
 				for _, event := range eventGroup {
 					matchEvents <- event.Key
@@ -99,12 +182,19 @@ func (s *Service) fetchEvents(ctx context.Context, interval time.Duration, event
 
 		tbaEvents, err := s.TBA.GetEvents(timeoutContext, s.Year)
 		if errors.Is(err, tba.ErrNotModified{}) {
+			s.status.notModified(StageEvents)
+			return
+		} else if errors.Is(err, tba.ErrCircuitOpen{}) {
+			s.Logger.WithError(err).Debug("skipping TBA request while circuit breaker is open")
 			return
 		} else if err != nil {
+			s.status.failure(StageEvents, err)
 			s.Logger.WithError(err).Errorf("unable get events from TBA for year %d", s.Year)
 			return
 		}
 
+		tbaEvents = s.filterEvents(tbaEvents)
+
 		events <- tbaEvents
 
 		s.Logger.WithField("year", s.Year).WithField("count", len(tbaEvents)).Info("sent year events")
@@ -121,7 +211,7 @@ func (s *Service) fetchEvents(ctx context.Context, interval time.Duration, event
 	}
 }
 
-func (s *Service) seedActiveEvents(ctx context.Context, interval time.Duration, events chan<- string) {
+func (s *Service) seedActiveEvents(ctx context.Context, interval time.Duration, events chan<- []string) {
 	const timeout = time.Second * 10
 
 	activeTicker := time.NewTicker(interval)
@@ -141,11 +231,16 @@ func (s *Service) seedActiveEvents(ctx context.Context, interval time.Duration,
 			return
 		}
 
+		var eventKeys []string
 		for _, event := range activeEvents {
-			events <- event
+			if s.eventInScope(event) {
+				eventKeys = append(eventKeys, event.Key)
+			}
 		}
 
-		s.Logger.WithField("count", len(activeEvents)).Info("sent active events")
+		events <- eventKeys
+
+		s.Logger.WithField("count", len(eventKeys)).Info("sent active events")
 	}
 
 	getEvents()
@@ -168,11 +263,20 @@ func (s *Service) storeEvents(ctx context.Context, events <-chan []store.Event)
 
 		err := s.Store.EventsUpsert(timeoutContext, eventGroup)
 		if err != nil {
+			s.status.failure(StageEvents, err)
 			s.Logger.WithError(err).Errorf("unable to upsert events")
 			return
 		}
 
+		s.status.success(StageEvents, len(eventGroup))
+
 		s.Logger.WithField("count", len(eventGroup)).Info("stored events")
+
+		// A scoped refresh only sees some of the year's events, so it can't
+		// tell which ones TBA deleted.
+		if !s.scoped() {
+			s.markDeletedEvents(timeoutContext, eventGroup)
+		}
 	}
 
 	for eventGroup := range events {
@@ -196,14 +300,20 @@ func (s *Service) fetchTeams(ctx context.Context, interval time.Duration, teams
 
 		tbaTeams, err := s.TBA.GetTeams(timeoutContext)
 		if errors.Is(err, tba.ErrNotModified{}) {
+			s.status.notModified(StageTeams)
+			return
+		} else if errors.Is(err, tba.ErrCircuitOpen{}) {
+			s.Logger.WithError(err).Debug("skipping TBA request while circuit breaker is open")
 			return
 		} else if err != nil {
+			s.status.failure(StageTeams, err)
 			s.Logger.WithError(err).Errorf("unable get teams from TBA")
 			return
 		}
 
 		s.Logger.WithField("count", len(tbaTeams)).Info("sent teams")
 
+		s.status.startCycle(StageTeams)
 		teams <- tbaTeams
 	}
 
@@ -227,10 +337,13 @@ func (s *Service) storeTeams(ctx context.Context, teams <-chan []store.Team) {
 
 		err := s.Store.TeamsUpsert(timeoutContext, teamsGroup)
 		if err != nil {
+			s.status.failure(StageTeams, err)
 			s.Logger.WithError(err).Errorf("unable to upsert teams")
 			return
 		}
 
+		s.status.success(StageTeams, len(teamsGroup))
+
 		s.Logger.WithField("count", len(teamsGroup)).Info("stored teams")
 	}
 
@@ -252,8 +365,13 @@ func (s *Service) fetchMatches(ctx context.Context, events <-chan string, matche
 
 		tbaMatches, err := s.TBA.GetMatches(timeoutContext, eventKey)
 		if errors.Is(err, tba.ErrNotModified{}) {
+			s.status.notModified(StageMatches)
+			return
+		} else if errors.Is(err, tba.ErrCircuitOpen{}) {
+			s.Logger.WithError(err).Debug("skipping TBA request while circuit breaker is open")
 			return
 		} else if err != nil {
+			s.status.failure(StageMatches, err)
 			s.Logger.WithError(err).Errorf("unable get matches from TBA for event %q", eventKey)
 			return
 		}
@@ -280,16 +398,20 @@ func (s *Service) storeMatches(ctx context.Context, matches <-chan eventMatches)
 
 		err := s.Store.UpdateTBAMatches(timeoutContext, m.Matches)
 		if err != nil {
+			s.status.failure(StageMatches, err)
 			s.Logger.WithError(err).Errorf("unable to upsert matches")
 			return
 		}
 
 		err = s.Store.MarkMatchesDeleted(ctx, m.EventKey, m.Matches)
 		if err != nil {
+			s.status.failure(StageMatches, err)
 			s.Logger.WithError(err).Errorf("unable to mark matches deleted matches")
 			return
 		}
 
+		s.status.success(StageMatches, len(m.Matches))
+
 		s.Logger.WithField("count", len(m.Matches)).Info("stored matches")
 	}
 
@@ -311,8 +433,13 @@ func (s *Service) fetchRankings(ctx context.Context, eventKeys <-chan string, ra
 
 		tbaRankings, err := s.TBA.GetTeamRankings(timeoutContext, eventKey)
 		if errors.Is(err, tba.ErrNotModified{}) {
+			s.status.notModified(StageRankings)
+			return
+		} else if errors.Is(err, tba.ErrCircuitOpen{}) {
+			s.Logger.WithError(err).Debug("skipping TBA request while circuit breaker is open")
 			return
 		} else if err != nil {
+			s.status.failure(StageRankings, err)
 			s.Logger.WithError(err).Errorf("unable get rankings from TBA for event %q", eventKey)
 			return
 		}
@@ -336,10 +463,13 @@ func (s *Service) storeRankings(ctx context.Context, rankings <-chan []store.Eve
 
 		err := s.Store.EventTeamsUpsert(timeoutContext, rankingGroup)
 		if err != nil {
+			s.status.failure(StageRankings, err)
 			s.Logger.WithError(err).Errorf("unable to upsert rankings")
 			return
 		}
 
+		s.status.success(StageRankings, len(rankingGroup))
+
 		s.Logger.WithField("count", len(rankingGroup)).Info("stored rankings")
 	}
 
@@ -0,0 +1,175 @@
+package refresh
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilterEvents(t *testing.T) {
+	pnw, fim := "pnw", "fim"
+
+	events := []store.Event{
+		{Key: "2019orwil", District: &pnw},
+		{Key: "2019miket", District: &fim},
+		{Key: "2019cmptx"},
+	}
+
+	testCases := []struct {
+		name      string
+		events    []string
+		districts []string
+		want      []string
+		inScope   map[string]bool
+	}{
+		{
+			name:    "no scope",
+			want:    []string{"2019orwil", "2019miket", "2019cmptx"},
+			inScope: map[string]bool{"2019orwil": true, "2019miket": true, "2019cmptx": true, "2019wasno": true},
+		},
+		{
+			name:    "event whitelist",
+			events:  []string{"2019cmptx"},
+			want:    []string{"2019cmptx"},
+			inScope: map[string]bool{"2019orwil": false, "2019miket": false, "2019cmptx": true},
+		},
+		{
+			name:      "district whitelist",
+			districts: []string{"PNW"},
+			want:      []string{"2019orwil"},
+			inScope:   map[string]bool{"2019orwil": true, "2019miket": false, "2019cmptx": false},
+		},
+		{
+			name:      "event and district whitelist",
+			events:    []string{"2019cmptx"},
+			districts: []string{"fim"},
+			want:      []string{"2019miket", "2019cmptx"},
+			inScope:   map[string]bool{"2019orwil": false, "2019miket": true, "2019cmptx": true},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{Events: tt.events, Districts: tt.districts}
+
+			var got []string
+			for _, event := range s.filterEvents(events) {
+				got = append(got, event.Key)
+			}
+
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("filtered events differ: %s", cmp.Diff(tt.want, got))
+			}
+
+			for _, event := range append(events, store.Event{Key: "2019wasno", District: &pnw}) {
+				want, ok := tt.inScope[event.Key]
+				if !ok {
+					continue
+				}
+
+				if got := s.eventInScope(event); got != want {
+					t.Errorf("expected eventInScope(%q) to be %v but got %v", event.Key, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	var s Service
+
+	s.status.success(StageEvents, 3)
+	s.status.success(StageRankings, 4)
+	s.status.startCycle(StageRankings)
+	s.status.success(StageRankings, 2)
+	s.status.success(StageRankings, 5)
+	s.status.failure(StageMatches, errors.New("boom"))
+	s.status.failure(StageMatches, errors.New("bang"))
+	s.status.notModified(StageRankings)
+
+	status := s.Status()
+
+	if len(status) != 4 {
+		t.Errorf("expected all 4 stages to be reported but got %d", len(status))
+	}
+
+	if events := status[StageEvents]; events.LastSuccess == nil || events.Items != 3 || events.Errors != 0 {
+		t.Errorf("unexpected events status: %+v", events)
+	}
+
+	if matches := status[StageMatches]; matches.LastFailure == nil || matches.LastError != "bang" || matches.Errors != 2 {
+		t.Errorf("unexpected matches status: %+v", matches)
+	}
+
+	if rankings := status[StageRankings]; rankings.LastSuccess == nil || rankings.Items != 7 {
+		t.Errorf("unexpected rankings status: %+v", rankings)
+	}
+
+	if teams := status[StageTeams]; teams.LastSuccess != nil || teams.LastFailure != nil {
+		t.Errorf("expected teams stage to be untouched but got %+v", teams)
+	}
+}
+
+func TestDiffMatches(t *testing.T) {
+	one, two := 1, 2
+	now := time.Now()
+
+	existing := []store.Match{
+		{Key: "qm1", RedScore: &one, ScheduledTime: &now, RedAlliance: []string{"frc1"}},
+		{Key: "qm2", RedScore: &one, RedScoreBreakdown: store.ScoreBreakdown{"points": 1.0}},
+		{Key: "qm3"},
+		{Key: "qm4", TBADeleted: true},
+		{Key: "qm5", TBADeleted: true},
+	}
+
+	fetched := []store.Match{
+		{Key: "qm1", RedScore: &one, ScheduledTime: newTime(now.UTC()), RedAlliance: []string{"frc1"}, RedScoreBreakdown: store.ScoreBreakdown{}},
+		{Key: "qm2", RedScore: &two, RedScoreBreakdown: store.ScoreBreakdown{"points": 2.0}},
+		{Key: "qm4"},
+		{Key: "qm6"},
+	}
+
+	added, updated, deleted := diffMatches(existing, fetched)
+
+	if want := []string{"qm6"}; !cmp.Equal(added, want) {
+		t.Errorf("added matches differ: %s", cmp.Diff(want, added))
+	}
+
+	if want := []string{"qm2", "qm4"}; !cmp.Equal(updated, want) {
+		t.Errorf("updated matches differ: %s", cmp.Diff(want, updated))
+	}
+
+	if want := []string{"qm3"}; !cmp.Equal(deleted, want) {
+		t.Errorf("deleted matches differ: %s", cmp.Diff(want, deleted))
+	}
+}
+
+func newTime(t time.Time) *time.Time {
+	return &t
+}
+
+func TestSafeToMarkDeleted(t *testing.T) {
+	testCases := []struct {
+		name                    string
+		present, known, missing int
+		want                    bool
+	}{
+		{name: "empty response", present: 0, known: 100, missing: 100, want: false},
+		{name: "nothing missing", present: 100, known: 100, missing: 0, want: true},
+		{name: "a few missing", present: 8, known: 10, missing: 2, want: true},
+		{name: "small fraction missing", present: 190, known: 200, missing: 10, want: true},
+		{name: "truncated response", present: 50, known: 200, missing: 150, want: false},
+		{name: "brand new year", present: 150, known: 0, missing: 0, want: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := safeToMarkDeleted(tt.present, tt.known, tt.missing); got != tt.want {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package refresh
+
+import (
+	"sync"
+	"time"
+)
+
+// Pipeline stages reported in Status.
+const (
+	StageEvents   = "events"
+	StageTeams    = "teams"
+	StageMatches  = "matches"
+	StageRankings = "rankings"
+)
+
+// StageStatus describes the health of a single refresh pipeline stage.
+type StageStatus struct {
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	Errors      int        `json:"errors"`
+	// Items is how many items the stage handled in its latest refresh cycle.
+	Items int `json:"items"`
+}
+
+// Status describes the health of every refresh pipeline stage, keyed by stage name.
+type Status map[string]StageStatus
+
+type statusTracker struct {
+	mu     sync.Mutex
+	stages Status
+	// fresh holds the stages that started a new cycle since they last
+	// succeeded, so their item counts start over.
+	fresh map[string]bool
+}
+
+func (st *statusTracker) update(stage string, f func(ss *StageStatus)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.stages == nil {
+		st.stages = make(Status)
+	}
+
+	ss := st.stages[stage]
+	f(&ss)
+	st.stages[stage] = ss
+}
+
+// startCycle records that the given stages are about to handle a new batch of
+// events, so the items they handle next are counted from zero.
+func (st *statusTracker) startCycle(stages ...string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.fresh == nil {
+		st.fresh = make(map[string]bool)
+	}
+
+	for _, stage := range stages {
+		st.fresh[stage] = true
+	}
+}
+
+// success records a successful run of a stage that handled the given number of
+// items, adding them to the items handled in the current cycle.
+func (st *statusTracker) success(stage string, items int) {
+	now := time.Now()
+	st.update(stage, func(ss *StageStatus) {
+		ss.LastSuccess = &now
+		if st.fresh[stage] {
+			ss.Items = 0
+			delete(st.fresh, stage)
+		}
+		ss.Items += items
+	})
+}
+
+// notModified records a successful run of a stage where TBA had nothing new.
+func (st *statusTracker) notModified(stage string) {
+	now := time.Now()
+	st.update(stage, func(ss *StageStatus) {
+		ss.LastSuccess = &now
+	})
+}
+
+func (st *statusTracker) failure(stage string, err error) {
+	now := time.Now()
+	st.update(stage, func(ss *StageStatus) {
+		ss.LastFailure = &now
+		ss.LastError = err.Error()
+		ss.Errors++
+	})
+}
+
+func (st *statusTracker) snapshot() Status {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	status := Status{
+		StageEvents:   {},
+		StageTeams:    {},
+		StageMatches:  {},
+		StageRankings: {},
+	}
+
+	for stage, ss := range st.stages {
+		status[stage] = ss
+	}
+
+	return status
+}
@@ -0,0 +1,397 @@
+// Package schedule generates qualification schedules for events that don't get
+// one from TBA, like off-season events a realm runs itself. Schedules are built
+// one match at a time, always picking the teams that have played least and
+// rested longest, and then mixing up partners, opponents, and stations as much
+// as possible. Many random attempts are made and the best schedule is kept.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// DefaultAllianceSize is the number of teams per alliance in FRC.
+const DefaultAllianceSize = 3
+
+// defaultAttempts is how many schedules are generated to pick the best from.
+const defaultAttempts = 100
+
+// Weights of the different kinds of imbalance when comparing schedules.
+// Playing with the same partner again is worse than facing the same opponent.
+const (
+	partnerWeight  = 3
+	opponentWeight = 1
+	colorWeight    = 1
+	stationWeight  = 1
+)
+
+// Options configures schedule generation.
+type Options struct {
+	// MatchesPerTeam is how many matches each team plays. If the teams don't
+	// evenly fill the matches, some teams play one extra match.
+	MatchesPerTeam int
+
+	// MinTurnaround is the minimum number of matches a team sits out between
+	// any two of its matches.
+	MinTurnaround int
+
+	// AllianceSize is the number of teams on each alliance. If zero,
+	// DefaultAllianceSize is used.
+	AllianceSize int
+
+	// Attempts is how many schedules are generated to pick the best from. If
+	// zero, a default is used.
+	Attempts int
+
+	// Seed seeds the random source, so the same options and teams always give
+	// the same schedule.
+	Seed int64
+}
+
+// Match is a single generated qualification match. Alliances are listed in
+// station order.
+type Match struct {
+	Key          string
+	RedAlliance  []string
+	BlueAlliance []string
+}
+
+// ErrUnschedulable is returned when no schedule could be found that gives
+// every team its matches while respecting the minimum turnaround.
+var ErrUnschedulable = errors.New("no schedule satisfies the minimum turnaround")
+
+// Generate generates a qualification schedule for the given teams.
+func Generate(teams []string, opts Options) ([]Match, error) {
+	if opts.AllianceSize == 0 {
+		opts.AllianceSize = DefaultAllianceSize
+	}
+	if opts.Attempts == 0 {
+		opts.Attempts = defaultAttempts
+	}
+
+	if opts.AllianceSize < 1 {
+		return nil, errors.New("alliance size must be positive")
+	}
+	if opts.MatchesPerTeam < 1 {
+		return nil, errors.New("teams must play at least one match")
+	}
+	if opts.MinTurnaround < 0 {
+		return nil, errors.New("minimum turnaround can't be negative")
+	}
+	if len(teams) < 2*opts.AllianceSize {
+		return nil, fmt.Errorf("at least %d teams are needed", 2*opts.AllianceSize)
+	}
+
+	seen := make(map[string]bool)
+	for _, team := range teams {
+		if seen[team] {
+			return nil, fmt.Errorf("team %s is listed more than once", team)
+		}
+		seen[team] = true
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+
+	var best []Match
+	bestCost := -1
+	for i := 0; i < opts.Attempts; i++ {
+		g := newGenerator(teams, opts, rng)
+		if !g.generate() {
+			continue
+		}
+
+		if cost := g.cost(); bestCost == -1 || cost < bestCost {
+			best, bestCost = g.matches, cost
+		}
+	}
+
+	if best == nil {
+		return nil, ErrUnschedulable
+	}
+
+	return best, nil
+}
+
+// generator holds the state of a single attempt at generating a schedule.
+// Teams are referred to by their index in teams.
+type generator struct {
+	teams []string
+	opts  Options
+	rng   *rand.Rand
+
+	matches []Match
+
+	played    []int
+	lastMatch []int
+	partners  [][]int
+	opponents [][]int
+	red       []int
+	stations  [][]int
+}
+
+func newGenerator(teams []string, opts Options, rng *rand.Rand) *generator {
+	g := &generator{
+		teams:     teams,
+		opts:      opts,
+		rng:       rng,
+		played:    make([]int, len(teams)),
+		lastMatch: make([]int, len(teams)),
+		partners:  make([][]int, len(teams)),
+		opponents: make([][]int, len(teams)),
+		red:       make([]int, len(teams)),
+		stations:  make([][]int, len(teams)),
+	}
+
+	for i := range teams {
+		g.lastMatch[i] = -opts.MinTurnaround - 1
+		g.partners[i] = make([]int, len(teams))
+		g.opponents[i] = make([]int, len(teams))
+		g.stations[i] = make([]int, opts.AllianceSize)
+	}
+
+	return g
+}
+
+// generate fills in the matches, returning false if the attempt got stuck or
+// some team didn't get all its matches.
+func (g *generator) generate() bool {
+	perMatch := 2 * g.opts.AllianceSize
+	numMatches := (len(g.teams)*g.opts.MatchesPerTeam + perMatch - 1) / perMatch
+
+	for m := 0; m < numMatches; m++ {
+		picked := g.pick(m)
+		if picked == nil {
+			return false
+		}
+
+		red, blue := g.split(picked)
+		red, blue = g.arrange(red), g.arrange(blue)
+		g.record(m, red, blue)
+	}
+
+	for _, played := range g.played {
+		if played < g.opts.MatchesPerTeam || played > g.opts.MatchesPerTeam+1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pick chooses the teams for match m. Only teams that have sat out long enough
+// are considered, those that have played least go first, and among equals the
+// ones that would repeat the fewest pairings with the teams already picked are
+// preferred. Returns nil if not enough teams are available.
+func (g *generator) pick(m int) []int {
+	var available []int
+	for i := range g.teams {
+		if m-g.lastMatch[i] > g.opts.MinTurnaround {
+			available = append(available, i)
+		}
+	}
+
+	perMatch := 2 * g.opts.AllianceSize
+	if len(available) < perMatch {
+		return nil
+	}
+
+	g.rng.Shuffle(len(available), func(i, j int) {
+		available[i], available[j] = available[j], available[i]
+	})
+	sort.SliceStable(available, func(i, j int) bool {
+		return g.played[available[i]] < g.played[available[j]]
+	})
+
+	picked := make([]int, 0, perMatch)
+	for len(picked) < perMatch {
+		// Teams that have played the same number of matches as the first
+		// available team are interchangeable as far as fairness goes.
+		tier := 1
+		for tier < len(available) && g.played[available[tier]] == g.played[available[0]] {
+			tier++
+		}
+
+		choice := 0
+		if len(picked) > 0 {
+			bestRepeats := -1
+			for i := 0; i < tier; i++ {
+				repeats := 0
+				for _, other := range picked {
+					repeats += g.partners[available[i]][other] + g.opponents[available[i]][other]
+				}
+				if bestRepeats == -1 || repeats < bestRepeats {
+					choice, bestRepeats = i, repeats
+				}
+			}
+		}
+
+		picked = append(picked, available[choice])
+		available = append(available[:choice], available[choice+1:]...)
+	}
+
+	return picked
+}
+
+// split divides the picked teams into red and blue alliances with the fewest
+// repeated partners and opponents, while evening out how often each team has
+// been on each color.
+func (g *generator) split(picked []int) (red, blue []int) {
+	size := g.opts.AllianceSize
+	bestCost := -1
+
+	var search func(start int, chosen []int)
+	search = func(start int, chosen []int) {
+		if len(chosen) == size {
+			r := append([]int{}, chosen...)
+			var b []int
+			for _, team := range picked {
+				if !contains(r, team) {
+					b = append(b, team)
+				}
+			}
+
+			if cost := g.splitCost(r, b); bestCost == -1 || cost < bestCost {
+				red, blue, bestCost = r, b, cost
+			}
+			return
+		}
+
+		for i := start; i < len(picked); i++ {
+			search(i+1, append(chosen, picked[i]))
+		}
+	}
+	search(0, nil)
+
+	return red, blue
+}
+
+// splitCost is how much putting red and blue against each other would add to
+// the cost of the schedule.
+func (g *generator) splitCost(red, blue []int) int {
+	cost := 0
+	for _, alliance := range [][]int{red, blue} {
+		for i, a := range alliance {
+			for _, b := range alliance[i+1:] {
+				cost += partnerWeight * (2*g.partners[a][b] + 1)
+			}
+		}
+	}
+
+	for _, a := range red {
+		for _, b := range blue {
+			cost += opponentWeight * (2*g.opponents[a][b] + 1)
+		}
+	}
+
+	for _, team := range red {
+		cost += colorWeight * (2*g.colorImbalance(team) + 1)
+	}
+	for _, team := range blue {
+		cost += colorWeight * (1 - 2*g.colorImbalance(team))
+	}
+
+	return cost
+}
+
+// colorImbalance is how many more times a team has been on red than blue.
+func (g *generator) colorImbalance(team int) int {
+	return 2*g.red[team] - g.played[team]
+}
+
+// arrange orders an alliance so teams play the stations they've played least.
+func (g *generator) arrange(alliance []int) []int {
+	best := append([]int{}, alliance...)
+	bestCost := -1
+
+	var permute func(k int)
+	permute = func(k int) {
+		if k == len(alliance) {
+			cost := 0
+			for station, team := range alliance {
+				cost += stationWeight * g.stations[team][station]
+			}
+			if bestCost == -1 || cost < bestCost {
+				copy(best, alliance)
+				bestCost = cost
+			}
+			return
+		}
+
+		for i := k; i < len(alliance); i++ {
+			alliance[k], alliance[i] = alliance[i], alliance[k]
+			permute(k + 1)
+			alliance[k], alliance[i] = alliance[i], alliance[k]
+		}
+	}
+	permute(0)
+
+	return best
+}
+
+// record adds match m with the given alliances to the schedule.
+func (g *generator) record(m int, red, blue []int) {
+	match := Match{Key: "qm" + strconv.Itoa(m+1)}
+
+	for _, alliance := range [][]int{red, blue} {
+		for station, team := range alliance {
+			g.played[team]++
+			g.lastMatch[team] = m
+			g.stations[team][station]++
+
+			for _, partner := range alliance {
+				if partner != team {
+					g.partners[team][partner]++
+				}
+			}
+		}
+	}
+
+	for _, a := range red {
+		g.red[a]++
+		match.RedAlliance = append(match.RedAlliance, g.teams[a])
+
+		for _, b := range blue {
+			g.opponents[a][b]++
+			g.opponents[b][a]++
+		}
+	}
+
+	for _, b := range blue {
+		match.BlueAlliance = append(match.BlueAlliance, g.teams[b])
+	}
+
+	g.matches = append(g.matches, match)
+}
+
+// cost scores the finished schedule, lower being better. Repeats are squared
+// so that many pairs meeting twice is better than one pair meeting many times.
+func (g *generator) cost() int {
+	cost := 0
+	for a := range g.teams {
+		for b := a + 1; b < len(g.teams); b++ {
+			cost += partnerWeight * g.partners[a][b] * g.partners[a][b]
+			cost += opponentWeight * g.opponents[a][b] * g.opponents[a][b]
+		}
+
+		imbalance := g.colorImbalance(a)
+		cost += colorWeight * imbalance * imbalance
+
+		for _, n := range g.stations[a] {
+			cost += stationWeight * n * n
+		}
+	}
+
+	return cost
+}
+
+func contains(teams []int, team int) bool {
+	for _, t := range teams {
+		if t == team {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package schedule
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func teamKeys(n int) []string {
+	teams := make([]string, n)
+	for i := range teams {
+		teams[i] = "frc" + strconv.Itoa(i+1)
+	}
+	return teams
+}
+
+func TestGenerate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		teams int
+		opts  Options
+	}{
+		{
+			name:  "even fill",
+			teams: 24,
+			opts:  Options{MatchesPerTeam: 8, MinTurnaround: 2},
+		},
+		{
+			name:  "surrogates",
+			teams: 29,
+			opts:  Options{MatchesPerTeam: 10, MinTurnaround: 3},
+		},
+		{
+			name:  "minimum teams",
+			teams: 6,
+			opts:  Options{MatchesPerTeam: 5},
+		},
+		{
+			name:  "two team alliances",
+			teams: 12,
+			opts:  Options{MatchesPerTeam: 6, MinTurnaround: 1, AllianceSize: 2},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			teams := teamKeys(tt.teams)
+
+			matches, err := Generate(teams, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			allianceSize := tt.opts.AllianceSize
+			if allianceSize == 0 {
+				allianceSize = DefaultAllianceSize
+			}
+
+			wantMatches := (tt.teams*tt.opts.MatchesPerTeam + 2*allianceSize - 1) / (2 * allianceSize)
+			if len(matches) != wantMatches {
+				t.Fatalf("expected %d matches but got %d", wantMatches, len(matches))
+			}
+
+			played := make(map[string]int)
+			red := make(map[string]int)
+			last := make(map[string]int)
+			for m, match := range matches {
+				if match.Key != "qm"+strconv.Itoa(m+1) {
+					t.Errorf("expected match %d to be qm%d but got %s", m, m+1, match.Key)
+				}
+
+				if len(match.RedAlliance) != allianceSize || len(match.BlueAlliance) != allianceSize {
+					t.Errorf("%s has alliances of the wrong size: %v %v", match.Key, match.RedAlliance, match.BlueAlliance)
+				}
+
+				inMatch := make(map[string]bool)
+				for _, team := range append(append([]string{}, match.RedAlliance...), match.BlueAlliance...) {
+					if inMatch[team] {
+						t.Errorf("%s has %s more than once", match.Key, team)
+					}
+					inMatch[team] = true
+
+					if n, ok := last[team]; ok && m-n <= tt.opts.MinTurnaround {
+						t.Errorf("%s plays %s only %d matches after its last", team, match.Key, m-n)
+					}
+					last[team] = m
+					played[team]++
+				}
+
+				for _, team := range match.RedAlliance {
+					red[team]++
+				}
+			}
+
+			for _, team := range teams {
+				if played[team] < tt.opts.MatchesPerTeam || played[team] > tt.opts.MatchesPerTeam+1 {
+					t.Errorf("%s played %d matches", team, played[team])
+				}
+
+				if imbalance := 2*red[team] - played[team]; imbalance < -2 || imbalance > 2 {
+					t.Errorf("%s was red %d of %d matches", team, red[team], played[team])
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+	teams := teamKeys(18)
+	opts := Options{MatchesPerTeam: 6, MinTurnaround: 1, Seed: 2733}
+
+	first, err := Generate(teams, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	second, err := Generate(teams, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !cmp.Equal(first, second) {
+		t.Errorf("expected the same schedule for the same seed: %s", cmp.Diff(first, second))
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		teams []string
+		opts  Options
+	}{
+		{
+			name:  "too few teams",
+			teams: teamKeys(5),
+			opts:  Options{MatchesPerTeam: 5},
+		},
+		{
+			name:  "no matches",
+			teams: teamKeys(12),
+		},
+		{
+			name:  "negative turnaround",
+			teams: teamKeys(12),
+			opts:  Options{MatchesPerTeam: 5, MinTurnaround: -1},
+		},
+		{
+			name:  "duplicate team",
+			teams: append(teamKeys(12), "frc1"),
+			opts:  Options{MatchesPerTeam: 5},
+		},
+		{
+			name:  "turnaround too long",
+			teams: teamKeys(12),
+			opts:  Options{MatchesPerTeam: 5, MinTurnaround: 2, Attempts: 5},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Generate(tt.teams, tt.opts); err == nil {
+				t.Errorf("expected an error but got none")
+			}
+		})
+	}
+}
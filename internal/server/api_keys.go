@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+// createdAPIKey is an API key along with the key itself, which is only ever
+// returned when the key is created.
+type createdAPIKey struct {
+	store.APIKey
+	Key string `json:"key"`
+}
+
+// checkManageAPIKeys returns whether the requesting user can manage a realm's
+// API keys. Super-admins can manage any realm's keys, and users who can manage
+// their realm can manage its keys.
+func checkManageAPIKeys(r *http.Request, realmID int64) error {
+	return checkRealmPermission(r, realmID, store.PermRealmManage)
+}
+
+func (s *Server) getAPIKeysHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkManageAPIKeys(r, realmID); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		keys, err := s.Store.GetRealmAPIKeys(r.Context(), realmID)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting api keys")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, keys, http.StatusOK)
+	}
+}
+
+func (s *Server) createAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkManageAPIKeys(r, realmID); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		var key store.APIKey
+		if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(key); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		secret, prefix, hash, err := ihttp.GenerateAPIKey()
+		if err != nil {
+			s.Logger.WithError(err).Error("generating api key")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		key.RealmID = realmID
+		key.CreatedBy = subjectID
+		key.Prefix = prefix
+		key.Hash = hash
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			if key, err = s.Store.CreateAPIKeyTx(r.Context(), tx, key); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.apiKey.create", store.AuditTargetRealm, auditID(realmID), nil, key)
+		})
+		if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("creating api key")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, createdAPIKey{APIKey: key, Key: secret}, http.StatusCreated)
+	}
+}
+
+func (s *Server) revokeAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkManageAPIKeys(r, realmID); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		keyID, err := strconv.ParseInt(mux.Vars(r)["keyId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.RevokeAPIKeyTx(r.Context(), tx, realmID, keyID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.apiKey.revoke", store.AuditTargetRealm, auditID(realmID), map[string]int64{"apiKeyId": keyID}, nil)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("revoking api key")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
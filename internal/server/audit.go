@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// audit records a change in the audit log using the transaction that made it,
+// so the change is rolled back if it can't be recorded. before and after are
+// marshalled to JSON, and nil means there's nothing to record, like the state
+// before something was created.
+func (s *Server) audit(r *http.Request, tx *sqlx.Tx, realmID *int64, action, targetType, targetID string, before, after interface{}) error {
+	entry := store.AuditEntry{
+		RealmID:    realmID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+
+	if subject, err := ihttp.GetSubject(r); err == nil {
+		entry.ActorID = &subject
+	}
+	if key, ok := ihttp.GetAPIKey(r); ok {
+		entry.APIKeyID = &key.ID
+	}
+
+	var err error
+	if entry.Before, err = auditData(before); err != nil {
+		return fmt.Errorf("unable to marshal %s audit entry: %w", action, err)
+	}
+	if entry.After, err = auditData(after); err != nil {
+		return fmt.Errorf("unable to marshal %s audit entry: %w", action, err)
+	}
+
+	return s.Store.InsertAuditEntryTx(r.Context(), tx, entry)
+}
+
+// auditData marshals a snapshot for the audit log. Users' email addresses are
+// left out, since anyone who can manage the realm can read its audit log.
+func auditData(v interface{}) (store.AuditData, error) {
+	switch u := v.(type) {
+	case store.User:
+		u.Email = nil
+		v = u
+	case *store.User:
+		if u != nil {
+			c := *u
+			c.Email = nil
+			v = c
+		}
+	}
+
+	if v == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(b) == "null" {
+		return nil, nil
+	}
+
+	return b, nil
+}
+
+// auditID formats an integer id as an audit log target id.
+func auditID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// parseAuditFilter reads audit log filters from query parameters.
+func parseAuditFilter(q url.Values) (store.AuditFilter, error) {
+	f := store.AuditFilter{Limit: defaultAuditLimit}
+
+	for _, param := range []struct {
+		name string
+		dst  **int64
+	}{{"realm", &f.RealmID}, {"actor", &f.ActorID}} {
+		if v := q.Get(param.name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s: %w", param.name, err)
+			}
+			*param.dst = &id
+		}
+	}
+
+	if v := q.Get("targetType"); v != "" {
+		switch v {
+		case store.AuditTargetReport, store.AuditTargetUser, store.AuditTargetRealm,
+			store.AuditTargetEvent, store.AuditTargetMatch, store.AuditTargetSchema:
+			f.TargetType = &v
+		default:
+			return f, fmt.Errorf("invalid targetType %q", v)
+		}
+	}
+
+	if v := q.Get("targetId"); v != "" {
+		f.TargetID = &v
+	}
+
+	for _, param := range []struct {
+		name string
+		dst  **time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if v := q.Get(param.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s: %w", param.name, err)
+			}
+			*param.dst = &t
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			return f, fmt.Errorf("limit must be between 1 and %d", maxAuditLimit)
+		}
+		f.Limit = limit
+	}
+
+	return f, nil
+}
+
+// auditHandler returns a handler to search the audit log. Super-admins can
+// search every realm, and everyone else only sees entries for their own
+// realm.
+func (s *Server) auditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditFilter(r.URL.Query())
+		if err != nil {
+			ihttp.Respond(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			realmID, err := ihttp.GetRealmID(r)
+			if err != nil {
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			}
+
+			if filter.RealmID != nil && *filter.RealmID != realmID {
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			}
+
+			filter.RealmID = &realmID
+		}
+
+		entries, err := s.Store.GetAuditEntries(r.Context(), filter)
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("getting audit entries")
+			return
+		}
+
+		ihttp.Respond(w, entries, http.StatusOK)
+	}
+}
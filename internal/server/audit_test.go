@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAuditFilter(t *testing.T) {
+	id := int64(7)
+	targetType := store.AuditTargetReport
+	targetID := "2020orwil/qm1"
+	since := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name    string
+		query   string
+		want    store.AuditFilter
+		wantErr bool
+	}{
+		{
+			name:  "no filters",
+			query: "",
+			want:  store.AuditFilter{Limit: defaultAuditLimit},
+		},
+		{
+			name:  "all filters",
+			query: "realm=7&actor=7&targetType=report&targetId=2020orwil/qm1&since=2020-03-01T00:00:00Z&until=2020-03-01T00:00:00Z&limit=5",
+			want: store.AuditFilter{
+				RealmID:    &id,
+				ActorID:    &id,
+				TargetType: &targetType,
+				TargetID:   &targetID,
+				Since:      &since,
+				Until:      &since,
+				Limit:      5,
+			},
+		},
+		{
+			name:    "invalid actor",
+			query:   "actor=josiah",
+			wantErr: true,
+		},
+		{
+			name:    "unknown target type",
+			query:   "targetType=comment",
+			wantErr: true,
+		},
+		{
+			name:    "invalid time",
+			query:   "since=yesterday",
+			wantErr: true,
+		},
+		{
+			name:    "limit too large",
+			query:   "limit=1001",
+			wantErr: true,
+		},
+		{
+			name:    "limit too small",
+			query:   "limit=0",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("unable to parse query: %v", err)
+			}
+
+			got, err := parseAuditFilter(q)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error but got none")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("unexpected filter: %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestAuditData(t *testing.T) {
+	var nilReport *store.Report
+
+	testCases := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{name: "nil", v: nil, want: ""},
+		{name: "nil pointer", v: nilReport, want: ""},
+		{name: "value", v: map[string]int64{"shareId": 3}, want: `{"shareId":3}`},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := auditData(tt.v)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("expected %q but got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAuditDataOmitsEmail(t *testing.T) {
+	email := "user@example.com"
+	u := store.User{ID: 3, Username: "user", Email: &email}
+
+	for _, v := range []interface{}{u, &u} {
+		got, err := auditData(v)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if strings.Contains(string(got), email) || !strings.Contains(string(got), `"username":"user"`) {
+			t.Errorf("expected user without email but got %s", got)
+		}
+	}
+
+	if u.Email == nil {
+		t.Errorf("expected the user's email to be left alone")
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// eventDeletionsHandler returns a handler to get the log of events that were
+// marked as deleted because they disappeared from TBA.
+func (s *Server) eventDeletionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		deletions, err := s.Store.GetEventDeletions(r.Context())
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("unable to retrieve event deletions")
+			return
+		}
+
+		ihttp.Respond(w, deletions, http.StatusOK)
+	}
+}
+
+// undeleteEventHandler returns a handler to restore an event that was marked
+// as deleted from TBA.
+func (s *Server) undeleteEventHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventKey := mux.Vars(r)["eventKey"]
+
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		userID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.UndoEventDeletionTx(r.Context(), tx, eventKey, userID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, nil, "event.restore", store.AuditTargetEvent, eventKey, nil, nil)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("unable to undo event deletion")
+			return
+		}
+
+		s.Logger.WithField("eventKey", eventKey).WithField("userID", userID).Info("restored event deleted from TBA")
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
 	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/Pigmice2733/peregrine-backend/internal/tba"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 )
@@ -84,6 +85,46 @@ func (s *Server) eventHandler() http.HandlerFunc {
 	}
 }
 
+// refreshEventHandler returns a handler that immediately refreshes an event
+// from TBA and responds with a summary of what changed. TBA events are shared
+// by every realm, so only super-admins can refresh them.
+func (s *Server) refreshEventHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		eventKey := mux.Vars(r)["eventKey"]
+
+		summary, err := s.Refresher.RefreshEvent(r.Context(), eventKey)
+		if errors.Is(err, tba.ErrNotFound{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if errors.Is(err, tba.ErrCircuitOpen{}) {
+			ihttp.Error(w, http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("unable to refresh event")
+			return
+		}
+
+		// The refresh is spread over several transactions, so it's recorded on
+		// its own once it's done.
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			return s.audit(r, tx, nil, "event.refresh", store.AuditTargetEvent, eventKey, nil, summary)
+		})
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("recording event refresh")
+			return
+		}
+
+		ihttp.Respond(w, summary, http.StatusOK)
+	}
+}
+
 func (s *Server) upsertEventHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		eventKey := mux.Vars(r)["eventKey"]
@@ -104,23 +145,36 @@ func (s *Server) upsertEventHandler() http.HandlerFunc {
 		event.Key = eventKey
 		event.RealmID = &creatorRealm
 
+		var before *store.Event
 		existed, err := editEvent(r.Context(), s.Store, roles, creatorRealm, event.Key, func(tx *sqlx.Tx) error {
+			if old, err := s.Store.GetEventForRealmTx(r.Context(), tx, eventKey, &creatorRealm); err == nil {
+				before = &old
+			} else if !errors.Is(err, store.ErrNoResults{}) {
+				return fmt.Errorf("unable to get event: %w", err)
+			}
+
 			if err := s.Store.UpsertEventTx(r.Context(), tx, event); err != nil {
 				return fmt.Errorf("unable to upsert event: %w", err)
 			}
 
-			return nil
+			if before != nil {
+				return s.audit(r, tx, &creatorRealm, "event.update", store.AuditTargetEvent, eventKey, before, event)
+			}
+			return s.audit(r, tx, &creatorRealm, "event.create", store.AuditTargetEvent, eventKey, nil, event)
 		})
 		if errors.Is(err, forbiddenError{}) {
 			ihttp.Error(w, http.StatusForbidden)
 			return
+		} else if errors.Is(err, store.ErrQuotaExceeded{}) {
+			ihttp.Respond(w, newQuotaResponse(err), http.StatusForbidden)
+			return
 		} else if err != nil {
 			s.Logger.WithError(err).Error("unable to upsert event")
 			ihttp.Error(w, http.StatusInternalServerError)
 			return
 		}
 
-		if existed {
+		if existed && before != nil {
 			w.WriteHeader(http.StatusNoContent)
 		} else {
 			w.WriteHeader(http.StatusCreated)
@@ -128,6 +182,97 @@ func (s *Server) upsertEventHandler() http.HandlerFunc {
 	}
 }
 
+// deleteEventHandler returns a handler to delete one of the realm's custom
+// events. Events from TBA can't be deleted this way.
+func (s *Server) deleteEventHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventKey := mux.Vars(r)["eventKey"]
+
+		realmID, err := ihttp.GetRealmID(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		userID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			before, err := s.Store.GetEventForRealmTx(r.Context(), tx, eventKey, &realmID)
+			if err != nil {
+				return err
+			}
+
+			if before.RealmID == nil {
+				return forbiddenError{errors.New("events from TBA can't be deleted")}
+			}
+
+			if err := s.Store.DeleteEventTx(r.Context(), tx, eventKey, realmID, &userID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "event.delete", store.AuditTargetEvent, eventKey, before, nil)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("deleting event")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// restoreEventHandler returns a handler to restore one of the realm's deleted
+// custom events.
+func (s *Server) restoreEventHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventKey := mux.Vars(r)["eventKey"]
+
+		realmID, err := ihttp.GetRealmID(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			event, err := s.Store.GetDeletedEventTx(r.Context(), tx, eventKey, realmID)
+			if err != nil {
+				return err
+			}
+
+			if err := s.Store.RestoreEventTx(r.Context(), tx, eventKey, realmID); err != nil {
+				return err
+			}
+
+			event.DeletedAt, event.DeletedBy = nil, nil
+			return s.audit(r, tx, &realmID, "event.restore", store.AuditTargetEvent, eventKey, nil, event)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if errors.Is(err, store.ErrQuotaExceeded{}) {
+			ihttp.Respond(w, newQuotaResponse(err), http.StatusForbidden)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("restoring event")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 func editEvent(ctx context.Context, sto *store.Service, roles store.Roles, userRealmID int64, eventKey string, editFunc func(tx *sqlx.Tx) error) (existed bool, err error) {
 	existed = true
 
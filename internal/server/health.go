@@ -6,6 +6,8 @@ import (
 	"time"
 
 	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/refresh"
+	"github.com/Pigmice2733/peregrine-backend/internal/tba"
 )
 
 func openAPIHandler(openAPI []byte) http.HandlerFunc {
@@ -21,28 +23,64 @@ type Pinger interface {
 	Ping(ctx context.Context) error
 }
 
+// TBAPinger is a Pinger that also reports the state of the circuit breaker
+// guarding requests to TBA.
+type TBAPinger interface {
+	Pinger
+	BreakerStatus() tba.BreakerStatus
+}
+
 type healthServices struct {
 	TBA        bool `json:"tba"`
 	PostgreSQL bool `json:"postgresql"`
 }
 
 type healthStatus struct {
-	Uptime   string         `json:"uptime"`
-	Services healthServices `json:"services"`
-	Ok       bool           `json:"ok"`
+	Uptime     string            `json:"uptime"`
+	Services   healthServices    `json:"services"`
+	TBABreaker tba.BreakerStatus `json:"tbaBreaker"`
+	Ok         bool              `json:"ok"`
 }
 
-func healthHandler(getUptime func() time.Duration, tba, postgres Pinger) http.HandlerFunc {
+func healthHandler(getUptime func() time.Duration, tbaService TBAPinger, postgres Pinger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		services := healthServices{
-			TBA:        tba.Ping(r.Context()) == nil,
+			TBA:        tbaService.Ping(r.Context()) == nil,
 			PostgreSQL: postgres.Ping(r.Context()) == nil,
 		}
 
+		breaker := tbaService.BreakerStatus()
+
 		ihttp.Respond(w, healthStatus{
-			Uptime:   getUptime().String(),
-			Services: services,
-			Ok:       services.TBA && services.PostgreSQL,
+			Uptime:     getUptime().String(),
+			Services:   services,
+			TBABreaker: breaker,
+			Ok:         services.TBA && services.PostgreSQL && breaker.State != tba.BreakerOpen,
 		}, http.StatusOK)
 	}
 }
+
+// RefreshStatuser reports the health of each stage of the TBA refresh pipeline.
+type RefreshStatuser interface {
+	Status() refresh.Status
+}
+
+// refresherStatusHandler returns a handler to get the health of each refresh
+// stage. Error messages can include TBA URLs and upstream responses, so only
+// super-admins see them.
+func refresherStatusHandler(refresher RefreshStatuser) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := refresher.Status()
+
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			redacted := make(refresh.Status, len(status))
+			for stage, ss := range status {
+				ss.LastError = ""
+				redacted[stage] = ss
+			}
+			status = redacted
+		}
+
+		ihttp.Respond(w, status, http.StatusOK)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Pigmice2733/peregrine-backend/internal/tba"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -58,11 +59,21 @@ func (mp mockPinger) Ping(context.Context) error {
 	return nil
 }
 
+type mockTBA struct {
+	mockPinger
+	breaker tba.BreakerStatus
+}
+
+func (mt mockTBA) BreakerStatus() tba.BreakerStatus {
+	return mt.breaker
+}
+
 func TestHealthHandler(t *testing.T) {
 	testCases := []struct {
 		name             string
 		tbaHealthy       bool
 		postgresHealthy  bool
+		breaker          tba.BreakerStatus
 		uptime           func() time.Duration
 		expectedResponse healthStatus
 	}{
@@ -70,6 +81,23 @@ func TestHealthHandler(t *testing.T) {
 			name:            "all services healthy",
 			tbaHealthy:      true,
 			postgresHealthy: true,
+			breaker:         tba.BreakerStatus{State: tba.BreakerClosed},
+			uptime:          func() time.Duration { return time.Second * 10 },
+			expectedResponse: healthStatus{
+				Uptime: "10s",
+				Services: healthServices{
+					TBA:        true,
+					PostgreSQL: true,
+				},
+				TBABreaker: tba.BreakerStatus{State: tba.BreakerClosed},
+				Ok:         true,
+			},
+		},
+		{
+			name:            "tba circuit breaker is open",
+			tbaHealthy:      true,
+			postgresHealthy: true,
+			breaker:         tba.BreakerStatus{State: tba.BreakerOpen, ConsecutiveFailures: 5},
 			uptime:          func() time.Duration { return time.Second * 10 },
 			expectedResponse: healthStatus{
 				Uptime: "10s",
@@ -77,7 +105,8 @@ func TestHealthHandler(t *testing.T) {
 					TBA:        true,
 					PostgreSQL: true,
 				},
-				Ok: true,
+				TBABreaker: tba.BreakerStatus{State: tba.BreakerOpen, ConsecutiveFailures: 5},
+				Ok:         false,
 			},
 		},
 		{
@@ -119,7 +148,7 @@ func TestHealthHandler(t *testing.T) {
 				t.FailNow()
 			}
 
-			handler := healthHandler(tt.uptime, mockPinger{tt.tbaHealthy}, mockPinger{tt.postgresHealthy})
+			handler := healthHandler(tt.uptime, mockTBA{mockPinger{tt.tbaHealthy}, tt.breaker}, mockPinger{tt.postgresHealthy})
 
 			handler(rr, req)
 
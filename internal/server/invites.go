@@ -0,0 +1,312 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	defaultInviteDuration = time.Hour * 24 * 7 // 1 week
+	inviteCodeLength      = 8
+	// inviteCodeAlphabet leaves out characters that are easy to mix up, since
+	// codes are often read off of a projector.
+	inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+func generateInviteCode() (string, error) {
+	max := big.NewInt(int64(len(inviteCodeAlphabet)))
+
+	var code strings.Builder
+	for i := 0; i < inviteCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("unable to generate invite code: %w", err)
+		}
+
+		code.WriteByte(inviteCodeAlphabet[n.Int64()])
+	}
+
+	return code.String(), nil
+}
+
+// normalizeInviteCode makes codes case-insensitive and ignores any spaces or
+// dashes people type in them.
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// checkInviteUsers returns a forbiddenError unless the requesting user can
+// invite and approve users in the realm, which requires being able to manage
+// users and to grant verified.
+func (s *Server) checkInviteUsers(r *http.Request, realmID int64) error {
+	if err := checkRealmPermission(r, realmID, store.PermUsersManage); err != nil {
+		return err
+	}
+
+	return s.checkGrantRoles(r, realmID, store.Roles{IsVerified: true}, nil)
+}
+
+func (s *Server) getRealmInvitesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermUsersManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		invites, err := s.Store.GetRealmInvites(r.Context(), realmID)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting realm invites")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, invites, http.StatusOK)
+	}
+}
+
+func (s *Server) createRealmInviteHandler() http.HandlerFunc {
+	type requestInvite struct {
+		MaxUses   int        `json:"maxUses" validate:"required,min=1,max=1000"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		err = s.checkInviteUsers(r, realmID)
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("checking invite permissions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		var ri requestInvite
+		if err := json.NewDecoder(r.Body).Decode(&ri); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(ri); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		invite := store.RealmInvite{RealmID: realmID, CreatedBy: &subjectID, MaxUses: ri.MaxUses, ExpiresAt: time.Now().Add(defaultInviteDuration)}
+		if ri.ExpiresAt != nil {
+			if !ri.ExpiresAt.After(time.Now()) {
+				ihttp.Error(w, http.StatusUnprocessableEntity)
+				return
+			}
+
+			invite.ExpiresAt = *ri.ExpiresAt
+		}
+
+		// Codes are short, so retry a few times in case one collides
+		for attempt := 0; attempt < 3; attempt++ {
+			invite.Code, err = generateInviteCode()
+			if err != nil {
+				break
+			}
+
+			var created store.RealmInvite
+			err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+				var err error
+				if created, err = s.Store.CreateRealmInviteTx(r.Context(), tx, invite); err != nil {
+					return err
+				}
+
+				return s.audit(r, tx, &realmID, "realm.invite.create", store.AuditTargetRealm, auditID(realmID), nil, created)
+			})
+			if err == nil {
+				invite = created
+				break
+			} else if !errors.Is(err, store.ErrExists{}) {
+				break
+			}
+		}
+
+		if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("creating realm invite")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, invite, http.StatusCreated)
+	}
+}
+
+func (s *Server) revokeRealmInviteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		realmID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		inviteID, err := strconv.ParseInt(vars["inviteId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermUsersManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.RevokeRealmInviteTx(r.Context(), tx, realmID, inviteID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.invite.revoke", store.AuditTargetRealm, auditID(realmID), map[string]int64{"inviteId": inviteID}, nil)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("revoking realm invite")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) pendingUsersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermUsersManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		users, err := s.Store.GetPendingUsers(r.Context(), realmID)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting pending users")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, users, http.StatusOK)
+	}
+}
+
+func (s *Server) approveUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		target, err := s.checkManageUser(r, id)
+		if err == nil {
+			err = s.checkInviteUsers(r, target.RealmID)
+		}
+		if err == nil {
+			err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+				if err := s.Store.ApproveUserTx(r.Context(), tx, id); err != nil {
+					return err
+				}
+
+				after := target
+				after.Pending = false
+				after.Roles.IsVerified = true
+				return s.audit(r, tx, &target.RealmID, "user.approve", store.AuditTargetUser, auditID(id), target, after)
+			})
+		}
+
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("approving user")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) rejectUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		target, err := s.checkManageUser(r, id)
+		if err == nil {
+			err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+				if err := s.Store.RejectUserTx(r.Context(), tx, id); err != nil {
+					return err
+				}
+
+				return s.audit(r, tx, &target.RealmID, "user.reject", store.AuditTargetUser, auditID(id), target, nil)
+			})
+		}
+
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("rejecting user")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
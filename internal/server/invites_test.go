@@ -0,0 +1,45 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateInviteCode(t *testing.T) {
+	code, err := generateInviteCode()
+	if err != nil {
+		t.Fatalf("unable to generate invite code: %v", err)
+	}
+
+	if len(code) != inviteCodeLength {
+		t.Errorf("expected code of length %d but got %q", inviteCodeLength, code)
+	}
+
+	for _, c := range code {
+		if !strings.ContainsRune(inviteCodeAlphabet, c) {
+			t.Errorf("unexpected character %q in code %q", c, code)
+		}
+	}
+
+	if normalizeInviteCode(code) != code {
+		t.Errorf("expected generated code %q to already be normalized", code)
+	}
+}
+
+func TestNormalizeInviteCode(t *testing.T) {
+	testCases := []struct {
+		code string
+		want string
+	}{
+		{code: "ABCD2345", want: "ABCD2345"},
+		{code: "abcd2345", want: "ABCD2345"},
+		{code: "abcd-2345", want: "ABCD2345"},
+		{code: " AbCd 2345 ", want: "ABCD2345"},
+	}
+
+	for _, tt := range testCases {
+		if got := normalizeInviteCode(tt.code); got != tt.want {
+			t.Errorf("normalizeInviteCode(%q): expected %q but got %q", tt.code, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package server
+
+import (
+	"net/http"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+)
+
+// jwksHandler returns a handler that serves the public keys tokens are signed
+// with, so other services can verify peregrine tokens.
+func jwksHandler(keys *ihttp.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		ihttp.Respond(w, keys.JWKS(), http.StatusOK)
+	}
+}
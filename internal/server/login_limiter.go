@@ -0,0 +1,201 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginPolicy controls how failed logins are slowed down and locked out.
+type loginPolicy struct {
+	// freeAttempts is how many failures are allowed before clients have to
+	// wait between attempts. The wait starts at baseDelay and doubles with
+	// each failure after that.
+	freeAttempts int
+	baseDelay    time.Duration
+
+	// lockoutAttempts is how many failures lock out further attempts for
+	// lockoutDuration.
+	lockoutAttempts int
+	lockoutDuration time.Duration
+
+	// window is how long failures are remembered for after the last one.
+	window time.Duration
+}
+
+var (
+	usernameLoginPolicy = loginPolicy{
+		freeAttempts:    3,
+		baseDelay:       time.Second,
+		lockoutAttempts: 10,
+		lockoutDuration: time.Minute * 15,
+		window:          time.Hour,
+	}
+
+	// ipLoginPolicy is looser than the username policy since a whole team
+	// often logs in from behind the same address at competitions.
+	ipLoginPolicy = loginPolicy{
+		freeAttempts:    20,
+		baseDelay:       time.Second,
+		lockoutAttempts: 100,
+		lockoutDuration: time.Minute * 15,
+		window:          time.Hour,
+	}
+)
+
+type loginAttempts struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// wait returns how long until another attempt is allowed.
+func (a *loginAttempts) wait(p loginPolicy, now time.Time) time.Duration {
+	if now.Before(a.lockedUntil) {
+		return a.lockedUntil.Sub(now)
+	}
+
+	if a.failures < p.freeAttempts || now.Sub(a.last) > p.window {
+		return 0
+	}
+
+	delay := p.lockoutDuration
+	if shift := uint(a.failures - p.freeAttempts); shift < 16 && p.baseDelay<<shift < delay {
+		delay = p.baseDelay << shift
+	}
+
+	if wait := a.last.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
+
+// fail records a failure, and returns whether it locked out further attempts.
+func (a *loginAttempts) fail(p loginPolicy, now time.Time) bool {
+	if now.Sub(a.last) > p.window {
+		a.failures = 0
+	}
+
+	a.failures++
+	a.last = now
+
+	if a.failures >= p.lockoutAttempts {
+		a.lockedUntil = now.Add(p.lockoutDuration)
+		return true
+	}
+
+	return false
+}
+
+// loginLimiter tracks failed logins by username and by IP address so password
+// guessing slows down and eventually gets locked out. It checks attempts
+// before passwords are hashed, which keeps bcrypt from being used to exhaust
+// the server's CPU. Attempts are tracked in memory, so each server process
+// keeps its own counts. A separate limiter throttles password reset requests,
+// where every request counts as a failed attempt.
+type loginLimiter struct {
+	users, ips           map[string]*loginAttempts
+	userPolicy, ipPolicy loginPolicy
+	lastPrune            time.Time
+	mu                   sync.Mutex
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{
+		users:      make(map[string]*loginAttempts),
+		ips:        make(map[string]*loginAttempts),
+		userPolicy: usernameLoginPolicy,
+		ipPolicy:   ipLoginPolicy,
+	}
+}
+
+func normalizeLoginUsername(username string) string {
+	return strings.ToLower(username)
+}
+
+// attempt starts a login as username from ip. If there have been too many
+// failed attempts it returns how long the client must wait before trying
+// again. Otherwise the attempt is counted as a failure until succeed is
+// called, so concurrent attempts can't all get past the limits before any of
+// them fail, and it returns the number of recent failures for the username
+// and whether they're now locked out.
+func (l *loginLimiter) attempt(username, ip string, now time.Time) (wait time.Duration, failures int, locked bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	username = normalizeLoginUsername(username)
+
+	user, ok := l.users[username]
+	if !ok {
+		user = &loginAttempts{}
+		l.users[username] = user
+	}
+
+	addr, ok := l.ips[ip]
+	if !ok {
+		addr = &loginAttempts{}
+		l.ips[ip] = addr
+	}
+
+	wait = user.wait(l.userPolicy, now)
+	if ipWait := addr.wait(l.ipPolicy, now); ipWait > wait {
+		wait = ipWait
+	}
+
+	if wait > 0 {
+		return wait, user.failures, false
+	}
+
+	locked = user.fail(l.userPolicy, now)
+	if addr.fail(l.ipPolicy, now) {
+		locked = true
+	}
+
+	return 0, user.failures, locked
+}
+
+// succeed records that an attempt succeeded, forgetting the failed logins for
+// the username and not counting the attempt against the IP address.
+func (l *loginLimiter) succeed(username, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.users, normalizeLoginUsername(username))
+
+	if addr, ok := l.ips[ip]; ok && addr.failures > 0 {
+		addr.failures--
+	}
+}
+
+// unlock forgets the failed logins for a username.
+func (l *loginLimiter) unlock(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.users, normalizeLoginUsername(username))
+}
+
+// prune forgets attempts that have expired so guessing many different
+// usernames doesn't grow the limiter forever. It runs at most once a minute.
+func (l *loginLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+
+	l.lastPrune = now
+
+	for key, a := range l.users {
+		if now.Sub(a.last) > l.userPolicy.window && !now.Before(a.lockedUntil) {
+			delete(l.users, key)
+		}
+	}
+
+	for key, a := range l.ips {
+		if now.Sub(a.last) > l.ipPolicy.window && !now.Before(a.lockedUntil) {
+			delete(l.ips, key)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	start := time.Unix(1558050528, 0)
+
+	t.Run("progressive delays and lockout", func(t *testing.T) {
+		logins := newLoginLimiter()
+		now := start
+
+		for i := 1; i < usernameLoginPolicy.lockoutAttempts; i++ {
+			wait, failures, locked := logins.attempt("franklin", "192.0.2.1", now)
+			if wait != 0 || failures != i || locked {
+				t.Fatalf("attempt %d: expected failure %d to be allowed but got wait %v, failures %d, locked %v", i, i, wait, failures, locked)
+			}
+
+			if i < usernameLoginPolicy.freeAttempts {
+				continue
+			}
+
+			delay := usernameLoginPolicy.baseDelay << uint(i-usernameLoginPolicy.freeAttempts)
+			if wait, _, _ := logins.attempt("franklin", "192.0.2.1", now.Add(delay-time.Millisecond)); wait != time.Millisecond {
+				t.Errorf("attempt %d: expected delay of %v but got %v", i, delay, delay-time.Millisecond+wait)
+			}
+
+			now = now.Add(delay)
+		}
+
+		if _, _, locked := logins.attempt("franklin", "192.0.2.1", now); !locked {
+			t.Fatalf("expected user to be locked out")
+		}
+
+		if wait, _, _ := logins.attempt("franklin", "192.0.2.2", now); wait != usernameLoginPolicy.lockoutDuration {
+			t.Errorf("expected lockout of %v from another address but got %v", usernameLoginPolicy.lockoutDuration, wait)
+		}
+
+		if wait, _, _ := logins.attempt("FRANKLIN", "192.0.2.1", now); wait == 0 {
+			t.Errorf("expected lockout to ignore username case")
+		}
+
+		if wait, _, _ := logins.attempt("harding", "192.0.2.1", now); wait != 0 {
+			t.Errorf("expected other users to be able to log in but got wait %v", wait)
+		}
+
+		logins.unlock("franklin")
+
+		if wait, _, _ := logins.attempt("franklin", "192.0.2.1", now); wait != 0 {
+			t.Errorf("expected unlocked user to be able to log in but got wait %v", wait)
+		}
+	})
+
+	t.Run("success resets failures", func(t *testing.T) {
+		logins := newLoginLimiter()
+
+		for i := 0; i < usernameLoginPolicy.freeAttempts; i++ {
+			logins.attempt("franklin", "192.0.2.1", start)
+		}
+
+		logins.succeed("franklin", "192.0.2.1")
+
+		if wait, failures, _ := logins.attempt("franklin", "192.0.2.1", start); wait != 0 || failures != 1 {
+			t.Errorf("expected failures to be reset but got wait %v and %d failures", wait, failures)
+		}
+	})
+
+	t.Run("failures expire", func(t *testing.T) {
+		logins := newLoginLimiter()
+
+		for i := 0; i < usernameLoginPolicy.freeAttempts; i++ {
+			logins.attempt("franklin", "192.0.2.1", start)
+		}
+
+		later := start.Add(usernameLoginPolicy.window + time.Second)
+		if wait, failures, _ := logins.attempt("franklin", "192.0.2.1", later); wait != 0 || failures != 1 {
+			t.Errorf("expected failures to expire but got wait %v and %d failures", wait, failures)
+		}
+	})
+
+	t.Run("ip limits", func(t *testing.T) {
+		logins := newLoginLimiter()
+
+		for i := 0; i < ipLoginPolicy.freeAttempts; i++ {
+			if wait, _, _ := logins.attempt("user"+string(rune('a'+i)), "192.0.2.1", start); wait != 0 {
+				t.Fatalf("expected attempt %d to be allowed but got wait %v", i, wait)
+			}
+		}
+
+		if wait, _, _ := logins.attempt("franklin", "192.0.2.1", start); wait == 0 {
+			t.Errorf("expected attempts from the same address to be delayed")
+		}
+
+		if wait, _, _ := logins.attempt("franklin", "192.0.2.2", start); wait != 0 {
+			t.Errorf("expected attempts from another address to be allowed but got wait %v", wait)
+		}
+	})
+}
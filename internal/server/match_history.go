@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+)
+
+type matchVersion struct {
+	store.MatchVersion
+	Changes []string `json:"changes"`
+}
+
+// matchHistoryHandler returns a handler to get every recorded version of a
+// match, along with what changed in each version.
+func (s *Server) matchHistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		eventKey, matchKey := vars["eventKey"], vars["matchKey"]
+
+		var realmID *int64
+		userRealmID, err := ihttp.GetRealmID(r)
+		if err == nil {
+			realmID = &userRealmID
+		}
+
+		if _, err := s.Store.GetMatchForRealm(r.Context(), eventKey, matchKey, realmID); errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("retrieving match")
+			return
+		}
+
+		versions, err := s.Store.GetMatchHistory(r.Context(), eventKey, matchKey)
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("retrieving match history")
+			return
+		}
+
+		history := make([]matchVersion, 0, len(versions))
+		for i, version := range versions {
+			var prev store.MatchVersion
+			if i > 0 {
+				prev = versions[i-1]
+			}
+
+			history = append(history, matchVersion{
+				MatchVersion: version,
+				Changes:      matchVersionChanges(prev, version),
+			})
+		}
+
+		ihttp.Respond(w, history, http.StatusOK)
+	}
+}
+
+// matchVersionChanges returns the names of the fields that differ between two
+// versions of a match. Score breakdown changes are reported per key, e.g.
+// "redScoreBreakdown.foulPoints". Predicted times aren't compared since they
+// change constantly.
+func matchVersionChanges(prev, cur store.MatchVersion) []string {
+	changes := make([]string, 0)
+	for _, change := range store.MatchChanges(versionMatch(prev), versionMatch(cur)) {
+		if change != "predictedTime" {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// versionMatch returns the fields of a match version that can change as a match.
+func versionMatch(v store.MatchVersion) store.Match {
+	return store.Match{
+		ScheduledTime:      v.ScheduledTime,
+		ActualTime:         v.ActualTime,
+		RedScore:           v.RedScore,
+		BlueScore:          v.BlueScore,
+		RedAlliance:        v.RedAlliance,
+		BlueAlliance:       v.BlueAlliance,
+		RedScoreBreakdown:  v.RedScoreBreakdown,
+		BlueScoreBreakdown: v.BlueScoreBreakdown,
+	}
+}
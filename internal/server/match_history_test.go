@@ -0,0 +1,64 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatchVersionChanges(t *testing.T) {
+	ten, twelve := 10, 12
+	scheduled := time.Date(2019, 3, 2, 9, 0, 0, 0, time.UTC)
+	delayed := scheduled.Add(time.Minute * 7)
+
+	testCases := []struct {
+		name      string
+		prev, cur store.MatchVersion
+		want      []string
+	}{
+		{
+			name: "first version",
+			cur: store.MatchVersion{
+				ScheduledTime: &scheduled,
+				RedAlliance:   []string{"frc1", "frc2", "frc3"},
+				BlueAlliance:  []string{"frc4", "frc5", "frc6"},
+			},
+			want: []string{"scheduledTime", "redAlliance", "blueAlliance"},
+		},
+		{
+			name: "predicted time only",
+			prev: store.MatchVersion{ScheduledTime: &scheduled, PredictedTime: &scheduled},
+			cur:  store.MatchVersion{ScheduledTime: newTime(scheduled.Local()), PredictedTime: &delayed},
+			want: []string{},
+		},
+		{
+			name: "score correction",
+			prev: store.MatchVersion{
+				RedScore:          &ten,
+				BlueScore:         &ten,
+				RedScoreBreakdown: store.ScoreBreakdown{"foulPoints": 0.0, "totalPoints": 10.0, "rp": 1.0},
+			},
+			cur: store.MatchVersion{
+				RedScore:          &twelve,
+				BlueScore:         &ten,
+				RedScoreBreakdown: store.ScoreBreakdown{"foulPoints": 2.0, "totalPoints": 12.0},
+			},
+			want: []string{"redScore", "redScoreBreakdown.foulPoints", "redScoreBreakdown.rp", "redScoreBreakdown.totalPoints"},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchVersionChanges(tt.prev, tt.cur)
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("changes differ: %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func newTime(t time.Time) *time.Time {
+	return &t
+}
@@ -145,12 +145,26 @@ func (s *Server) upsertMatchHandler() http.HandlerFunc {
 			return
 		}
 
-		existed, err := editMatch(r.Context(), s.Store, roles, userRealmID, sm.Key, func(tx *sqlx.Tx) error {
+		existed, err := editMatch(r.Context(), s.Store, roles, userRealmID, sm.Key, func(tx *sqlx.Tx, realmID *int64) error {
+			var before *store.Match
+			if old, err := s.Store.GetMatchForRealmTx(r.Context(), tx, eventKey, matchKey, &userRealmID); err == nil {
+				before = &old
+			} else if !errors.Is(err, store.ErrNoResults{}) {
+				return fmt.Errorf("unable to get match: %w", err)
+			}
+
 			if err := s.Store.UpsertMatchTx(r.Context(), tx, sm); err != nil {
 				return fmt.Errorf("unable to upsert match: %w", err)
 			}
 
-			return nil
+			if err := s.updateRankingsTx(r.Context(), tx, eventKey, userRealmID); err != nil {
+				return err
+			}
+
+			if before != nil {
+				return s.audit(r, tx, realmID, "match.update", store.AuditTargetMatch, eventKey+"/"+matchKey, before, sm)
+			}
+			return s.audit(r, tx, realmID, "match.create", store.AuditTargetMatch, eventKey+"/"+matchKey, nil, sm)
 		})
 		if errors.Is(err, forbiddenError{}) {
 			ihttp.Error(w, http.StatusForbidden)
@@ -183,12 +197,23 @@ func (s *Server) deleteMatchHandler() http.HandlerFunc {
 			return
 		}
 
-		existed, err := editMatch(r.Context(), s.Store, roles, userRealmID, matchKey, func(tx *sqlx.Tx) error {
+		existed, err := editMatch(r.Context(), s.Store, roles, userRealmID, matchKey, func(tx *sqlx.Tx, realmID *int64) error {
+			before, err := s.Store.GetMatchForRealmTx(r.Context(), tx, eventKey, matchKey, &userRealmID)
+			if errors.Is(err, store.ErrNoResults{}) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("unable to get match: %w", err)
+			}
+
 			if err := s.Store.DeleteMatchTx(r.Context(), tx, matchKey, eventKey); err != nil {
 				return fmt.Errorf("unable to delete match: %w", err)
 			}
 
-			return nil
+			if err := s.updateRankingsTx(r.Context(), tx, eventKey, userRealmID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, realmID, "match.delete", store.AuditTargetMatch, eventKey+"/"+matchKey, before, nil)
 		})
 		if errors.Is(err, forbiddenError{}) {
 			ihttp.Error(w, http.StatusForbidden)
@@ -207,7 +232,7 @@ func (s *Server) deleteMatchHandler() http.HandlerFunc {
 	}
 }
 
-func editMatch(ctx context.Context, sto *store.Service, roles store.Roles, userRealmID int64, matchKey string, editFunc func(tx *sqlx.Tx) error) (existed bool, err error) {
+func editMatch(ctx context.Context, sto *store.Service, roles store.Roles, userRealmID int64, matchKey string, editFunc func(tx *sqlx.Tx, realmID *int64) error) (existed bool, err error) {
 	existed = true
 
 	err = sto.DoTransaction(ctx, func(tx *sqlx.Tx) error {
@@ -228,7 +253,7 @@ func editMatch(ctx context.Context, sto *store.Service, roles store.Roles, userR
 			return forbiddenError{errors.New("only realm admins with matching realm IDs can edit matches with a specified realm ID")}
 		}
 
-		if err := editFunc(tx); err != nil {
+		if err := editFunc(tx, realmID); err != nil {
 			return fmt.Errorf("unable to edit match: %w", err)
 		}
 
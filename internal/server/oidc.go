@@ -0,0 +1,505 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/config"
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/oidc"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	oidcLoginDuration = time.Minute * 10
+	// oidcLoginAudience keeps login tokens from being mistaken for any other
+	// kind of token signed with the same keys.
+	oidcLoginAudience = "peregrine-oidc-login"
+)
+
+// OIDCProvider is an OpenID Connect provider users can log in with.
+type OIDCProvider struct {
+	*oidc.Provider
+
+	Name         string
+	RedirectURLs []string
+	Domains      []config.OIDCDomain
+}
+
+// allowsRedirect returns whether the provider can send users back to the URL.
+func (p OIDCProvider) allowsRedirect(redirectURI string) bool {
+	for _, u := range p.RedirectURLs {
+		if u == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
+
+// domain returns the rule for which realm someone logging in for the first
+// time joins, based on their email's domain. Emails the provider hasn't
+// verified never match.
+func (p OIDCProvider) domain(token oidc.IDToken) (config.OIDCDomain, bool) {
+	at := strings.LastIndex(token.Email, "@")
+	if !token.EmailVerified || at < 0 {
+		return config.OIDCDomain{}, false
+	}
+
+	for _, d := range p.Domains {
+		if strings.EqualFold(d.Domain, token.Email[at+1:]) {
+			return d, true
+		}
+	}
+
+	return config.OIDCDomain{}, false
+}
+
+// oidcLoginClaims hold everything needed to finish logging in with a provider.
+// They're signed and handed to the client to send back with the code, so no
+// login state has to be kept on the server.
+type oidcLoginClaims struct {
+	jwt.StandardClaims
+	State       string `json:"state"`
+	Nonce       string `json:"nonce"`
+	Verifier    string `json:"verifier"`
+	RedirectURI string `json:"redirectUri"`
+}
+
+type oidcCallback struct {
+	Code       string `json:"code" validate:"required"`
+	State      string `json:"state" validate:"required"`
+	LoginToken string `json:"loginToken" validate:"required"`
+}
+
+// oidcUsername picks a username for someone logging in for the first time,
+// based on their email address or name.
+func oidcUsername(token oidc.IDToken) string {
+	base := token.GivenName + token.FamilyName
+	if at := strings.LastIndex(token.Email, "@"); at > 0 {
+		base = token.Email[:at]
+	}
+
+	username := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return -1
+		}
+
+		return unicode.ToLower(r)
+	}, base)
+
+	if len(username) < 4 {
+		username = "user" + username
+	}
+
+	// Leave room for a suffix in case the username is taken
+	if len(username) > 26 {
+		username = username[:26]
+	}
+
+	return username
+}
+
+func (s *Server) oidcProvider(r *http.Request) (OIDCProvider, string, bool) {
+	id := mux.Vars(r)["provider"]
+	p, ok := s.OIDC[id]
+	return p, id, ok
+}
+
+func (s *Server) oidcProvidersHandler() http.HandlerFunc {
+	type provider struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		providers := []provider{}
+		for id, p := range s.OIDC {
+			providers = append(providers, provider{ID: id, Name: p.Name})
+		}
+
+		sort.Slice(providers, func(i, j int) bool {
+			return providers[i].ID < providers[j].ID
+		})
+
+		ihttp.Respond(w, providers, http.StatusOK)
+	}
+}
+
+func (s *Server) oidcAuthorizeHandler() http.HandlerFunc {
+	type authorizeRequest struct {
+		RedirectURI string `json:"redirectUri" validate:"required"`
+	}
+
+	type authorizeResponse struct {
+		URL        string `json:"url"`
+		LoginToken string `json:"loginToken"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, id, ok := s.oidcProvider(r)
+		if !ok {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		}
+
+		var ar authorizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(ar); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if !p.allowsRedirect(ar.RedirectURI) {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		claims := oidcLoginClaims{
+			StandardClaims: jwt.StandardClaims{
+				Audience:  oidcLoginAudience,
+				Subject:   id,
+				ExpiresAt: time.Now().Add(oidcLoginDuration).Unix(),
+			},
+			RedirectURI: ar.RedirectURI,
+		}
+
+		for _, v := range []*string{&claims.State, &claims.Nonce, &claims.Verifier} {
+			var err error
+			if *v, err = oidc.GenerateVerifier(); err != nil {
+				s.Logger.WithError(err).Error("generating oidc login state")
+				ihttp.Error(w, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		authURL, err := p.AuthCodeURL(r.Context(), claims.RedirectURI, claims.State, claims.Nonce, claims.Verifier)
+		if err != nil {
+			s.Logger.WithError(err).WithField("provider", id).Error("getting oidc authorization url")
+			ihttp.Error(w, http.StatusServiceUnavailable)
+			return
+		}
+
+		loginToken, err := s.Keys.Sign(claims)
+		if err != nil {
+			s.Logger.WithError(err).Error("signing oidc login token")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, authorizeResponse{URL: authURL, LoginToken: loginToken}, http.StatusOK)
+	}
+}
+
+// exchangeOIDCCode reads a callback from the provider in the request, checks
+// it against the login token, and exchanges the code for the user's ID token.
+// Callbacks that don't check out return a forbiddenError.
+func (s *Server) exchangeOIDCCode(r *http.Request, p OIDCProvider, id string) (oidc.IDToken, error) {
+	var cb oidcCallback
+	if err := json.NewDecoder(r.Body).Decode(&cb); err != nil {
+		return oidc.IDToken{}, badRequestError{err}
+	}
+
+	if err := validator.New().Struct(cb); err != nil {
+		return oidc.IDToken{}, badRequestError{err}
+	}
+
+	var claims oidcLoginClaims
+	token, err := jwt.ParseWithClaims(cb.LoginToken, &claims, s.Keys.Keyfunc)
+	if err != nil || !token.Valid || !claims.VerifyAudience(oidcLoginAudience, true) || claims.Subject != id {
+		return oidc.IDToken{}, forbiddenError{errors.New("invalid oidc login token")}
+	}
+
+	if cb.State != claims.State {
+		return oidc.IDToken{}, forbiddenError{errors.New("oidc state doesn't match")}
+	}
+
+	idToken, err := p.Exchange(r.Context(), cb.Code, claims.RedirectURI, claims.Verifier, claims.Nonce)
+	if err != nil {
+		return idToken, forbiddenError{fmt.Errorf("unable to exchange oidc code: %w", err)}
+	}
+
+	return idToken, nil
+}
+
+// provisionOIDCUser creates a user for someone logging in with a provider for
+// the first time, in the realm their email's domain maps to. If their domain
+// doesn't map to a realm a forbiddenError is returned.
+func (s *Server) provisionOIDCUser(r *http.Request, p OIDCProvider, id string, token oidc.IDToken) (int64, error) {
+	domain, ok := p.domain(token)
+	if !ok {
+		return 0, forbiddenError{fmt.Errorf("no realm for email %q", token.Email)}
+	}
+
+	// Users can still set a password later, but they shouldn't be able to
+	// log in with one until they do.
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return 0, fmt.Errorf("unable to generate password: %w", err)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(base64.RawURLEncoding.EncodeToString(password)), bcrypt.MinCost)
+	if err != nil {
+		return 0, fmt.Errorf("unable to hash password: %w", err)
+	}
+
+	email := token.Email
+	firstName, lastName := token.GivenName, token.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName = token.Name
+	}
+
+	u := store.User{
+		HashedPassword: string(hashedPassword),
+		RealmID:        domain.RealmID,
+		FirstName:      firstName,
+		LastName:       lastName,
+		Email:          &email,
+		Roles:          store.Roles{IsVerified: domain.Verified},
+		Pending:        !domain.Verified,
+	}
+
+	identity := store.UserIdentity{Provider: id, Subject: token.Subject, Email: &email}
+
+	base := oidcUsername(token)
+	u.Username = base
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+			if err != nil {
+				return 0, fmt.Errorf("unable to generate username: %w", err)
+			}
+
+			u.Username = base + strconv.FormatInt(n.Int64(), 10)
+		}
+
+		err = s.Store.CheckSimilarUsernameExists(r.Context(), u.Username, nil)
+		if err == nil {
+			err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+				var err error
+				if u.ID, err = s.Store.CreateUserWithIdentityTx(r.Context(), tx, u, identity); err != nil {
+					return err
+				}
+
+				return s.audit(r, tx, &u.RealmID, "user.create", store.AuditTargetUser, auditID(u.ID), nil, u)
+			})
+			if err == nil {
+				return u.ID, nil
+			}
+		}
+
+		if !errors.Is(err, store.ErrExists{}) || attempt >= 5 {
+			return 0, err
+		}
+
+		// Someone else may have just logged in with the same identity
+		if user, err := s.Store.GetUserByIdentity(r.Context(), id, token.Subject); err == nil {
+			return user.ID, nil
+		}
+	}
+}
+
+func (s *Server) oidcCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, id, ok := s.oidcProvider(r)
+		if !ok {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		}
+
+		token, err := s.exchangeOIDCCode(r, p, id)
+		if errors.Is(err, badRequestError{}) {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		} else if errors.Is(err, forbiddenError{}) {
+			s.Logger.WithError(err).WithField("provider", id).Info("failed oidc login")
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("exchanging oidc code")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		user, err := s.Store.GetUserByIdentity(r.Context(), id, token.Subject)
+		if errors.Is(err, store.ErrNoResults{}) {
+			var userID int64
+			userID, err = s.provisionOIDCUser(r, p, id, token)
+			if err == nil {
+				user, err = s.Store.GetUserByID(r.Context(), userID)
+			}
+		}
+
+		if errors.Is(err, forbiddenError{}) {
+			s.Logger.WithError(err).WithField("provider", id).Info("failed oidc login")
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrFKeyViolation{}) {
+			s.Logger.WithError(err).WithField("provider", id).Error("oidc domain has a realm that doesn't exist")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		} else if errors.Is(err, store.ErrQuotaExceeded{}) {
+			ihttp.Respond(w, newQuotaResponse(err), http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrExists{}) {
+			// The identity is still linked to a user who was deleted
+			s.Logger.WithError(err).WithField("provider", id).Info("failed oidc login for deleted user")
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("getting oidc user")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		session, err := s.Store.CreateSession(r.Context(), user.ID, r.UserAgent(), time.Now().Add(refreshTokenDuration))
+		if err != nil {
+			s.Logger.WithError(err).Error("creating session")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		respondWithTokens(w, s.Logger, time.Now, user, session, s.Keys)
+	}
+}
+
+func (s *Server) oidcLinkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, id, ok := s.oidcProvider(r)
+		if !ok {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		}
+
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		token, err := s.exchangeOIDCCode(r, p, id)
+		if errors.Is(err, badRequestError{}) {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		} else if errors.Is(err, forbiddenError{}) {
+			s.Logger.WithError(err).WithField("provider", id).Info("failed oidc link")
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("exchanging oidc code")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		identity := store.UserIdentity{UserID: subjectID, Provider: id, Subject: token.Subject}
+		if token.Email != "" {
+			identity.Email = &token.Email
+		}
+
+		identity, err = s.Store.CreateUserIdentity(r.Context(), identity)
+		if errors.Is(err, store.ErrExists{}) {
+			ihttp.Error(w, http.StatusConflict)
+			return
+		} else if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("linking oidc identity")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, identity, http.StatusCreated)
+	}
+}
+
+// getUserIdentitiesHandler lists a user's linked identities. Users can view
+// and unlink identities for the same users whose sessions they can manage.
+func (s *Server) getUserIdentitiesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targetID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		err = s.checkManageSessions(r, targetID)
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("checking identity permissions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		identities, err := s.Store.GetUserIdentities(r.Context(), targetID)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting user identities")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, identities, http.StatusOK)
+	}
+}
+
+func (s *Server) deleteUserIdentityHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		targetID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		identityID, err := strconv.ParseInt(vars["identityId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		err = s.checkManageSessions(r, targetID)
+		if err == nil {
+			err = s.Store.DeleteUserIdentity(r.Context(), targetID, identityID)
+		}
+
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("deleting user identity")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/config"
+	"github.com/Pigmice2733/peregrine-backend/internal/oidc"
+	"github.com/Pigmice2733/peregrine-backend/internal/oidc/oidctest"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+func TestOIDCUsername(t *testing.T) {
+	testCases := []struct {
+		name  string
+		token oidc.IDToken
+		want  string
+	}{
+		{name: "email", token: oidc.IDToken{Email: "Franklin.Harding@example.com"}, want: "franklinharding"},
+		{name: "short email", token: oidc.IDToken{Email: "fh@example.com"}, want: "userfh"},
+		{name: "no email", token: oidc.IDToken{GivenName: "Franklin", FamilyName: "Harding"}, want: "franklinharding"},
+		{name: "non-ascii", token: oidc.IDToken{Email: "zoë@example.com"}, want: "userzo"},
+		{name: "long email", token: oidc.IDToken{Email: "abcdefghijklmnopqrstuvwxyz0123456789@example.com"}, want: "abcdefghijklmnopqrstuvwxyz"},
+	}
+
+	for _, tt := range testCases {
+		if got := oidcUsername(tt.token); got != tt.want {
+			t.Errorf("%s: expected %q but got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestOIDCDomain(t *testing.T) {
+	p := OIDCProvider{Domains: []config.OIDCDomain{{Domain: "example.com", RealmID: 2, Verified: true}}}
+
+	testCases := []struct {
+		name string
+		json string
+		want bool
+	}{
+		{name: "matching domain", json: `{"email":"franklin@Example.com","email_verified":true}`, want: true},
+		{name: "string verified", json: `{"email":"franklin@example.com","email_verified":"true"}`, want: true},
+		{name: "unverified email", json: `{"email":"franklin@example.com","email_verified":false}`, want: false},
+		{name: "other domain", json: `{"email":"franklin@example.org","email_verified":true}`, want: false},
+		{name: "subdomain", json: `{"email":"franklin@evil.example.com","email_verified":true}`, want: false},
+		{name: "no email", json: `{"email_verified":true}`, want: false},
+	}
+
+	for _, tt := range testCases {
+		var token oidc.IDToken
+		if err := json.Unmarshal([]byte(tt.json), &token); err != nil {
+			t.Fatalf("%s: unable to unmarshal token: %v", tt.name, err)
+		}
+
+		if d, ok := p.domain(token); ok != tt.want || (ok && d.RealmID != 2) {
+			t.Errorf("%s: expected match %v but got %v", tt.name, tt.want, ok)
+		}
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	mock, err := oidctest.NewProvider("peregrine", "client-secret")
+	if err != nil {
+		t.Fatalf("unable to start mock provider: %v", err)
+	}
+	defer mock.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	redirectURI := "https://peregrine.example.com/login"
+	s := &Server{
+		Keys:   secretKeySet(t, "a-very-secret-secret-that-is-long"),
+		Logger: logger,
+		OIDC: map[string]OIDCProvider{
+			"mock": {Provider: mock.OIDCProvider(), Name: "Mock", RedirectURLs: []string{redirectURI}},
+		},
+	}
+
+	identity := oidctest.Identity{Subject: "1234", Email: "franklin@example.com", EmailVerified: true}
+
+	authorize := func(t *testing.T, provider, redirectURI string) (code, state, loginToken string, status int) {
+		body, _ := json.Marshal(map[string]string{"redirectUri": redirectURI})
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)), map[string]string{"provider": provider})
+		w := httptest.NewRecorder()
+
+		s.oidcAuthorizeHandler()(w, r)
+		if w.Code != http.StatusOK {
+			return "", "", "", w.Code
+		}
+
+		var resp struct {
+			URL        string `json:"url"`
+			LoginToken string `json:"loginToken"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("unable to decode authorize response: %v", err)
+		}
+
+		u, err := url.Parse(resp.URL)
+		if err != nil {
+			t.Fatalf("unable to parse authorization url: %v", err)
+		}
+
+		code, err = mock.Authorize(resp.URL, identity)
+		if err != nil {
+			t.Fatalf("unable to authorize: %v", err)
+		}
+
+		return code, u.Query().Get("state"), resp.LoginToken, w.Code
+	}
+
+	exchange := func(provider, code, state, loginToken string) (oidc.IDToken, error) {
+		body, _ := json.Marshal(oidcCallback{Code: code, State: state, LoginToken: loginToken})
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+
+		return s.exchangeOIDCCode(r, s.OIDC[provider], provider)
+	}
+
+	t.Run("login", func(t *testing.T) {
+		code, state, loginToken, _ := authorize(t, "mock", redirectURI)
+
+		token, err := exchange("mock", code, state, loginToken)
+		if err != nil {
+			t.Fatalf("unable to exchange code: %v", err)
+		}
+
+		if token.Subject != identity.Subject || token.Email != identity.Email {
+			t.Errorf("unexpected id token %+v", token)
+		}
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		if _, _, _, status := authorize(t, "other", redirectURI); status != http.StatusNotFound {
+			t.Errorf("expected status %d but got %d", http.StatusNotFound, status)
+		}
+	})
+
+	t.Run("redirect not allowed", func(t *testing.T) {
+		if _, _, _, status := authorize(t, "mock", "https://evil.example.com"); status != http.StatusUnprocessableEntity {
+			t.Errorf("expected status %d but got %d", http.StatusUnprocessableEntity, status)
+		}
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		code, _, loginToken, _ := authorize(t, "mock", redirectURI)
+
+		if _, err := exchange("mock", code, "forged", loginToken); !errors.Is(err, forbiddenError{}) {
+			t.Errorf("expected forbidden error but got %v", err)
+		}
+	})
+
+	t.Run("login token from another login", func(t *testing.T) {
+		code, state, _, _ := authorize(t, "mock", redirectURI)
+		_, _, otherToken, _ := authorize(t, "mock", redirectURI)
+
+		if _, err := exchange("mock", code, state, otherToken); !errors.Is(err, forbiddenError{}) {
+			t.Errorf("expected forbidden error but got %v", err)
+		}
+	})
+
+	t.Run("access token as login token", func(t *testing.T) {
+		code, state, _, _ := authorize(t, "mock", redirectURI)
+
+		accessToken, err := s.Keys.Sign(&oidcLoginClaims{State: state})
+		if err != nil {
+			t.Fatalf("unable to sign token: %v", err)
+		}
+
+		if _, err := exchange("mock", code, state, accessToken); !errors.Is(err, forbiddenError{}) {
+			t.Errorf("expected forbidden error but got %v", err)
+		}
+	})
+
+	t.Run("missing fields", func(t *testing.T) {
+		if _, err := exchange("mock", "", "", ""); !errors.Is(err, badRequestError{}) {
+			t.Errorf("expected bad request error but got %v", err)
+		}
+	})
+}
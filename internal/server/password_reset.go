@@ -0,0 +1,270 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/notify"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	passwordResetDuration      = time.Hour      // 1 hour
+	adminPasswordResetDuration = time.Hour * 24 // 1 day
+)
+
+// createdPasswordReset is a password reset token an admin created for a user,
+// to be handed to them directly.
+type createdPasswordReset struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// generateResetToken returns a new random password reset token along with the
+// hash of it that is stored.
+func generateResetToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("unable to generate password reset token: %w", err)
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// passwordResetURL returns the link users follow to reset their password with
+// a token, or an empty string if no reset page is configured.
+func passwordResetURL(base, token string) (string, error) {
+	if base == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse password reset url: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func (s *Server) requestPasswordResetHandler() http.HandlerFunc {
+	type resetRequest struct {
+		Username string `json:"username" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rr resetRequest
+		if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(rr); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		// Every request counts against the username and IP address, so this
+		// can't be used to flood someone's inbox or the mail server.
+		if wait, _, _ := s.resets.attempt(rr.Username, ihttp.ClientIP(r, s.TrustProxy), time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			ihttp.Error(w, http.StatusTooManyRequests)
+			return
+		}
+
+		// Always respond the same way so this can't be used to find out which
+		// users exist or have an email address.
+		defer w.WriteHeader(http.StatusAccepted)
+
+		user, err := s.Store.GetUserByUsername(r.Context(), rr.Username)
+		if errors.Is(err, store.ErrNoResults{}) {
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("getting user for password reset")
+			return
+		}
+
+		if user.Email == nil || *user.Email == "" || s.Notifier == nil {
+			return
+		}
+
+		token, hash, err := generateResetToken()
+		if err != nil {
+			s.Logger.WithError(err).Error("generating password reset token")
+			return
+		}
+
+		expiresAt := time.Now().Add(passwordResetDuration)
+		if err := s.Store.CreatePasswordReset(r.Context(), user.ID, hash, nil, expiresAt); err != nil {
+			s.Logger.WithError(err).Error("creating password reset")
+			return
+		}
+
+		link, err := passwordResetURL(s.PasswordResetURL, token)
+		if err != nil {
+			s.Logger.WithError(err).Error("creating password reset url")
+			return
+		}
+
+		body := "Someone asked to reset the password for your peregrine account " + user.Username + ".\n\n"
+		if link != "" {
+			body += "Follow this link within the next hour to choose a new password:\n\n" + link + "\n\n"
+		} else {
+			body += "Use this code within the next hour to choose a new password:\n\n" + token + "\n\n"
+		}
+		body += "If you didn't ask for this you can ignore this message."
+
+		err = s.Notifier.Notify(r.Context(), notify.Message{
+			To:      *user.Email,
+			Subject: "Reset your peregrine password",
+			Body:    body,
+		})
+		if err != nil {
+			s.Logger.WithError(err).WithField("userId", user.ID).Error("sending password reset")
+		}
+	}
+}
+
+func (s *Server) confirmPasswordResetHandler() http.HandlerFunc {
+	type resetConfirmation struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"gte=8,lte=128"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rc resetConfirmation
+		if err := json.NewDecoder(r.Body).Decode(&rc); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(rc); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		var userID int64
+		err := s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			if userID, err = s.Store.LockPasswordResetTx(r.Context(), tx, hashResetToken(rc.Token)); err != nil {
+				return err
+			}
+
+			// Only hash the password once the token is known to be good, so
+			// made up tokens can't be used to exhaust the server's CPU.
+			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(rc.Password), bcryptCost)
+			if err != nil {
+				return fmt.Errorf("unable to hash password: %w", err)
+			}
+
+			if err := s.Store.ResetPasswordTx(r.Context(), tx, userID, string(hashedPassword)); err != nil {
+				return err
+			}
+
+			user, err := s.Store.GetUserByIDTx(r.Context(), tx, userID)
+			if err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &user.RealmID, "user.password.reset", store.AuditTargetUser, auditID(userID), nil, nil)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("resetting password")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		s.Logger.WithField("userId", userID).Info("reset password")
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) createPasswordResetHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targetID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		target, err := s.checkManageUser(r, targetID)
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("getting user")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		token, hash, err := generateResetToken()
+		if err != nil {
+			s.Logger.WithError(err).Error("generating password reset token")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		reset := createdPasswordReset{Token: token, ExpiresAt: time.Now().Add(adminPasswordResetDuration)}
+
+		reset.URL, err = passwordResetURL(s.PasswordResetURL, token)
+		if err != nil {
+			s.Logger.WithError(err).Error("creating password reset url")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.CreatePasswordResetTx(r.Context(), tx, targetID, hash, &subjectID, reset.ExpiresAt); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &target.RealmID, "user.password.resetLink", store.AuditTargetUser, auditID(targetID), nil, nil)
+		})
+		if err != nil {
+			s.Logger.WithError(err).Error("creating password reset")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, reset, http.StatusCreated)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import "testing"
+
+func TestGenerateResetToken(t *testing.T) {
+	token, hash, err := generateResetToken()
+	if err != nil {
+		t.Fatalf("unable to generate password reset token: %v", err)
+	}
+
+	if token == "" || hash != hashResetToken(token) || hash == token {
+		t.Errorf("expected hash to be the hash of the token")
+	}
+
+	other, _, err := generateResetToken()
+	if err != nil || other == token {
+		t.Errorf("expected tokens to be unique")
+	}
+}
+
+func TestPasswordResetURL(t *testing.T) {
+	testCases := []struct {
+		name string
+		base string
+		want string
+	}{
+		{name: "no reset page", base: "", want: ""},
+		{name: "reset page", base: "https://example.com/reset", want: "https://example.com/reset?token=abc-_123"},
+		{name: "existing query", base: "https://example.com/?page=reset", want: "https://example.com/?page=reset&token=abc-_123"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := passwordResetURL(tt.base, "abc-_123")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("expected %q but got %q", tt.want, got)
+			}
+		})
+	}
+}
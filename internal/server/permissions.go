@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+)
+
+// checkRealmPermission returns a forbiddenError unless the requesting user is a
+// super-admin, or belongs to the realm and has the given permission.
+func checkRealmPermission(r *http.Request, realmID int64, permission string) error {
+	if ihttp.GetRoles(r).IsSuperAdmin {
+		return nil
+	}
+
+	userRealmID, err := ihttp.GetRealmID(r)
+	if err != nil || userRealmID != realmID || !ihttp.GetPermissions(r).Has(permission) {
+		return forbiddenError{fmt.Errorf("%s permission in realm %d required", permission, realmID)}
+	}
+
+	return nil
+}
+
+// checkMemberPermission is like checkRealmPermission, but also allows users who
+// have the permission in the realm through any realm they belong to, not just
+// their active realm.
+func (s *Server) checkMemberPermission(r *http.Request, realmID int64, permission string) error {
+	if err := checkRealmPermission(r, realmID, permission); err == nil {
+		return nil
+	}
+
+	subjectID, err := ihttp.GetSubject(r)
+	if err != nil {
+		return forbiddenError{err}
+	}
+
+	user, err := s.Store.GetUserByID(r.Context(), subjectID)
+	if err != nil {
+		return fmt.Errorf("unable to get user: %w", err)
+	}
+
+	if user.RealmID != realmID {
+		m, err := s.Store.GetRealmMembership(r.Context(), subjectID, realmID)
+		if errors.Is(err, store.ErrNoResults{}) {
+			return forbiddenError{fmt.Errorf("not a member of realm %d", realmID)}
+		} else if err != nil {
+			return err
+		}
+
+		user = user.InRealm(m)
+	}
+
+	if !user.Permissions().Has(permission) {
+		return forbiddenError{fmt.Errorf("%s permission in realm %d required", permission, realmID)}
+	}
+
+	return nil
+}
+
+// checkManageUser returns the target user if the requesting user can manage
+// them. Super-admins can manage anyone. Otherwise the requesting user needs to
+// be able to manage users in the target's realm, and can't manage super-admins
+// or users with permissions they don't have themselves.
+func (s *Server) checkManageUser(r *http.Request, targetID int64) (store.User, error) {
+	target, err := s.Store.GetUserByID(r.Context(), targetID)
+	if err != nil {
+		return target, err
+	}
+
+	return target, checkManageTarget(r, target)
+}
+
+// checkManageTarget returns a forbiddenError unless the requesting user can
+// manage the target user (see checkManageUser).
+func checkManageTarget(r *http.Request, target store.User) error {
+	if ihttp.GetRoles(r).IsSuperAdmin {
+		return nil
+	}
+
+	if target.Roles.IsSuperAdmin {
+		return forbiddenError{errors.New("only super-admins can manage super-admins")}
+	}
+
+	if err := checkRealmPermission(r, target.RealmID, store.PermUsersManage); err != nil {
+		return err
+	}
+
+	if !ihttp.GetPermissions(r).Contains(target.Permissions()) {
+		return forbiddenError{errors.New("can't manage users with permissions you don't have")}
+	}
+
+	return nil
+}
+
+// checkGrantRoles returns a forbiddenError unless the requesting user has every
+// permission the given built-in roles and realm role would grant, so nobody
+// can give out more than they have. Only super-admins can grant super-admin.
+// If the role doesn't belong to the realm an ErrNoResults is returned.
+func (s *Server) checkGrantRoles(r *http.Request, realmID int64, roles store.Roles, roleID *int64) error {
+	var rolePermissions []string
+	if roleID != nil && *roleID != 0 {
+		role, err := s.Store.GetRealmRole(r.Context(), realmID, *roleID)
+		if err != nil {
+			return err
+		}
+
+		rolePermissions = role.Permissions
+	}
+
+	if ihttp.GetRoles(r).IsSuperAdmin {
+		return nil
+	}
+
+	if roles.IsSuperAdmin {
+		return forbiddenError{errors.New("only super-admins can grant super-admin")}
+	}
+
+	if !ihttp.GetPermissions(r).Contains(store.NewPermissionSet(roles, rolePermissions)) {
+		return forbiddenError{errors.New("can't grant permissions you don't have")}
+	}
+
+	return nil
+}
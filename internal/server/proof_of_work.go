@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	challengeDuration = time.Minute * 10
+	// maxChallenges bounds how many unsolved challenges are remembered, so
+	// requesting lots of them can't use up memory. When it's reached the
+	// oldest challenge is forgotten to make room.
+	maxChallenges = 10000
+	// maxChallengesPerIP bounds how many unsolved challenges one IP address
+	// can have, so a single client can't push everyone else's challenges out.
+	maxChallengesPerIP = 5
+)
+
+var errTooManyChallenges = errors.New("too many outstanding challenges")
+
+type challenge struct {
+	ip      string
+	expires time.Time
+}
+
+// challenges hands out proof-of-work challenges and checks solutions. A
+// challenge is solved by finding a nonce where the SHA-256 hash of the
+// challenge followed by the nonce starts with difficulty zero bits. Each
+// challenge can only be solved once.
+type challenges struct {
+	mu      sync.Mutex
+	issued  map[string]challenge
+	perIP   map[string]int
+	maxSize int
+	maxIP   int
+}
+
+func newChallenges() *challenges {
+	return &challenges{
+		issued:  make(map[string]challenge),
+		perIP:   make(map[string]int),
+		maxSize: maxChallenges,
+		maxIP:   maxChallengesPerIP,
+	}
+}
+
+// issue returns a new challenge for ip and when it expires. If ip already has
+// too many unsolved challenges errTooManyChallenges is returned.
+func (c *challenges) issue(ip string, now time.Time) (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to generate challenge: %w", err)
+	}
+
+	key := hex.EncodeToString(b)
+	expires := now.Add(challengeDuration)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.perIP[ip] >= c.maxIP || len(c.issued) >= c.maxSize {
+		c.prune(now)
+	}
+
+	if c.perIP[ip] >= c.maxIP {
+		return "", time.Time{}, errTooManyChallenges
+	}
+
+	if len(c.issued) >= c.maxSize {
+		c.evictOldest()
+	}
+
+	c.issued[key] = challenge{ip: ip, expires: expires}
+	c.perIP[ip]++
+
+	return key, expires, nil
+}
+
+// prune forgets expired challenges.
+func (c *challenges) prune(now time.Time) {
+	for key, ch := range c.issued {
+		if !now.Before(ch.expires) {
+			c.remove(key, ch)
+		}
+	}
+}
+
+// evictOldest forgets the challenge that expires soonest.
+func (c *challenges) evictOldest() {
+	var oldestKey string
+	var oldest challenge
+	for key, ch := range c.issued {
+		if oldestKey == "" || ch.expires.Before(oldest.expires) {
+			oldestKey, oldest = key, ch
+		}
+	}
+
+	if oldestKey != "" {
+		c.remove(oldestKey, oldest)
+	}
+}
+
+func (c *challenges) remove(key string, ch challenge) {
+	delete(c.issued, key)
+
+	if c.perIP[ch.ip] <= 1 {
+		delete(c.perIP, ch.ip)
+	} else {
+		c.perIP[ch.ip]--
+	}
+}
+
+// solve returns whether the nonce solves an unexpired challenge, and uses up
+// the challenge if it does.
+func (c *challenges) solve(key, nonce string, difficulty int, now time.Time) bool {
+	if leadingZeroBits(sha256.Sum256([]byte(key+nonce))) < difficulty {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.issued[key]
+	if !ok {
+		return false
+	}
+
+	c.remove(key, ch)
+
+	return now.Before(ch.expires)
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+
+	return n
+}
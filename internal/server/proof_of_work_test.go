@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func solveChallenge(challenge string, difficulty int) string {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		if leadingZeroBits(sha256.Sum256([]byte(challenge+nonce))) >= difficulty {
+			return nonce
+		}
+	}
+}
+
+func TestChallenges(t *testing.T) {
+	const difficulty = 8
+	const ip = "192.0.2.1"
+	now := time.Unix(1558050528, 0)
+
+	t.Run("solved once", func(t *testing.T) {
+		c := newChallenges()
+
+		challenge, expires, err := c.issue(ip, now)
+		if err != nil {
+			t.Fatalf("unable to issue challenge: %v", err)
+		}
+
+		if !expires.Equal(now.Add(challengeDuration)) {
+			t.Errorf("expected challenge to expire at %v but got %v", now.Add(challengeDuration), expires)
+		}
+
+		nonce := solveChallenge(challenge, difficulty)
+
+		if !c.solve(challenge, nonce, difficulty, now) {
+			t.Fatalf("expected nonce %q to solve challenge %q", nonce, challenge)
+		}
+
+		if c.solve(challenge, nonce, difficulty, now) {
+			t.Errorf("expected challenge to only be solvable once")
+		}
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		c := newChallenges()
+
+		challenge, _, err := c.issue(ip, now)
+		if err != nil {
+			t.Fatalf("unable to issue challenge: %v", err)
+		}
+
+		if c.solve(challenge, "nonce", sha256.Size*8, now) {
+			t.Errorf("expected nonce to not solve challenge")
+		}
+
+		if !c.solve(challenge, solveChallenge(challenge, difficulty), difficulty, now) {
+			t.Errorf("expected challenge to still be solvable after a wrong nonce")
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		c := newChallenges()
+
+		challenge, _, err := c.issue(ip, now)
+		if err != nil {
+			t.Fatalf("unable to issue challenge: %v", err)
+		}
+
+		if c.solve(challenge, solveChallenge(challenge, difficulty), difficulty, now.Add(challengeDuration)) {
+			t.Errorf("expected expired challenge to not be solvable")
+		}
+	})
+
+	t.Run("per ip limit", func(t *testing.T) {
+		c := newChallenges()
+
+		var first string
+		for i := 0; i < c.maxIP; i++ {
+			challenge, _, err := c.issue(ip, now)
+			if err != nil {
+				t.Fatalf("unable to issue challenge %d: %v", i, err)
+			}
+			if i == 0 {
+				first = challenge
+			}
+		}
+
+		if _, _, err := c.issue(ip, now); err != errTooManyChallenges {
+			t.Errorf("expected too many challenges error but got %v", err)
+		}
+
+		if _, _, err := c.issue("192.0.2.2", now); err != nil {
+			t.Errorf("expected another ip to get a challenge but got %v", err)
+		}
+
+		if !c.solve(first, solveChallenge(first, difficulty), difficulty, now) {
+			t.Fatalf("expected challenge to be solvable")
+		}
+
+		if _, _, err := c.issue(ip, now); err != nil {
+			t.Errorf("expected solving a challenge to free up room but got %v", err)
+		}
+
+		if _, _, err := c.issue(ip, now.Add(challengeDuration)); err != nil {
+			t.Errorf("expected expired challenges to not count but got %v", err)
+		}
+	})
+
+	t.Run("evicts oldest", func(t *testing.T) {
+		c := newChallenges()
+		c.maxSize = 2
+
+		oldest, _, err := c.issue("192.0.2.1", now)
+		if err != nil {
+			t.Fatalf("unable to issue challenge: %v", err)
+		}
+
+		newer, _, err := c.issue("192.0.2.2", now.Add(time.Second))
+		if err != nil {
+			t.Fatalf("unable to issue challenge: %v", err)
+		}
+
+		if _, _, err := c.issue("192.0.2.3", now.Add(time.Second*2)); err != nil {
+			t.Fatalf("expected a full pool to make room but got %v", err)
+		}
+
+		if c.solve(oldest, solveChallenge(oldest, difficulty), difficulty, now) {
+			t.Errorf("expected the oldest challenge to have been evicted")
+		}
+
+		if !c.solve(newer, solveChallenge(newer, difficulty), difficulty, now) {
+			t.Errorf("expected newer challenge to still be solvable")
+		}
+	})
+
+	t.Run("unknown challenge", func(t *testing.T) {
+		c := newChallenges()
+
+		challenge := "0123456789abcdef"
+		if c.solve(challenge, solveChallenge(challenge, difficulty), difficulty, now) {
+			t.Errorf("expected challenge that wasn't issued to not be solvable")
+		}
+	})
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	testCases := []struct {
+		prefix []byte
+		want   int
+	}{
+		{prefix: []byte{0x80}, want: 0},
+		{prefix: []byte{0x01}, want: 7},
+		{prefix: []byte{0x00, 0x40}, want: 9},
+		{prefix: []byte{0x00, 0x00, 0x00, 0x0f}, want: 28},
+	}
+
+	for _, tt := range testCases {
+		var sum [sha256.Size]byte
+		copy(sum[:], tt.prefix)
+		sum[sha256.Size-1] = 1
+
+		if got := leadingZeroBits(sum); got != tt.want {
+			t.Errorf("leadingZeroBits(%x): expected %d but got %d", tt.prefix, tt.want, got)
+		}
+	}
+}
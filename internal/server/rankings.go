@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/ranking"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/jmoiron/sqlx"
+)
+
+// updateRankingsTx recomputes the rankings of one of a realm's custom events
+// from its stored match scores, using the rules for the season the event is in.
+// Events from TBA get their rankings from TBA instead, and events outside the
+// realm are left alone. It runs in the transaction that changed the matches,
+// which must hold the event lock or the exclusive matches lock, so concurrent
+// changes can't leave stale rankings behind.
+func (s *Server) updateRankingsTx(ctx context.Context, tx *sqlx.Tx, eventKey string, realmID int64) error {
+	event, err := s.Store.GetEventForRealmTx(ctx, tx, eventKey, &realmID)
+	if errors.Is(err, store.ErrNoResults{}) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to get event to update rankings: %w", err)
+	}
+
+	if event.RealmID == nil {
+		return nil
+	}
+
+	matches, err := s.Store.GetMatchesForRealmTx(ctx, tx, eventKey, nil, false, &realmID)
+	if err != nil {
+		return fmt.Errorf("unable to get matches to update rankings: %w", err)
+	}
+
+	teams := ranking.Compute(eventKey, matches, ranking.RulesForYear(event.StartDate.Year()))
+	if err := s.Store.SetEventRankingsTx(ctx, tx, eventKey, teams); err != nil {
+		return fmt.Errorf("unable to store rankings: %w", err)
+	}
+
+	return nil
+}
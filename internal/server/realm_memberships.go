@@ -0,0 +1,289 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+// checkManageMember returns the target's membership in the realm if the
+// requesting user can manage it. Like checkManageUser, this needs the
+// permission to manage users in the realm, and nobody can manage members with
+// permissions they don't have themselves.
+func (s *Server) checkManageMember(r *http.Request, realmID, userID int64) (store.RealmMembership, error) {
+	m, err := s.Store.GetRealmMembership(r.Context(), userID, realmID)
+	if err != nil {
+		return m, err
+	}
+
+	if err := checkRealmPermission(r, realmID, store.PermUsersManage); err != nil {
+		return m, err
+	}
+
+	if !ihttp.GetRoles(r).IsSuperAdmin && !ihttp.GetPermissions(r).Contains(store.NewPermissionSet(m.Roles, m.RolePermissions)) {
+		return m, forbiddenError{errors.New("can't manage members with permissions you don't have")}
+	}
+
+	return m, nil
+}
+
+func (s *Server) getUserRealmsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		err = s.checkManageSessions(r, id)
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("checking realm membership permissions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		memberships, err := s.Store.GetUserRealmMemberships(r.Context(), id)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting user realm memberships")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, memberships, http.StatusOK)
+	}
+}
+
+func (s *Server) joinRealmHandler() http.HandlerFunc {
+	type requestJoin struct {
+		InviteCode string `json:"inviteCode" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		// Only users themselves can join realms, since they get access to
+		// everything in them.
+		if subjectID, err := ihttp.GetSubject(r); err != nil || subjectID != id {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		var rj requestJoin
+		if err := json.NewDecoder(r.Body).Decode(&rj); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(rj); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		var m store.RealmMembership
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			if m, err = s.Store.JoinRealmWithInviteTx(r.Context(), tx, id, normalizeInviteCode(rj.InviteCode)); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &m.RealmID, "user.membership.create", store.AuditTargetUser, auditID(id), nil, m)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrExists{}) {
+			ihttp.Error(w, http.StatusConflict)
+			return
+		} else if errors.Is(err, store.ErrQuotaExceeded{}) {
+			ihttp.Respond(w, newQuotaResponse(err), http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("joining realm")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, m, http.StatusCreated)
+	}
+}
+
+func (s *Server) getRealmMembersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermUsersManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		members, err := s.Store.GetRealmMembers(r.Context(), realmID)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting realm members")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, members, http.StatusOK)
+	}
+}
+
+func (s *Server) updateRealmMemberHandler() http.HandlerFunc {
+	type requestMember struct {
+		Roles  store.Roles `json:"roles"`
+		RoleID *int64      `json:"roleId"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		realmID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		userID, err := strconv.ParseInt(vars["userId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		var rm requestMember
+		if err := json.NewDecoder(r.Body).Decode(&rm); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		// Super-admin is global, so it can't be granted through a membership
+		if rm.Roles.IsSuperAdmin {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		_, err = s.checkManageMember(r, realmID, userID)
+		if err == nil {
+			err = s.checkGrantRoles(r, realmID, rm.Roles, rm.RoleID)
+		}
+
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("checking realm member permissions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			before, err := s.Store.GetRealmMembershipTx(r.Context(), tx, userID, realmID)
+			if err != nil {
+				return err
+			}
+
+			m := before
+			m.Roles = rm.Roles
+			m.RoleID = rm.RoleID
+
+			if err := s.Store.UpdateRealmMembershipTx(r.Context(), tx, m); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "user.membership.update", store.AuditTargetUser, auditID(userID), before, m)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("updating realm member")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) deleteRealmMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		realmID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		userID, err := strconv.ParseInt(vars["userId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		// Users can always leave realms themselves
+		if subjectID, err := ihttp.GetSubject(r); err != nil || subjectID != userID {
+			_, err = s.checkManageMember(r, realmID, userID)
+			if errors.Is(err, forbiddenError{}) {
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			} else if errors.Is(err, store.ErrNoResults{}) {
+				ihttp.Error(w, http.StatusNotFound)
+				return
+			} else if err != nil {
+				s.Logger.WithError(err).Error("checking realm member permissions")
+				ihttp.Error(w, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			before, err := s.Store.GetRealmMembershipTx(r.Context(), tx, userID, realmID)
+			if err != nil {
+				return err
+			}
+
+			if err := s.Store.DeleteRealmMembershipTx(r.Context(), tx, userID, realmID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "user.membership.delete", store.AuditTargetUser, auditID(userID), before, nil)
+		})
+
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("removing realm member")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+// QuotaResponse is returned when a request would put a realm over one of its
+// quotas.
+type QuotaResponse struct {
+	Error string `json:"error"`
+	Quota string `json:"quota"`
+	Limit int    `json:"limit"`
+}
+
+// newQuotaResponse describes the store.ErrQuotaExceeded in err.
+func newQuotaResponse(err error) QuotaResponse {
+	var quotaErr store.ErrQuotaExceeded
+	_ = errors.As(err, &quotaErr)
+
+	return QuotaResponse{Error: "quota exceeded", Quota: quotaErr.Quota, Limit: quotaErr.Limit}
+}
+
+// realmUsageHandler returns a handler to get how much each realm is storing
+// compared to its quotas.
+func (s *Server) realmUsageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		usage, err := s.Store.GetRealmUsage(r.Context())
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("getting realm usage")
+			return
+		}
+
+		ihttp.Respond(w, usage, http.StatusOK)
+	}
+}
+
+// getRealmQuotasHandler returns a handler to get the quotas set for a specific
+// realm, without defaults filled in.
+func (s *Server) getRealmQuotasHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		quotas, err := s.Store.GetRealmQuotas(r.Context(), realmID)
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("getting realm quotas")
+			return
+		}
+
+		ihttp.Respond(w, quotas, http.StatusOK)
+	}
+}
+
+// setRealmQuotasHandler returns a handler to set a specific realm's quotas.
+// Quotas that are left out use the defaults.
+func (s *Server) setRealmQuotasHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		var quotas store.RealmQuotas
+		if err := json.NewDecoder(r.Body).Decode(&quotas); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(quotas); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			before, err := s.Store.GetRealmQuotasTx(r.Context(), tx, realmID)
+			if err != nil {
+				return err
+			}
+
+			if err := s.Store.SetRealmQuotasTx(r.Context(), tx, realmID, quotas); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.quotas.update", store.AuditTargetRealm, auditID(realmID), before, quotas)
+		})
+		if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("setting realm quotas")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
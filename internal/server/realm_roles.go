@@ -0,0 +1,199 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+func (s *Server) getRealmRolesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermUsersManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		roles, err := s.Store.GetRealmRoles(r.Context(), realmID)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting realm roles")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, roles, http.StatusOK)
+	}
+}
+
+func (s *Server) createRealmRoleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermRealmManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		var role store.RealmRole
+		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(role); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		role.RealmID = realmID
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			if role, err = s.Store.CreateRealmRoleTx(r.Context(), tx, role); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.role.create", store.AuditTargetRealm, auditID(realmID), nil, role)
+		})
+		if errors.Is(err, store.ErrExists{}) {
+			ihttp.Error(w, http.StatusConflict)
+			return
+		} else if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("creating realm role")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, role, http.StatusCreated)
+	}
+}
+
+func (s *Server) updateRealmRoleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		realmID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		roleID, err := strconv.ParseInt(vars["roleId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermRealmManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		var role store.RealmRole
+		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(role); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		role.ID = roleID
+		role.RealmID = realmID
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			before, err := s.Store.GetRealmRoleTx(r.Context(), tx, realmID, roleID)
+			if err != nil {
+				return err
+			}
+
+			if err := s.Store.UpdateRealmRoleTx(r.Context(), tx, role); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.role.update", store.AuditTargetRealm, auditID(realmID), before, role)
+		})
+
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if errors.Is(err, store.ErrExists{}) {
+			ihttp.Error(w, http.StatusConflict)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("updating realm role")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) deleteRealmRoleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		realmID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		roleID, err := strconv.ParseInt(vars["roleId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermRealmManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			before, err := s.Store.GetRealmRoleTx(r.Context(), tx, realmID, roleID)
+			if err != nil {
+				return err
+			}
+
+			if err := s.Store.DeleteRealmRoleTx(r.Context(), tx, realmID, roleID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.role.delete", store.AuditTargetRealm, auditID(realmID), before, nil)
+		})
+
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("deleting realm role")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+// visibleAnnouncements returns the announcements that haven't expired yet.
+func visibleAnnouncements(announcements []store.Announcement, now time.Time) []store.Announcement {
+	visible := make([]store.Announcement, 0, len(announcements))
+	for _, a := range announcements {
+		if !a.Expired(now) {
+			visible = append(visible, a)
+		}
+	}
+
+	return visible
+}
+
+// mergeRealmSettings applies a JSON merge patch (RFC 7396) to the top level
+// of the settings: settings in the patch replace the current ones, and null
+// resets a setting to its default. Unknown settings are a badRequestError.
+func mergeRealmSettings(settings store.RealmSettings, patch map[string]json.RawMessage) (store.RealmSettings, error) {
+	current, err := json.Marshal(settings)
+	if err != nil {
+		return settings, fmt.Errorf("unable to marshal realm settings: %w", err)
+	}
+
+	merged := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(current, &merged); err != nil {
+		return settings, fmt.Errorf("unable to unmarshal realm settings: %w", err)
+	}
+
+	for name, value := range patch {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			delete(merged, name)
+		} else {
+			merged[name] = value
+		}
+	}
+
+	j, err := json.Marshal(merged)
+	if err != nil {
+		return settings, fmt.Errorf("unable to marshal realm settings: %w", err)
+	}
+
+	var updated store.RealmSettings
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&updated); err != nil {
+		return settings, badRequestError{fmt.Errorf("invalid realm settings: %w", err)}
+	}
+
+	return updated, nil
+}
+
+// checkRealmSettings validates the settings named in the patch. Problems with
+// the settings are returned as a badRequestError.
+func (s *Server) checkRealmSettings(ctx context.Context, realmID int64, settings store.RealmSettings, patch map[string]json.RawMessage) error {
+	if err := validator.New().Struct(settings); err != nil {
+		return badRequestError{err}
+	}
+
+	if _, ok := patch["timezone"]; ok && settings.Timezone != "" {
+		if _, err := time.LoadLocation(settings.Timezone); err != nil {
+			return badRequestError{fmt.Errorf("unknown timezone %q", settings.Timezone)}
+		}
+	}
+
+	if _, ok := patch["defaultSchemas"]; ok {
+		for year, schemaID := range settings.DefaultSchemas {
+			schema, err := s.Store.GetSchemaByID(ctx, schemaID)
+			if errors.Is(err, store.ErrNoResults{}) || (err == nil && schema.RealmID != nil && *schema.RealmID != realmID) {
+				return badRequestError{fmt.Errorf("default schema %d for %d does not exist", schemaID, year)}
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, ok := patch["defaultEvents"]; ok {
+		for _, eventKey := range settings.DefaultEvents {
+			_, err := s.Store.GetEventForRealm(ctx, eventKey, &realmID)
+			if errors.Is(err, store.ErrNoResults{}) {
+				return badRequestError{fmt.Errorf("default event %s does not exist", eventKey)}
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getRealmSettingsHandler returns a handler to get a realm's settings. Anyone
+// in the realm can see its settings, but expired announcements are left out.
+func (s *Server) getRealmSettingsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if userRealmID, err := ihttp.GetRealmID(r); !ihttp.GetRoles(r).IsSuperAdmin && (err != nil || userRealmID != realmID) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		settings, err := s.Store.GetRealmSettings(r.Context(), realmID)
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("getting realm settings")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		settings.Announcements = visibleAnnouncements(settings.Announcements, time.Now())
+
+		ihttp.Respond(w, settings, http.StatusOK)
+	}
+}
+
+// patchRealmSettingsHandler returns a handler to update some of a realm's
+// settings, see mergeRealmSettings. It responds with the updated settings.
+func (s *Server) patchRealmSettingsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermRealmManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		var patch map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		now := time.Now()
+
+		var settings store.RealmSettings
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var before store.RealmSettings
+			var err error
+			settings, err = s.Store.UpdateRealmSettingsTx(r.Context(), tx, realmID, func(settings *store.RealmSettings) error {
+				before = *settings
+
+				updated, err := mergeRealmSettings(*settings, patch)
+				if err != nil {
+					return err
+				}
+
+				if err := s.checkRealmSettings(r.Context(), realmID, updated, patch); err != nil {
+					return err
+				}
+
+				updated.Announcements = visibleAnnouncements(updated.Announcements, now)
+				*settings = updated
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.settings.update", store.AuditTargetRealm, auditID(realmID), before, settings)
+		})
+		var badRequest badRequestError
+		if errors.As(err, &badRequest) {
+			ihttp.Respond(w, badRequest, http.StatusUnprocessableEntity)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("updating realm settings")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, settings, http.StatusOK)
+	}
+}
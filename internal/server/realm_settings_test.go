@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeRealmSettings(t *testing.T) {
+	teamNumber := 2733
+
+	current := store.RealmSettings{
+		TeamNumber:            &teamNumber,
+		Timezone:              "America/Los_Angeles",
+		DefaultSchemas:        map[int]int64{2019: 4},
+		LeaderboardVisibility: store.LeaderboardManagers,
+	}
+
+	testCases := []struct {
+		name    string
+		patch   string
+		want    store.RealmSettings
+		wantErr bool
+	}{
+		{
+			name:  "empty patch",
+			patch: `{}`,
+			want:  current,
+		},
+		{
+			name:  "replace setting",
+			patch: `{"timezone": "America/New_York", "defaultSchemas": {"2020": 5}}`,
+			want: store.RealmSettings{
+				TeamNumber:            &teamNumber,
+				Timezone:              "America/New_York",
+				DefaultSchemas:        map[int]int64{2020: 5},
+				LeaderboardVisibility: store.LeaderboardManagers,
+			},
+		},
+		{
+			name:  "reset setting",
+			patch: `{"teamNumber": null, "leaderboardVisibility": null}`,
+			want: store.RealmSettings{
+				Timezone:       "America/Los_Angeles",
+				DefaultSchemas: map[int]int64{2019: 4},
+			},
+		},
+		{
+			name:    "unknown setting",
+			patch:   `{"colour": "red"}`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			patch:   `{"teamNumber": "2733"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+				t.Fatalf("unable to unmarshal patch: %v", err)
+			}
+
+			got, err := mergeRealmSettings(current, patch)
+			if tt.wantErr {
+				if !errors.Is(err, badRequestError{}) {
+					t.Errorf("expected badRequestError but got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("settings differ (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
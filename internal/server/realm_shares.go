@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+func (s *Server) getRealmSharesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermRealmManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		shares, err := s.Store.GetRealmShares(r.Context(), realmID)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting realm shares")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, shares, http.StatusOK)
+	}
+}
+
+func (s *Server) createRealmShareHandler() http.HandlerFunc {
+	type requestShare struct {
+		PartnerRealmID int64   `json:"partnerRealmId" validate:"required"`
+		EventKey       *string `json:"eventKey" validate:"omitempty,min=1"`
+		Year           *int    `json:"year" validate:"omitempty,min=1992"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermRealmManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		var rs requestShare
+		if err := json.NewDecoder(r.Body).Decode(&rs); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(rs); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if rs.PartnerRealmID == realmID || (rs.EventKey != nil && rs.Year != nil) {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		share := store.RealmShare{
+			RealmID:        realmID,
+			PartnerRealmID: rs.PartnerRealmID,
+			EventKey:       rs.EventKey,
+			Year:           rs.Year,
+			CreatedBy:      &subjectID,
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			if share, err = s.Store.CreateRealmShareTx(r.Context(), tx, share); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.share.create", store.AuditTargetRealm, auditID(realmID), nil, share)
+		})
+		if errors.Is(err, store.ErrExists{}) {
+			ihttp.Error(w, http.StatusConflict)
+			return
+		} else if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("creating realm share")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, share, http.StatusCreated)
+	}
+}
+
+func (s *Server) acceptRealmShareHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		realmID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		shareID, err := strconv.ParseInt(vars["shareId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermRealmManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.AcceptRealmShareTx(r.Context(), tx, realmID, shareID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.share.accept", store.AuditTargetRealm, auditID(realmID), nil, map[string]int64{"shareId": shareID})
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("accepting realm share")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) deleteRealmShareHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		realmID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		shareID, err := strconv.ParseInt(vars["shareId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermRealmManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.DeleteRealmShareTx(r.Context(), tx, realmID, shareID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "realm.share.delete", store.AuditTargetRealm, auditID(realmID), map[string]int64{"shareId": shareID}, nil)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("deleting realm share")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
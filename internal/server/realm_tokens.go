@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+const realmTokenDuration = time.Hour * 24 * 30 // 30 days
+
+// createdRealmToken is a new realm token, which is only ever shown when it's
+// created.
+type createdRealmToken struct {
+	store.RealmToken
+	Token string `json:"token"`
+}
+
+func (s *Server) createRealmTokenHandler() http.HandlerFunc {
+	type requestToken struct {
+		Note      string     `json:"note" validate:"lte=200"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		var rt requestToken
+		if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(rt); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		expiresAt := time.Now().Add(realmTokenDuration)
+		if rt.ExpiresAt != nil {
+			if !rt.ExpiresAt.After(time.Now()) {
+				ihttp.Error(w, http.StatusUnprocessableEntity)
+				return
+			}
+			expiresAt = *rt.ExpiresAt
+		}
+
+		// Realm tokens are generated and hashed the same way as password
+		// reset tokens.
+		token, hash, err := generateResetToken()
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("generating realm token")
+			return
+		}
+
+		created, err := s.Store.CreateRealmToken(r.Context(), store.RealmToken{
+			TokenHash: hash,
+			Note:      rt.Note,
+			CreatedBy: &subjectID,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("creating realm token")
+			return
+		}
+
+		ihttp.Respond(w, createdRealmToken{RealmToken: created, Token: token}, http.StatusCreated)
+	}
+}
+
+func (s *Server) getRealmTokensHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		tokens, err := s.Store.GetRealmTokens(r.Context())
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("getting realm tokens")
+			return
+		}
+
+		ihttp.Respond(w, tokens, http.StatusOK)
+	}
+}
+
+func (s *Server) deleteRealmTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		err = s.Store.DeleteRealmToken(r.Context(), id)
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("deleting realm token")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
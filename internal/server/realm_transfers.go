@@ -0,0 +1,256 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+func (s *Server) createUserTransferHandler() http.HandlerFunc {
+	type requestTransfer struct {
+		RealmID int64 `json:"realmId" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		var rt requestTransfer
+		if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(rt); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		// Whoever requests the transfer approves it for each realm they can
+		// manage users in.
+		target, err := s.checkManageUser(r, id)
+		fromApproved := err == nil
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil && !errors.Is(err, forbiddenError{}) {
+			s.Logger.WithError(err).Error("checking user transfer permissions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		err = s.checkInviteUsers(r, rt.RealmID)
+		toApproved := err == nil
+		if err != nil && !errors.Is(err, forbiddenError{}) {
+			s.Logger.WithError(err).Error("checking user transfer permissions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		if subjectID != id && !fromApproved && !toApproved {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		if rt.RealmID == target.RealmID {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		transfer := store.RealmTransfer{UserID: id, FromRealmID: target.RealmID, ToRealmID: rt.RealmID, RequestedBy: &subjectID}
+		if fromApproved {
+			transfer.FromApprovedBy = &subjectID
+		}
+		if toApproved {
+			transfer.ToApprovedBy = &subjectID
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			if transfer, err = s.Store.CreateRealmTransferTx(r.Context(), tx, transfer); err != nil {
+				return err
+			}
+
+			return s.auditTransfer(r, tx, "user.transfer.create", transfer)
+		})
+		if errors.Is(err, store.ErrExists{}) {
+			ihttp.Error(w, http.StatusConflict)
+			return
+		} else if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		} else if errors.Is(err, store.ErrQuotaExceeded{}) {
+			ihttp.Respond(w, newQuotaResponse(err), http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("creating realm transfer")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, transfer, http.StatusCreated)
+	}
+}
+
+func (s *Server) getRealmTransfersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermUsersManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		transfers, err := s.Store.GetRealmTransfers(r.Context(), realmID)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting realm transfers")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, transfers, http.StatusOK)
+	}
+}
+
+func (s *Server) approveRealmTransferHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		realmID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		transferID, err := strconv.ParseInt(vars["transferId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		err = s.checkInviteUsers(r, realmID)
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("checking transfer permissions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		var transfer store.RealmTransfer
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			if transfer, err = s.Store.ApproveRealmTransferTx(r.Context(), tx, realmID, transferID, subjectID); err != nil {
+				return err
+			}
+
+			return s.auditTransfer(r, tx, "user.transfer.approve", transfer)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if errors.Is(err, store.ErrQuotaExceeded{}) {
+			ihttp.Respond(w, newQuotaResponse(err), http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("approving realm transfer")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, transfer, http.StatusOK)
+	}
+}
+
+func (s *Server) deleteRealmTransferHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		realmID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		transferID, err := strconv.ParseInt(vars["transferId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := checkRealmPermission(r, realmID, store.PermUsersManage); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			transfers, err := s.Store.GetRealmTransfersTx(r.Context(), tx, realmID)
+			if err != nil {
+				return err
+			}
+
+			var before store.RealmTransfer
+			for _, t := range transfers {
+				if t.ID == transferID {
+					before = t
+				}
+			}
+
+			if err := s.Store.DeleteRealmTransferTx(r.Context(), tx, realmID, transferID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "user.transfer.delete", store.AuditTargetUser, auditID(before.UserID), before, nil)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("deleting realm transfer")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// auditTransfer records a change to a transfer. Transfers involve two realms,
+// so they're recorded for both.
+func (s *Server) auditTransfer(r *http.Request, tx *sqlx.Tx, action string, transfer store.RealmTransfer) error {
+	for _, realmID := range []int64{transfer.FromRealmID, transfer.ToRealmID} {
+		realmID := realmID
+		if err := s.audit(r, tx, &realmID, action, store.AuditTargetUser, auditID(transfer.UserID), nil, transfer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
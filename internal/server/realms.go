@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"errors"
 
+	"github.com/Pigmice2733/peregrine-backend/internal/config"
 	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
 	"github.com/Pigmice2733/peregrine-backend/internal/store"
 	"github.com/gorilla/mux"
@@ -16,22 +18,98 @@ import (
 	validator "gopkg.in/go-playground/validator.v9"
 )
 
-// createRealmHandler returns a handler to create a new realm.
+// realmCreationHandler returns a handler that describes how realms can be
+// created. When anyone can create a realm, it includes a new proof-of-work
+// challenge to solve.
+func (s *Server) realmCreationHandler() http.HandlerFunc {
+	type realmCreation struct {
+		Policy     string     `json:"policy"`
+		Challenge  string     `json:"challenge,omitempty"`
+		Difficulty int        `json:"difficulty,omitempty"`
+		ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := realmCreation{Policy: s.Realms.CreationPolicy}
+
+		if rc.Policy == config.RealmCreationOpen {
+			challenge, expiresAt, err := s.challenges.issue(ihttp.ClientIP(r, s.TrustProxy), time.Now())
+			if errors.Is(err, errTooManyChallenges) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(challengeDuration.Seconds())))
+				ihttp.Error(w, http.StatusTooManyRequests)
+				return
+			} else if err != nil {
+				ihttp.Error(w, http.StatusInternalServerError)
+				s.Logger.WithError(err).Error("issuing realm challenge")
+				return
+			}
+
+			rc.Challenge = challenge
+			rc.Difficulty = s.Realms.ProofOfWorkDifficulty
+			rc.ExpiresAt = &expiresAt
+		}
+
+		ihttp.Respond(w, rc, http.StatusOK)
+	}
+}
+
+// createRealmHandler returns a handler to create a new realm. Super-admins can
+// always create realms. Depending on the realm creation policy, other users
+// need a realm token, or (when realms are open) a token or a solved challenge
+// from realmCreationHandler, and become the new realm's admin.
 func (s *Server) createRealmHandler() http.HandlerFunc {
+	type requestRealm struct {
+		store.Realm
+		Token     string `json:"token"`
+		Challenge string `json:"challenge"`
+		Nonce     string `json:"nonce"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		var realm store.Realm
-		if err := json.NewDecoder(r.Body).Decode(&realm); err != nil {
+		var rr requestRealm
+		if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
 			ihttp.Error(w, http.StatusUnprocessableEntity)
 			return
 		}
 
+		realm := rr.Realm
+
 		if err := validator.New().Struct(realm); err != nil {
 			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
 			return
 		}
 
-		id, err := s.Store.InsertRealm(r.Context(), realm)
-		if errors.Is(err, store.ErrExists{}) {
+		creatorID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		policy := s.Realms.CreationPolicy
+		open := policy == config.RealmCreationOpen
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			switch {
+			case ihttp.GetRoles(r).IsSuperAdmin:
+				realm.ID, err = s.Store.InsertRealmTx(r.Context(), tx, realm)
+			case rr.Token != "" && (open || policy == config.RealmCreationToken):
+				realm.ID, err = s.Store.InsertRealmWithTokenTx(r.Context(), tx, realm, hashResetToken(rr.Token), creatorID)
+			case open && s.challenges.solve(rr.Challenge, rr.Nonce, s.Realms.ProofOfWorkDifficulty, time.Now()):
+				realm.ID, err = s.Store.InsertRealmWithAdminTx(r.Context(), tx, realm, creatorID)
+			default:
+				return forbiddenError{errors.New("not allowed to create realms")}
+			}
+			if err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realm.ID, "realm.create", store.AuditTargetRealm, auditID(realm.ID), nil, realm)
+		})
+		if errors.Is(err, forbiddenError{}) || errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrExists{}) {
 			ihttp.Error(w, http.StatusConflict)
 			return
 		} else if err != nil {
@@ -40,8 +118,6 @@ func (s *Server) createRealmHandler() http.HandlerFunc {
 			return
 		}
 
-		realm.ID = id
-
 		ihttp.Respond(w, realm, http.StatusCreated)
 	}
 }
@@ -104,18 +180,26 @@ func (s *Server) updateRealmHandler() http.HandlerFunc {
 		}
 
 		roles := ihttp.GetRoles(r)
+		perms := ihttp.GetPermissions(r)
 		userRealmID, err := ihttp.GetRealmID(r)
 		if err != nil {
 			ihttp.Error(w, http.StatusForbidden)
 			return
 		}
 
-		existed, err := editRealm(r.Context(), s.Store, roles, userRealmID, id, func(tx *sqlx.Tx) error {
+		existed, err := editRealm(r.Context(), s.Store, roles, perms, userRealmID, id, func(tx *sqlx.Tx) error {
+			var before *store.Realm
+			if old, err := s.Store.GetRealmTx(r.Context(), tx, id); err == nil {
+				before = &old
+			} else if !errors.Is(err, store.ErrNoResults{}) {
+				return err
+			}
+
 			if err := s.Store.UpdateRealmTx(r.Context(), tx, realm); err != nil {
 				return fmt.Errorf("unable to update realm %d: %w", realm.ID, err)
 			}
 
-			return nil
+			return s.audit(r, tx, &id, "realm.update", store.AuditTargetRealm, auditID(id), before, realm)
 		})
 		if errors.Is(err, forbiddenError{}) {
 			ihttp.Error(w, http.StatusForbidden)
@@ -134,7 +218,9 @@ func (s *Server) updateRealmHandler() http.HandlerFunc {
 	}
 }
 
-// deleteRealmHandler returns a handler to delete a specific realm.
+// deleteRealmHandler returns a handler to delete a specific realm. The reports
+// query parameter decides what happens to the realm's reports (see
+// store.DeleteRealmTx), and defaults to archiving them.
 func (s *Server) deleteRealmHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
@@ -143,25 +229,86 @@ func (s *Server) deleteRealmHandler() http.HandlerFunc {
 			return
 		}
 
+		reports := r.URL.Query().Get("reports")
+		var reassignTo int64
+
+		switch reports {
+		case "":
+			reports = store.RealmReportsArchive
+		case store.RealmReportsArchive, store.RealmReportsAnonymize:
+		case store.RealmReportsReassign:
+			reassignTo, err = strconv.ParseInt(r.URL.Query().Get("reassignTo"), 10, 64)
+			if err != nil {
+				ihttp.Error(w, http.StatusBadRequest)
+				return
+			}
+
+			if reassignTo == id {
+				ihttp.Error(w, http.StatusUnprocessableEntity)
+				return
+			}
+
+			target, err := s.Store.GetRealm(r.Context(), reassignTo)
+			if errors.Is(err, store.ErrNoResults{}) || (err == nil && target.ArchivedAt != nil) {
+				ihttp.Error(w, http.StatusUnprocessableEntity)
+				return
+			} else if err != nil {
+				s.Logger.WithError(err).Error("getting realm to reassign reports to")
+				ihttp.Error(w, http.StatusInternalServerError)
+				return
+			}
+
+			// Handing data to another realm needs the same permission there
+			err = s.checkMemberPermission(r, reassignTo, store.PermRealmManage)
+			if errors.Is(err, forbiddenError{}) {
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			} else if err != nil {
+				s.Logger.WithError(err).Error("checking permissions in realm to reassign reports to")
+				ihttp.Error(w, http.StatusInternalServerError)
+				return
+			}
+		default:
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
 		roles := ihttp.GetRoles(r)
+		perms := ihttp.GetPermissions(r)
 		userRealmID, err := ihttp.GetRealmID(r)
 		if err != nil {
 			ihttp.Error(w, http.StatusForbidden)
 			return
 		}
 
-		existed, err := editRealm(r.Context(), s.Store, roles, userRealmID, id, func(tx *sqlx.Tx) error {
-			if err := s.Store.DeleteRealmTx(r.Context(), tx, id); err != nil {
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		existed, err := editRealm(r.Context(), s.Store, roles, perms, userRealmID, id, func(tx *sqlx.Tx) error {
+			before, err := s.Store.GetRealmTx(r.Context(), tx, id)
+			if errors.Is(err, store.ErrNoResults{}) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if _, err := s.Store.DeleteRealmTx(r.Context(), tx, id, reports, reassignTo, &subjectID); err != nil {
 				return fmt.Errorf("unable to delete realm %d: %w", id, err)
 			}
 
-			return nil
+			return s.audit(r, tx, &id, "realm.delete", store.AuditTargetRealm, auditID(id), before, nil)
 		})
 		if errors.Is(err, forbiddenError{}) {
 			ihttp.Error(w, http.StatusForbidden)
 			return
+		} else if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
 		} else if err != nil {
-			s.Logger.WithError(err).Error("unable to delete match")
+			s.Logger.WithError(err).Error("unable to delete realm")
 			ihttp.Error(w, http.StatusInternalServerError)
 			return
 		}
@@ -174,7 +321,7 @@ func (s *Server) deleteRealmHandler() http.HandlerFunc {
 	}
 }
 
-func editRealm(ctx context.Context, sto *store.Service, roles store.Roles, userRealmID, realmID int64, editFunc func(tx *sqlx.Tx) error) (existed bool, err error) {
+func editRealm(ctx context.Context, sto *store.Service, roles store.Roles, perms store.PermissionSet, userRealmID, realmID int64, editFunc func(tx *sqlx.Tx) error) (existed bool, err error) {
 	existed = true
 
 	err = sto.DoTransaction(ctx, func(tx *sqlx.Tx) error {
@@ -189,12 +336,12 @@ func editRealm(ctx context.Context, sto *store.Service, roles store.Roles, userR
 
 		existed = exists
 
-		if !roles.IsAdmin && !roles.IsSuperAdmin {
-			return forbiddenError{errors.New("only super-admins and admins can edit realms")}
+		if !perms.Has(store.PermRealmManage) {
+			return forbiddenError{errors.New("only users who can manage realms can edit realms")}
 		}
 
 		if !roles.IsSuperAdmin && userRealmID != realmID {
-			return forbiddenError{errors.New("realm admins can only edit realms they belong to")}
+			return forbiddenError{errors.New("realm managers can only edit realms they belong to")}
 		}
 
 		if err := editFunc(tx); err != nil {
@@ -128,8 +128,13 @@ func (s *Server) postReportHandler() http.HandlerFunc {
 			report.RealmID = &realmID
 		}
 
+		if !s.checkRequiredReportFields(w, r, realmID, report) {
+			return
+		}
+
 		var status int
 		var reportID int64
+		var before *store.Report
 		err = editReport(r.Context(), s.Store, nil, nil,
 			func(tx *sqlx.Tx) error {
 				// make sure team is present at match, and the event is visible to user
@@ -147,19 +152,37 @@ func (s *Server) postReportHandler() http.HandlerFunc {
 			func(_ *store.Report, _ *store.User) error {
 				return nil
 			}, func(tx *sqlx.Tx) error {
-				created, id, err := s.Store.UpsertReport(r.Context(), report)
+				existing, err := s.Store.GetReportsTx(r.Context(), tx, &report.EventKey, &report.MatchKey, &report.TeamKey, report.RealmID, report.ReporterID)
+				if err != nil {
+					return err
+				}
+				if len(existing) > 0 {
+					before = &existing[0]
+				}
+
+				created, id, err := s.Store.UpsertReportTx(r.Context(), tx, report)
+				if err != nil {
+					return err
+				}
+
+				action := "report.update"
+				status = http.StatusOK
 				if created {
+					action = "report.create"
 					status = http.StatusCreated
-				} else {
-					status = http.StatusOK
 				}
 				reportID = id
-				return err
+				report.ID = id
+
+				return s.audit(r, tx, report.RealmID, action, store.AuditTargetReport, auditID(id), before, report)
 			})
 
 		if errors.Is(err, badRequestError{}) {
 			ihttp.Error(w, http.StatusBadRequest)
 			return
+		} else if errors.Is(err, store.ErrQuotaExceeded{}) {
+			ihttp.Respond(w, newQuotaResponse(err), http.StatusForbidden)
+			return
 		} else if err != nil {
 			ihttp.Error(w, http.StatusInternalServerError)
 			s.Logger.WithError(err).Error("upserting report")
@@ -177,6 +200,60 @@ type ConflictResponse struct {
 	ID    int64  `json:"id"`
 }
 
+// MissingFieldsResponse is returned when a report doesn't include all of the
+// fields its realm requires.
+type MissingFieldsResponse struct {
+	Error  string   `json:"error"`
+	Fields []string `json:"fields"`
+}
+
+// checkRequiredReportFields responds with a MissingFieldsResponse if the report
+// is missing any of the realm's required fields, and returns whether the
+// report can be saved.
+func (s *Server) checkRequiredReportFields(w http.ResponseWriter, r *http.Request, realmID int64, report store.Report) bool {
+	missing, err := s.missingReportFields(r.Context(), realmID, report)
+	if err != nil {
+		ihttp.Error(w, http.StatusInternalServerError)
+		s.Logger.WithError(err).Error("getting realm settings")
+		return false
+	}
+
+	if len(missing) > 0 {
+		ihttp.Respond(w, MissingFieldsResponse{Error: "missing required fields", Fields: missing}, http.StatusUnprocessableEntity)
+		return false
+	}
+
+	return true
+}
+
+// missingReportFields returns the fields the realm requires that the report is
+// missing.
+func (s *Server) missingReportFields(ctx context.Context, realmID int64, report store.Report) ([]string, error) {
+	settings, err := s.Store.GetRealmSettings(ctx, realmID)
+	if errors.Is(err, store.ErrNoResults{}) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return settings.MissingReportFields(report.Data), nil
+}
+
+// missingFieldsError is returned when a report is missing fields its realm
+// requires.
+type missingFieldsError struct {
+	fields []string
+}
+
+func (e missingFieldsError) Error() string {
+	return fmt.Sprintf("report is missing required fields %v", e.fields)
+}
+
+func (e missingFieldsError) Is(target error) bool {
+	_, ok := target.(missingFieldsError)
+	return ok
+}
+
 func (s *Server) putReportHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
@@ -196,6 +273,7 @@ func (s *Server) putReportHandler() http.HandlerFunc {
 		report.ID = id
 
 		roles := ihttp.GetRoles(r)
+		perms := ihttp.GetPermissions(r)
 
 		reporterID, err := ihttp.GetSubject(r)
 		if err != nil {
@@ -210,15 +288,18 @@ func (s *Server) putReportHandler() http.HandlerFunc {
 			return
 		}
 
+		var before store.Report
 		err = editReport(r.Context(), s.Store, &id, report.ReporterID,
 			func(tx *sqlx.Tx) error { return nil },
 			func(oldReport *store.Report, targetUser *store.User) error {
 				if oldReport == nil {
 					return store.ErrNoResults{}
 				}
+				before = *oldReport
 
-				if !roles.IsSuperAdmin && !roles.IsAdmin {
-					if report.ReporterID == nil || reporterID != *report.ReporterID ||
+				if !perms.Has(store.PermReportsEditAny) {
+					if !perms.Has(store.PermReportsWrite) ||
+						report.ReporterID == nil || reporterID != *report.ReporterID ||
 						oldReport.ReporterID == nil || reporterID != *oldReport.ReporterID {
 						return forbiddenError{}
 					}
@@ -242,9 +323,24 @@ func (s *Server) putReportHandler() http.HandlerFunc {
 					}
 				}
 
+				// Only check the realm's required fields once we know the
+				// report can be put in it, so they aren't leaked to others.
+				if report.RealmID != nil {
+					missing, err := s.missingReportFields(r.Context(), *report.RealmID, report)
+					if err != nil {
+						return fmt.Errorf("unable to get realm settings: %w", err)
+					} else if len(missing) > 0 {
+						return missingFieldsError{fields: missing}
+					}
+				}
+
 				return nil
 			}, func(tx *sqlx.Tx) error {
-				return s.Store.UpdateReportTx(r.Context(), tx, report, replace)
+				if err := s.Store.UpdateReportTx(r.Context(), tx, report, replace); err != nil {
+					return err
+				}
+
+				return s.audit(r, tx, report.RealmID, "report.update", store.AuditTargetReport, auditID(id), before, report)
 			})
 
 		if errors.Is(err, store.ErrConflictingReport{}) {
@@ -252,6 +348,11 @@ func (s *Server) putReportHandler() http.HandlerFunc {
 			_ = errors.As(err, &conflictErr)
 			ihttp.Respond(w, ConflictResponse{Error: "conflicts", ID: conflictErr.ID}, http.StatusBadRequest)
 			return
+		} else if errors.Is(err, missingFieldsError{}) {
+			var missingErr missingFieldsError
+			_ = errors.As(err, &missingErr)
+			ihttp.Respond(w, MissingFieldsResponse{Error: "missing required fields", Fields: missingErr.fields}, http.StatusUnprocessableEntity)
+			return
 		} else if errors.Is(err, store.ErrNoResults{}) {
 			ihttp.Error(w, http.StatusNotFound)
 			return
@@ -284,6 +385,7 @@ func (s *Server) deleteReportHandler() http.HandlerFunc {
 		}
 
 		roles := ihttp.GetRoles(r)
+		perms := ihttp.GetPermissions(r)
 		userRealmID, err := ihttp.GetRealmID(r)
 		if err != nil {
 			ihttp.Error(w, http.StatusForbidden)
@@ -296,28 +398,26 @@ func (s *Server) deleteReportHandler() http.HandlerFunc {
 			return
 		}
 
+		var before store.Report
 		err = editReport(r.Context(), s.Store, &id, nil,
 			func(tx *sqlx.Tx) error { return nil },
 			func(report *store.Report, _ *store.User) error {
 				if report == nil {
 					return store.ErrNoResults{}
 				}
+				before = *report
 
-				if roles.IsSuperAdmin {
-					return nil
-				}
-
-				if report.RealmID != nil && userRealmID == *report.RealmID && roles.IsAdmin {
-					return nil
+				if !canDeleteReport(roles, perms, userRealmID, userID, *report) {
+					return forbiddenError{}
 				}
 
-				if report.ReporterID != nil && userID == *report.ReporterID {
-					return nil
+				return nil
+			}, func(tx *sqlx.Tx) error {
+				if err := s.Store.DeleteReportTx(r.Context(), tx, id, &userID); err != nil {
+					return err
 				}
 
-				return forbiddenError{}
-			}, func(tx *sqlx.Tx) error {
-				return s.Store.DeleteReportTx(r.Context(), tx, id)
+				return s.audit(r, tx, before.RealmID, "report.delete", store.AuditTargetReport, auditID(id), before, nil)
 			})
 
 		if errors.Is(err, store.ErrNoResults{}) {
@@ -336,6 +436,80 @@ func (s *Server) deleteReportHandler() http.HandlerFunc {
 	}
 }
 
+// canDeleteReport returns whether a user can delete a report, or restore it
+// once it's deleted. Super-admins can delete any report, users who can delete
+// any report in its realm can delete it, and reporters who can still write
+// reports can delete their own.
+func canDeleteReport(roles store.Roles, perms store.PermissionSet, userRealmID, userID int64, report store.Report) bool {
+	if roles.IsSuperAdmin {
+		return true
+	}
+
+	if report.RealmID != nil && userRealmID == *report.RealmID && perms.Has(store.PermReportsDeleteAny) {
+		return true
+	}
+
+	return report.ReporterID != nil && userID == *report.ReporterID && perms.Has(store.PermReportsWrite)
+}
+
+// restoreReportHandler returns a handler to restore a deleted report. Anyone
+// who could have deleted the report can restore it.
+func (s *Server) restoreReportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		userRealmID, err := ihttp.GetRealmID(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		userID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		report, err := s.Store.GetDeletedReport(r.Context(), id)
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("getting deleted report")
+			return
+		}
+
+		if !canDeleteReport(ihttp.GetRoles(r), ihttp.GetPermissions(r), userRealmID, userID, report) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			restored, err := s.Store.RestoreReportTx(r.Context(), tx, id)
+			if err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, restored.RealmID, "report.restore", store.AuditTargetReport, auditID(id), nil, restored)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("restoring report")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 func (s *Server) leaderboardHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		realmID, err := ihttp.GetRealmID(r)
@@ -344,6 +518,26 @@ func (s *Server) leaderboardHandler() http.HandlerFunc {
 			return
 		}
 
+		settings, err := s.Store.GetRealmSettings(r.Context(), realmID)
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("getting realm settings")
+			return
+		}
+
+		if !ihttp.GetRoles(r).IsSuperAdmin {
+			switch settings.LeaderboardVisibility {
+			case store.LeaderboardManagers:
+				if !ihttp.GetPermissions(r).Has(store.PermUsersManage) {
+					ihttp.Error(w, http.StatusForbidden)
+					return
+				}
+			case store.LeaderboardHidden:
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			}
+		}
+
 		var filterYear *int
 		if year, err := strconv.Atoi(r.URL.Query().Get("year")); err == nil {
 			filterYear = &year
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+)
+
+func TestCanDeleteReport(t *testing.T) {
+	realmID, otherRealmID := int64(1), int64(2)
+	reporterID, otherUserID := int64(3), int64(4)
+
+	report := store.Report{RealmID: &realmID, ReporterID: &reporterID}
+	writer := store.PermissionSet{store.PermReportsWrite: true}
+	deleter := store.PermissionSet{store.PermReportsDeleteAny: true}
+
+	testCases := []struct {
+		name        string
+		roles       store.Roles
+		perms       store.PermissionSet
+		userRealmID int64
+		userID      int64
+		report      store.Report
+		want        bool
+	}{
+		{
+			name:        "super-admin",
+			roles:       store.Roles{IsSuperAdmin: true},
+			userRealmID: otherRealmID,
+			userID:      otherUserID,
+			report:      report,
+			want:        true,
+		},
+		{
+			name:        "reporter",
+			perms:       writer,
+			userRealmID: realmID,
+			userID:      reporterID,
+			report:      report,
+			want:        true,
+		},
+		{
+			name:        "reporter without write permission",
+			userRealmID: realmID,
+			userID:      reporterID,
+			report:      report,
+			want:        false,
+		},
+		{
+			name:        "delete any in realm",
+			perms:       deleter,
+			userRealmID: realmID,
+			userID:      otherUserID,
+			report:      report,
+			want:        true,
+		},
+		{
+			name:        "delete any in other realm",
+			perms:       deleter,
+			userRealmID: otherRealmID,
+			userID:      otherUserID,
+			report:      report,
+			want:        false,
+		},
+		{
+			name:        "other reporter",
+			perms:       writer,
+			userRealmID: realmID,
+			userID:      otherUserID,
+			report:      report,
+			want:        false,
+		},
+		{
+			name:        "purged reporter",
+			perms:       writer,
+			userRealmID: realmID,
+			userID:      reporterID,
+			report:      store.Report{RealmID: &realmID},
+			want:        false,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := canDeleteReport(tt.roles, tt.perms, tt.userRealmID, tt.userID, tt.report)
+			if got != tt.want {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
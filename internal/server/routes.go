@@ -5,6 +5,7 @@ import (
 	"time"
 
 	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
 	"github.com/gorilla/mux"
 )
 
@@ -12,52 +13,127 @@ func (s *Server) registerRoutes() *mux.Router {
 	r := mux.NewRouter()
 
 	r.Handle("/", healthHandler(s.uptime, s.TBA, s.Store)).Methods(http.MethodGet)
+	if s.Refresher != nil {
+		r.Handle("/refresher", ihttp.ACL(refresherStatusHandler(s.Refresher), ihttp.Access{Permission: store.PermRealmManage, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodGet)
+	}
+	r.Handle("/.well-known/jwks.json", jwksHandler(s.Keys)).Methods(http.MethodGet)
 	r.Handle("/openapi.yaml", openAPIHandler(openAPI)).Methods(http.MethodGet)
 
-	r.Handle("/authenticate", authenticateHandler(s.Logger, time.Now, s.Store, s.JWTSecret)).Methods(http.MethodPost)
-	r.Handle("/refresh", refreshHandler(s.Logger, time.Now, s.Store, s.JWTSecret)).Methods(http.MethodPost)
+	r.Handle("/authenticate", authenticateHandler(s.Logger, time.Now, s.Store, s.Store, s.Keys, s.logins, s.TrustProxy)).Methods(http.MethodPost)
+	r.Handle("/refresh", refreshHandler(s.Logger, time.Now, s.Store, s.Store, s.Store, s.Keys)).Methods(http.MethodPost)
+	r.Handle("/logout", ihttp.ACL(s.logoutHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodPost)
 
-	r.Handle("/users", s.createUserHandler()).Methods(http.MethodPost)
-	r.Handle("/users", ihttp.ACL(s.getUsersHandler(), false, false, true)).Methods(http.MethodGet)
-	r.Handle("/users/{id}", ihttp.ACL(s.getUserByIDHandler(), false, false, true)).Methods(http.MethodGet)
-	r.Handle("/users/{id}", ihttp.ACL(s.patchUserHandler(), false, false, true)).Methods(http.MethodPatch)
-	r.Handle("/users/{id}", ihttp.ACL(s.deleteUserHandler(), false, false, true)).Methods(http.MethodDelete)
+	r.Handle("/oidc", s.oidcProvidersHandler()).Methods(http.MethodGet)
+	r.Handle("/oidc/{provider}/authorize", s.oidcAuthorizeHandler()).Methods(http.MethodPost)
+	r.Handle("/oidc/{provider}/callback", s.oidcCallbackHandler()).Methods(http.MethodPost)
+	r.Handle("/oidc/{provider}/link", ihttp.ACL(s.oidcLinkHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodPost)
+
+	r.Handle("/password-reset", s.requestPasswordResetHandler()).Methods(http.MethodPost)
+	r.Handle("/password-reset/confirm", s.confirmPasswordResetHandler()).Methods(http.MethodPost)
 
-	r.Handle("/schemas", ihttp.ACL(s.getSchemasHandler(), false, false, false)).Methods(http.MethodGet)
-	r.Handle("/schemas", ihttp.ACL(s.createSchemaHandler(), true, true, true)).Methods(http.MethodPost)
-	r.Handle("/schemas/{id}", ihttp.ACL(s.getSchemaByIDHandler(), false, false, false)).Methods(http.MethodGet)
+	r.Handle("/users", s.createUserHandler()).Methods(http.MethodPost)
+	r.Handle("/users", ihttp.ACL(s.getUsersHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodGet)
+	r.Handle("/users/{id}", ihttp.ACL(s.getUserByIDHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodGet)
+	r.Handle("/users/{id}", ihttp.ACL(s.patchUserHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodPatch)
+	r.Handle("/users/{id}", ihttp.ACL(s.deleteUserHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodDelete)
+	r.Handle("/users/{id}/restore", ihttp.ACL(s.restoreUserHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodPost)
+	r.Handle("/users/{id}/approve", ihttp.ACL(s.approveUserHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodPost)
+	r.Handle("/users/{id}/reject", ihttp.ACL(s.rejectUserHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodPost)
+	r.Handle("/users/{id}/unlock", ihttp.ACL(s.unlockUserHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodPost)
+	r.Handle("/users/{id}/password-reset", ihttp.ACL(s.createPasswordResetHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodPost)
+	r.Handle("/users/{id}/identities", ihttp.ACL(s.getUserIdentitiesHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodGet)
+	r.Handle("/users/{id}/identities/{identityId}", ihttp.ACL(s.deleteUserIdentityHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodDelete)
+	r.Handle("/users/{id}/realms", ihttp.ACL(s.getUserRealmsHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodGet)
+	r.Handle("/users/{id}/realms", ihttp.ACL(s.joinRealmHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodPost)
+	r.Handle("/users/{id}/transfer", ihttp.ACL(s.createUserTransferHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodPost)
+	r.Handle("/users/{id}/sessions", ihttp.ACL(s.getUserSessionsHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodGet)
+	r.Handle("/users/{id}/sessions", ihttp.ACL(s.revokeUserSessionsHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodDelete)
+	r.Handle("/users/{id}/sessions/{sessionId}", ihttp.ACL(s.revokeUserSessionHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodDelete)
+
+	r.Handle("/schemas", ihttp.ACL(s.getSchemasHandler(), ihttp.Access{})).Methods(http.MethodGet)
+	r.Handle("/schemas", ihttp.ACL(s.createSchemaHandler(), ihttp.Access{Permission: store.PermSchemasCreate, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPost)
+	r.Handle("/schemas/{id}", ihttp.ACL(s.getSchemaByIDHandler(), ihttp.Access{})).Methods(http.MethodGet)
 
 	r.Handle("/years", s.eventYearsHandler()).Methods(http.MethodGet)
 
 	r.Handle("/events", s.eventsHandler()).Methods(http.MethodGet)
-	r.Handle("/events/{eventKey}", ihttp.ACL(s.upsertEventHandler(), true, true, true)).Methods(http.MethodPut)
+	r.Handle("/events/{eventKey}", ihttp.ACL(s.upsertEventHandler(), ihttp.Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPut)
+	r.Handle("/events/{eventKey}", ihttp.ACL(s.deleteEventHandler(), ihttp.Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodDelete)
 	r.Handle("/events/{eventKey}", s.eventHandler()).Methods(http.MethodGet)
+	r.Handle("/events/{eventKey}/restore", ihttp.ACL(s.restoreEventHandler(), ihttp.Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPost)
+	if s.Refresher != nil {
+		r.Handle("/events/{eventKey}/refresh", ihttp.ACL(s.refreshEventHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodPost)
+	}
+
+	r.Handle("/events/{eventKey}/undelete", ihttp.ACL(s.undeleteEventHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodPost)
+	r.Handle("/event-deletions", ihttp.ACL(s.eventDeletionsHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodGet)
 
-	r.Handle("/events/{eventKey}/stats", s.eventStats()).Methods(http.MethodGet)
+	r.Handle("/events/{eventKey}/stats", ihttp.ACL(s.eventStats(), ihttp.Access{Scopes: []string{store.ScopeReadStats}})).Methods(http.MethodGet)
 
 	r.Handle("/events/{eventKey}/matches", s.matchesHandler()).Methods(http.MethodGet)
+	r.Handle("/events/{eventKey}/schedule", ihttp.ACL(s.setEventScheduleHandler(), ihttp.Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPut)
+	r.Handle("/events/{eventKey}/schedule/generate", ihttp.ACL(s.generateScheduleHandler(), ihttp.Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPost)
 	r.Handle("/events/{eventKey}/matches/{matchKey}", s.matchHandler()).Methods(http.MethodGet)
-	r.Handle("/events/{eventKey}/matches/{matchKey}", ihttp.ACL(s.upsertMatchHandler(), true, true, true)).Methods(http.MethodPut)
-	r.Handle("/events/{eventKey}/matches/{matchKey}", ihttp.ACL(s.deleteMatchHandler(), true, true, true)).Methods(http.MethodDelete)
+	r.Handle("/events/{eventKey}/matches/{matchKey}", ihttp.ACL(s.upsertMatchHandler(), ihttp.Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPut)
+	r.Handle("/events/{eventKey}/matches/{matchKey}", ihttp.ACL(s.deleteMatchHandler(), ihttp.Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodDelete)
+
+	r.Handle("/events/{eventKey}/matches/{matchKey}/score", ihttp.ACL(s.setMatchScoreHandler(), ihttp.Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPut)
+	r.Handle("/events/{eventKey}/matches/{matchKey}/history", s.matchHistoryHandler()).Methods(http.MethodGet)
 
 	r.Handle("/events/{eventKey}/teams", s.eventTeamsHandler()).Methods(http.MethodGet)
+	r.Handle("/events/{eventKey}/teams", ihttp.ACL(s.setEventTeamsHandler(), ihttp.Access{Permission: store.PermEventsEdit, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPut)
 	r.Handle("/events/{eventKey}/teams/{teamKey}", s.eventTeamHandler()).Methods(http.MethodGet)
 
-	r.Handle("/events/{eventKey}/matches/{matchKey}/teams/{teamKey}/stats", s.matchTeamStats()).Methods(http.MethodGet)
+	r.Handle("/events/{eventKey}/matches/{matchKey}/teams/{teamKey}/stats", ihttp.ACL(s.matchTeamStats(), ihttp.Access{Scopes: []string{store.ScopeReadStats}})).Methods(http.MethodGet)
 
-	r.Handle("/reports", ihttp.ACL(s.reportsHandler(), false, false, false)).Methods(http.MethodGet)
-	r.Handle("/reports", ihttp.ACL(s.postReportHandler(), false, true, true)).Methods(http.MethodPost)
-	r.Handle("/reports/{id}", ihttp.ACL(s.reportHandler(), false, false, false)).Methods(http.MethodGet)
-	r.Handle("/reports/{id}", ihttp.ACL(s.putReportHandler(), false, true, true)).Methods(http.MethodPut)
-	r.Handle("/reports/{id}", ihttp.ACL(s.deleteReportHandler(), false, true, true)).Methods(http.MethodDelete)
+	r.Handle("/reports", ihttp.ACL(s.reportsHandler(), ihttp.Access{Scopes: []string{store.ScopeReadReports}})).Methods(http.MethodGet)
+	r.Handle("/reports", ihttp.ACL(s.postReportHandler(), ihttp.Access{Permission: store.PermReportsWrite, Scopes: []string{store.ScopeWriteReports}})).Methods(http.MethodPost)
+	r.Handle("/reports/{id}", ihttp.ACL(s.reportHandler(), ihttp.Access{Scopes: []string{store.ScopeReadReports}})).Methods(http.MethodGet)
+	r.Handle("/reports/{id}", ihttp.ACL(s.putReportHandler(), ihttp.Access{LoggedIn: true, Scopes: []string{store.ScopeWriteReports}})).Methods(http.MethodPut)
+	r.Handle("/reports/{id}", ihttp.ACL(s.deleteReportHandler(), ihttp.Access{LoggedIn: true, Scopes: []string{store.ScopeWriteReports}})).Methods(http.MethodDelete)
+	r.Handle("/reports/{id}/restore", ihttp.ACL(s.restoreReportHandler(), ihttp.Access{LoggedIn: true, Scopes: []string{store.ScopeWriteReports}})).Methods(http.MethodPost)
 
 	r.Handle("/leaderboard", s.leaderboardHandler()).Methods(http.MethodGet)
 
+	r.Handle("/audit", ihttp.ACL(s.auditHandler(), ihttp.Access{Permission: store.PermRealmManage, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodGet)
+
+	r.Handle("/realm-creation", s.realmCreationHandler()).Methods(http.MethodGet)
+	r.Handle("/realm-tokens", ihttp.ACL(s.getRealmTokensHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodGet)
+	r.Handle("/realm-tokens", ihttp.ACL(s.createRealmTokenHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodPost)
+	r.Handle("/realm-tokens/{id}", ihttp.ACL(s.deleteRealmTokenHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodDelete)
+	r.Handle("/realm-usage", ihttp.ACL(s.realmUsageHandler(), ihttp.Access{Permission: store.PermRealmManage, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodGet)
+
 	r.Handle("/realms", s.realmsHandler()).Methods(http.MethodGet)
-	r.Handle("/realms", s.createRealmHandler()).Methods(http.MethodPost)
+	r.Handle("/realms", ihttp.ACL(s.createRealmHandler(), ihttp.Access{LoggedIn: true, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPost)
 	r.Handle("/realms/{id}", s.realmHandler()).Methods(http.MethodGet)
-	r.Handle("/realms/{id}", ihttp.ACL(s.updateRealmHandler(), true, true, true)).Methods(http.MethodPost)
-	r.Handle("/realms/{id}", ihttp.ACL(s.deleteRealmHandler(), true, true, true)).Methods(http.MethodDelete)
+	r.Handle("/realms/{id}", ihttp.ACL(s.updateRealmHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodPost)
+	r.Handle("/realms/{id}", ihttp.ACL(s.deleteRealmHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodDelete)
+	r.Handle("/realms/{id}/quotas", ihttp.ACL(s.getRealmQuotasHandler(), ihttp.Access{Permission: store.PermRealmManage, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/quotas", ihttp.ACL(s.setRealmQuotasHandler(), ihttp.Access{Permission: store.PermRealmManage, Scopes: []string{store.ScopeAdmin}})).Methods(http.MethodPut)
+	r.Handle("/realms/{id}/settings", ihttp.ACL(s.getRealmSettingsHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/settings", ihttp.ACL(s.patchRealmSettingsHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodPatch)
+	r.Handle("/realms/{id}/roles", ihttp.ACL(s.getRealmRolesHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/roles", ihttp.ACL(s.createRealmRoleHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodPost)
+	r.Handle("/realms/{id}/roles/{roleId}", ihttp.ACL(s.updateRealmRoleHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodPut)
+	r.Handle("/realms/{id}/roles/{roleId}", ihttp.ACL(s.deleteRealmRoleHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodDelete)
+	r.Handle("/realms/{id}/invites", ihttp.ACL(s.getRealmInvitesHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/invites", ihttp.ACL(s.createRealmInviteHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodPost)
+	r.Handle("/realms/{id}/invites/{inviteId}", ihttp.ACL(s.revokeRealmInviteHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodDelete)
+	r.Handle("/realms/{id}/members", ihttp.ACL(s.getRealmMembersHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/members/{userId}", ihttp.ACL(s.updateRealmMemberHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodPut)
+	r.Handle("/realms/{id}/members/{userId}", ihttp.ACL(s.deleteRealmMemberHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodDelete)
+	r.Handle("/realms/{id}/transfers", ihttp.ACL(s.getRealmTransfersHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/transfers/{transferId}/approve", ihttp.ACL(s.approveRealmTransferHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodPost)
+	r.Handle("/realms/{id}/transfers/{transferId}", ihttp.ACL(s.deleteRealmTransferHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodDelete)
+	r.Handle("/realms/{id}/trash", ihttp.ACL(s.trashHandler(), ihttp.Access{LoggedIn: true})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/pending-users", ihttp.ACL(s.pendingUsersHandler(), ihttp.Access{Permission: store.PermUsersManage})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/api-keys", ihttp.ACL(s.getAPIKeysHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/api-keys", ihttp.ACL(s.createAPIKeyHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodPost)
+	r.Handle("/realms/{id}/api-keys/{keyId}", ihttp.ACL(s.revokeAPIKeyHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodDelete)
+	r.Handle("/realms/{id}/shares", ihttp.ACL(s.getRealmSharesHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodGet)
+	r.Handle("/realms/{id}/shares", ihttp.ACL(s.createRealmShareHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodPost)
+	r.Handle("/realms/{id}/shares/{shareId}/accept", ihttp.ACL(s.acceptRealmShareHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodPost)
+	r.Handle("/realms/{id}/shares/{shareId}", ihttp.ACL(s.deleteRealmShareHandler(), ihttp.Access{Permission: store.PermRealmManage})).Methods(http.MethodDelete)
 
 	r.Handle("/teams/{teamKey}", s.teamHandler()).Methods(http.MethodGet)
 
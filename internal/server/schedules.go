@@ -0,0 +1,548 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/schedule"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+// maxAllianceSize is the most teams an alliance can have in a match.
+const maxAllianceSize = 3
+
+var (
+	teamKeyPattern          = regexp.MustCompile(`^frc[0-9]+[A-Z]?$`)
+	qualificationKeyPattern = regexp.MustCompile(`^qm[0-9]+$`)
+)
+
+// scheduleMatch is a single qualification match in an uploaded schedule.
+type scheduleMatch struct {
+	Key          string     `json:"key"`
+	Time         *time.Time `json:"time"`
+	RedAlliance  []string   `json:"redAlliance"`
+	BlueAlliance []string   `json:"blueAlliance"`
+}
+
+// generateScheduleRequest configures a generated qualification schedule.
+type generateScheduleRequest struct {
+	// Teams defaults to the teams already at the event.
+	Teams          []string   `json:"teams" validate:"omitempty,max=100"`
+	MatchesPerTeam int        `json:"matchesPerTeam" validate:"min=1,max=20"`
+	MinTurnaround  int        `json:"minTurnaround" validate:"min=0"`
+	Start          *time.Time `json:"start"`
+	CycleMinutes   int        `json:"cycleMinutes" validate:"min=0,max=60"`
+}
+
+// matchScore is a manually entered match result.
+type matchScore struct {
+	RedScore           *int                 `json:"redScore" validate:"required,min=0"`
+	BlueScore          *int                 `json:"blueScore" validate:"required,min=0"`
+	RedScoreBreakdown  store.ScoreBreakdown `json:"redScoreBreakdown"`
+	BlueScoreBreakdown store.ScoreBreakdown `json:"blueScoreBreakdown"`
+}
+
+// normalizeTeamKey turns a team number like "2733" into the team key
+// "frc2733". Team keys are returned unchanged.
+func normalizeTeamKey(team string) string {
+	team = strings.TrimSpace(team)
+	if team != "" && !strings.HasPrefix(team, "frc") {
+		return "frc" + team
+	}
+	return team
+}
+
+// validateTeamKeys checks that each team key is well formed and only given
+// once.
+func validateTeamKeys(keys []string) error {
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !teamKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid team key %q", key)
+		}
+		if seen[key] {
+			return fmt.Errorf("team %s is listed more than once", key)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// validateSchedule checks that a schedule only has uniquely keyed
+// qualification matches, and that every match has full alliances with no team
+// playing twice.
+func validateSchedule(uploaded []scheduleMatch) error {
+	seen := make(map[string]bool)
+	for _, m := range uploaded {
+		if !qualificationKeyPattern.MatchString(m.Key) {
+			return fmt.Errorf("invalid qualification match key %q", m.Key)
+		}
+		if seen[m.Key] {
+			return fmt.Errorf("match %s is scheduled more than once", m.Key)
+		}
+		seen[m.Key] = true
+
+		for _, alliance := range [][]string{m.RedAlliance, m.BlueAlliance} {
+			if len(alliance) == 0 || len(alliance) > maxAllianceSize {
+				return fmt.Errorf("match %s must have between 1 and %d teams per alliance", m.Key, maxAllianceSize)
+			}
+		}
+
+		if err := validateTeamKeys(append(append([]string{}, m.RedAlliance...), m.BlueAlliance...)); err != nil {
+			return fmt.Errorf("match %s: %w", m.Key, err)
+		}
+	}
+
+	return nil
+}
+
+// parseScheduleCSV parses a schedule with a header row. The columns are "key"
+// (or "match", which can also be just the match number), an optional "time" in
+// RFC 3339 format, and the teams in "red1", "red2", "red3", "blue1", "blue2"
+// and "blue3". Teams can be given as numbers or keys, and empty team cells are
+// skipped.
+func parseScheduleCSV(r io.Reader) ([]scheduleMatch, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("schedule is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read header: %w", err)
+	}
+
+	keyColumn, timeColumn := -1, -1
+	var redColumns, blueColumns []int
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "key" || name == "match":
+			keyColumn = i
+		case name == "time":
+			timeColumn = i
+		case strings.HasPrefix(name, "red"):
+			redColumns = append(redColumns, i)
+		case strings.HasPrefix(name, "blue"):
+			blueColumns = append(blueColumns, i)
+		default:
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+
+	if keyColumn == -1 {
+		return nil, errors.New("schedule has no key column")
+	}
+
+	teams := func(record []string, columns []int) []string {
+		alliance := []string{}
+		for _, column := range columns {
+			if team := normalizeTeamKey(record[column]); team != "" {
+				alliance = append(alliance, team)
+			}
+		}
+		return alliance
+	}
+
+	parsed := []scheduleMatch{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read match: %w", err)
+		}
+
+		m := scheduleMatch{
+			Key:          strings.TrimSpace(record[keyColumn]),
+			RedAlliance:  teams(record, redColumns),
+			BlueAlliance: teams(record, blueColumns),
+		}
+		if _, err := strconv.Atoi(m.Key); err == nil {
+			m.Key = "qm" + m.Key
+		}
+
+		if timeColumn != -1 && strings.TrimSpace(record[timeColumn]) != "" {
+			t, err := time.Parse(time.RFC3339, strings.TrimSpace(record[timeColumn]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid time for match %s: %w", m.Key, err)
+			}
+			m.Time = &t
+		}
+
+		parsed = append(parsed, m)
+	}
+
+	return parsed, nil
+}
+
+// getCustomEvent gets one of the requesting user's realm's events, responding
+// with an error and returning false if it doesn't exist or was imported from
+// TBA.
+func (s *Server) getCustomEvent(w http.ResponseWriter, r *http.Request, eventKey string) (realmID int64, ok bool) {
+	realmID, err := ihttp.GetRealmID(r)
+	if err != nil {
+		ihttp.Error(w, http.StatusForbidden)
+		return realmID, false
+	}
+
+	event, err := s.Store.GetEventForRealm(r.Context(), eventKey, &realmID)
+	if errors.Is(err, store.ErrNoResults{}) {
+		ihttp.Error(w, http.StatusNotFound)
+		return realmID, false
+	} else if err != nil {
+		ihttp.Error(w, http.StatusInternalServerError)
+		s.Logger.WithError(err).Error("getting event")
+		return realmID, false
+	}
+
+	if event.RealmID == nil {
+		ihttp.Error(w, http.StatusForbidden)
+		return realmID, false
+	}
+
+	return realmID, true
+}
+
+// setEventTeamsHandler returns a handler to set the teams at one of the realm's
+// custom events. Teams playing in the event's matches are kept.
+func (s *Server) setEventTeamsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventKey := mux.Vars(r)["eventKey"]
+
+		var teams []string
+		if err := json.NewDecoder(r.Body).Decode(&teams); err != nil || teams == nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		for i := range teams {
+			teams[i] = normalizeTeamKey(teams[i])
+		}
+
+		if err := validateTeamKeys(teams); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		realmID, ok := s.getCustomEvent(w, r, eventKey)
+		if !ok {
+			return
+		}
+
+		err := s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.LockCustomEventTx(r.Context(), tx, eventKey, realmID); err != nil {
+				return err
+			}
+
+			eventTeams, err := s.Store.GetEventTeamsForRealmTx(r.Context(), tx, eventKey, &realmID)
+			if err != nil {
+				return err
+			}
+
+			var before []string
+			for _, team := range eventTeams {
+				before = append(before, team.Key)
+			}
+			sort.Strings(before)
+
+			if err := s.Store.SetEventTeamKeysTx(r.Context(), tx, eventKey, teams); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "event.teams.update", store.AuditTargetEvent, eventKey, before, teams)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("setting event teams")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// setEventScheduleHandler returns a handler to replace the qualification
+// schedule of one of the realm's custom events. The schedule can be JSON or,
+// with a text/csv content type, CSV. Scores already entered for matches that
+// stay in the schedule are kept.
+func (s *Server) setEventScheduleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventKey := mux.Vars(r)["eventKey"]
+
+		var uploaded []scheduleMatch
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+			var err error
+			uploaded, err = parseScheduleCSV(r.Body)
+			if err != nil {
+				ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&uploaded); err != nil || uploaded == nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		for i := range uploaded {
+			for j := range uploaded[i].RedAlliance {
+				uploaded[i].RedAlliance[j] = normalizeTeamKey(uploaded[i].RedAlliance[j])
+			}
+			for j := range uploaded[i].BlueAlliance {
+				uploaded[i].BlueAlliance[j] = normalizeTeamKey(uploaded[i].BlueAlliance[j])
+			}
+		}
+
+		if err := validateSchedule(uploaded); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		realmID, ok := s.getCustomEvent(w, r, eventKey)
+		if !ok {
+			return
+		}
+
+		matches := make([]store.Match, 0, len(uploaded))
+		for _, m := range uploaded {
+			matches = append(matches, store.Match{
+				Key:           m.Key,
+				EventKey:      eventKey,
+				ScheduledTime: m.Time,
+				RedAlliance:   m.RedAlliance,
+				BlueAlliance:  m.BlueAlliance,
+			})
+		}
+
+		err := s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.ExclusiveLockMatchesTx(r.Context(), tx); err != nil {
+				return err
+			}
+
+			if err := s.Store.LockCustomEventTx(r.Context(), tx, eventKey, realmID); err != nil {
+				return err
+			}
+
+			before, err := s.Store.GetMatchesForRealmTx(r.Context(), tx, eventKey, nil, false, &realmID)
+			if err != nil {
+				return err
+			}
+
+			if err := s.Store.ReplaceScheduleTx(r.Context(), tx, eventKey, realmID, matches); err != nil {
+				return err
+			}
+
+			if err := s.updateRankingsTx(r.Context(), tx, eventKey, realmID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "event.schedule.update", store.AuditTargetEvent, eventKey, before, uploaded)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if errors.Is(err, store.ErrFKeyViolation{}) {
+			ihttp.Error(w, http.StatusConflict)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("replacing event schedule")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// generateScheduleHandler returns a handler to generate a qualification
+// schedule for one of the realm's custom events that doesn't have one yet. If
+// a start time is given, matches are scheduled every cycleMinutes from then.
+func (s *Server) generateScheduleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventKey := mux.Vars(r)["eventKey"]
+
+		var req generateScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(req); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		realmID, ok := s.getCustomEvent(w, r, eventKey)
+		if !ok {
+			return
+		}
+
+		teams := req.Teams
+		if teams == nil {
+			eventTeams, err := s.Store.GetEventTeamsForRealm(r.Context(), eventKey, &realmID)
+			if err != nil {
+				ihttp.Error(w, http.StatusInternalServerError)
+				s.Logger.WithError(err).Error("getting event teams")
+				return
+			}
+
+			for _, team := range eventTeams {
+				teams = append(teams, team.Key)
+			}
+		}
+
+		for i := range teams {
+			teams[i] = normalizeTeamKey(teams[i])
+		}
+
+		if err := validateTeamKeys(teams); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		generated, err := schedule.Generate(teams, schedule.Options{
+			MatchesPerTeam: req.MatchesPerTeam,
+			MinTurnaround:  req.MinTurnaround,
+			Seed:           time.Now().UnixNano(),
+		})
+		if err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		generatedSchedule := make([]scheduleMatch, 0, len(generated))
+		for i, m := range generated {
+			sm := scheduleMatch{Key: m.Key, RedAlliance: m.RedAlliance, BlueAlliance: m.BlueAlliance}
+			if req.Start != nil {
+				t := req.Start.Add(time.Duration(i*req.CycleMinutes) * time.Minute)
+				sm.Time = &t
+			}
+			generatedSchedule = append(generatedSchedule, sm)
+		}
+
+		scheduled := false
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.ExclusiveLockMatchesTx(r.Context(), tx); err != nil {
+				return err
+			}
+
+			if err := s.Store.LockCustomEventTx(r.Context(), tx, eventKey, realmID); err != nil {
+				return err
+			}
+
+			var err error
+			if scheduled, err = s.Store.HasQualificationMatchesTx(r.Context(), tx, eventKey); err != nil || scheduled {
+				return err
+			}
+
+			for _, m := range generatedSchedule {
+				err := s.Store.UpsertMatchTx(r.Context(), tx, store.Match{
+					Key:           m.Key,
+					EventKey:      eventKey,
+					ScheduledTime: m.Time,
+					RedAlliance:   m.RedAlliance,
+					BlueAlliance:  m.BlueAlliance,
+				})
+				if err != nil {
+					return fmt.Errorf("unable to upsert match: %w", err)
+				}
+			}
+
+			return s.audit(r, tx, &realmID, "event.schedule.generate", store.AuditTargetEvent, eventKey, nil, generatedSchedule)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("saving generated schedule")
+			return
+		} else if scheduled {
+			ihttp.Respond(w, errors.New("event already has a qualification schedule"), http.StatusConflict)
+			return
+		}
+
+		ihttp.Respond(w, generatedSchedule, http.StatusCreated)
+	}
+}
+
+// setMatchScoreHandler returns a handler to enter the result of a match at one
+// of the realm's custom events.
+func (s *Server) setMatchScoreHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		eventKey, matchKey := vars["eventKey"], vars["matchKey"]
+
+		var score matchScore
+		if err := json.NewDecoder(r.Body).Decode(&score); err != nil {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := validator.New().Struct(score); err != nil {
+			ihttp.Respond(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		realmID, ok := s.getCustomEvent(w, r, eventKey)
+		if !ok {
+			return
+		}
+
+		err := s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.LockCustomEventTx(r.Context(), tx, eventKey, realmID); err != nil {
+				return err
+			}
+
+			before, err := s.Store.GetMatchForRealmTx(r.Context(), tx, eventKey, matchKey, &realmID)
+			if err != nil {
+				return err
+			}
+
+			after, err := s.Store.SetMatchScoreTx(r.Context(), tx, realmID, store.Match{
+				Key:                matchKey,
+				EventKey:           eventKey,
+				RedScore:           score.RedScore,
+				BlueScore:          score.BlueScore,
+				RedScoreBreakdown:  score.RedScoreBreakdown,
+				BlueScoreBreakdown: score.BlueScoreBreakdown,
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := s.updateRankingsTx(r.Context(), tx, eventKey, realmID); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &realmID, "match.score.update", store.AuditTargetMatch, eventKey+"/"+matchKey, before, after)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("setting match score")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
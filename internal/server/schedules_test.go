@@ -0,0 +1,153 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseScheduleCSV(t *testing.T) {
+	start := time.Date(2020, 7, 18, 9, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name    string
+		csv     string
+		want    []scheduleMatch
+		wantErr bool
+	}{
+		{
+			name: "keys and times",
+			csv: "key,time,red1,red2,red3,blue1,blue2,blue3\n" +
+				"qm1,2020-07-18T09:00:00Z,frc1,frc2,frc3,frc4,frc5,frc6\n",
+			want: []scheduleMatch{{
+				Key:          "qm1",
+				Time:         &start,
+				RedAlliance:  []string{"frc1", "frc2", "frc3"},
+				BlueAlliance: []string{"frc4", "frc5", "frc6"},
+			}},
+		},
+		{
+			name: "match numbers and team numbers",
+			csv: "Match, Red 1, Red 2, Blue 1, Blue 2\n" +
+				"1, 2733, 1432, 254, \n" +
+				"2, 4488, 2990, 1425, 5468\n",
+			want: []scheduleMatch{
+				{
+					Key:          "qm1",
+					RedAlliance:  []string{"frc2733", "frc1432"},
+					BlueAlliance: []string{"frc254"},
+				},
+				{
+					Key:          "qm2",
+					RedAlliance:  []string{"frc4488", "frc2990"},
+					BlueAlliance: []string{"frc1425", "frc5468"},
+				},
+			},
+		},
+		{
+			name:    "empty",
+			csv:     "",
+			wantErr: true,
+		},
+		{
+			name:    "no key column",
+			csv:     "red1,blue1\nfrc1,frc2\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown column",
+			csv:     "key,field,red1,blue1\nqm1,A,frc1,frc2\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid time",
+			csv:     "key,time,red1,blue1\nqm1,9am,frc1,frc2\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing cells",
+			csv:     "key,red1,blue1\nqm1,frc1\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseScheduleCSV(strings.NewReader(tt.csv))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error but got none")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("unexpected schedule: %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	testCases := []struct {
+		name     string
+		schedule []scheduleMatch
+		wantErr  bool
+	}{
+		{
+			name: "valid",
+			schedule: []scheduleMatch{
+				{Key: "qm1", RedAlliance: []string{"frc1", "frc2"}, BlueAlliance: []string{"frc3", "frc4"}},
+				{Key: "qm2", RedAlliance: []string{"frc1", "frc3"}, BlueAlliance: []string{"frc2", "frc4"}},
+			},
+		},
+		{
+			name:     "playoff match",
+			schedule: []scheduleMatch{{Key: "sf1m1", RedAlliance: []string{"frc1"}, BlueAlliance: []string{"frc2"}}},
+			wantErr:  true,
+		},
+		{
+			name: "duplicate match",
+			schedule: []scheduleMatch{
+				{Key: "qm1", RedAlliance: []string{"frc1"}, BlueAlliance: []string{"frc2"}},
+				{Key: "qm1", RedAlliance: []string{"frc3"}, BlueAlliance: []string{"frc4"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "empty alliance",
+			schedule: []scheduleMatch{{Key: "qm1", RedAlliance: []string{"frc1"}}},
+			wantErr:  true,
+		},
+		{
+			name:     "alliance too large",
+			schedule: []scheduleMatch{{Key: "qm1", RedAlliance: []string{"frc1", "frc2", "frc3", "frc4"}, BlueAlliance: []string{"frc5"}}},
+			wantErr:  true,
+		},
+		{
+			name:     "team on both alliances",
+			schedule: []scheduleMatch{{Key: "qm1", RedAlliance: []string{"frc1"}, BlueAlliance: []string{"frc1"}}},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid team key",
+			schedule: []scheduleMatch{{Key: "qm1", RedAlliance: []string{"pigmice"}, BlueAlliance: []string{"frc1"}}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSchedule(tt.schedule)
+			if tt.wantErr && err == nil {
+				t.Errorf("expected an error but got none")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
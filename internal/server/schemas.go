@@ -9,6 +9,7 @@ import (
 	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
 	"github.com/Pigmice2733/peregrine-backend/internal/store"
 	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
 )
 
 func (s *Server) createSchemaHandler() http.HandlerFunc {
@@ -38,7 +39,14 @@ func (s *Server) createSchemaHandler() http.HandlerFunc {
 			schema.RealmID = nil
 		}
 
-		err := s.Store.CreateSchema(r.Context(), schema)
+		err := s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			if schema.ID, err = s.Store.CreateSchemaTx(r.Context(), tx, schema); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, schema.RealmID, "schema.create", store.AuditTargetSchema, auditID(schema.ID), nil, schema)
+		})
 		if errors.Is(err, store.ErrExists{}) {
 			ihttp.Respond(w, err, http.StatusConflict)
 			return
@@ -118,9 +126,18 @@ func (s *Server) getSchemaByIDHandler() http.HandlerFunc {
 			realmID = &userRealmID
 		}
 
-		if schema.Year == nil && !roles.IsSuperAdmin && schema.RealmID != realmID {
-			ihttp.Error(w, http.StatusForbidden)
-			return
+		if schema.Year == nil && !roles.IsSuperAdmin {
+			visible, err := s.Store.RealmVisibleToRealm(r.Context(), schema.RealmID, realmID)
+			if err != nil {
+				s.Logger.WithError(err).Error("checking schema visibility")
+				ihttp.Error(w, http.StatusInternalServerError)
+				return
+			}
+
+			if !visible {
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			}
 		}
 
 		ihttp.Respond(w, schema, http.StatusOK)
@@ -8,6 +8,8 @@ import (
 	"github.com/NYTimes/gziphandler"
 	"github.com/Pigmice2733/peregrine-backend/internal/config"
 	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/notify"
+	"github.com/Pigmice2733/peregrine-backend/internal/refresh"
 	"github.com/Pigmice2733/peregrine-backend/internal/store"
 	"github.com/Pigmice2733/peregrine-backend/internal/tba"
 	"github.com/sirupsen/logrus"
@@ -20,10 +22,24 @@ var openAPI []byte
 type Server struct {
 	config.Server
 
-	TBA    *tba.Service
-	Store  *store.Service
-	Logger *logrus.Logger
-	start  time.Time
+	TBA       *tba.Service
+	Store     *store.Service
+	Refresher *refresh.Service
+	Keys      *ihttp.KeySet
+	Notifier  notify.Notifier
+	OIDC      map[string]OIDCProvider
+	Logger    *logrus.Logger
+
+	// PasswordResetURL is the page password reset tokens are linked to.
+	PasswordResetURL string
+
+	// Realms decides who can create realms.
+	Realms config.Realms
+
+	start      time.Time
+	logins     *loginLimiter
+	resets     *loginLimiter
+	challenges *challenges
 }
 
 func (s *Server) uptime() time.Duration {
@@ -32,13 +48,16 @@ func (s *Server) uptime() time.Duration {
 
 // Run starts the server, and returns if it runs into an error
 func (s *Server) Run(ctx context.Context) error {
+	s.logins = newLoginLimiter()
+	s.resets = newLoginLimiter()
+	s.challenges = newChallenges()
 	router := s.registerRoutes()
 
 	var handler http.Handler = router
 	handler = ihttp.LimitBody(handler)
 	handler = gziphandler.GzipHandler(handler)
 	handler = ihttp.Log(handler, s.Logger)
-	handler = ihttp.Auth(handler, s.JWTSecret)
+	handler = ihttp.Auth(handler, s.Keys, s.Store, s.Store)
 	handler = ihttp.CORS(handler, s.Origin)
 
 	httpServer := &http.Server{
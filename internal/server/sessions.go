@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+)
+
+// checkManageSessions returns whether the requesting user can view and revoke
+// the target user's sessions. Users can manage their own sessions, users who
+// can manage users can manage the sessions of users in their realm (see
+// checkManageUser), and super-admins can manage anyone's sessions.
+func (s *Server) checkManageSessions(r *http.Request, targetID int64) error {
+	subjectID, err := ihttp.GetSubject(r)
+	if err != nil {
+		return forbiddenError{err}
+	}
+
+	if subjectID == targetID {
+		return nil
+	}
+
+	_, err = s.checkManageUser(r, targetID)
+	return err
+}
+
+func (s *Server) logoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subjectID, err := ihttp.GetSubject(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		sessionID, err := ihttp.GetSessionID(r)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		err = s.Store.RevokeSession(r.Context(), subjectID, sessionID, store.RevokedLogout)
+		if err != nil && !errors.Is(err, store.ErrNoResults{}) {
+			s.Logger.WithError(err).Error("revoking session")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) getUserSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targetID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		err = s.checkManageSessions(r, targetID)
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("checking session permissions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		sessions, err := s.Store.GetUserSessions(r.Context(), targetID)
+		if err != nil {
+			s.Logger.WithError(err).Error("getting user sessions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		ihttp.Respond(w, sessions, http.StatusOK)
+	}
+}
+
+func (s *Server) revokeUserSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		targetID, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		sessionID, err := strconv.ParseInt(vars["sessionId"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		err = s.checkManageSessions(r, targetID)
+		if err == nil {
+			err = s.Store.RevokeSession(r.Context(), targetID, sessionID, store.RevokedAdmin)
+		}
+
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("revoking user session")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) revokeUserSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targetID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		err = s.checkManageSessions(r, targetID)
+		if err == nil {
+			err = s.Store.RevokeUserSessions(r.Context(), targetID, store.RevokedAdmin)
+		}
+
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("revoking user sessions")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	ihttp "github.com/Pigmice2733/peregrine-backend/internal/http"
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/gorilla/mux"
+)
+
+// Trash is what has been deleted from a realm and can still be restored.
+type Trash struct {
+	Reports []store.Report `json:"reports"`
+	Users   []store.User   `json:"users"`
+	Events  []store.Event  `json:"events"`
+}
+
+// trashHandler returns a handler to list what has been deleted from a realm.
+// Members of the realm see the reports they deleted themselves, along with
+// everything they'd be allowed to restore: every deleted report if they can
+// delete any report, deleted users if they can manage users, and deleted
+// custom events if they can edit events.
+func (s *Server) trashHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		realmID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		var reporterID *int64
+		if err := checkRealmPermission(r, realmID, store.PermReportsDeleteAny); err != nil {
+			userRealmID, err := ihttp.GetRealmID(r)
+			if err != nil || userRealmID != realmID {
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			}
+
+			subject, err := ihttp.GetSubject(r)
+			if err != nil {
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			}
+			reporterID = &subject
+		}
+
+		trash := Trash{Users: []store.User{}, Events: []store.Event{}}
+
+		trash.Reports, err = s.Store.GetDeletedReports(r.Context(), realmID, reporterID)
+		if err != nil {
+			ihttp.Error(w, http.StatusInternalServerError)
+			s.Logger.WithError(err).Error("getting deleted reports")
+			return
+		}
+
+		if checkRealmPermission(r, realmID, store.PermUsersManage) == nil {
+			trash.Users, err = s.Store.GetDeletedUsers(r.Context(), realmID)
+			if err != nil {
+				ihttp.Error(w, http.StatusInternalServerError)
+				s.Logger.WithError(err).Error("getting deleted users")
+				return
+			}
+		}
+
+		if checkRealmPermission(r, realmID, store.PermEventsEdit) == nil {
+			trash.Events, err = s.Store.GetDeletedEvents(r.Context(), realmID)
+			if err != nil {
+				ihttp.Error(w, http.StatusInternalServerError)
+				s.Logger.WithError(err).Error("getting deleted events")
+				return
+			}
+		}
+
+		ihttp.Respond(w, trash, http.StatusOK)
+	}
+}
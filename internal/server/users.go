@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -26,11 +26,14 @@ type baseUser struct {
 
 type requestUser struct {
 	baseUser
-	RealmID   int64       `json:"realmId" validate:"required"`
-	FirstName string      `json:"firstName" validate:"required"`
-	LastName  string      `json:"lastName" validate:"required"`
-	Roles     store.Roles `json:"roles"`
-	Stars     []string    `json:"stars"`
+	RealmID    int64       `json:"realmId" validate:"required_without=InviteCode"`
+	InviteCode string      `json:"inviteCode"`
+	FirstName  string      `json:"firstName" validate:"required"`
+	LastName   string      `json:"lastName" validate:"required"`
+	Email      *string     `json:"email" validate:"omitempty,email"`
+	Roles      store.Roles `json:"roles"`
+	RoleID     *int64      `json:"roleId"`
+	Stars      []string    `json:"stars"`
 }
 
 const (
@@ -39,15 +42,32 @@ const (
 	bcryptCost           = 13
 )
 
-func generateAccessToken(user store.User, expires time.Time, secret string) (string, error) {
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, &ihttp.Claims{
+func generateAccessToken(user store.User, sessionID int64, expires time.Time, keys *ihttp.KeySet) (string, error) {
+	return keys.Sign(&ihttp.Claims{
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expires.Unix(),
 			Subject:   strconv.FormatInt(user.ID, 10),
 		},
-		Roles:   user.Roles,
-		RealmID: user.RealmID,
-	}).SignedString([]byte(secret))
+		Type:        ihttp.TokenTypeAccess,
+		Roles:       user.Roles,
+		Permissions: user.RolePermissions,
+		RealmID:     user.RealmID,
+		SessionID:   sessionID,
+	})
+}
+
+func generateRefreshToken(user store.User, session store.Session, keys *ihttp.KeySet) (string, error) {
+	return keys.Sign(&ihttp.RefreshClaims{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: session.ExpiresAt.Unix(),
+			Subject:   strconv.FormatInt(user.ID, 10),
+		},
+		Type:            ihttp.TokenTypeRefresh,
+		PasswordChanged: user.PasswordChanged.Unix(),
+		SessionID:       session.ID,
+		Generation:      session.Generation,
+		RealmID:         user.RealmID,
+	})
 }
 
 type authenticateResponse struct {
@@ -61,7 +81,12 @@ type UserByNameGetter interface {
 	GetUserByUsername(ctx context.Context, username string) (user store.User, err error)
 }
 
-func authenticateHandler(logger *logrus.Logger, now func() time.Time, userStore UserByNameGetter, secret string) http.HandlerFunc {
+// SessionCreator is used for starting a new session when a user logs in.
+type SessionCreator interface {
+	CreateSession(ctx context.Context, userID int64, userAgent string, expiresAt time.Time) (store.Session, error)
+}
+
+func authenticateHandler(logger *logrus.Logger, now func() time.Time, userStore UserByNameGetter, sessions SessionCreator, keys *ihttp.KeySet, logins *loginLimiter, trustProxy bool) http.HandlerFunc {
 	validate := validator.New()
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -76,9 +101,35 @@ func authenticateHandler(logger *logrus.Logger, now func() time.Time, userStore
 			return
 		}
 
+		ip := ihttp.ClientIP(r, trustProxy)
+
+		// Check for too many failed attempts before doing anything expensive
+		wait, failures, locked := logins.attempt(ru.Username, ip, now())
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			ihttp.Error(w, http.StatusTooManyRequests)
+			return
+		}
+
+		fail := func(reason string) {
+			entry := logger.WithFields(logrus.Fields{
+				"username": ru.Username,
+				"ip":       ip,
+				"failures": failures,
+				"reason":   reason,
+			})
+			if locked {
+				entry.Warn("failed login, locking out further attempts")
+			} else {
+				entry.Info("failed login")
+			}
+
+			ihttp.Error(w, http.StatusUnauthorized)
+		}
+
 		user, err := userStore.GetUserByUsername(r.Context(), ru.Username)
 		if errors.Is(err, store.ErrNoResults{}) {
-			ihttp.Error(w, http.StatusUnauthorized)
+			fail("unknown username")
 			return
 		} else if err != nil {
 			logger.WithError(err).Error("retrieving user from database")
@@ -88,7 +139,7 @@ func authenticateHandler(logger *logrus.Logger, now func() time.Time, userStore
 
 		err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(ru.Password))
 		if err == bcrypt.ErrMismatchedHashAndPassword {
-			ihttp.Error(w, http.StatusUnauthorized)
+			fail("incorrect password")
 			return
 		} else if err != nil {
 			logger.WithError(err).Error("comparing user hash and password")
@@ -96,28 +147,37 @@ func authenticateHandler(logger *logrus.Logger, now func() time.Time, userStore
 			return
 		}
 
-		accessToken, err := generateAccessToken(user, now().Add(accessTokenDuration), secret)
-		if err != nil {
-			logger.WithError(err).Error("generating jwt access token signed string")
-			ihttp.Error(w, http.StatusInternalServerError)
-			return
-		}
+		logins.succeed(ru.Username, ip)
 
-		refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &ihttp.RefreshClaims{
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: now().Add(refreshTokenDuration).Unix(),
-				Subject:   strconv.FormatInt(user.ID, 10),
-			},
-			PasswordChanged: user.PasswordChanged.Unix(),
-		}).SignedString([]byte(secret))
+		session, err := sessions.CreateSession(r.Context(), user.ID, r.UserAgent(), now().Add(refreshTokenDuration))
 		if err != nil {
-			logger.WithError(err).Error("generating jwt refresh token signed string")
+			logger.WithError(err).Error("creating session")
 			ihttp.Error(w, http.StatusInternalServerError)
 			return
 		}
 
-		ihttp.Respond(w, authenticateResponse{accessToken, refreshToken}, http.StatusOK)
+		respondWithTokens(w, logger, now, user, session, keys)
+	}
+}
+
+// respondWithTokens responds with a new access token and refresh token for the
+// given user's session.
+func respondWithTokens(w http.ResponseWriter, logger *logrus.Logger, now func() time.Time, user store.User, session store.Session, keys *ihttp.KeySet) {
+	accessToken, err := generateAccessToken(user, session.ID, now().Add(accessTokenDuration), keys)
+	if err != nil {
+		logger.WithError(err).Error("generating jwt access token signed string")
+		ihttp.Error(w, http.StatusInternalServerError)
+		return
 	}
+
+	refreshToken, err := generateRefreshToken(user, session, keys)
+	if err != nil {
+		logger.WithError(err).Error("generating jwt refresh token signed string")
+		ihttp.Error(w, http.StatusInternalServerError)
+		return
+	}
+
+	ihttp.Respond(w, authenticateResponse{accessToken, refreshToken}, http.StatusOK)
 }
 
 // UserByIDGetter is used for retrieving users by ID. It should return store.ErrNoResults
@@ -126,15 +186,28 @@ type UserByIDGetter interface {
 	GetUserByID(ctx context.Context, id int64) (user store.User, err error)
 }
 
-type refreshRequest struct {
-	RefreshToken string `json:"refreshToken"`
+// SessionRotator is used for exchanging a session's refresh token for a new
+// one. It should return store.ErrNoResults if the session is not active, and
+// store.ErrReused if the refresh token was already exchanged.
+type SessionRotator interface {
+	RotateSession(ctx context.Context, id, generation int64, expiresAt time.Time) (store.Session, error)
 }
 
-type refreshResponse struct {
-	AccessToken string `json:"accessToken"`
+// RealmMembershipGetter is used for retrieving a user's membership in a realm
+// other than their home realm. It should return store.ErrNoResults if they
+// aren't a member.
+type RealmMembershipGetter interface {
+	GetRealmMembership(ctx context.Context, userID, realmID int64) (store.RealmMembership, error)
 }
 
-func refreshHandler(logger *logrus.Logger, now func() time.Time, userStore UserByIDGetter, secret string) http.HandlerFunc {
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+	// RealmID switches the active realm of the session, otherwise it stays
+	// the same.
+	RealmID *int64 `json:"realmId"`
+}
+
+func refreshHandler(logger *logrus.Logger, now func() time.Time, userStore UserByIDGetter, memberships RealmMembershipGetter, sessions SessionRotator, keys *ihttp.KeySet) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var rr refreshRequest
 		if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
@@ -142,20 +215,14 @@ func refreshHandler(logger *logrus.Logger, now func() time.Time, userStore UserB
 			return
 		}
 
-		token, err := jwt.ParseWithClaims(rr.RefreshToken, &ihttp.RefreshClaims{}, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-
-			return []byte(secret), nil
-		})
+		token, err := jwt.ParseWithClaims(rr.RefreshToken, &ihttp.RefreshClaims{}, keys.Keyfunc)
 		if err != nil || !token.Valid {
 			ihttp.Error(w, http.StatusUnauthorized)
 			return
 		}
 
 		claims, ok := token.Claims.(*ihttp.RefreshClaims)
-		if !ok {
+		if !ok || claims.Type != ihttp.TokenTypeRefresh || claims.SessionID == 0 {
 			ihttp.Error(w, http.StatusUnauthorized)
 			return
 		}
@@ -182,29 +249,70 @@ func refreshHandler(logger *logrus.Logger, now func() time.Time, userStore UserB
 			return
 		}
 
-		accessToken, err := generateAccessToken(user, now().Add(accessTokenDuration), secret)
-		if err != nil {
-			logger.WithError(err).Error("generating jwt access token signed string")
+		realmID := claims.RealmID
+		if rr.RealmID != nil {
+			realmID = *rr.RealmID
+		}
+
+		if realmID != 0 && realmID != user.RealmID {
+			m, err := memberships.GetRealmMembership(r.Context(), user.ID, realmID)
+			if errors.Is(err, store.ErrNoResults{}) && rr.RealmID != nil {
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			} else if err == nil {
+				user = user.InRealm(m)
+			} else if !errors.Is(err, store.ErrNoResults{}) {
+				logger.WithError(err).Error("retrieving realm membership")
+				ihttp.Error(w, http.StatusInternalServerError)
+				return
+			}
+			// Otherwise they've left the realm since, so use their home realm
+		}
+
+		session, err := sessions.RotateSession(r.Context(), claims.SessionID, claims.Generation, now().Add(refreshTokenDuration))
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		} else if errors.Is(err, store.ErrReused{}) {
+			logger.WithError(err).WithField("userId", userID).Warn("refresh token reused, revoked session")
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			logger.WithError(err).Error("rotating session")
 			ihttp.Error(w, http.StatusInternalServerError)
 			return
 		}
 
-		ihttp.Respond(w, refreshResponse{accessToken}, http.StatusOK)
+		if session.UserID != user.ID {
+			ihttp.Error(w, http.StatusUnauthorized)
+			return
+		}
+
+		respondWithTokens(w, logger, now, user, session, keys)
 	}
 }
 
 func (s *Server) createUserHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// API keys are for reading and writing realm data, not for creating
+		// users, who could be granted more than the key.
+		if _, ok := ihttp.GetAPIKey(r); ok {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
 		var ru requestUser
 		if err := json.NewDecoder(r.Body).Decode(&ru); err != nil {
 			ihttp.Error(w, http.StatusUnprocessableEntity)
 			return
 		}
 
-		// If the creator user isn't an admin, reset their roles
+		// If the creator user can't manage users, reset their roles. Users
+		// signing up with an invite code are only ever verified.
 		roles := ihttp.GetRoles(r)
-		if !roles.IsAdmin && !roles.IsSuperAdmin {
+		if ru.InviteCode != "" || !ihttp.GetPermissions(r).Has(store.PermUsersManage) {
 			ru.Roles = store.Roles{}
+			ru.RoleID = nil
 		}
 
 		// Only super-admins can create super-admins
@@ -217,6 +325,7 @@ func (s *Server) createUserHandler() http.HandlerFunc {
 			if id, err := ihttp.GetRealmID(r); err != nil || id != ru.RealmID {
 				ru.Roles.IsVerified = false
 				ru.Roles.IsAdmin = false
+				ru.RoleID = nil
 			}
 		}
 
@@ -225,7 +334,20 @@ func (s *Server) createUserHandler() http.HandlerFunc {
 			return
 		}
 
-		err := s.Store.CheckSimilarUsernameExists(r.Context(), ru.Username, nil)
+		err := s.checkGrantRoles(r, ru.RealmID, ru.Roles, ru.RoleID)
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("checking roles can be granted")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		err = s.Store.CheckSimilarUsernameExists(r.Context(), ru.Username, nil)
 		if errors.Is(err, store.ErrExists{}) {
 			ihttp.Error(w, http.StatusConflict)
 			return
@@ -235,7 +357,7 @@ func (s *Server) createUserHandler() http.HandlerFunc {
 			return
 		}
 
-		u := store.User{Username: ru.Username, RealmID: ru.RealmID, Roles: ru.Roles, Stars: ru.Stars, FirstName: ru.FirstName, LastName: ru.LastName}
+		u := store.User{Username: ru.Username, RealmID: ru.RealmID, Roles: ru.Roles, RoleID: ru.RoleID, Stars: ru.Stars, FirstName: ru.FirstName, LastName: ru.LastName, Email: ru.Email}
 
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(ru.Password), bcryptCost)
 		if err != nil {
@@ -246,14 +368,35 @@ func (s *Server) createUserHandler() http.HandlerFunc {
 
 		u.HashedPassword = string(hashedPassword)
 
-		err = s.Store.CreateUser(r.Context(), u)
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			var err error
+			if ru.InviteCode != "" {
+				u, err = s.Store.CreateUserWithInviteTx(r.Context(), tx, u, normalizeInviteCode(ru.InviteCode))
+			} else {
+				// Users who sign up themselves wait for approval by someone who
+				// can manage users in the realm.
+				u.Pending = checkRealmPermission(r, u.RealmID, store.PermUsersManage) != nil
+				u, err = s.Store.CreateUserTx(r.Context(), tx, u)
+			}
+			if err != nil {
+				return err
+			}
 
-		if errors.Is(err, store.ErrExists{}) {
+			return s.audit(r, tx, &u.RealmID, "user.create", store.AuditTargetUser, auditID(u.ID), nil, u)
+		})
+
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrExists{}) {
 			ihttp.Error(w, http.StatusConflict)
 			return
 		} else if errors.Is(err, store.ErrFKeyViolation{}) {
 			ihttp.Error(w, http.StatusUnprocessableEntity)
 			return
+		} else if errors.Is(err, store.ErrQuotaExceeded{}) {
+			ihttp.Respond(w, newQuotaResponse(err), http.StatusForbidden)
+			return
 		} else if err != nil {
 			s.Logger.WithError(err).Error("creating new user")
 			ihttp.Error(w, http.StatusInternalServerError)
@@ -289,10 +432,29 @@ func (s *Server) getUsersHandler() http.HandlerFunc {
 			return
 		}
 
+		for i := range users {
+			hideEmail(r, &users[i])
+		}
+
 		ihttp.Respond(w, users, http.StatusOK)
 	}
 }
 
+// hideEmail clears a user's email unless the request is from that user or
+// someone who can manage users in their realm, so listing users can't be used
+// to collect emails.
+func hideEmail(r *http.Request, user *store.User) {
+	if subjectID, err := ihttp.GetSubject(r); err == nil && subjectID == user.ID {
+		return
+	}
+
+	if checkRealmPermission(r, user.RealmID, store.PermUsersManage) == nil {
+		return
+	}
+
+	user.Email = nil
+}
+
 func (s *Server) getUserByIDHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
@@ -301,7 +463,7 @@ func (s *Server) getUserByIDHandler() http.HandlerFunc {
 			return
 		}
 
-		realmID, err := ihttp.GetSubject(r)
+		subjectID, err := ihttp.GetSubject(r)
 		if err != nil {
 			ihttp.Error(w, http.StatusForbidden)
 			return
@@ -321,13 +483,15 @@ func (s *Server) getUserByIDHandler() http.HandlerFunc {
 
 		// only allow users to get other users within their realm if they aren't a super
 		// admin
-		if !roles.IsSuperAdmin && realmID != user.RealmID {
+		if !roles.IsSuperAdmin && subjectID != user.ID {
 			if realmID, err := ihttp.GetRealmID(r); err != nil || realmID != user.RealmID {
 				ihttp.Error(w, http.StatusNotFound)
 				return
 			}
 		}
 
+		hideEmail(r, &user)
+
 		ihttp.Respond(w, user, http.StatusOK)
 	}
 }
@@ -338,7 +502,9 @@ func (s *Server) patchUserHandler() http.HandlerFunc {
 		Password  *string      `json:"password" validate:"omitempty,gte=8,lte=128"`
 		FirstName *string      `json:"firstName" validate:"omitempty,gte=0"`
 		LastName  *string      `json:"lastName" validate:"omitempty,gte=0"`
+		Email     *string      `json:"email" validate:"omitempty,email"`
 		Roles     *store.Roles `json:"roles"`
+		RoleID    *int64       `json:"roleId"`
 		Stars     []string     `json:"stars"`
 	}
 
@@ -354,9 +520,8 @@ func (s *Server) patchUserHandler() http.HandlerFunc {
 			ihttp.Error(w, http.StatusForbidden)
 			return
 		}
-		roles := ihttp.GetRoles(r)
 
-		if targetID != subjectID && !roles.IsAdmin && !roles.IsSuperAdmin {
+		if targetID != subjectID && !ihttp.GetPermissions(r).Has(store.PermUsersManage) {
 			ihttp.Error(w, http.StatusForbidden)
 			return
 		}
@@ -367,33 +532,45 @@ func (s *Server) patchUserHandler() http.HandlerFunc {
 			return
 		}
 
-		// Admins can only patch users in the same realm, only super-admins can edit super-admins
-		if targetID != subjectID && !roles.IsSuperAdmin {
-			targetUser, err := s.Store.GetUserByID(r.Context(), targetID)
-			if err != nil {
-				s.Logger.WithError(err).Error("getting user")
-				ihttp.Error(w, http.StatusInternalServerError)
-				return
-			}
-			if realmID, err := ihttp.GetRealmID(r); err != nil || realmID != targetUser.RealmID {
-				ihttp.Error(w, http.StatusForbidden)
-				return
-			}
-			if targetUser.Roles.IsSuperAdmin {
-				ihttp.Error(w, http.StatusForbidden)
-				return
-			}
+		// Users can only patch other users if they can manage them
+		targetRealmID, err := ihttp.GetRealmID(r)
+		if targetID != subjectID {
+			var targetUser store.User
+			targetUser, err = s.checkManageUser(r, targetID)
+			targetRealmID = targetUser.RealmID
 		}
 
-		// Only (super)admins can create admins or verify users
-		if ru.Roles != nil && (ru.Roles.IsAdmin || ru.Roles.IsVerified) && !(roles.IsAdmin || roles.IsSuperAdmin) {
+		if errors.Is(err, forbiddenError{}) {
 			ihttp.Error(w, http.StatusForbidden)
 			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("getting user")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
 		}
 
-		// Only super-admins can create super-admins
-		if ru.Roles != nil && !roles.IsSuperAdmin {
-			ru.Roles.IsSuperAdmin = false
+		// Users can only hand out roles with permissions they have themselves
+		if ru.Roles != nil || ru.RoleID != nil {
+			grant := store.Roles{}
+			if ru.Roles != nil {
+				grant = *ru.Roles
+			}
+
+			err := s.checkGrantRoles(r, targetRealmID, grant, ru.RoleID)
+			if errors.Is(err, forbiddenError{}) {
+				ihttp.Error(w, http.StatusForbidden)
+				return
+			} else if errors.Is(err, store.ErrNoResults{}) {
+				ihttp.Error(w, http.StatusUnprocessableEntity)
+				return
+			} else if err != nil {
+				s.Logger.WithError(err).Error("checking roles can be granted")
+				ihttp.Error(w, http.StatusInternalServerError)
+				return
+			}
 		}
 
 		if err := validator.New().Struct(ru); err != nil {
@@ -413,7 +590,7 @@ func (s *Server) patchUserHandler() http.HandlerFunc {
 			}
 		}
 
-		u := store.PatchUser{ID: targetID, Username: ru.Username, Roles: ru.Roles, FirstName: ru.FirstName, LastName: ru.LastName, Stars: ru.Stars}
+		u := store.PatchUser{ID: targetID, Username: ru.Username, Roles: ru.Roles, RoleID: ru.RoleID, FirstName: ru.FirstName, LastName: ru.LastName, Email: ru.Email, Stars: ru.Stars}
 
 		if ru.Password != nil {
 			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*ru.Password), bcryptCost)
@@ -427,7 +604,24 @@ func (s *Server) patchUserHandler() http.HandlerFunc {
 			u.HashedPassword = &hashedPasswordString
 		}
 
-		err = s.Store.PatchUser(r.Context(), u)
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			before, err := s.Store.GetUserByIDTx(r.Context(), tx, targetID)
+			if err != nil {
+				return err
+			}
+
+			if err := s.Store.PatchUserTx(r.Context(), tx, u); err != nil {
+				return err
+			}
+
+			after, err := s.Store.GetUserByIDTx(r.Context(), tx, targetID)
+			if err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &after.RealmID, "user.update", store.AuditTargetUser, auditID(targetID), before, after)
+		})
+
 		if errors.Is(err, store.ErrNoResults{}) {
 			ihttp.Error(w, http.StatusNotFound)
 			return
@@ -458,44 +652,27 @@ func (s *Server) deleteUserHandler() http.HandlerFunc {
 			return
 		}
 
-		roles := ihttp.GetRoles(r)
+		// only allow people to delete other users if they can manage them
+		if id != requesterSubject {
+			_, err = s.checkManageUser(r, id)
+		}
 
-		// only allow people to delete themselves if they're not an admin or super admin
-		if id != requesterSubject && !roles.IsAdmin && !roles.IsSuperAdmin {
+		if errors.Is(err, forbiddenError{}) {
 			ihttp.Error(w, http.StatusForbidden)
 			return
-		}
-
-		if roles.IsSuperAdmin {
-			err = s.Store.DeleteUserByID(r.Context(), id)
-		} else {
-			// Only super-admins can delete super-admins
-			err := s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
-				targetUser, err := s.Store.GetUserByID(r.Context(), id)
+		} else if err == nil {
+			err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+				before, err := s.Store.GetUserByIDTx(r.Context(), tx, id)
 				if err != nil {
-					return fmt.Errorf("unable to get target user: %w", err)
-				}
-				if targetUser.Roles.IsSuperAdmin {
-					return forbiddenError{}
+					return err
 				}
 
-				var realmID int64
-				realmID, err = ihttp.GetRealmID(r)
-				if err != nil {
-					return forbiddenError{}
+				if err := s.Store.DeleteUserByIDTx(r.Context(), tx, id, &requesterSubject); err != nil {
+					return err
 				}
 
-				return s.Store.DeleteUserByIDRealmTx(r.Context(), tx, id, realmID)
+				return s.audit(r, tx, &before.RealmID, "user.delete", store.AuditTargetUser, auditID(id), before, nil)
 			})
-
-			if errors.Is(err, forbiddenError{}) {
-				ihttp.Error(w, http.StatusForbidden)
-				return
-			} else if err != nil {
-				s.Logger.WithError(err).Error("unable to delete user")
-				ihttp.Error(w, http.StatusInternalServerError)
-				return
-			}
 		}
 
 		if errors.Is(err, store.ErrNoResults{}) {
@@ -510,3 +687,88 @@ func (s *Server) deleteUserHandler() http.HandlerFunc {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// restoreUserHandler returns a handler to restore a deleted user. The
+// requesting user needs to be able to manage the deleted user.
+func (s *Server) restoreUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		target, err := s.Store.GetDeletedUser(r.Context(), id)
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("getting deleted user")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := checkManageTarget(r, target); err != nil {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		}
+
+		target.DeletedAt, target.DeletedBy = nil, nil
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			if err := s.Store.RestoreUserTx(r.Context(), tx, id); err != nil {
+				return err
+			}
+
+			return s.audit(r, tx, &target.RealmID, "user.restore", store.AuditTargetUser, auditID(id), nil, target)
+		})
+		if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if errors.Is(err, store.ErrQuotaExceeded{}) {
+			ihttp.Respond(w, newQuotaResponse(err), http.StatusForbidden)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("restoring user")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) unlockUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			ihttp.Error(w, http.StatusBadRequest)
+			return
+		}
+
+		target, err := s.checkManageUser(r, id)
+		if errors.Is(err, forbiddenError{}) {
+			ihttp.Error(w, http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNoResults{}) {
+			ihttp.Error(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.Logger.WithError(err).Error("getting user")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		err = s.Store.DoTransaction(r.Context(), func(tx *sqlx.Tx) error {
+			return s.audit(r, tx, &target.RealmID, "user.unlock", store.AuditTargetUser, auditID(id), nil, nil)
+		})
+		if err != nil {
+			s.Logger.WithError(err).Error("auditing user unlock")
+			ihttp.Error(w, http.StatusInternalServerError)
+			return
+		}
+
+		s.logins.unlock(target.Username)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,232 @@
+// Package simulate replays a past event's stored matches as if the event were
+// happening live, for rehearsing scouting workflows before the season. The
+// schedule is revealed up front and then each match's scores and breakdowns
+// are revealed on an accelerated clock through the same store methods the
+// refresh package uses, so clients can't tell the difference.
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// Service simulates a live event by replaying the matches of SourceEventKey
+// into TargetEventKey.
+type Service struct {
+	Store  *store.Service
+	Logger *logrus.Logger
+
+	SourceEventKey string
+	TargetEventKey string
+
+	// RealmID is the realm the source event is read from and the simulated
+	// event is created in. If nil, the simulated event is public like a TBA event.
+	RealmID *int64
+
+	// Speed is how many times faster than real time the event is replayed.
+	Speed float64
+
+	// Lead is how long after the schedule is revealed the first match is played.
+	Lead time.Duration
+
+	// Now and Sleep may be overridden for testing. They default to time.Now and
+	// a context-aware time.Sleep.
+	Now   func() time.Time
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+// step is a single match to be revealed at a specific time.
+type step struct {
+	At    time.Time
+	Match store.Match
+}
+
+// plan maps the matches of the source event onto a simulated timeline starting
+// at start. Match times are shifted and compressed by speed so that the first
+// match happens lead after start. Matches without any time are played last, in
+// key order. The returned schedule has all scores and breakdowns stripped, and
+// the steps hold the full matches in the order they should be revealed.
+func plan(matches []store.Match, targetEventKey string, start time.Time, speed float64, lead time.Duration) (schedule []store.Match, steps []step) {
+	sorted := make([]store.Match, len(matches))
+	copy(sorted, matches)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, tj := sorted[i].GetTime(), sorted[j].GetTime()
+		if ti == nil || tj == nil {
+			if ti == nil && tj == nil {
+				return sorted[i].Key < sorted[j].Key
+			}
+			return ti != nil
+		}
+		return ti.Before(*tj)
+	})
+
+	var origin, last time.Time
+	for _, m := range sorted {
+		if t := m.GetTime(); t != nil {
+			if origin.IsZero() {
+				origin = *t
+			}
+			last = *t
+		}
+	}
+
+	simTime := func(t *time.Time) time.Time {
+		if t == nil {
+			t = &last
+		}
+		return start.Add(lead + time.Duration(float64(t.Sub(origin))/speed))
+	}
+
+	for _, m := range sorted {
+		at := simTime(m.GetTime())
+		scheduled := at
+
+		m.EventKey = targetEventKey
+		m.TBAURL = nil
+		m.TBADeleted = false
+
+		unplayed := m
+		unplayed.ScheduledTime = &scheduled
+		unplayed.PredictedTime = &scheduled
+		unplayed.ActualTime = nil
+		unplayed.RedScore = nil
+		unplayed.BlueScore = nil
+		unplayed.RedScoreBreakdown = nil
+		unplayed.BlueScoreBreakdown = nil
+		unplayed.Videos = nil
+		schedule = append(schedule, unplayed)
+
+		played := m
+		played.ScheduledTime = &scheduled
+		played.PredictedTime = &scheduled
+		played.ActualTime = &scheduled
+		steps = append(steps, step{At: at, Match: played})
+	}
+
+	return schedule, steps
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run creates the simulated event, reveals its schedule, and then reveals each
+// match as the simulated clock reaches it. Once every match has been played the
+// source event's rankings are copied over. Run blocks until the simulation is
+// finished or the context is cancelled.
+func (s *Service) Run(ctx context.Context) error {
+	if s.Speed <= 0 {
+		return fmt.Errorf("simulation speed must be positive, got %f", s.Speed)
+	}
+
+	now, sleepFunc := s.Now, s.Sleep
+	if now == nil {
+		now = time.Now
+	}
+	if sleepFunc == nil {
+		sleepFunc = sleep
+	}
+
+	source, err := s.Store.GetEventForRealm(ctx, s.SourceEventKey, s.RealmID)
+	if err != nil {
+		return fmt.Errorf("unable to get source event %q: %w", s.SourceEventKey, err)
+	}
+
+	matches, err := s.Store.GetMatchesForRealm(ctx, s.SourceEventKey, nil, false, s.RealmID)
+	if err != nil {
+		return fmt.Errorf("unable to get source event matches: %w", err)
+	}
+
+	if len(matches) == 0 {
+		return fmt.Errorf("source event %q has no matches to simulate", s.SourceEventKey)
+	}
+
+	rankings, err := s.Store.GetEventTeamsForRealm(ctx, s.SourceEventKey, s.RealmID)
+	if err != nil {
+		return fmt.Errorf("unable to get source event teams: %w", err)
+	}
+
+	start := now()
+	schedule, steps := plan(matches, s.TargetEventKey, start, s.Speed, s.Lead)
+
+	// Start the event at the beginning of the day so it's considered active.
+	year, month, day := start.Date()
+	event := source
+	event.Key = s.TargetEventKey
+	event.Name = source.Name + " (Simulated)"
+	event.RealmID = s.RealmID
+	event.TBADeleted = false
+	event.StartDate = time.Date(year, month, day, 0, 0, 0, 0, start.Location())
+	event.EndDate = steps[len(steps)-1].At.Add(time.Hour)
+
+	if err := s.Store.EventsUpsert(ctx, []store.Event{event}); err != nil {
+		return fmt.Errorf("unable to create simulated event: %w", err)
+	}
+
+	// The simulated event will never be on TBA, so don't let the refresher
+	// mark it as deleted halfway through the rehearsal.
+	if err := s.Store.ExemptEventFromTBADeletion(ctx, s.TargetEventKey); err != nil {
+		return fmt.Errorf("unable to exempt simulated event from deletion: %w", err)
+	}
+
+	teams := make([]store.EventTeam, 0, len(rankings))
+	for _, t := range rankings {
+		teams = append(teams, store.EventTeam{Key: t.Key, EventKey: s.TargetEventKey})
+	}
+
+	if err := s.Store.EventTeamsUpsert(ctx, teams); err != nil {
+		return fmt.Errorf("unable to store simulated event teams: %w", err)
+	}
+
+	if err := s.Store.UpdateTBAMatches(ctx, schedule); err != nil {
+		return fmt.Errorf("unable to store simulated schedule: %w", err)
+	}
+
+	if err := s.Store.MarkMatchesDeleted(ctx, s.TargetEventKey, schedule); err != nil {
+		return fmt.Errorf("unable to mark stale simulated matches deleted: %w", err)
+	}
+
+	s.Logger.WithField("event", s.TargetEventKey).WithField("count", len(schedule)).Info("revealed simulated schedule")
+
+	for _, st := range steps {
+		if err := sleepFunc(ctx, st.At.Sub(now())); err != nil {
+			return err
+		}
+
+		if err := s.Store.UpdateTBAMatches(ctx, []store.Match{st.Match}); err != nil {
+			return fmt.Errorf("unable to reveal simulated match %q: %w", st.Match.Key, err)
+		}
+
+		s.Logger.WithField("event", s.TargetEventKey).WithField("match", st.Match.Key).Info("revealed simulated match")
+	}
+
+	for i := range rankings {
+		rankings[i].EventKey = s.TargetEventKey
+	}
+
+	if err := s.Store.EventTeamsUpsert(ctx, rankings); err != nil {
+		return fmt.Errorf("unable to store simulated rankings: %w", err)
+	}
+
+	s.Logger.WithField("event", s.TargetEventKey).Info("finished simulating event")
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package simulate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Pigmice2733/peregrine-backend/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newInt(i int) *int {
+	return &i
+}
+
+func newTime(t time.Time) *time.Time {
+	return &t
+}
+
+func newString(s string) *string {
+	return &s
+}
+
+func TestPlan(t *testing.T) {
+	origin := time.Date(2019, 3, 2, 9, 0, 0, 0, time.UTC)
+	start := time.Date(2020, 1, 4, 12, 0, 0, 0, time.UTC)
+
+	matches := []store.Match{
+		{
+			Key:                "qm2",
+			EventKey:           "2019orwil",
+			ActualTime:         newTime(origin.Add(time.Minute * 10)),
+			RedScore:           newInt(30),
+			BlueScore:          newInt(20),
+			RedAlliance:        []string{"frc1"},
+			BlueAlliance:       []string{"frc2"},
+			RedScoreBreakdown:  store.ScoreBreakdown{"rp": 1.0},
+			BlueScoreBreakdown: store.ScoreBreakdown{"rp": 0.0},
+			TBAURL:             newString("https://www.thebluealliance.com/match/2019orwil_qm2"),
+		},
+		{
+			Key:          "qm3",
+			EventKey:     "2019orwil",
+			RedAlliance:  []string{"frc1"},
+			BlueAlliance: []string{"frc2"},
+		},
+		{
+			Key:           "qm1",
+			EventKey:      "2019orwil",
+			ScheduledTime: newTime(origin),
+			RedScore:      newInt(10),
+			BlueScore:     newInt(12),
+			RedAlliance:   []string{"frc2"},
+			BlueAlliance:  []string{"frc1"},
+			Videos:        []string{"https://www.youtube.com/watch?v=foo"},
+		},
+	}
+
+	schedule, steps := plan(matches, "2019sim", start, 10, time.Minute)
+
+	first := start.Add(time.Minute)
+	second := start.Add(time.Minute * 2)
+
+	expectedSchedule := []store.Match{
+		{
+			Key:           "qm1",
+			EventKey:      "2019sim",
+			ScheduledTime: newTime(first),
+			PredictedTime: newTime(first),
+			RedAlliance:   []string{"frc2"},
+			BlueAlliance:  []string{"frc1"},
+		},
+		{
+			Key:           "qm2",
+			EventKey:      "2019sim",
+			ScheduledTime: newTime(second),
+			PredictedTime: newTime(second),
+			RedAlliance:   []string{"frc1"},
+			BlueAlliance:  []string{"frc2"},
+		},
+		{
+			Key:           "qm3",
+			EventKey:      "2019sim",
+			ScheduledTime: newTime(second),
+			PredictedTime: newTime(second),
+			RedAlliance:   []string{"frc1"},
+			BlueAlliance:  []string{"frc2"},
+		},
+	}
+
+	if !cmp.Equal(schedule, expectedSchedule) {
+		t.Errorf("unexpected schedule, got diff: %s", cmp.Diff(expectedSchedule, schedule))
+	}
+
+	expectedSteps := []step{
+		{
+			At: first,
+			Match: store.Match{
+				Key:           "qm1",
+				EventKey:      "2019sim",
+				ScheduledTime: newTime(first),
+				PredictedTime: newTime(first),
+				ActualTime:    newTime(first),
+				RedScore:      newInt(10),
+				BlueScore:     newInt(12),
+				RedAlliance:   []string{"frc2"},
+				BlueAlliance:  []string{"frc1"},
+				Videos:        []string{"https://www.youtube.com/watch?v=foo"},
+			},
+		},
+		{
+			At: second,
+			Match: store.Match{
+				Key:                "qm2",
+				EventKey:           "2019sim",
+				ScheduledTime:      newTime(second),
+				PredictedTime:      newTime(second),
+				ActualTime:         newTime(second),
+				RedScore:           newInt(30),
+				BlueScore:          newInt(20),
+				RedAlliance:        []string{"frc1"},
+				BlueAlliance:       []string{"frc2"},
+				RedScoreBreakdown:  store.ScoreBreakdown{"rp": 1.0},
+				BlueScoreBreakdown: store.ScoreBreakdown{"rp": 0.0},
+			},
+		},
+		{
+			At: second,
+			Match: store.Match{
+				Key:           "qm3",
+				EventKey:      "2019sim",
+				ScheduledTime: newTime(second),
+				PredictedTime: newTime(second),
+				ActualTime:    newTime(second),
+				RedAlliance:   []string{"frc1"},
+				BlueAlliance:  []string{"frc2"},
+			},
+		},
+	}
+
+	if !cmp.Equal(steps, expectedSteps) {
+		t.Errorf("unexpected steps, got diff: %s", cmp.Diff(expectedSteps, steps))
+	}
+}
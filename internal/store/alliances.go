@@ -49,7 +49,8 @@ func (s *Service) LockAlliance(ctx context.Context, tx *sqlx.Tx, eventKey, match
 					alliances.event_key = $1 AND
 					alliances.match_key = $2 AND
 					$3 = ANY(alliances.team_keys) AND
-					(events.realm_id IS NULL OR events.realm_id = $4)
+					(events.realm_id IS NULL OR events.realm_id = $4) AND
+					events.deleted_at IS NULL
 				FOR UPDATE of alliances
 			)
 			`, eventKey, matchKey, teamKey, realmID).Scan(&present)
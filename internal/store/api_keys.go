@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Scopes an API key can be granted.
+const (
+	ScopeReadReports  = "reports:read"
+	ScopeWriteReports = "reports:write"
+	ScopeReadStats    = "stats:read"
+	ScopeAdmin        = "admin"
+)
+
+// APIKey is a key used by scripts and bots to access a realm's data without
+// logging in as a user. Only a hash of the key is stored, the key itself is
+// only known when it's created. Requests made with a key act on behalf of the
+// user that created it, so a key is deleted along with its creator, and it can
+// never do more than its creator currently can in the key's realm.
+type APIKey struct {
+	ID         int64          `json:"id" db:"id"`
+	RealmID    int64          `json:"realmId" db:"realm_id"`
+	CreatedBy  int64          `json:"createdBy" db:"created_by"`
+	Name       string         `json:"name" db:"name" validate:"required,max=128"`
+	Prefix     string         `json:"prefix" db:"prefix"`
+	Hash       string         `json:"-" db:"key_hash"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes" validate:"required,min=1,dive,oneof=reports:read reports:write stats:read admin"`
+	CreatedAt  time.Time      `json:"createdAt" db:"created_at"`
+	LastUsedAt *time.Time     `json:"lastUsedAt,omitempty" db:"last_used_at"`
+	ExpiresAt  *time.Time     `json:"expiresAt,omitempty" db:"expires_at"`
+	RevokedAt  *time.Time     `json:"revokedAt,omitempty" db:"revoked_at"`
+
+	// CreatorRoles and CreatorPermissions are the roles and realm role
+	// permissions the creator currently has in the key's realm. They're only
+	// set by UseAPIKey.
+	CreatorRoles       Roles          `json:"-" db:"creator_roles"`
+	CreatorPermissions pq.StringArray `json:"-" db:"creator_permissions"`
+}
+
+// HasScope returns whether the key was granted the given scope. The admin
+// scope grants every other scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Access returns the roles and permissions requests made with the key have.
+// Keys are verified and only admin keys are admins, but neither is granted
+// unless the creator still has it, and the key's permissions are the ones its
+// scopes allow that the creator still has.
+func (k APIKey) Access() (Roles, PermissionSet) {
+	creator := NewPermissionSet(k.CreatorRoles, k.CreatorPermissions)
+	creatorAdmin := k.CreatorRoles.IsAdmin || k.CreatorRoles.IsSuperAdmin
+
+	roles := Roles{
+		IsVerified: k.CreatorRoles.IsVerified || creatorAdmin,
+		IsAdmin:    creatorAdmin && k.HasScope(ScopeAdmin),
+	}
+
+	perms := make(PermissionSet)
+	for p := range NewPermissionSet(Roles{IsVerified: true, IsAdmin: k.HasScope(ScopeAdmin)}, nil) {
+		if creator.Has(p) {
+			perms[p] = true
+		}
+	}
+
+	return roles, perms
+}
+
+// CreateAPIKeyTx stores a new API key and returns it. If the realm or creator
+// doesn't exist ErrFKeyViolation is returned.
+func (s *Service) CreateAPIKeyTx(ctx context.Context, tx *sqlx.Tx, key APIKey) (APIKey, error) {
+	var created APIKey
+	err := tx.GetContext(ctx, &created, `
+		INSERT INTO api_keys (realm_id, created_by, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING *
+	`, key.RealmID, key.CreatedBy, key.Name, key.Prefix, key.Hash, key.Scopes, key.ExpiresAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgExists {
+		return created, ErrExists{fmt.Errorf("api key already exists: %w", err)}
+	} else if ok && pqErr.Code == pgFKeyViolation {
+		return created, ErrFKeyViolation{fmt.Errorf("api key fk violation %s: %w", pqErr.Constraint, err)}
+	} else if err != nil {
+		return created, fmt.Errorf("unable to create api key: %w", err)
+	}
+
+	return created, nil
+}
+
+// UseAPIKey gets the active API key with the given hash and records that it
+// was just used, along with its creator's current roles and permissions in the
+// key's realm. Super-admins stay super-admins in every realm. If there is no such key, it has expired or been revoked, or its
+// creator was deleted, ErrNoResults is returned.
+func (s *Service) UseAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	var key APIKey
+	err := s.db.GetContext(ctx, &key, `
+		WITH used AS (
+			UPDATE api_keys
+				SET last_used_at = now()
+				WHERE
+					key_hash = $1 AND
+					revoked_at IS NULL AND
+					(expires_at IS NULL OR expires_at > now()) AND
+					NOT EXISTS(SELECT FROM users WHERE users.id = api_keys.created_by AND users.deleted_at IS NOT NULL)
+				RETURNING *
+		)
+		SELECT
+			used.*,
+			CASE
+				WHEN users.realm_id = used.realm_id THEN users.roles
+				ELSE COALESCE(realm_memberships.roles, '{}') ||
+					jsonb_build_object('isSuperAdmin', COALESCE(users.roles->'isSuperAdmin', 'false'))
+			END AS creator_roles,
+			COALESCE(realm_roles.permissions, '{}') AS creator_permissions
+		FROM used
+		INNER JOIN users ON users.id = used.created_by AND users.deleted_at IS NULL
+		LEFT JOIN realm_memberships
+			ON realm_memberships.user_id = users.id AND realm_memberships.realm_id = used.realm_id
+		LEFT JOIN realm_roles ON realm_roles.id = CASE
+			WHEN users.realm_id = used.realm_id THEN users.role_id
+			ELSE realm_memberships.role_id
+		END
+	`, hash)
+	if err == sql.ErrNoRows {
+		return key, ErrNoResults{fmt.Errorf("no active api key with hash %q", hash)}
+	} else if err != nil {
+		return key, fmt.Errorf("unable to use api key: %w", err)
+	}
+
+	return key, nil
+}
+
+// GetRealmAPIKeys returns all API keys belonging to a realm that haven't been
+// revoked, newest first.
+func (s *Service) GetRealmAPIKeys(ctx context.Context, realmID int64) ([]APIKey, error) {
+	keys := make([]APIKey, 0)
+	err := s.db.SelectContext(ctx, &keys, `
+		SELECT *
+		FROM api_keys
+		WHERE realm_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, realmID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKeyTx revokes an API key belonging to a realm. If the realm has no
+// such active key ErrNoResults is returned.
+func (s *Service) RevokeAPIKeyTx(ctx context.Context, tx *sqlx.Tx, realmID, id int64) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE api_keys
+			SET revoked_at = now()
+			WHERE id = $1 AND realm_id = $2 AND revoked_at IS NULL
+	`, id, realmID)
+	if err != nil {
+		return fmt.Errorf("unable to revoke api key: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no active api key with id %d in realm %d", id, realmID)}
+	}
+
+	return nil
+}
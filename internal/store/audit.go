@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Types of things audit log entries can be about.
+const (
+	AuditTargetReport = "report"
+	AuditTargetUser   = "user"
+	AuditTargetRealm  = "realm"
+	AuditTargetEvent  = "event"
+	AuditTargetMatch  = "match"
+	AuditTargetSchema = "schema"
+)
+
+// AuditData is a JSON snapshot of an audit log entry's target. It's null when
+// there's nothing to record, like the state before something was created.
+type AuditData json.RawMessage
+
+// MarshalJSON returns the snapshot, or null if there isn't one.
+func (d AuditData) MarshalJSON() ([]byte, error) {
+	if len(d) == 0 {
+		return []byte("null"), nil
+	}
+
+	return d, nil
+}
+
+// Value returns the snapshot for storing as JSONB.
+func (d AuditData) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+
+	return string(d), nil
+}
+
+// Scan copies a JSONB snapshot from the database.
+func (d *AuditData) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*d = nil
+	case []byte:
+		*d = append(AuditData(nil), src...)
+	case string:
+		*d = AuditData(src)
+	default:
+		return errors.New("got invalid type for AuditData")
+	}
+
+	return nil
+}
+
+// AuditEntry records a single change: who made it, what it was made to, and
+// the state of the target before and after.
+type AuditEntry struct {
+	ID         int64     `json:"id" db:"id"`
+	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+	ActorID    *int64    `json:"actorId" db:"actor_id"`
+	APIKeyID   *int64    `json:"apiKeyId,omitempty" db:"api_key_id"`
+	RealmID    *int64    `json:"realmId" db:"realm_id"`
+	Action     string    `json:"action" db:"action"`
+	TargetType string    `json:"targetType" db:"target_type"`
+	TargetID   string    `json:"targetId" db:"target_id"`
+	Before     AuditData `json:"before" db:"before"`
+	After      AuditData `json:"after" db:"after"`
+}
+
+// AuditFilter narrows down which audit log entries are returned. Unset fields
+// don't filter anything.
+type AuditFilter struct {
+	RealmID    *int64
+	ActorID    *int64
+	TargetType *string
+	TargetID   *string
+	Since      *time.Time
+	Until      *time.Time
+	Limit      int
+}
+
+// InsertAuditEntryTx adds an entry to the audit log using the given
+// transaction.
+func (s *Service) InsertAuditEntryTx(ctx context.Context, tx *sqlx.Tx, e AuditEntry) error {
+	_, err := tx.NamedExecContext(ctx, `
+		INSERT INTO audit_log (actor_id, api_key_id, realm_id, action, target_type, target_id, before, after)
+		VALUES (:actor_id, :api_key_id, :realm_id, :action, :target_type, :target_id, :before, :after)
+	`, e)
+	if err != nil {
+		return fmt.Errorf("unable to insert audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditEntries returns the audit log entries matching the filter, newest
+// first.
+func (s *Service) GetAuditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+
+	err := s.db.SelectContext(ctx, &entries, `
+		SELECT *
+		FROM audit_log
+		WHERE
+			(CAST($1 AS INTEGER) IS NULL OR realm_id = $1) AND
+			(CAST($2 AS INTEGER) IS NULL OR actor_id = $2) AND
+			(CAST($3 AS TEXT) IS NULL OR target_type = $3) AND
+			(CAST($4 AS TEXT) IS NULL OR target_id = $4) AND
+			(CAST($5 AS TIMESTAMPTZ) IS NULL OR created_at >= $5) AND
+			(CAST($6 AS TIMESTAMPTZ) IS NULL OR created_at < $6)
+		ORDER BY id DESC
+		LIMIT $7
+	`, f.RealmID, f.ActorID, f.TargetType, f.TargetID, f.Since, f.Until, f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get audit entries: %w", err)
+	}
+
+	return entries, nil
+}
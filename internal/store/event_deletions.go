@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// EventDeletion records a TBA event that was marked as deleted because it
+// disappeared from TBA, and whether an admin has since undone that.
+type EventDeletion struct {
+	ID       int64      `json:"id" db:"id"`
+	EventKey string     `json:"eventKey" db:"event_key"`
+	MarkedAt time.Time  `json:"markedAt" db:"marked_at"`
+	UndoneAt *time.Time `json:"undoneAt,omitempty" db:"undone_at"`
+	UndoneBy *int64     `json:"undoneBy,omitempty" db:"undone_by"`
+}
+
+// GetTBAEventKeys returns the keys of all events in the given year that came
+// from TBA (have a NULL realm_id) and are not marked as deleted.
+func (s *Service) GetTBAEventKeys(ctx context.Context, year int) ([]string, error) {
+	keys := make([]string, 0)
+	err := s.db.SelectContext(ctx, &keys, `
+		SELECT key
+		FROM events
+		WHERE
+			realm_id IS NULL AND
+			NOT tba_deleted AND
+			EXTRACT(YEAR FROM start_date) = $1
+	`, year)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get TBA event keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// MarkEventsDeleted counts one more consecutive absence from TBA for every
+// event in the given year that is not a custom event (has a NULL realm_id) and
+// whose key is not in present. Events that have now been absent threshold
+// times in a row get tba_deleted set to true and are recorded in the
+// event_deletions log. Events an admin has restored with UndoEventDeletion are
+// never marked again. The keys of newly deleted events are returned.
+func (s *Service) MarkEventsDeleted(ctx context.Context, year int, present []string, threshold int) ([]string, error) {
+	deleted := make([]string, 0)
+
+	err := s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE events
+				SET
+					tba_missing_count = tba_missing_count + 1,
+					tba_deleted = tba_missing_count + 1 >= $3
+				WHERE
+					key != ALL($1) AND
+					realm_id IS NULL AND
+					NOT tba_deleted AND
+					NOT tba_delete_exempt AND
+					EXTRACT(YEAR FROM start_date) = $2
+				RETURNING key, tba_deleted
+		`, pq.Array(present), year, threshold)
+		if err != nil {
+			return fmt.Errorf("unable to count missing events: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var key string
+			var isDeleted bool
+			if err := rows.Scan(&key, &isDeleted); err != nil {
+				return fmt.Errorf("unable to scan missing event: %w", err)
+			}
+
+			if isDeleted {
+				deleted = append(deleted, key)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("unable to read missing events: %w", err)
+		}
+
+		if len(deleted) == 0 {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO event_deletions (event_key)
+			SELECT unnest($1::TEXT[])
+		`, pq.Array(deleted))
+		if err != nil {
+			return fmt.Errorf("unable to log deleted events: %w", err)
+		}
+
+		return nil
+	})
+
+	return deleted, err
+}
+
+// GetEventDeletions returns the log of events marked as deleted from TBA, most
+// recent first.
+func (s *Service) GetEventDeletions(ctx context.Context) ([]EventDeletion, error) {
+	deletions := make([]EventDeletion, 0)
+	err := s.db.SelectContext(ctx, &deletions, `
+		SELECT id, event_key, marked_at, undone_at, undone_by
+		FROM event_deletions
+		ORDER BY marked_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get event deletions: %w", err)
+	}
+
+	return deletions, nil
+}
+
+// UndoEventDeletionTx restores a TBA event that was marked as deleted and
+// exempts it from being marked again. If there is no such deleted event
+// ErrNoResults is returned.
+func (s *Service) UndoEventDeletionTx(ctx context.Context, tx *sqlx.Tx, eventKey string, userID int64) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE events
+			SET
+				tba_deleted = false,
+				tba_missing_count = 0,
+				tba_delete_exempt = true
+			WHERE
+				key = $1 AND
+				realm_id IS NULL AND
+				tba_deleted
+	`, eventKey)
+	if err != nil {
+		return fmt.Errorf("unable to restore event: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("unable to determine rows affected: %w", err)
+	} else if n == 0 {
+		return ErrNoResults{fmt.Errorf("no deleted TBA event with key %s", eventKey)}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE event_deletions
+			SET
+				undone_at = now(),
+				undone_by = $2
+			WHERE
+				event_key = $1 AND
+				undone_at IS NULL
+	`, eventKey, userID)
+	if err != nil {
+		return fmt.Errorf("unable to log restored event: %w", err)
+	}
+
+	return nil
+}
+
+// ExemptEventFromTBADeletion stops an event from being marked as deleted when
+// it is missing from TBA, e.g. because it was never on TBA in the first place.
+func (s *Service) ExemptEventFromTBADeletion(ctx context.Context, eventKey string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE events SET tba_delete_exempt = true WHERE key = $1", eventKey)
+	if err != nil {
+		return fmt.Errorf("unable to exempt event from deletion: %w", err)
+	}
+
+	return nil
+}
@@ -28,25 +28,30 @@ type Event struct {
 	Lat          float64        `json:"lat" db:"lat"`
 	Lon          float64        `json:"lon" db:"lon"`
 	TBADeleted   bool           `json:"tbaDeleted" db:"tba_deleted"`
+	DeletedAt    *time.Time     `json:"deletedAt,omitempty" db:"deleted_at"`
+	DeletedBy    *int64         `json:"deletedBy,omitempty" db:"deleted_by"`
 }
 
-const eventsQuery = `
-SELECT
-	key,
-	name,
-	district,
-	full_district,
-	week,
-	start_date,
-	end_date,
-	webcasts,
-	location_name,
-	gmaps_url,
-	lat,
-	lon,
-	tba_deleted,
+const eventColumns = `
+	events.key,
+	events.name,
+	events.district,
+	events.full_district,
+	events.week,
+	events.start_date,
+	events.end_date,
+	events.webcasts,
+	events.location_name,
+	events.gmaps_url,
+	events.lat,
+	events.lon,
+	events.tba_deleted,
 	events.realm_id,
-	COALESCE(schema_id, s.id) AS schema_id
+`
+
+const eventsQuery = `
+SELECT` + eventColumns + `
+	COALESCE(events.schema_id, s.id) AS schema_id
 FROM
 	events
 LEFT JOIN
@@ -59,7 +64,7 @@ ON
 // If tbaDeleted is true, events that have been deleted from TBA will be returned in addition to events that
 // have not been deleted. Otherwise, only events that have not been deleted will be returned.
 func (s *Service) GetEvents(ctx context.Context, tbaDeleted bool, year *int) (events []Event, err error) {
-	query := eventsQuery + "WHERE (EXTRACT(YEAR FROM start_date) = $1 OR $1 IS NULL)"
+	query := eventsQuery + "WHERE events.deleted_at IS NULL AND (EXTRACT(YEAR FROM start_date) = $1 OR $1 IS NULL)"
 
 	if !tbaDeleted {
 		query += " AND NOT tba_deleted"
@@ -69,13 +74,33 @@ func (s *Service) GetEvents(ctx context.Context, tbaDeleted bool, year *int) (ev
 	return events, s.db.SelectContext(ctx, &events, query, year)
 }
 
-const eventsRealmQuery = eventsQuery + `WHERE (events.realm_id IS NULL OR events.realm_id = $1)`
+// eventsRealmQuery is like eventsQuery, but events without a schema of their
+// own fall back to the realm's default schema for the year before the standard
+// schema.
+const eventsRealmQuery = `
+SELECT` + eventColumns + `
+	COALESCE(
+		events.schema_id,
+		CAST(r.settings->'defaultSchemas'->>CAST(CAST(EXTRACT(YEAR FROM start_date) AS INTEGER) AS TEXT) AS INTEGER),
+		s.id
+	) AS schema_id
+FROM
+	events
+LEFT JOIN
+	realms r
+ON
+	r.id = $1
+LEFT JOIN
+	schemas s
+ON
+	s.year = EXTRACT(YEAR FROM start_date)
+WHERE (events.realm_id IS NULL OR events.realm_id = $1) AND events.deleted_at IS NULL`
 
 const eventRealmYearQuery = `
 SELECT DISTINCT
 	EXTRACT(YEAR FROM start_date)
 FROM events
-WHERE (events.realm_id IS NULL OR events.realm_id = $1)
+WHERE (events.realm_id IS NULL OR events.realm_id = $1) AND events.deleted_at IS NULL
 `
 
 // GetEventsForRealm returns all events from a specific realm. Additionally all
@@ -102,7 +127,16 @@ func (s *Service) GetEventYearsForRealm(ctx context.Context, realmID *int64) (ye
 
 // GetEventForRealm retrieves a specific event in a specific realm (or no realm for TBA events).
 func (s *Service) GetEventForRealm(ctx context.Context, eventKey string, realmID *int64) (event Event, err error) {
-	err = s.db.GetContext(ctx, &event, eventsRealmQuery+" AND key = $2", realmID, eventKey)
+	return s.getEventForRealm(ctx, s.db, eventKey, realmID)
+}
+
+// GetEventForRealmTx is GetEventForRealm using the given transaction.
+func (s *Service) GetEventForRealmTx(ctx context.Context, tx *sqlx.Tx, eventKey string, realmID *int64) (event Event, err error) {
+	return s.getEventForRealm(ctx, tx, eventKey, realmID)
+}
+
+func (s *Service) getEventForRealm(ctx context.Context, q sqlx.QueryerContext, eventKey string, realmID *int64) (event Event, err error) {
+	err = sqlx.GetContext(ctx, q, &event, eventsRealmQuery+" AND key = $2", realmID, eventKey)
 	if err == sql.ErrNoRows {
 		return event, ErrNoResults{fmt.Errorf("event %s does not exist: %w", eventKey, err)}
 	}
@@ -110,10 +144,14 @@ func (s *Service) GetEventForRealm(ctx context.Context, eventKey string, realmID
 	return event, err
 }
 
-// GetActiveEvents returns all event keys for events that are currently happening.
-func (s *Service) GetActiveEvents(ctx context.Context) ([]string, error) {
-	const query = `SELECT key FROM events WHERE start_date <= CURRENT_DATE AND end_date >= CURRENT_DATE`
-	events := make([]string, 0)
+// GetActiveEvents returns the keys and districts of all TBA events that are
+// currently happening.
+func (s *Service) GetActiveEvents(ctx context.Context) ([]Event, error) {
+	const query = `
+	SELECT key, district
+	FROM events
+	WHERE start_date <= CURRENT_DATE AND end_date >= CURRENT_DATE AND realm_id IS NULL AND deleted_at IS NULL`
+	events := make([]Event, 0)
 	return events, s.db.SelectContext(ctx, &events, query)
 }
 
@@ -141,7 +179,8 @@ func (s *Service) EventsUpsert(ctx context.Context, events []Event) error {
 					lon = :lon,
 					realm_id = :realm_id,
 					schema_id = COALESCE(events.schema_id, :schema_id),
-					tba_deleted = false
+					tba_deleted = false,
+					tba_missing_count = 0
 		`)
 		if err != nil {
 			return fmt.Errorf("unable to prepare events upsert statemant: %w", err)
@@ -166,29 +205,6 @@ func (s *Service) EventsUpsert(ctx context.Context, events []Event) error {
 	})
 }
 
-// MarkEventsDeleted will set tba_deleted to true on all events that were
-// *not* included in the events slice and are not custom events (have a NULL realm_id).
-func (s *Service) MarkEventsDeleted(ctx context.Context, events []Event) error {
-	keys := pq.StringArray{}
-	for _, e := range events {
-		keys = append(keys, e.Key)
-	}
-
-	_, err := s.db.ExecContext(ctx, `
-		UPDATE events
-			SET
-				tba_deleted = true
-			WHERE
-				key != ALL($1) AND
-				realm_id IS NULL
-	`, keys)
-	if err != nil {
-		return fmt.Errorf("unable to mark tba_deleted on missing events: %w", err)
-	}
-
-	return nil
-}
-
 // ExclusiveLockEventsTx acquires an exclusive lock on the events table.
 func (s *Service) ExclusiveLockEventsTx(ctx context.Context, tx *sqlx.Tx) error {
 	_, err := tx.ExecContext(ctx, "LOCK TABLE events IN EXCLUSIVE MODE")
@@ -199,7 +215,8 @@ func (s *Service) ExclusiveLockEventsTx(ctx context.Context, tx *sqlx.Tx) error
 	return nil
 }
 
-// GetEventRealmIDTx returns the realm ID of an event by key.
+// GetEventRealmIDTx returns the realm ID of an event by key. Deleted events are
+// included, since their keys stay taken until they're purged.
 func (s *Service) GetEventRealmIDTx(ctx context.Context, tx *sqlx.Tx, eventKey string) (realmID *int64, err error) {
 	err = tx.QueryRowContext(ctx, "SELECT realm_id FROM events WHERE key = $1", eventKey).Scan(&realmID)
 	if err == sql.ErrNoRows {
@@ -212,8 +229,24 @@ func (s *Service) GetEventRealmIDTx(ctx context.Context, tx *sqlx.Tx, eventKey s
 }
 
 // UpsertEventTx upserts a single event into the database and returns whether
-// the event was created or updated.
+// the event was created or updated. A deleted event with the same key is
+// replaced and restored. New realm events count towards the realm's custom
+// events quota, and ErrQuotaExceeded is returned if it has been reached.
 func (s *Service) UpsertEventTx(ctx context.Context, tx *sqlx.Tx, event Event) error {
+	if event.RealmID != nil {
+		var exists bool
+		err := tx.GetContext(ctx, &exists, "SELECT EXISTS(SELECT FROM events WHERE key = $1 AND realm_id = $2 AND deleted_at IS NULL)", event.Key, event.RealmID)
+		if err != nil {
+			return fmt.Errorf("unable to check if event exists: %w", err)
+		}
+
+		if !exists {
+			if err := s.checkRealmQuotaTx(ctx, tx, *event.RealmID, QuotaCustomEvents); err != nil {
+				return err
+			}
+		}
+	}
+
 	_, err := tx.NamedExecContext(ctx, `
 			INSERT INTO events (key, name, district, full_district, week, start_date, end_date, webcasts, location_name, gmaps_url, lat, lon, realm_id, schema_id, tba_deleted)
 				VALUES (:key, :name, :district, :full_district, :week, :start_date, :end_date, :webcasts, :location_name, :gmaps_url, :lat, :lon, :realm_id, :schema_id, :tba_deleted)
@@ -233,7 +266,9 @@ func (s *Service) UpsertEventTx(ctx context.Context, tx *sqlx.Tx, event Event) e
 						lon = :lon,
 						realm_id = :realm_id,
 						schema_id = :schema_id,
-						tba_deleted = :tba_deleted
+						tba_deleted = :tba_deleted,
+						deleted_at = NULL,
+						deleted_by = NULL
 		`, event)
 	if err != nil {
 		return fmt.Errorf("unable to upsert event: %w", err)
@@ -241,3 +276,116 @@ func (s *Service) UpsertEventTx(ctx context.Context, tx *sqlx.Tx, event Event) e
 
 	return nil
 }
+
+// DeleteEventTx marks a realm's custom event as deleted by the given user. It
+// can be restored until it's purged. If the realm has no such event
+// ErrNoResults is returned.
+func (s *Service) DeleteEventTx(ctx context.Context, tx *sqlx.Tx, eventKey string, realmID int64, deletedBy *int64) error {
+	res, err := tx.ExecContext(ctx, `
+	UPDATE events
+		SET deleted_at = now(), deleted_by = $3
+		WHERE key = $1 AND realm_id = $2 AND deleted_at IS NULL
+	`, eventKey, realmID, deletedBy)
+	if err != nil {
+		return fmt.Errorf("unable to delete event: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("realm %d has no event %s", realmID, eventKey)}
+	}
+
+	return nil
+}
+
+// GetDeletedEvents returns a realm's deleted custom events, most recently
+// deleted first.
+func (s *Service) GetDeletedEvents(ctx context.Context, realmID int64) ([]Event, error) {
+	events := make([]Event, 0)
+
+	err := s.db.SelectContext(ctx, &events, `
+	SELECT`+eventColumns+`
+		events.schema_id,
+		events.deleted_at,
+		events.deleted_by
+	FROM events
+	WHERE realm_id = $1 AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`, realmID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get deleted events: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetDeletedEventTx retrieves one of a realm's deleted custom events. If the
+// realm has no such deleted event ErrNoResults is returned.
+func (s *Service) GetDeletedEventTx(ctx context.Context, tx *sqlx.Tx, eventKey string, realmID int64) (Event, error) {
+	var event Event
+
+	err := tx.GetContext(ctx, &event, `
+	SELECT`+eventColumns+`
+		events.schema_id,
+		events.deleted_at,
+		events.deleted_by
+	FROM events
+	WHERE key = $1 AND realm_id = $2 AND deleted_at IS NOT NULL
+	`, eventKey, realmID)
+	if err == sql.ErrNoRows {
+		return event, ErrNoResults{fmt.Errorf("realm %d has no deleted event %s", realmID, eventKey)}
+	} else if err != nil {
+		return event, fmt.Errorf("unable to get deleted event: %w", err)
+	}
+
+	return event, nil
+}
+
+// RestoreEventTx restores one of a realm's deleted custom events. If the realm
+// has no such deleted event ErrNoResults is returned, and if the realm is at
+// its custom events quota ErrQuotaExceeded is returned.
+func (s *Service) RestoreEventTx(ctx context.Context, tx *sqlx.Tx, eventKey string, realmID int64) error {
+	var exists bool
+	err := tx.GetContext(ctx, &exists, `
+		SELECT EXISTS(SELECT FROM events WHERE key = $1 AND realm_id = $2 AND deleted_at IS NOT NULL)
+	`, eventKey, realmID)
+	if err != nil {
+		return fmt.Errorf("unable to check if deleted event exists: %w", err)
+	} else if !exists {
+		return ErrNoResults{fmt.Errorf("realm %d has no deleted event %s", realmID, eventKey)}
+	}
+
+	if err := s.checkRealmQuotaTx(ctx, tx, realmID, QuotaCustomEvents); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE events
+			SET deleted_at = NULL, deleted_by = NULL
+			WHERE key = $1 AND realm_id = $2 AND deleted_at IS NOT NULL
+	`, eventKey, realmID)
+	if err != nil {
+		return fmt.Errorf("unable to restore event: %w", err)
+	}
+
+	return nil
+}
+
+// LockCustomEventTx locks one of a realm's custom events until the transaction
+// ends, so its teams and schedule can be changed without racing other changes.
+// If the realm has no such event ErrNoResults is returned.
+func (s *Service) LockCustomEventTx(ctx context.Context, tx *sqlx.Tx, eventKey string, realmID int64) error {
+	var key string
+	err := tx.GetContext(ctx, &key, `
+		SELECT key
+		FROM events
+		WHERE key = $1 AND realm_id = $2 AND deleted_at IS NULL
+		FOR UPDATE
+	`, eventKey, realmID)
+	if err == sql.ErrNoRows {
+		return ErrNoResults{fmt.Errorf("realm %d has no event %s", realmID, eventKey)}
+	} else if err != nil {
+		return fmt.Errorf("unable to lock event: %w", err)
+	}
+
+	return nil
+}
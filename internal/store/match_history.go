@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Sources of match history versions.
+const (
+	MatchSourceTBA    = "tba"
+	MatchSourceManual = "manual"
+)
+
+// MatchVersion is a snapshot of a match's schedule, alliances, and scores as
+// of when it was recorded.
+type MatchVersion struct {
+	ID                 int64          `json:"id" db:"id"`
+	RecordedAt         time.Time      `json:"recordedAt" db:"recorded_at"`
+	Source             string         `json:"source" db:"source"`
+	PredictedTime      *time.Time     `json:"predictedTime" db:"predicted_time"`
+	ScheduledTime      *time.Time     `json:"scheduledTime" db:"scheduled_time"`
+	ActualTime         *time.Time     `json:"actualTime" db:"actual_time"`
+	RedScore           *int           `json:"redScore" db:"red_score"`
+	BlueScore          *int           `json:"blueScore" db:"blue_score"`
+	RedAlliance        pq.StringArray `json:"redAlliance" db:"red_alliance"`
+	BlueAlliance       pq.StringArray `json:"blueAlliance" db:"blue_alliance"`
+	RedScoreBreakdown  ScoreBreakdown `json:"redScoreBreakdown" db:"red_score_breakdown"`
+	BlueScoreBreakdown ScoreBreakdown `json:"blueScoreBreakdown" db:"blue_score_breakdown"`
+}
+
+type matchHistoryRow struct {
+	Match
+	Source string `db:"source"`
+}
+
+// recordMatchHistoryTx adds a version of the match to its history, unless
+// nothing but the predicted time changed since the latest version. Predicted
+// times are updated by TBA every few minutes during an event, so they'd drown
+// out the changes we actually care about.
+func (s *Service) recordMatchHistoryTx(ctx context.Context, tx *sqlx.Tx, match Match, source string) error {
+	if match.RedAlliance == nil {
+		match.RedAlliance = pq.StringArray{}
+	}
+	if match.BlueAlliance == nil {
+		match.BlueAlliance = pq.StringArray{}
+	}
+
+	_, err := tx.NamedExecContext(ctx, `
+		INSERT INTO match_history (match_key, event_key, source, predicted_time, scheduled_time, actual_time, red_score, blue_score, red_alliance, blue_alliance, red_score_breakdown, blue_score_breakdown)
+		SELECT :key, :event_key, :source, :predicted_time, :scheduled_time, :actual_time, :red_score, :blue_score, :red_alliance, :blue_alliance, :red_score_breakdown, :blue_score_breakdown
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM (
+				SELECT *
+				FROM match_history
+				WHERE event_key = :event_key AND match_key = :key
+				ORDER BY id DESC
+				LIMIT 1
+			) latest
+			WHERE
+				latest.scheduled_time IS NOT DISTINCT FROM CAST(:scheduled_time AS TIMESTAMPTZ) AND
+				latest.actual_time IS NOT DISTINCT FROM CAST(:actual_time AS TIMESTAMPTZ) AND
+				latest.red_score IS NOT DISTINCT FROM CAST(:red_score AS INTEGER) AND
+				latest.blue_score IS NOT DISTINCT FROM CAST(:blue_score AS INTEGER) AND
+				latest.red_alliance = CAST(:red_alliance AS TEXT[]) AND
+				latest.blue_alliance = CAST(:blue_alliance AS TEXT[]) AND
+				latest.red_score_breakdown IS NOT DISTINCT FROM CAST(:red_score_breakdown AS JSONB) AND
+				latest.blue_score_breakdown IS NOT DISTINCT FROM CAST(:blue_score_breakdown AS JSONB)
+		)
+	`, matchHistoryRow{Match: match, Source: source})
+	if err != nil {
+		return fmt.Errorf("unable to record match history: %w", err)
+	}
+
+	return nil
+}
+
+// GetMatchHistory returns every recorded version of a match, oldest first.
+func (s *Service) GetMatchHistory(ctx context.Context, eventKey, matchKey string) ([]MatchVersion, error) {
+	versions := make([]MatchVersion, 0)
+	err := s.db.SelectContext(ctx, &versions, `
+		SELECT
+			id,
+			recorded_at,
+			source,
+			predicted_time,
+			scheduled_time,
+			actual_time,
+			red_score,
+			blue_score,
+			red_alliance,
+			blue_alliance,
+			red_score_breakdown,
+			blue_score_breakdown
+		FROM match_history
+		WHERE event_key = $1 AND match_key = $2
+		ORDER BY id
+	`, eventKey, matchKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get match history: %w", err)
+	}
+
+	return versions, nil
+}
+
+// MatchChanges returns the JSON names of the fields that differ between two
+// versions of a match. Score breakdown changes are reported per key, e.g.
+// "redScoreBreakdown.foulPoints", and a missing breakdown is the same as an
+// empty one.
+func MatchChanges(prev, cur Match) []string {
+	changes := make([]string, 0)
+
+	if !timesEqual(prev.PredictedTime, cur.PredictedTime) {
+		changes = append(changes, "predictedTime")
+	}
+	if !timesEqual(prev.ScheduledTime, cur.ScheduledTime) {
+		changes = append(changes, "scheduledTime")
+	}
+	if !timesEqual(prev.ActualTime, cur.ActualTime) {
+		changes = append(changes, "actualTime")
+	}
+	if !intsEqual(prev.RedScore, cur.RedScore) {
+		changes = append(changes, "redScore")
+	}
+	if !intsEqual(prev.BlueScore, cur.BlueScore) {
+		changes = append(changes, "blueScore")
+	}
+	if !stringsEqual(prev.RedAlliance, cur.RedAlliance) {
+		changes = append(changes, "redAlliance")
+	}
+	if !stringsEqual(prev.BlueAlliance, cur.BlueAlliance) {
+		changes = append(changes, "blueAlliance")
+	}
+	if !stringsEqual(prev.Videos, cur.Videos) {
+		changes = append(changes, "videos")
+	}
+
+	changes = append(changes, breakdownChanges("redScoreBreakdown", prev.RedScoreBreakdown, cur.RedScoreBreakdown)...)
+	changes = append(changes, breakdownChanges("blueScoreBreakdown", prev.BlueScoreBreakdown, cur.BlueScoreBreakdown)...)
+
+	return changes
+}
+
+func breakdownChanges(name string, prev, cur ScoreBreakdown) []string {
+	var keys []string
+	for key, value := range cur {
+		if prevValue, ok := prev[key]; !ok || !reflect.DeepEqual(prevValue, value) {
+			keys = append(keys, name+"."+key)
+		}
+	}
+
+	for key := range prev {
+		if _, ok := cur[key]; !ok {
+			keys = append(keys, name+"."+key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func timesEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
+}
+
+func intsEqual(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
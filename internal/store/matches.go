@@ -43,13 +43,13 @@ func (sb ScoreBreakdown) Value() (driver.Value, error) {
 
 // Scan unmarshals the JSON representation of the score breakdown stored in
 // the database into the score breakdown.
-func (sb ScoreBreakdown) Scan(src interface{}) error {
+func (sb *ScoreBreakdown) Scan(src interface{}) error {
 	j, ok := src.([]byte)
 	if !ok {
 		return errors.New("got invalid type for ScoreBreakdown")
 	}
 
-	return json.Unmarshal(j, &sb)
+	return json.Unmarshal(j, sb)
 }
 
 // GetTime returns the actual match time if available, and if not, predicted time
@@ -94,7 +94,8 @@ INNER JOIN
 	ON
 		matches.event_key = events.key
 WHERE
-	(events.realm_id = $1 OR events.realm_id IS NULL)`
+	(events.realm_id = $1 OR events.realm_id IS NULL) AND
+	events.deleted_at IS NULL`
 
 // GetMatchesForRealm returns all matches for a realm from a specific event that include the given
 // teams. If teams is nil or empty a list of all the matches for that event are
@@ -102,6 +103,15 @@ WHERE
 // will be returned in addition to matches that have not been deleted. Otherwise,
 // only matches that have not been deleted will be returned.
 func (s *Service) GetMatchesForRealm(ctx context.Context, eventKey string, teamKeys []string, tbaDeleted bool, realmID *int64) ([]Match, error) {
+	return s.getMatchesForRealm(ctx, s.db, eventKey, teamKeys, tbaDeleted, realmID)
+}
+
+// GetMatchesForRealmTx is GetMatchesForRealm using the given transaction.
+func (s *Service) GetMatchesForRealmTx(ctx context.Context, tx *sqlx.Tx, eventKey string, teamKeys []string, tbaDeleted bool, realmID *int64) ([]Match, error) {
+	return s.getMatchesForRealm(ctx, tx, eventKey, teamKeys, tbaDeleted, realmID)
+}
+
+func (s *Service) getMatchesForRealm(ctx context.Context, q sqlx.QueryerContext, eventKey string, teamKeys []string, tbaDeleted bool, realmID *int64) ([]Match, error) {
 	if teamKeys == nil {
 		teamKeys = []string{}
 	}
@@ -112,7 +122,7 @@ func (s *Service) GetMatchesForRealm(ctx context.Context, eventKey string, teamK
 	}
 
 	matches := make([]Match, 0)
-	err := s.db.SelectContext(ctx, &matches, query, realmID, eventKey, pq.Array(teamKeys))
+	err := sqlx.SelectContext(ctx, q, &matches, query, realmID, eventKey, pq.Array(teamKeys))
 	if err != nil {
 		return nil, err
 	}
@@ -207,9 +217,132 @@ func (s *Service) UpsertMatchTx(ctx context.Context, tx *sqlx.Tx, match Match) e
 		return fmt.Errorf("unable to upsert event team keys: %w", err)
 	}
 
+	return s.recordMatchHistoryTx(ctx, tx, match, MatchSourceManual)
+}
+
+// GetMatchForRealmTx is GetMatchForRealm using the given transaction.
+func (s *Service) GetMatchForRealmTx(ctx context.Context, tx *sqlx.Tx, eventKey, matchKey string, realmID *int64) (Match, error) {
+	var m Match
+	err := tx.GetContext(ctx, &m, matchesQuery+" AND matches.event_key = $2 AND matches.key = $3", realmID, eventKey, matchKey)
+	if err == sql.ErrNoRows {
+		return m, ErrNoResults{fmt.Errorf("match %s at event %s does not exist: %w", matchKey, eventKey, err)}
+	} else if err != nil {
+		return m, fmt.Errorf("unable to get match: %w", err)
+	}
+
+	return m, nil
+}
+
+// HasQualificationMatchesTx returns whether an event has any qualification
+// matches, using the given transaction.
+func (s *Service) HasQualificationMatchesTx(ctx context.Context, tx *sqlx.Tx, eventKey string) (bool, error) {
+	var exists bool
+	err := tx.GetContext(ctx, &exists, "SELECT EXISTS(SELECT FROM matches WHERE event_key = $1 AND key LIKE 'qm%')", eventKey)
+	if err != nil {
+		return false, fmt.Errorf("unable to check for qualification matches: %w", err)
+	}
+
+	return exists, nil
+}
+
+// ReplaceScheduleTx replaces the qualification schedule of one of a realm's
+// custom events in the given transaction. Matches are created, or have their
+// scheduled time and alliances updated while keeping any scores already
+// entered, and their teams are added to the event. Qualification matches left
+// out of the schedule are deleted, unless they already have reports, in which
+// case ErrFKeyViolation is returned.
+func (s *Service) ReplaceScheduleTx(ctx context.Context, tx *sqlx.Tx, eventKey string, realmID int64, matches []Match) error {
+	stmt, err := tx.PrepareNamedContext(ctx, `
+		INSERT INTO matches (key, event_key, scheduled_time, red_score_breakdown, blue_score_breakdown)
+		VALUES (:key, :event_key, :scheduled_time, :red_score_breakdown, :blue_score_breakdown)
+		ON CONFLICT (key, event_key)
+		DO
+			UPDATE
+				SET scheduled_time = :scheduled_time
+	`)
+	if err != nil {
+		return fmt.Errorf("unable to prepare schedule upsert statement: %w", err)
+	}
+	defer stmt.Close()
+
+	keys := make([]string, 0, len(matches))
+	for _, match := range matches {
+		match.EventKey = eventKey
+		keys = append(keys, match.Key)
+
+		if _, err := stmt.ExecContext(ctx, match); err != nil {
+			return fmt.Errorf("unable to upsert scheduled match: %w", err)
+		}
+
+		if err := s.AlliancesUpsertTx(ctx, tx, eventKey, match.Key, match.BlueAlliance, match.RedAlliance); err != nil {
+			return fmt.Errorf("unable to upsert alliances: %w", err)
+		}
+
+		if err := s.EventTeamKeysUpsertTx(ctx, tx, eventKey, append(match.BlueAlliance, match.RedAlliance...)); err != nil {
+			return fmt.Errorf("unable to upsert event team keys: %w", err)
+		}
+
+		stored, err := s.GetMatchForRealmTx(ctx, tx, eventKey, match.Key, &realmID)
+		if err != nil {
+			return err
+		}
+
+		if err := s.recordMatchHistoryTx(ctx, tx, stored, MatchSourceManual); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range []string{"alliances", "matches"} {
+		column := "match_key"
+		if table == "matches" {
+			column = "key"
+		}
+
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			DELETE FROM %[1]s
+			WHERE event_key = $1 AND %[2]s LIKE 'qm%%' AND %[2]s != ALL($2)
+		`, table, column), eventKey, pq.Array(keys))
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgFKeyViolation {
+			return ErrFKeyViolation{fmt.Errorf("matches left out of the schedule have reports: %w", err)}
+		} else if err != nil {
+			return fmt.Errorf("unable to delete unscheduled %s: %w", table, err)
+		}
+	}
+
 	return nil
 }
 
+// SetMatchScoreTx sets the scores and score breakdowns of a match in one of a
+// realm's custom events using the given transaction, and marks it as played if
+// it wasn't already. The change is recorded in the match history. If the match
+// doesn't exist ErrNoResults is returned.
+func (s *Service) SetMatchScoreTx(ctx context.Context, tx *sqlx.Tx, realmID int64, score Match) (Match, error) {
+	res, err := tx.NamedExecContext(ctx, `
+		UPDATE matches
+			SET
+				red_score = :red_score,
+				blue_score = :blue_score,
+				red_score_breakdown = :red_score_breakdown,
+				blue_score_breakdown = :blue_score_breakdown,
+				actual_time = COALESCE(actual_time, now())
+			WHERE key = :key AND event_key = :event_key
+	`, score)
+	if err != nil {
+		return score, fmt.Errorf("unable to set match score: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return score, ErrNoResults{fmt.Errorf("match %s at event %s does not exist", score.Key, score.EventKey)}
+	}
+
+	match, err := s.GetMatchForRealmTx(ctx, tx, score.EventKey, score.Key, &realmID)
+	if err != nil {
+		return match, err
+	}
+
+	return match, s.recordMatchHistoryTx(ctx, tx, match, MatchSourceManual)
+}
+
 // MarkMatchesDeleted will set tba_deleted to true on all matches for an event
 // that were *not* included in the passed matches slice.
 func (s *Service) MarkMatchesDeleted(ctx context.Context, eventKey string, matches []Match) error {
@@ -237,6 +370,7 @@ func (s *Service) MarkMatchesDeleted(ctx context.Context, eventKey string, match
 // into the database. New matches are added, existing matches will be updated,
 // and matches deleted from TBA will be deleted from the database. User-created
 // matches will be unaffected. It will set tba_deleted to false for all updated matches.
+// Any changes are recorded in the match history.
 func (s *Service) UpdateTBAMatches(ctx context.Context, matches []Match) error {
 	return s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
 		upsert, err := tx.PrepareNamedContext(ctx, `
@@ -269,6 +403,10 @@ func (s *Service) UpdateTBAMatches(ctx context.Context, matches []Match) error {
 			if err = s.AlliancesUpsertTx(ctx, tx, match.EventKey, match.Key, match.BlueAlliance, match.RedAlliance); err != nil {
 				return fmt.Errorf("unable to upsert alliances: %w", err)
 			}
+
+			if err = s.recordMatchHistoryTx(ctx, tx, match, MatchSourceTBA); err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -298,6 +436,7 @@ INNER JOIN
 		matches.event_key = events.key
 WHERE
 	(events.realm_id = $1 OR events.realm_id IS NULL) AND
+	events.deleted_at IS NULL AND
 	matches.event_key = $2`
 
 // GetEventAnalysisInfoForRealm returns match information that's pertinent to doing analysis by getting
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CreatePasswordReset stores the hash of a single-use token that can be used
+// to reset a user's password until it expires. createdBy is the admin who
+// created it for the user, if any.
+func (s *Service) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, createdBy *int64, expiresAt time.Time) error {
+	return s.createPasswordReset(ctx, s.db, userID, tokenHash, createdBy, expiresAt)
+}
+
+// CreatePasswordResetTx is CreatePasswordReset using the given transaction.
+func (s *Service) CreatePasswordResetTx(ctx context.Context, tx *sqlx.Tx, userID int64, tokenHash string, createdBy *int64, expiresAt time.Time) error {
+	return s.createPasswordReset(ctx, tx, userID, tokenHash, createdBy, expiresAt)
+}
+
+func (s *Service) createPasswordReset(ctx context.Context, e sqlx.ExecerContext, userID int64, tokenHash string, createdBy *int64, expiresAt time.Time) error {
+	_, err := e.ExecContext(ctx, `
+		INSERT INTO password_resets (user_id, token_hash, created_by, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, tokenHash, createdBy, expiresAt)
+	if err != nil {
+		return fmt.Errorf("unable to create password reset: %w", err)
+	}
+
+	return nil
+}
+
+// LockPasswordResetTx locks a password reset token that can still be used
+// until the transaction ends, and returns the ID of its user. If the token
+// doesn't exist, has expired, or was already used ErrNoResults is returned.
+func (s *Service) LockPasswordResetTx(ctx context.Context, tx *sqlx.Tx, tokenHash string) (int64, error) {
+	var userID int64
+
+	err := tx.GetContext(ctx, &userID, `
+		SELECT user_id
+			FROM password_resets
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+			FOR UPDATE
+	`, tokenHash)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoResults{fmt.Errorf("no usable password reset token: %w", err)}
+	} else if err != nil {
+		return 0, fmt.Errorf("unable to lock password reset token: %w", err)
+	}
+
+	return userID, nil
+}
+
+// ResetPasswordTx changes the password of a user whose reset token was locked
+// with LockPasswordResetTx using the given transaction. Changing the password
+// invalidates the user's refresh tokens, and uses up every reset token they
+// have. If the user doesn't exist or was deleted ErrNoResults is returned.
+func (s *Service) ResetPasswordTx(ctx context.Context, tx *sqlx.Tx, userID int64, hashedPassword string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users
+			SET
+				hashed_password = $2,
+				password_changed = now()
+			WHERE id = $1 AND deleted_at IS NULL
+	`, userID, hashedPassword)
+	if err != nil {
+		return fmt.Errorf("unable to update password: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("unable to update password: %w", err)
+	} else if n == 0 {
+		return ErrNoResults{fmt.Errorf("user %d does not exist", userID)}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE password_resets
+			SET used_at = now()
+			WHERE user_id = $1 AND used_at IS NULL
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("unable to use password reset tokens: %w", err)
+	}
+
+	return nil
+}
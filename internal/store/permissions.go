@@ -0,0 +1,72 @@
+package store
+
+// Permissions that can be granted to users through roles. Permissions only
+// apply within a user's own realm.
+const (
+	// PermReportsWrite allows creating reports, and editing and deleting your own reports.
+	PermReportsWrite = "reports:write"
+	// PermReportsEditAny allows editing anyone's reports.
+	PermReportsEditAny = "reports:edit:any"
+	// PermReportsDeleteAny allows deleting anyone's reports.
+	PermReportsDeleteAny = "reports:delete:any"
+	// PermSchemasCreate allows creating schemas.
+	PermSchemasCreate = "schemas:create"
+	// PermEventsEdit allows creating and editing events and their matches.
+	PermEventsEdit = "events:edit"
+	// PermUsersManage allows verifying, editing, and deleting users, and
+	// managing their sessions and roles.
+	PermUsersManage = "users:manage"
+	// PermRealmManage allows editing the realm, its roles, and its API keys.
+	PermRealmManage = "realm:manage"
+)
+
+// AllPermissions are all permissions that can be granted.
+var AllPermissions = []string{
+	PermReportsWrite,
+	PermReportsEditAny,
+	PermReportsDeleteAny,
+	PermSchemasCreate,
+	PermEventsEdit,
+	PermUsersManage,
+	PermRealmManage,
+}
+
+// PermissionSet is a set of permissions.
+type PermissionSet map[string]bool
+
+// NewPermissionSet returns the permissions granted by a user's built-in roles
+// along with the permissions of their realm role. Verified users can write
+// reports, and admins and super-admins have every permission.
+func NewPermissionSet(roles Roles, rolePermissions []string) PermissionSet {
+	ps := make(PermissionSet)
+
+	if roles.IsAdmin || roles.IsSuperAdmin {
+		for _, p := range AllPermissions {
+			ps[p] = true
+		}
+	} else if roles.IsVerified {
+		ps[PermReportsWrite] = true
+	}
+
+	for _, p := range rolePermissions {
+		ps[p] = true
+	}
+
+	return ps
+}
+
+// Has returns whether the set contains the given permission.
+func (ps PermissionSet) Has(permission string) bool {
+	return ps[permission]
+}
+
+// Contains returns whether every permission in other is also in the set.
+func (ps PermissionSet) Contains(other PermissionSet) bool {
+	for p := range other {
+		if !ps[p] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// PurgeCounts is how many deleted rows of each kind PurgeDeleted removed.
+type PurgeCounts struct {
+	Reports int64
+	Events  int64
+	Users   int64
+}
+
+// PurgeDeleted permanently removes the reports, custom events, and users that
+// were deleted before the given time, so they can no longer be restored.
+// Everything stored for a purged event, like its matches and the reports for
+// them, is removed along with it.
+func (s *Service) PurgeDeleted(ctx context.Context, before time.Time) (PurgeCounts, error) {
+	var counts PurgeCounts
+
+	err := s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM reports WHERE deleted_at < $1", before)
+		if err != nil {
+			return fmt.Errorf("unable to purge reports: %w", err)
+		}
+		counts.Reports, _ = res.RowsAffected()
+
+		const purgedEvents = "(SELECT key FROM events WHERE deleted_at < $1)"
+		for _, table := range []string{"reports", "alliances", "matches", "teams", "stars"} {
+			_, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE event_key IN "+purgedEvents, before)
+			if err != nil {
+				return fmt.Errorf("unable to purge %s of deleted events: %w", table, err)
+			}
+		}
+
+		res, err = tx.ExecContext(ctx, "DELETE FROM events WHERE deleted_at < $1", before)
+		if err != nil {
+			return fmt.Errorf("unable to purge events: %w", err)
+		}
+		counts.Events, _ = res.RowsAffected()
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM stars WHERE user_id IN (SELECT id FROM users WHERE deleted_at < $1)", before)
+		if err != nil {
+			return fmt.Errorf("unable to purge stars of deleted users: %w", err)
+		}
+
+		res, err = tx.ExecContext(ctx, "DELETE FROM users WHERE deleted_at < $1", before)
+		if err != nil {
+			return fmt.Errorf("unable to purge users: %w", err)
+		}
+		counts.Users, _ = res.RowsAffected()
+
+		return nil
+	})
+
+	return counts, err
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RealmInvite is an invitation code that lets people sign up as verified
+// members of a realm without waiting to be approved. Codes expire and can only
+// be used a limited number of times.
+type RealmInvite struct {
+	ID        int64      `json:"id" db:"id"`
+	RealmID   int64      `json:"realmId" db:"realm_id"`
+	Code      string     `json:"code" db:"code"`
+	CreatedBy *int64     `json:"createdBy" db:"created_by"`
+	MaxUses   int        `json:"maxUses" db:"max_uses" validate:"required,min=1,max=1000"`
+	Uses      int        `json:"uses" db:"uses"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+	ExpiresAt time.Time  `json:"expiresAt" db:"expires_at"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" db:"revoked_at"`
+}
+
+// CreateRealmInviteTx stores a new invitation code and returns it. If the code
+// is already in use ErrExists is returned.
+func (s *Service) CreateRealmInviteTx(ctx context.Context, tx *sqlx.Tx, invite RealmInvite) (RealmInvite, error) {
+	var created RealmInvite
+	err := tx.GetContext(ctx, &created, `
+		INSERT INTO realm_invites (realm_id, code, created_by, max_uses, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`, invite.RealmID, invite.Code, invite.CreatedBy, invite.MaxUses, invite.ExpiresAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgExists {
+		return created, ErrExists{fmt.Errorf("invite code already exists: %w", err)}
+	} else if ok && pqErr.Code == pgFKeyViolation {
+		return created, ErrFKeyViolation{fmt.Errorf("invite fk violation on realm ID %d: %w", invite.RealmID, err)}
+	} else if err != nil {
+		return created, fmt.Errorf("unable to create realm invite: %w", err)
+	}
+
+	return created, nil
+}
+
+// GetRealmInvites returns a realm's invitation codes that can still be used,
+// newest first.
+func (s *Service) GetRealmInvites(ctx context.Context, realmID int64) ([]RealmInvite, error) {
+	invites := make([]RealmInvite, 0)
+	err := s.db.SelectContext(ctx, &invites, `
+		SELECT *
+		FROM realm_invites
+		WHERE realm_id = $1 AND revoked_at IS NULL AND expires_at > now() AND uses < max_uses
+		ORDER BY created_at DESC
+	`, realmID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get realm invites: %w", err)
+	}
+
+	return invites, nil
+}
+
+// RevokeRealmInviteTx revokes an invitation code belonging to a realm. If the
+// realm has no such invite ErrNoResults is returned.
+func (s *Service) RevokeRealmInviteTx(ctx context.Context, tx *sqlx.Tx, realmID, id int64) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE realm_invites
+			SET revoked_at = now()
+			WHERE id = $1 AND realm_id = $2 AND revoked_at IS NULL
+	`, id, realmID)
+	if err != nil {
+		return fmt.Errorf("unable to revoke realm invite: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no active invite with id %d in realm %d", id, realmID)}
+	}
+
+	return nil
+}
+
+// useRealmInviteTx counts a use of an invitation code and returns the invite.
+// If the code can't be used ErrNoResults is returned.
+func (s *Service) useRealmInviteTx(ctx context.Context, tx *sqlx.Tx, code string) (RealmInvite, error) {
+	var invite RealmInvite
+	err := tx.GetContext(ctx, &invite, `
+		UPDATE realm_invites
+			SET uses = uses + 1
+			WHERE code = $1 AND revoked_at IS NULL AND expires_at > now() AND uses < max_uses
+			RETURNING *
+	`, code)
+	if err == sql.ErrNoRows {
+		return invite, ErrNoResults{fmt.Errorf("no usable invite with code %q", code)}
+	} else if err != nil {
+		return invite, fmt.Errorf("unable to use realm invite: %w", err)
+	}
+
+	return invite, nil
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RealmMembership lets a user belong to a realm other than their home realm
+// (User.RealmID) with separate roles there. Users pick which of their realms
+// is active when they refresh their access token.
+type RealmMembership struct {
+	UserID          int64          `json:"userId" db:"user_id"`
+	RealmID         int64          `json:"realmId" db:"realm_id"`
+	Username        string         `json:"username" db:"username"`
+	Roles           Roles          `json:"roles" db:"roles"`
+	RoleID          *int64         `json:"roleId" db:"role_id"`
+	RolePermissions pq.StringArray `json:"-" db:"role_permissions"`
+	CreatedAt       time.Time      `json:"createdAt" db:"created_at"`
+}
+
+// InRealm returns the user as a member of the membership's realm, with the
+// roles they have there. Super-admins stay super-admins in every realm.
+func (u User) InRealm(m RealmMembership) User {
+	isSuperAdmin := u.Roles.IsSuperAdmin
+
+	u.RealmID = m.RealmID
+	u.Roles = m.Roles
+	u.Roles.IsSuperAdmin = isSuperAdmin
+	u.RoleID = m.RoleID
+	u.RolePermissions = m.RolePermissions
+	u.Pending = false
+
+	return u
+}
+
+const realmMembershipColumns = `
+	realm_memberships.user_id,
+	realm_memberships.realm_id,
+	users.username,
+	realm_memberships.roles,
+	realm_memberships.role_id,
+	COALESCE(realm_roles.permissions, '{}') AS role_permissions,
+	realm_memberships.created_at
+	FROM realm_memberships
+	INNER JOIN users ON users.id = realm_memberships.user_id AND users.deleted_at IS NULL
+	LEFT JOIN realm_roles ON realm_roles.id = realm_memberships.role_id
+`
+
+// JoinRealmWithInviteTx uses an invitation code to make an existing user a
+// verified member of the invite's realm using the given transaction, and
+// returns the new membership. If the code can't be used ErrNoResults is
+// returned, if the user already belongs to the realm ErrExists is returned,
+// and if the realm is full ErrQuotaExceeded is returned.
+func (s *Service) JoinRealmWithInviteTx(ctx context.Context, tx *sqlx.Tx, userID int64, code string) (RealmMembership, error) {
+	var m RealmMembership
+
+	invite, err := s.useRealmInviteTx(ctx, tx, code)
+	if err != nil {
+		return m, err
+	}
+
+	var home bool
+	err = tx.GetContext(ctx, &home, "SELECT EXISTS(SELECT FROM users WHERE id = $1 AND realm_id = $2 AND deleted_at IS NULL)", userID, invite.RealmID)
+	if err != nil {
+		return m, fmt.Errorf("unable to check user's realm: %w", err)
+	}
+
+	if home {
+		return m, ErrExists{fmt.Errorf("user %d already belongs to realm %d", userID, invite.RealmID)}
+	}
+
+	if err := s.checkRealmQuotaTx(ctx, tx, invite.RealmID, QuotaUsers); err != nil {
+		return m, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO realm_memberships (user_id, realm_id, roles)
+		VALUES ($1, $2, $3)
+	`, userID, invite.RealmID, Roles{IsVerified: true})
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgExists {
+		return m, ErrExists{fmt.Errorf("user %d already belongs to realm %d: %w", userID, invite.RealmID, err)}
+	} else if ok && pqErr.Code == pgFKeyViolation {
+		return m, ErrFKeyViolation{fmt.Errorf("realm membership fk violation %s: %w", pqErr.Constraint, err)}
+	} else if err != nil {
+		return m, fmt.Errorf("unable to create realm membership: %w", err)
+	}
+
+	err = tx.GetContext(ctx, &m, `SELECT `+realmMembershipColumns+`
+		WHERE realm_memberships.user_id = $1 AND realm_memberships.realm_id = $2
+	`, userID, invite.RealmID)
+	if err != nil {
+		return m, fmt.Errorf("unable to get realm membership: %w", err)
+	}
+
+	return m, nil
+}
+
+// addRealmAdminTx makes a user a verified admin of a realm other than their
+// home realm, for whoever created it.
+func (s *Service) addRealmAdminTx(ctx context.Context, tx *sqlx.Tx, realmID, userID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO realm_memberships (user_id, realm_id, roles)
+		VALUES ($1, $2, $3)
+	`, userID, realmID, Roles{IsAdmin: true, IsVerified: true})
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgFKeyViolation {
+		return ErrFKeyViolation{fmt.Errorf("realm membership fk violation %s: %w", pqErr.Constraint, err)}
+	} else if err != nil {
+		return fmt.Errorf("unable to add realm admin: %w", err)
+	}
+
+	return nil
+}
+
+// GetRealmMembership retrieves a user's membership in a realm other than their
+// home realm. If they aren't a member ErrNoResults is returned.
+func (s *Service) GetRealmMembership(ctx context.Context, userID, realmID int64) (RealmMembership, error) {
+	return s.getRealmMembership(ctx, s.db, userID, realmID)
+}
+
+// GetRealmMembershipTx is GetRealmMembership using the given transaction.
+func (s *Service) GetRealmMembershipTx(ctx context.Context, tx *sqlx.Tx, userID, realmID int64) (RealmMembership, error) {
+	return s.getRealmMembership(ctx, tx, userID, realmID)
+}
+
+func (s *Service) getRealmMembership(ctx context.Context, q sqlx.QueryerContext, userID, realmID int64) (RealmMembership, error) {
+	var m RealmMembership
+
+	err := sqlx.GetContext(ctx, q, &m, `SELECT `+realmMembershipColumns+`
+		WHERE realm_memberships.user_id = $1 AND realm_memberships.realm_id = $2
+	`, userID, realmID)
+	if err == sql.ErrNoRows {
+		return m, ErrNoResults{fmt.Errorf("user %d is not a member of realm %d", userID, realmID)}
+	} else if err != nil {
+		return m, fmt.Errorf("unable to get realm membership: %w", err)
+	}
+
+	return m, nil
+}
+
+// GetUserRealmMemberships returns the realms a user belongs to besides their
+// home realm.
+func (s *Service) GetUserRealmMemberships(ctx context.Context, userID int64) ([]RealmMembership, error) {
+	memberships := make([]RealmMembership, 0)
+
+	err := s.db.SelectContext(ctx, &memberships, `SELECT `+realmMembershipColumns+`
+		WHERE realm_memberships.user_id = $1
+		ORDER BY realm_memberships.created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get user realm memberships: %w", err)
+	}
+
+	return memberships, nil
+}
+
+// GetRealmMembers returns the users who belong to a realm without it being
+// their home realm.
+func (s *Service) GetRealmMembers(ctx context.Context, realmID int64) ([]RealmMembership, error) {
+	memberships := make([]RealmMembership, 0)
+
+	err := s.db.SelectContext(ctx, &memberships, `SELECT `+realmMembershipColumns+`
+		WHERE realm_memberships.realm_id = $1
+		ORDER BY users.username
+	`, realmID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get realm members: %w", err)
+	}
+
+	return memberships, nil
+}
+
+// UpdateRealmMembershipTx sets a member's roles in a realm. A role ID of 0
+// removes their realm role. If they aren't a member ErrNoResults is returned.
+func (s *Service) UpdateRealmMembershipTx(ctx context.Context, tx *sqlx.Tx, m RealmMembership) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE realm_memberships
+			SET roles = $3, role_id = NULLIF($4, 0)
+			WHERE user_id = $1 AND realm_id = $2
+	`, m.UserID, m.RealmID, m.Roles, m.RoleID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgFKeyViolation {
+		return ErrFKeyViolation{fmt.Errorf("realm membership fk violation %s: %w", pqErr.Constraint, err)}
+	} else if err != nil {
+		return fmt.Errorf("unable to update realm membership: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("user %d is not a member of realm %d", m.UserID, m.RealmID)}
+	}
+
+	return nil
+}
+
+// DeleteRealmMembershipTx removes a user from a realm other than their home
+// realm. If they aren't a member ErrNoResults is returned.
+func (s *Service) DeleteRealmMembershipTx(ctx context.Context, tx *sqlx.Tx, userID, realmID int64) error {
+	res, err := tx.ExecContext(ctx, "DELETE FROM realm_memberships WHERE user_id = $1 AND realm_id = $2", userID, realmID)
+	if err != nil {
+		return fmt.Errorf("unable to delete realm membership: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("user %d is not a member of realm %d", userID, realmID)}
+	}
+
+	return nil
+}
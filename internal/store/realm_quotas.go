@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Names of the quotas realms have.
+const (
+	QuotaUsers         = "users"
+	QuotaCustomEvents  = "customEvents"
+	QuotaReportsPerDay = "reportsPerDay"
+)
+
+// ErrQuotaExceeded is returned when storing something would put a realm over
+// one of its quotas.
+type ErrQuotaExceeded struct {
+	RealmID int64
+	Quota   string
+	Limit   int
+}
+
+// Is returns whether the target is an ErrQuotaExceeded.
+func (err ErrQuotaExceeded) Is(target error) bool {
+	_, ok := target.(ErrQuotaExceeded)
+	return ok
+}
+
+func (err ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("realm %d is at its %s quota of %d", err.RealmID, err.Quota, err.Limit)
+}
+
+// RealmQuotas limits how much a realm can store. Reports per day counts
+// reports created in the last 24 hours. Nil quotas are unlimited, or for a
+// realm's own quotas, fall back to Service.DefaultQuotas.
+type RealmQuotas struct {
+	Users         *int `json:"users" db:"users" validate:"omitempty,gte=0"`
+	CustomEvents  *int `json:"customEvents" db:"custom_events" validate:"omitempty,gte=0"`
+	ReportsPerDay *int `json:"reportsPerDay" db:"reports_per_day" validate:"omitempty,gte=0"`
+}
+
+// Or returns the quotas, with unset quotas taken from defaults.
+func (q RealmQuotas) Or(defaults RealmQuotas) RealmQuotas {
+	if q.Users == nil {
+		q.Users = defaults.Users
+	}
+	if q.CustomEvents == nil {
+		q.CustomEvents = defaults.CustomEvents
+	}
+	if q.ReportsPerDay == nil {
+		q.ReportsPerDay = defaults.ReportsPerDay
+	}
+
+	return q
+}
+
+// RealmUsage is how much a realm is storing compared to its quotas.
+type RealmUsage struct {
+	RealmID       int64       `json:"realmId"`
+	Name          string      `json:"name"`
+	Users         int         `json:"users"`
+	CustomEvents  int         `json:"customEvents"`
+	ReportsPerDay int         `json:"reportsPerDay"`
+	Quotas        RealmQuotas `json:"quotas"`
+}
+
+const realmUsageQuery = `
+SELECT
+	realms.id AS realm_id,
+	realms.name,
+	(SELECT COUNT(*) FROM users WHERE users.realm_id = realms.id AND users.deleted_at IS NULL) +
+		(SELECT COUNT(*) FROM realm_memberships INNER JOIN users ON users.id = realm_memberships.user_id
+			WHERE realm_memberships.realm_id = realms.id AND users.deleted_at IS NULL) AS users,
+	(SELECT COUNT(*) FROM events WHERE events.realm_id = realms.id AND events.deleted_at IS NULL) AS custom_events,
+	(SELECT COUNT(*) FROM reports
+		WHERE reports.realm_id = realms.id AND reports.created_at > now() - INTERVAL '1 day') AS reports_per_day,
+	realm_quotas.users AS quota_users,
+	realm_quotas.custom_events AS quota_custom_events,
+	realm_quotas.reports_per_day AS quota_reports_per_day
+FROM realms
+LEFT JOIN realm_quotas ON realm_quotas.realm_id = realms.id
+`
+
+// realmUsageRow is a RealmUsage as it's scanned, before the default quotas are
+// filled in.
+type realmUsageRow struct {
+	RealmID       int64         `db:"realm_id"`
+	Name          string        `db:"name"`
+	Users         int           `db:"users"`
+	CustomEvents  int           `db:"custom_events"`
+	ReportsPerDay int           `db:"reports_per_day"`
+	QuotaUsers    sql.NullInt64 `db:"quota_users"`
+	QuotaEvents   sql.NullInt64 `db:"quota_custom_events"`
+	QuotaReports  sql.NullInt64 `db:"quota_reports_per_day"`
+}
+
+func nullIntPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+
+	i := int(n.Int64)
+	return &i
+}
+
+func (row realmUsageRow) usage(defaults RealmQuotas) RealmUsage {
+	return RealmUsage{
+		RealmID:       row.RealmID,
+		Name:          row.Name,
+		Users:         row.Users,
+		CustomEvents:  row.CustomEvents,
+		ReportsPerDay: row.ReportsPerDay,
+		Quotas: RealmQuotas{
+			Users:         nullIntPtr(row.QuotaUsers),
+			CustomEvents:  nullIntPtr(row.QuotaEvents),
+			ReportsPerDay: nullIntPtr(row.QuotaReports),
+		}.Or(defaults),
+	}
+}
+
+// GetRealmUsage returns how much every realm that hasn't been archived is
+// storing, along with the quotas that apply to it.
+func (s *Service) GetRealmUsage(ctx context.Context) ([]RealmUsage, error) {
+	var rows []realmUsageRow
+
+	err := s.db.SelectContext(ctx, &rows, realmUsageQuery+"WHERE realms.archived_at IS NULL ORDER BY realms.id")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get realm usage: %w", err)
+	}
+
+	usage := make([]RealmUsage, 0, len(rows))
+	for _, row := range rows {
+		usage = append(usage, row.usage(s.DefaultQuotas))
+	}
+
+	return usage, nil
+}
+
+// GetRealmQuotas returns the quotas set for a realm, not including defaults.
+func (s *Service) GetRealmQuotas(ctx context.Context, realmID int64) (RealmQuotas, error) {
+	return s.getRealmQuotas(ctx, s.db, realmID)
+}
+
+// GetRealmQuotasTx is GetRealmQuotas using the given transaction.
+func (s *Service) GetRealmQuotasTx(ctx context.Context, tx *sqlx.Tx, realmID int64) (RealmQuotas, error) {
+	return s.getRealmQuotas(ctx, tx, realmID)
+}
+
+func (s *Service) getRealmQuotas(ctx context.Context, q sqlx.QueryerContext, realmID int64) (RealmQuotas, error) {
+	var quotas RealmQuotas
+
+	err := sqlx.GetContext(ctx, q, &quotas, "SELECT users, custom_events, reports_per_day FROM realm_quotas WHERE realm_id = $1", realmID)
+	if err != nil && err != sql.ErrNoRows {
+		return quotas, fmt.Errorf("unable to get realm quotas: %w", err)
+	}
+
+	return quotas, nil
+}
+
+// SetRealmQuotasTx sets a realm's own quotas. Nil quotas fall back to the
+// defaults. If the realm doesn't exist ErrFKeyViolation is returned.
+func (s *Service) SetRealmQuotasTx(ctx context.Context, tx *sqlx.Tx, realmID int64, q RealmQuotas) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO realm_quotas (realm_id, users, custom_events, reports_per_day)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (realm_id) DO
+			UPDATE SET users = $2, custom_events = $3, reports_per_day = $4
+	`, realmID, q.Users, q.CustomEvents, q.ReportsPerDay)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgFKeyViolation {
+		return ErrFKeyViolation{fmt.Errorf("realm quotas fk violation on realm ID %d: %w", realmID, err)}
+	} else if err != nil {
+		return fmt.Errorf("unable to set realm quotas: %w", err)
+	}
+
+	return nil
+}
+
+// checkRealmQuotaTx returns ErrQuotaExceeded if the realm can't store another
+// of whatever the quota counts. It locks the realm until the transaction ends
+// so concurrent checks can't both take the last spot, and counts after taking
+// the lock so they see what the previous holder stored.
+func (s *Service) checkRealmQuotaTx(ctx context.Context, tx *sqlx.Tx, realmID int64, quota string) error {
+	_, err := tx.ExecContext(ctx, "SELECT FROM realms WHERE id = $1 FOR NO KEY UPDATE", realmID)
+	if err != nil {
+		return fmt.Errorf("unable to lock realm: %w", err)
+	}
+
+	var row realmUsageRow
+	err = tx.GetContext(ctx, &row, realmUsageQuery+"WHERE realms.id = $1", realmID)
+	if err == sql.ErrNoRows {
+		return ErrFKeyViolation{fmt.Errorf("realm %d does not exist", realmID)}
+	} else if err != nil {
+		return fmt.Errorf("unable to check realm quota: %w", err)
+	}
+
+	usage := row.usage(s.DefaultQuotas)
+
+	var used int
+	var limit *int
+	switch quota {
+	case QuotaUsers:
+		used, limit = usage.Users, usage.Quotas.Users
+	case QuotaCustomEvents:
+		used, limit = usage.CustomEvents, usage.Quotas.CustomEvents
+	case QuotaReportsPerDay:
+		used, limit = usage.ReportsPerDay, usage.Quotas.ReportsPerDay
+	default:
+		return fmt.Errorf("unknown realm quota %q", quota)
+	}
+
+	if limit != nil && used >= *limit {
+		return ErrQuotaExceeded{RealmID: realmID, Quota: quota, Limit: *limit}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RealmRole is a named group of permissions defined by a realm, such as a
+// scouting lead who can fix anyone's reports. Each user can be given one role
+// on top of their built-in roles.
+type RealmRole struct {
+	ID          int64          `json:"id" db:"id"`
+	RealmID     int64          `json:"realmId" db:"realm_id"`
+	Name        string         `json:"name" db:"name" validate:"required,max=64"`
+	Permissions pq.StringArray `json:"permissions" db:"permissions" validate:"dive,oneof=reports:write reports:edit:any reports:delete:any schemas:create events:edit users:manage realm:manage"`
+}
+
+// GetRealmRoles returns all roles defined by a realm.
+func (s *Service) GetRealmRoles(ctx context.Context, realmID int64) ([]RealmRole, error) {
+	roles := make([]RealmRole, 0)
+	err := s.db.SelectContext(ctx, &roles, "SELECT * FROM realm_roles WHERE realm_id = $1 ORDER BY name", realmID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get realm roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// GetRealmRole returns a single role defined by a realm. If the realm has no
+// such role ErrNoResults is returned.
+func (s *Service) GetRealmRole(ctx context.Context, realmID, id int64) (RealmRole, error) {
+	return s.getRealmRole(ctx, s.db, realmID, id)
+}
+
+// GetRealmRoleTx is GetRealmRole using the given transaction.
+func (s *Service) GetRealmRoleTx(ctx context.Context, tx *sqlx.Tx, realmID, id int64) (RealmRole, error) {
+	return s.getRealmRole(ctx, tx, realmID, id)
+}
+
+func (s *Service) getRealmRole(ctx context.Context, q sqlx.QueryerContext, realmID, id int64) (RealmRole, error) {
+	var role RealmRole
+	err := sqlx.GetContext(ctx, q, &role, "SELECT * FROM realm_roles WHERE id = $1 AND realm_id = $2", id, realmID)
+	if err == sql.ErrNoRows {
+		return role, ErrNoResults{fmt.Errorf("no role %d in realm %d", id, realmID)}
+	} else if err != nil {
+		return role, fmt.Errorf("unable to get realm role: %w", err)
+	}
+
+	return role, nil
+}
+
+// CreateRealmRoleTx creates a role and returns it. If the realm already has a
+// role with the same name ErrExists is returned.
+func (s *Service) CreateRealmRoleTx(ctx context.Context, tx *sqlx.Tx, role RealmRole) (RealmRole, error) {
+	var created RealmRole
+	err := tx.GetContext(ctx, &created, `
+		INSERT INTO realm_roles (realm_id, name, permissions)
+		VALUES ($1, $2, $3)
+		RETURNING *
+	`, role.RealmID, role.Name, role.Permissions)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgExists {
+		return created, ErrExists{fmt.Errorf("role %q already exists: %w", role.Name, err)}
+	} else if ok && pqErr.Code == pgFKeyViolation {
+		return created, ErrFKeyViolation{fmt.Errorf("role fk violation on realm ID %d: %w", role.RealmID, err)}
+	} else if err != nil {
+		return created, fmt.Errorf("unable to create realm role: %w", err)
+	}
+
+	return created, nil
+}
+
+// UpdateRealmRoleTx updates the name and permissions of a role. If the realm has
+// no such role ErrNoResults is returned, and if another role already has the
+// name ErrExists is returned.
+func (s *Service) UpdateRealmRoleTx(ctx context.Context, tx *sqlx.Tx, role RealmRole) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE realm_roles
+			SET
+				name = $3,
+				permissions = $4
+			WHERE id = $1 AND realm_id = $2
+	`, role.ID, role.RealmID, role.Name, role.Permissions)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgExists {
+		return ErrExists{fmt.Errorf("role %q already exists: %w", role.Name, err)}
+	} else if err != nil {
+		return fmt.Errorf("unable to update realm role: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no role %d in realm %d", role.ID, role.RealmID)}
+	}
+
+	return nil
+}
+
+// DeleteRealmRoleTx deletes a role. Users with the role are left with just their
+// built-in roles. If the realm has no such role ErrNoResults is returned.
+func (s *Service) DeleteRealmRoleTx(ctx context.Context, tx *sqlx.Tx, realmID, id int64) error {
+	res, err := tx.ExecContext(ctx, "DELETE FROM realm_roles WHERE id = $1 AND realm_id = $2", id, realmID)
+	if err != nil {
+		return fmt.Errorf("unable to delete realm role: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no role %d in realm %d", id, realmID)}
+	}
+
+	return nil
+}
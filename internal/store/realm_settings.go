@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Who can see a realm's leaderboard.
+const (
+	// LeaderboardMembers shows the leaderboard to everyone in the realm.
+	LeaderboardMembers = "members"
+	// LeaderboardManagers only shows the leaderboard to users who can manage
+	// users in the realm.
+	LeaderboardManagers = "managers"
+	// LeaderboardHidden hides the leaderboard from everyone but super-admins.
+	LeaderboardHidden = "hidden"
+)
+
+// Announcement is a message shown to everyone in a realm until it expires.
+type Announcement struct {
+	Message   string     `json:"message" validate:"required,lte=500"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Expired returns whether the announcement should no longer be shown.
+func (a Announcement) Expired(now time.Time) bool {
+	return a.ExpiresAt != nil && !now.Before(*a.ExpiresAt)
+}
+
+// RealmSettings holds a realm's preferences. The zero value is the default for
+// every setting.
+type RealmSettings struct {
+	// TeamNumber is the FRC team the realm scouts for, if any.
+	TeamNumber *int `json:"teamNumber,omitempty" validate:"omitempty,gte=1,lte=99999"`
+	// DefaultSchemas maps years to the schema the realm uses for events in
+	// that year which don't have a schema of their own.
+	DefaultSchemas map[int]int64 `json:"defaultSchemas,omitempty" validate:"lte=50,dive,keys,gte=1992,lte=9999,endkeys,gte=1"`
+	// DefaultEvents are the keys of the events the realm is scouting.
+	DefaultEvents []string `json:"defaultEvents,omitempty" validate:"lte=100,dive,required,lte=16"`
+	// Timezone is an IANA time zone name like America/Los_Angeles.
+	Timezone string `json:"timezone,omitempty" validate:"lte=64"`
+	// Announcements are shown to everyone in the realm.
+	Announcements []Announcement `json:"announcements,omitempty" validate:"lte=20,dive"`
+	// RequiredReportFields are the stats every report in the realm must have.
+	RequiredReportFields []string `json:"requiredReportFields,omitempty" validate:"lte=100,dive,required,lte=64"`
+	// LeaderboardVisibility is who can see the realm's leaderboard, and
+	// defaults to LeaderboardMembers.
+	LeaderboardVisibility string `json:"leaderboardVisibility,omitempty" validate:"omitempty,oneof=members managers hidden"`
+}
+
+// Value implements driver.Valuer to return JSON for the DB from RealmSettings.
+func (rs RealmSettings) Value() (driver.Value, error) { return json.Marshal(rs) }
+
+// Scan implements sql.Scanner to scan JSON from the DB into RealmSettings.
+func (rs *RealmSettings) Scan(src interface{}) error {
+	j, ok := src.([]byte)
+	if !ok {
+		return errors.New("got invalid type for RealmSettings")
+	}
+
+	return json.Unmarshal(j, rs)
+}
+
+// MissingReportFields returns the realm's required report fields that the
+// report data doesn't include.
+func (rs RealmSettings) MissingReportFields(data ReportData) []string {
+	present := make(map[string]bool, len(data))
+	for _, stat := range data {
+		present[stat.Name] = true
+	}
+
+	var missing []string
+	for _, name := range rs.RequiredReportFields {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
+
+// GetRealmSettings retrieves a realm's settings. If the realm doesn't exist
+// ErrNoResults is returned.
+func (s *Service) GetRealmSettings(ctx context.Context, realmID int64) (RealmSettings, error) {
+	var settings RealmSettings
+
+	err := s.db.GetContext(ctx, &settings, "SELECT settings FROM realms WHERE id = $1", realmID)
+	if err == sql.ErrNoRows {
+		return settings, ErrNoResults{fmt.Errorf("realm with id %d not found: %w", realmID, err)}
+	} else if err != nil {
+		return settings, fmt.Errorf("unable to get realm settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// UpdateRealmSettingsTx locks a realm's settings using the given transaction,
+// passes them to update to be changed, and stores the result, which is
+// returned. If update returns an error nothing is stored. If the realm doesn't
+// exist ErrNoResults is returned.
+func (s *Service) UpdateRealmSettingsTx(ctx context.Context, tx *sqlx.Tx, realmID int64, update func(*RealmSettings) error) (RealmSettings, error) {
+	var settings RealmSettings
+
+	err := tx.GetContext(ctx, &settings, "SELECT settings FROM realms WHERE id = $1 FOR UPDATE", realmID)
+	if err == sql.ErrNoRows {
+		return settings, ErrNoResults{fmt.Errorf("realm with id %d not found: %w", realmID, err)}
+	} else if err != nil {
+		return settings, fmt.Errorf("unable to lock realm settings: %w", err)
+	}
+
+	if err := update(&settings); err != nil {
+		return settings, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE realms SET settings = $2 WHERE id = $1", realmID, settings)
+	if err != nil {
+		return settings, fmt.Errorf("unable to update realm settings: %w", err)
+	}
+
+	return settings, nil
+}
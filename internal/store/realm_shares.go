@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RealmShare is an agreement between two realms to pool their reports, e.g.
+// for alliance partners at an event. One realm requests it and it takes effect
+// once the partner realm accepts. An agreement can be scoped to a single event
+// or year, or neither to cover everything.
+type RealmShare struct {
+	ID             int64      `json:"id" db:"id"`
+	RealmID        int64      `json:"realmId" db:"realm_id"`
+	PartnerRealmID int64      `json:"partnerRealmId" db:"partner_realm_id"`
+	EventKey       *string    `json:"eventKey,omitempty" db:"event_key"`
+	Year           *int       `json:"year,omitempty" db:"year"`
+	CreatedBy      *int64     `json:"createdBy" db:"created_by"`
+	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
+	AcceptedAt     *time.Time `json:"acceptedAt,omitempty" db:"accepted_at"`
+}
+
+// CreateRealmShareTx stores a new sharing request and returns it. If the realms
+// already have an agreement with the same scope ErrExists is returned, and if
+// the partner realm or event doesn't exist ErrFKeyViolation is returned.
+func (s *Service) CreateRealmShareTx(ctx context.Context, tx *sqlx.Tx, share RealmShare) (RealmShare, error) {
+	var created RealmShare
+	err := tx.GetContext(ctx, &created, `
+		INSERT INTO realm_shares (realm_id, partner_realm_id, event_key, year, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`, share.RealmID, share.PartnerRealmID, share.EventKey, share.Year, share.CreatedBy)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgExists {
+		return created, ErrExists{fmt.Errorf("realms %d and %d already have this agreement: %w", share.RealmID, share.PartnerRealmID, err)}
+	} else if ok && pqErr.Code == pgFKeyViolation {
+		return created, ErrFKeyViolation{fmt.Errorf("realm share fk violation %s: %w", pqErr.Constraint, err)}
+	} else if err != nil {
+		return created, fmt.Errorf("unable to create realm share: %w", err)
+	}
+
+	return created, nil
+}
+
+// GetRealmShares returns the agreements a realm has requested or been asked
+// to accept, newest first.
+func (s *Service) GetRealmShares(ctx context.Context, realmID int64) ([]RealmShare, error) {
+	shares := make([]RealmShare, 0)
+	err := s.db.SelectContext(ctx, &shares, `
+		SELECT *
+		FROM realm_shares
+		WHERE realm_id = $1 OR partner_realm_id = $1
+		ORDER BY created_at DESC
+	`, realmID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get realm shares: %w", err)
+	}
+
+	return shares, nil
+}
+
+// AcceptRealmShareTx accepts a pending request made to the partner realm. If
+// the partner realm has no such pending request ErrNoResults is returned.
+func (s *Service) AcceptRealmShareTx(ctx context.Context, tx *sqlx.Tx, partnerRealmID, id int64) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE realm_shares
+			SET accepted_at = now()
+			WHERE id = $1 AND partner_realm_id = $2 AND accepted_at IS NULL
+	`, id, partnerRealmID)
+	if err != nil {
+		return fmt.Errorf("unable to accept realm share: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no pending share with id %d for realm %d", id, partnerRealmID)}
+	}
+
+	return nil
+}
+
+// DeleteRealmShareTx ends an agreement, or withdraws or declines a request.
+// Either realm can delete it. If the realm has no such agreement ErrNoResults
+// is returned.
+func (s *Service) DeleteRealmShareTx(ctx context.Context, tx *sqlx.Tx, realmID, id int64) error {
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM realm_shares
+			WHERE id = $1 AND (realm_id = $2 OR partner_realm_id = $2)
+	`, id, realmID)
+	if err != nil {
+		return fmt.Errorf("unable to delete realm share: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no share with id %d for realm %d", id, realmID)}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RealmToken lets someone create a realm when creating realms is limited to
+// people with a token. Super-admins hand them out, and each can be used once
+// before it expires. Only a hash of the token is stored.
+type RealmToken struct {
+	ID        int64      `json:"id" db:"id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	Note      string     `json:"note" db:"note" validate:"lte=200"`
+	CreatedBy *int64     `json:"createdBy" db:"created_by"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+	ExpiresAt time.Time  `json:"expiresAt" db:"expires_at"`
+	UsedAt    *time.Time `json:"usedAt,omitempty" db:"used_at"`
+	RealmID   *int64     `json:"realmId,omitempty" db:"realm_id"`
+}
+
+// CreateRealmToken stores a new realm token and returns it. If the token hash
+// is already in use ErrExists is returned.
+func (s *Service) CreateRealmToken(ctx context.Context, t RealmToken) (RealmToken, error) {
+	var created RealmToken
+
+	err := s.db.GetContext(ctx, &created, `
+		INSERT INTO realm_tokens (token_hash, note, created_by, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING *
+	`, t.TokenHash, t.Note, t.CreatedBy, t.ExpiresAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgExists {
+		return created, ErrExists{fmt.Errorf("realm token already exists: %w", err)}
+	} else if err != nil {
+		return created, fmt.Errorf("unable to create realm token: %w", err)
+	}
+
+	return created, nil
+}
+
+// GetRealmTokens returns the realm tokens that can still be used, newest
+// first.
+func (s *Service) GetRealmTokens(ctx context.Context) ([]RealmToken, error) {
+	tokens := make([]RealmToken, 0)
+
+	err := s.db.SelectContext(ctx, &tokens, `
+		SELECT *
+		FROM realm_tokens
+		WHERE used_at IS NULL AND expires_at > now()
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get realm tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// DeleteRealmToken revokes a realm token that hasn't been used yet. If there's
+// no such token ErrNoResults is returned.
+func (s *Service) DeleteRealmToken(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM realm_tokens WHERE id = $1 AND used_at IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("unable to delete realm token: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no unused realm token with id %d", id)}
+	}
+
+	return nil
+}
+
+// InsertRealmWithTokenTx uses up a realm token to insert a realm with adminID
+// as its admin using the given transaction, and returns the new realm's ID. If
+// the token doesn't exist, has expired, or was already used ErrNoResults is
+// returned, and if the realm name is taken ErrExists is returned.
+func (s *Service) InsertRealmWithTokenTx(ctx context.Context, tx *sqlx.Tx, realm Realm, tokenHash string, adminID int64) (int64, error) {
+	var tokenID int64
+	err := tx.GetContext(ctx, &tokenID, `
+		UPDATE realm_tokens
+			SET used_at = now()
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+			RETURNING id
+	`, tokenHash)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoResults{fmt.Errorf("no usable realm token: %w", err)}
+	} else if err != nil {
+		return 0, fmt.Errorf("unable to use realm token: %w", err)
+	}
+
+	realmID, err := s.InsertRealmTx(ctx, tx, realm)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE realm_tokens SET realm_id = $2 WHERE id = $1", tokenID, realmID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to record realm token's realm: %w", err)
+	}
+
+	return realmID, s.addRealmAdminTx(ctx, tx, realmID, adminID)
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RealmTransfer is a request to move a user's home realm to another realm. It
+// needs approval from someone who can manage users in each realm, and takes
+// effect as soon as both have approved. The user's reports stay with the realm
+// they were written for.
+type RealmTransfer struct {
+	ID             int64      `json:"id" db:"id"`
+	UserID         int64      `json:"userId" db:"user_id"`
+	FromRealmID    int64      `json:"fromRealmId" db:"from_realm_id"`
+	ToRealmID      int64      `json:"toRealmId" db:"to_realm_id"`
+	RequestedBy    *int64     `json:"requestedBy" db:"requested_by"`
+	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
+	FromApprovedBy *int64     `json:"fromApprovedBy,omitempty" db:"from_approved_by"`
+	FromApprovedAt *time.Time `json:"fromApprovedAt,omitempty" db:"from_approved_at"`
+	ToApprovedBy   *int64     `json:"toApprovedBy,omitempty" db:"to_approved_by"`
+	ToApprovedAt   *time.Time `json:"toApprovedAt,omitempty" db:"to_approved_at"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty" db:"completed_at"`
+}
+
+// CreateRealmTransferTx stores a new transfer request and returns it. Set
+// FromApprovedBy or ToApprovedBy to approve it for either realm right away; if
+// both are set the user is transferred immediately. If the user already has a
+// pending transfer ErrExists is returned, and if the user or target realm
+// doesn't exist, or the target realm is archived, ErrFKeyViolation is
+// returned.
+func (s *Service) CreateRealmTransferTx(ctx context.Context, tx *sqlx.Tx, t RealmTransfer) (RealmTransfer, error) {
+	var created RealmTransfer
+
+	var archived bool
+	err := tx.GetContext(ctx, &archived, "SELECT archived_at IS NOT NULL FROM realms WHERE id = $1", t.ToRealmID)
+	if err == sql.ErrNoRows || archived {
+		return created, ErrFKeyViolation{fmt.Errorf("realm %d does not exist or is archived", t.ToRealmID)}
+	} else if err != nil {
+		return created, fmt.Errorf("unable to check realm: %w", err)
+	}
+
+	err = tx.GetContext(ctx, &created, `
+		INSERT INTO realm_transfers (
+			user_id, from_realm_id, to_realm_id, requested_by,
+			from_approved_by, from_approved_at, to_approved_by, to_approved_at
+		)
+		VALUES (
+			$1, $2, $3, $4,
+			$5, CASE WHEN $5::INTEGER IS NULL THEN NULL ELSE now() END,
+			$6, CASE WHEN $6::INTEGER IS NULL THEN NULL ELSE now() END
+		)
+		RETURNING *
+	`, t.UserID, t.FromRealmID, t.ToRealmID, t.RequestedBy, t.FromApprovedBy, t.ToApprovedBy)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgExists {
+		return created, ErrExists{fmt.Errorf("user %d already has a pending transfer: %w", t.UserID, err)}
+	} else if ok && pqErr.Code == pgFKeyViolation {
+		return created, ErrFKeyViolation{fmt.Errorf("realm transfer fk violation %s: %w", pqErr.Constraint, err)}
+	} else if err != nil {
+		return created, fmt.Errorf("unable to create realm transfer: %w", err)
+	}
+
+	if created.FromApprovedAt != nil && created.ToApprovedAt != nil {
+		return created, s.completeRealmTransferTx(ctx, tx, &created)
+	}
+
+	return created, nil
+}
+
+// GetRealmTransfers returns the pending transfers into and out of a realm,
+// oldest first.
+func (s *Service) GetRealmTransfers(ctx context.Context, realmID int64) ([]RealmTransfer, error) {
+	return s.getRealmTransfers(ctx, s.db, realmID)
+}
+
+// GetRealmTransfersTx is GetRealmTransfers using the given transaction.
+func (s *Service) GetRealmTransfersTx(ctx context.Context, tx *sqlx.Tx, realmID int64) ([]RealmTransfer, error) {
+	return s.getRealmTransfers(ctx, tx, realmID)
+}
+
+func (s *Service) getRealmTransfers(ctx context.Context, q sqlx.QueryerContext, realmID int64) ([]RealmTransfer, error) {
+	transfers := make([]RealmTransfer, 0)
+
+	err := sqlx.SelectContext(ctx, q, &transfers, `
+		SELECT *
+		FROM realm_transfers
+		WHERE (from_realm_id = $1 OR to_realm_id = $1) AND completed_at IS NULL
+		ORDER BY created_at
+	`, realmID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get realm transfers: %w", err)
+	}
+
+	return transfers, nil
+}
+
+// ApproveRealmTransferTx approves a pending transfer for the given realm, and
+// transfers the user if the other realm has already approved. It returns the
+// updated transfer. If the realm has no such pending transfer ErrNoResults is
+// returned.
+func (s *Service) ApproveRealmTransferTx(ctx context.Context, tx *sqlx.Tx, realmID, id, approverID int64) (RealmTransfer, error) {
+	var t RealmTransfer
+
+	err := tx.GetContext(ctx, &t, `
+		UPDATE realm_transfers
+			SET
+				from_approved_by = CASE WHEN from_realm_id = $2 AND from_approved_at IS NULL THEN $3 ELSE from_approved_by END,
+				from_approved_at = CASE WHEN from_realm_id = $2 THEN COALESCE(from_approved_at, now()) ELSE from_approved_at END,
+				to_approved_by = CASE WHEN to_realm_id = $2 AND to_approved_at IS NULL THEN $3 ELSE to_approved_by END,
+				to_approved_at = CASE WHEN to_realm_id = $2 THEN COALESCE(to_approved_at, now()) ELSE to_approved_at END
+			WHERE id = $1 AND (from_realm_id = $2 OR to_realm_id = $2) AND completed_at IS NULL
+			RETURNING *
+	`, id, realmID, approverID)
+	if err == sql.ErrNoRows {
+		return t, ErrNoResults{fmt.Errorf("no pending transfer with id %d for realm %d", id, realmID)}
+	} else if err != nil {
+		return t, fmt.Errorf("unable to approve realm transfer: %w", err)
+	}
+
+	if t.FromApprovedAt != nil && t.ToApprovedAt != nil {
+		return t, s.completeRealmTransferTx(ctx, tx, &t)
+	}
+
+	return t, nil
+}
+
+// DeleteRealmTransferTx cancels or rejects a pending transfer into or out of a
+// realm. If the realm has no such pending transfer ErrNoResults is returned.
+func (s *Service) DeleteRealmTransferTx(ctx context.Context, tx *sqlx.Tx, realmID, id int64) error {
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM realm_transfers
+			WHERE id = $1 AND (from_realm_id = $2 OR to_realm_id = $2) AND completed_at IS NULL
+	`, id, realmID)
+	if err != nil {
+		return fmt.Errorf("unable to delete realm transfer: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no pending transfer with id %d for realm %d", id, realmID)}
+	}
+
+	return nil
+}
+
+// completeRealmTransferTx moves the user to the transfer's target realm and
+// marks it completed. If the user was already a member of the target realm
+// they keep the roles they had there, otherwise they're verified, as long as
+// the realm isn't full (ErrQuotaExceeded). If the user no longer belongs to
+// the realm they were transferred from ErrNoResults is returned.
+func (s *Service) completeRealmTransferTx(ctx context.Context, tx *sqlx.Tx, t *RealmTransfer) error {
+	user, err := s.LockUser(ctx, tx, t.UserID)
+	if err != nil {
+		return err
+	}
+
+	if user.RealmID != t.FromRealmID {
+		return ErrNoResults{fmt.Errorf("user %d no longer belongs to realm %d", t.UserID, t.FromRealmID)}
+	}
+
+	roles := Roles{IsVerified: true}
+	var roleID *int64
+
+	var m RealmMembership
+	err = tx.GetContext(ctx, &m, `
+		DELETE FROM realm_memberships
+			WHERE user_id = $1 AND realm_id = $2
+			RETURNING roles, role_id
+	`, t.UserID, t.ToRealmID)
+	if err == nil {
+		roles, roleID = m.Roles, m.RoleID
+	} else if err == sql.ErrNoRows {
+		if err := s.checkRealmQuotaTx(ctx, tx, t.ToRealmID, QuotaUsers); err != nil {
+			return err
+		}
+	} else {
+		return fmt.Errorf("unable to remove realm membership: %w", err)
+	}
+
+	roles.IsSuperAdmin = user.Roles.IsSuperAdmin
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+			SET realm_id = $2, roles = $3, role_id = $4, pending = false
+			WHERE id = $1
+	`, t.UserID, t.ToRealmID, roles, roleID)
+	if err != nil {
+		return fmt.Errorf("unable to transfer user: %w", err)
+	}
+
+	err = tx.GetContext(ctx, &t.CompletedAt, `
+		UPDATE realm_transfers
+			SET completed_at = now()
+			WHERE id = $1
+			RETURNING completed_at
+	`, t.ID)
+	if err != nil {
+		return fmt.Errorf("unable to complete realm transfer: %w", err)
+	}
+
+	return nil
+}
@@ -3,28 +3,56 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
 // Realm holds the name of a realm, and whether to share the realms reports.
+// Archived realms were deleted but kept around for the data they own. Settings
+// are only exposed through their own endpoints.
 type Realm struct {
-	ID           int64  `json:"id" db:"id"`
-	Name         string `json:"name" db:"name" validate:"omitempty,gte=1,lte=32"`
-	ShareReports bool   `json:"shareReports" db:"share_reports"`
+	ID           int64         `json:"id" db:"id"`
+	Name         string        `json:"name" db:"name" validate:"omitempty,gte=1,lte=32"`
+	ShareReports bool          `json:"shareReports" db:"share_reports"`
+	ArchivedAt   *time.Time    `json:"archivedAt,omitempty" db:"archived_at"`
+	Settings     RealmSettings `json:"-" db:"settings"`
 }
 
-// GetRealms returns all realms in the database.
+// What happens to a realm's reports when it's deleted.
+const (
+	// RealmReportsArchive keeps the realm's reports, custom events and schemas
+	// hidden in the archived realm.
+	RealmReportsArchive = "archive"
+	// RealmReportsReassign moves the realm's reports, custom events and
+	// schemas to another realm.
+	RealmReportsReassign = "reassign"
+	// RealmReportsAnonymize strips the reporter and comment from the realm's
+	// reports, and keeps them hidden in the archived realm like archiving.
+	RealmReportsAnonymize = "anonymize"
+)
+
+// GetRealms returns all realms in the database that haven't been archived.
 func (s *Service) GetRealms(ctx context.Context) (realms []Realm, err error) {
 	realms = make([]Realm, 0)
-	return realms, s.db.SelectContext(ctx, &realms, "SELECT * FROM realms")
+	return realms, s.db.SelectContext(ctx, &realms, "SELECT * FROM realms WHERE archived_at IS NULL")
 }
 
 // GetRealm retrieves a specific realm.
 func (s *Service) GetRealm(ctx context.Context, id int64) (realm Realm, err error) {
-	err = s.db.GetContext(ctx, &realm, "SELECT * FROM realms WHERE id = $1", id)
+	return s.getRealm(ctx, s.db, id)
+}
+
+// GetRealmTx is GetRealm using the given transaction.
+func (s *Service) GetRealmTx(ctx context.Context, tx *sqlx.Tx, id int64) (realm Realm, err error) {
+	return s.getRealm(ctx, tx, id)
+}
+
+func (s *Service) getRealm(ctx context.Context, q sqlx.QueryerContext, id int64) (realm Realm, err error) {
+	err = sqlx.GetContext(ctx, q, &realm, "SELECT * FROM realms WHERE id = $1", id)
 	if err == sql.ErrNoRows {
 		return realm, ErrNoResults{fmt.Errorf("realm with id %d not found: %w", id, err)}
 	} else if err != nil {
@@ -54,11 +82,12 @@ func (s *Service) ExclusiveLockRealmsTx(ctx context.Context, tx *sqlx.Tx) error
 	return nil
 }
 
-// InsertRealm inserts a realm into the database.
-func (s *Service) InsertRealm(ctx context.Context, realm Realm) (int64, error) {
+// InsertRealmTx inserts a realm using the given transaction. If the realm
+// name is taken ErrExists is returned.
+func (s *Service) InsertRealmTx(ctx context.Context, tx *sqlx.Tx, realm Realm) (int64, error) {
 	var realmID int64
 
-	err := s.db.GetContext(ctx, &realmID, `
+	err := tx.GetContext(ctx, &realmID, `
 	    INSERT INTO realms (name, share_reports)
 		    VALUES ($1, $2)
 	        RETURNING id
@@ -72,14 +101,140 @@ func (s *Service) InsertRealm(ctx context.Context, realm Realm) (int64, error) {
 	return realmID, nil
 }
 
-// DeleteRealmTx deletes a realm from the database using the given transaction.
-func (s *Service) DeleteRealmTx(ctx context.Context, tx *sqlx.Tx, id int64) error {
-	_, err := tx.ExecContext(ctx, "DELETE FROM realms WHERE id = $1", id)
+// InsertRealmWithAdminTx inserts a realm with adminID as its admin using the
+// given transaction, and returns the new realm's ID.
+func (s *Service) InsertRealmWithAdminTx(ctx context.Context, tx *sqlx.Tx, realm Realm, adminID int64) (int64, error) {
+	realmID, err := s.InsertRealmTx(ctx, tx, realm)
 	if err != nil {
-		return fmt.Errorf("unable to delete realm: %w", err)
+		return 0, err
 	}
 
-	return nil
+	return realmID, s.addRealmAdminTx(ctx, tx, realmID, adminID)
+}
+
+// DeleteRealmTx deletes a realm using the given transaction. Users whose home
+// realm it is move to the oldest other realm they're a member of, or are
+// marked as deleted by deletedBy if they don't belong to any, so they can
+// still be restored until they're purged. The reports policy (one of the
+// RealmReports constants) decides what happens to the realm's reports, and
+// reassignTo is the realm they move to when reassigning. If the realm still
+// owns users, reports, custom events or schemas afterwards it's archived
+// instead of deleted, and true is returned. If reassignTo doesn't exist
+// ErrFKeyViolation is returned.
+func (s *Service) DeleteRealmTx(ctx context.Context, tx *sqlx.Tx, id int64, reports string, reassignTo int64, deletedBy *int64) (archived bool, err error) {
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+			SET
+				realm_id = m.realm_id,
+				roles = m.roles || jsonb_build_object('isSuperAdmin', COALESCE((users.roles->>'isSuperAdmin')::boolean, false)),
+				role_id = m.role_id,
+				pending = false
+			FROM (
+				SELECT DISTINCT ON (user_id) *
+				FROM realm_memberships
+				WHERE realm_id <> $1
+				ORDER BY user_id, created_at
+			) m
+			WHERE users.realm_id = $1 AND m.user_id = users.id AND users.deleted_at IS NULL
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("unable to move realm users to their other realms: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM realm_memberships
+			USING users
+			WHERE realm_memberships.user_id = users.id AND realm_memberships.realm_id = users.realm_id
+	`)
+	if err != nil {
+		return false, fmt.Errorf("unable to remove memberships of moved users: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE sessions
+			SET
+				revoked_at = now(),
+				revoked_reason = $2
+			WHERE
+				revoked_at IS NULL AND
+				user_id IN (SELECT id FROM users WHERE realm_id = $1 AND deleted_at IS NULL)
+	`, id, RevokedAdmin)
+	if err != nil {
+		return false, fmt.Errorf("unable to revoke realm users' sessions: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+			SET deleted_at = now(), deleted_by = $2
+			WHERE realm_id = $1 AND deleted_at IS NULL
+	`, id, deletedBy)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete realm users: %w", err)
+	}
+
+	switch reports {
+	case RealmReportsArchive:
+	case RealmReportsReassign:
+		for _, table := range []string{"reports", "events", "schemas"} {
+			_, err = tx.ExecContext(ctx, "UPDATE "+table+" SET realm_id = $2 WHERE realm_id = $1", id, reassignTo)
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgFKeyViolation {
+				return false, ErrFKeyViolation{fmt.Errorf("realm %d does not exist: %w", reassignTo, err)}
+			} else if err != nil {
+				return false, fmt.Errorf("unable to reassign realm %s: %w", table, err)
+			}
+		}
+	case RealmReportsAnonymize:
+		_, err = tx.ExecContext(ctx, "UPDATE reports SET reporter_id = NULL, comment = '' WHERE realm_id = $1", id)
+		if err != nil {
+			return false, fmt.Errorf("unable to anonymize realm reports: %w", err)
+		}
+	default:
+		return false, fmt.Errorf("unknown realm reports policy %q", reports)
+	}
+
+	err = tx.GetContext(ctx, &archived, `
+		SELECT
+			EXISTS(SELECT FROM users WHERE realm_id = $1) OR
+			EXISTS(SELECT FROM reports WHERE realm_id = $1) OR
+			EXISTS(SELECT FROM events WHERE realm_id = $1) OR
+			EXISTS(SELECT FROM schemas WHERE realm_id = $1)
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("unable to check for realm data: %w", err)
+	}
+
+	if !archived {
+		_, err = tx.ExecContext(ctx, "DELETE FROM realms WHERE id = $1", id)
+		if err != nil {
+			return false, fmt.Errorf("unable to delete realm: %w", err)
+		}
+
+		return false, nil
+	}
+
+	for _, query := range []string{
+		"DELETE FROM realm_invites WHERE realm_id = $1",
+		"DELETE FROM api_keys WHERE realm_id = $1",
+		"DELETE FROM realm_roles WHERE realm_id = $1",
+		"DELETE FROM realm_shares WHERE realm_id = $1 OR partner_realm_id = $1",
+		"DELETE FROM realm_transfers WHERE from_realm_id = $1 OR to_realm_id = $1",
+		"DELETE FROM realm_memberships WHERE realm_id = $1",
+	} {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return false, fmt.Errorf("unable to clean up archived realm: %w", err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE realms SET archived_at = now(), share_reports = false WHERE id = $1", id)
+	if err != nil {
+		return false, fmt.Errorf("unable to archive realm: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return false, ErrNoResults{errors.New("got 0 affected rows")}
+	}
+
+	return true, nil
 }
 
 // UpdateRealmTx updates a realm using the given transaction.
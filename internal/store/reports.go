@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
@@ -45,6 +46,9 @@ type Report struct {
 	RealmID    *int64     `json:"realmId" db:"realm_id"`
 	Data       ReportData `json:"data" db:"data"`
 	Comment    string     `json:"comment" db:"comment"`
+	CreatedAt  *time.Time `json:"createdAt,omitempty" db:"created_at"`
+	DeletedAt  *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+	DeletedBy  *int64     `json:"deletedBy,omitempty" db:"deleted_by"`
 }
 
 // Leaderboard holds information about how many reports each reporter submitted.
@@ -57,7 +61,7 @@ type Leaderboard []struct {
 func (s *Service) LockReport(ctx context.Context, tx *sqlx.Tx, id int64) (Report, error) {
 	var report Report
 
-	err := tx.GetContext(ctx, &report, "SELECT * FROM reports WHERE id = $1 FOR UPDATE", id)
+	err := tx.GetContext(ctx, &report, "SELECT * FROM reports WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id)
 	if err == sql.ErrNoRows {
 		return report, ErrNoResults{fmt.Errorf("report with ID %d does not exist", report.ID)}
 	} else if err != nil {
@@ -67,19 +71,17 @@ func (s *Service) LockReport(ctx context.Context, tx *sqlx.Tx, id int64) (Report
 	return report, nil
 }
 
-// GetReportForRealm retrieves a report in a specific realm
+// GetReportForRealm retrieves a report if it's visible to the realm
 func (s *Service) GetReportForRealm(ctx context.Context, id int64, realmID *int64) (Report, error) {
 	var report Report
 
 	err := s.db.GetContext(ctx, &report, `
 	SELECT reports.*
 		FROM reports
-	LEFT JOIN realms
-		ON realms.id = reports.realm_id
 	WHERE
 		reports.id = $1 AND
-		(reports.realm_id IS NULL OR realms.share_reports = true OR realms.id = $2)
-	`, id, realmID)
+		reports.deleted_at IS NULL AND
+		`+visibleToRealm("reports.realm_id", "reports.event_key", "$2"), id, realmID)
 	if err == sql.ErrNoRows {
 		return report, ErrNoResults{fmt.Errorf("report with ID %d does not exist", report.ID)}
 	} else if err != nil {
@@ -89,56 +91,62 @@ func (s *Service) GetReportForRealm(ctx context.Context, id int64, realmID *int6
 	return report, nil
 }
 
-// UpsertReport creates a new report in the db, or replaces the existing one if
-// the same reporter already has a report in the db for that team and match. It
-// returns a boolean that is true when the report was created, and false when it
-// was updated.
-func (s *Service) UpsertReport(ctx context.Context, r Report) (created bool, id int64, err error) {
+// UpsertReportTx creates a new report in the db, or replaces the existing one
+// if the same reporter already has a report in the db for that team and match.
+// It returns a boolean that is true when the report was created, and false when
+// it was updated. A deleted report for the same team and match is replaced and
+// restored, which counts as creating it. New reports count towards the realm's
+// reports per day quota, and ErrQuotaExceeded is returned if it has been
+// reached.
+func (s *Service) UpsertReportTx(ctx context.Context, tx *sqlx.Tx, r Report) (created bool, id int64, err error) {
 	var existed bool
 
-	err = s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
-		err = tx.QueryRowContext(ctx, `
-			SELECT EXISTS(
-				SELECT FROM reports
-				WHERE
-					event_key = $1 AND
-					match_key = $2 AND
-					team_key = $3 AND
-					reporter_id = $4
-			)
-			`, r.EventKey, r.MatchKey, r.TeamKey, r.ReporterID).Scan(&existed)
-		if err != nil {
-			return fmt.Errorf("unable to determine if report exists: %w", err)
-		}
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT FROM reports
+			WHERE
+				event_key = $1 AND
+				match_key = $2 AND
+				team_key = $3 AND
+				reporter_id = $4 AND
+				deleted_at IS NULL
+		)
+		`, r.EventKey, r.MatchKey, r.TeamKey, r.ReporterID).Scan(&existed)
+	if err != nil {
+		return false, 0, fmt.Errorf("unable to determine if report exists: %w", err)
+	}
 
-		reportStmt, err := tx.PrepareNamedContext(ctx, `INSERT INTO
-				reports (event_key, match_key, team_key, reporter_id, realm_id, data, comment)
-			VALUES (:event_key, :match_key, :team_key, :reporter_id, :realm_id, :data, :comment)
-			ON CONFLICT (event_key, match_key, team_key, reporter_id)
-				DO UPDATE SET data = :data, realm_id = :realm_id, comment = :comment
-			RETURNING id
-		`)
-		if err != nil {
-			return fmt.Errorf("unable to prepare user insert statement: %w", err)
+	if !existed && r.RealmID != nil {
+		if err := s.checkRealmQuotaTx(ctx, tx, *r.RealmID, QuotaReportsPerDay); err != nil {
+			return false, 0, err
 		}
+	}
 
-		err = reportStmt.GetContext(ctx, &id, r)
-		if err != nil {
-			if err, ok := err.(*pq.Error); ok {
-				if err.Code == pgExists {
-					return ErrExists{fmt.Errorf("report unique violation: %s, %s, %s, %d", r.EventKey, r.MatchKey, r.TeamKey, r.ReporterID)}
-				}
-				if err.Code == pgFKeyViolation {
-					return ErrFKeyViolation{fmt.Errorf("report fk violation %s", err.Constraint)}
-				}
+	reportStmt, err := tx.PrepareNamedContext(ctx, `INSERT INTO
+			reports (event_key, match_key, team_key, reporter_id, realm_id, data, comment)
+		VALUES (:event_key, :match_key, :team_key, :reporter_id, :realm_id, :data, :comment)
+		ON CONFLICT (event_key, match_key, team_key, reporter_id)
+			DO UPDATE SET data = :data, realm_id = :realm_id, comment = :comment, deleted_at = NULL, deleted_by = NULL
+		RETURNING id
+	`)
+	if err != nil {
+		return false, 0, fmt.Errorf("unable to prepare user insert statement: %w", err)
+	}
+
+	err = reportStmt.GetContext(ctx, &id, r)
+	if err != nil {
+		if err, ok := err.(*pq.Error); ok {
+			if err.Code == pgExists {
+				return false, 0, ErrExists{fmt.Errorf("report unique violation: %s, %s, %s, %d", r.EventKey, r.MatchKey, r.TeamKey, r.ReporterID)}
+			}
+			if err.Code == pgFKeyViolation {
+				return false, 0, ErrFKeyViolation{fmt.Errorf("report fk violation %s", err.Constraint)}
 			}
-			return fmt.Errorf("unable to upsert report: %w", err)
 		}
+		return false, 0, fmt.Errorf("unable to upsert report: %w", err)
+	}
 
-		return nil
-	})
-
-	return !existed, id, err
+	return !existed, id, nil
 }
 
 // ErrConflictingReport is returned when an existing report conflicts with the report we're trying
@@ -157,7 +165,10 @@ func (err ErrConflictingReport) Error() string {
 	return fmt.Sprintf("report with same event, match, team, and reporter id exists (id %d)", err.ID)
 }
 
-// UpdateReportTx updates an existing report in the db
+// UpdateReportTx updates an existing report in the db. If another report,
+// including a deleted one, has the same event, match, team, and reporter
+// ErrConflictingReport is returned unless replace is set, in which case the
+// conflicting report is deleted for good.
 func (s *Service) UpdateReportTx(ctx context.Context, tx *sqlx.Tx, r Report, replace bool) error {
 	var id int64
 	err := tx.GetContext(ctx, &id, `
@@ -189,7 +200,8 @@ func (s *Service) UpdateReportTx(ctx context.Context, tx *sqlx.Tx, r Report, rep
 		data = :data,
 		comment = :comment
 	WHERE
-		id = :id`, r)
+		id = :id AND
+		deleted_at IS NULL`, r)
 	if err == nil {
 		if n, err := res.RowsAffected(); err != nil && n == 0 {
 			return ErrNoResults{fmt.Errorf("could not update non-existent report: %w", err)}
@@ -211,23 +223,31 @@ func (s *Service) UpdateReportTx(ctx context.Context, tx *sqlx.Tx, r Report, rep
 
 // GetReports returns all reports matching the specified filters
 func (s *Service) GetReports(ctx context.Context, eventKey *string, matchKey *string, teamKey *string, realmID *int64, reporterID *int64) ([]Report, error) {
+	return s.getReports(ctx, s.db, eventKey, matchKey, teamKey, realmID, reporterID)
+}
+
+// GetReportsTx is GetReports using the given transaction.
+func (s *Service) GetReportsTx(ctx context.Context, tx *sqlx.Tx, eventKey *string, matchKey *string, teamKey *string, realmID *int64, reporterID *int64) ([]Report, error) {
+	return s.getReports(ctx, tx, eventKey, matchKey, teamKey, realmID, reporterID)
+}
+
+func (s *Service) getReports(ctx context.Context, q sqlx.QueryerContext, eventKey *string, matchKey *string, teamKey *string, realmID *int64, reporterID *int64) ([]Report, error) {
 	var query = `
 	SELECT reports.*
 	FROM reports
-	LEFT JOIN realms
-		ON realms.id = reports.realm_id
 	WHERE
+		reports.deleted_at IS NULL AND
 	`
 
 	var parameters []interface{}
 	filters := 1
 
 	if realmID != nil {
-		query += `(reports.realm_id IS NULL OR realms.share_reports = true OR realms.id = $1)`
+		query += visibleToRealm("reports.realm_id", "reports.event_key", "$1")
 		filters++
 		parameters = append(parameters, *realmID)
 	} else {
-		query += `(reports.realm_id IS NULL OR realms.share_reports = true)`
+		query += visibleToRealm("reports.realm_id", "reports.event_key", "NULL")
 	}
 
 	if eventKey != nil {
@@ -255,62 +275,68 @@ func (s *Service) GetReports(ctx context.Context, eventKey *string, matchKey *st
 	}
 
 	reports := []Report{}
-	return reports, s.db.SelectContext(ctx, &reports, query, parameters...)
+	return reports, sqlx.SelectContext(ctx, q, &reports, query, parameters...)
 }
 
-// GetEventReportsForRealm returns all event reports for a specific event and realm.
+// GetEventReportsForRealm returns all reports for a specific event that are visible to the realm.
+// Reports that don't belong to a realm are left out.
 func (s *Service) GetEventReportsForRealm(ctx context.Context, eventKey string, realmID *int64) ([]Report, error) {
-	const query = `
+	query := `
 	SELECT reports.*
 	FROM reports
-	INNER JOIN realms
-		ON realms.id = reports.realm_id AND
-		(realms.share_reports = true OR realms.id = $2)
 	WHERE
-		reports.event_key = $1`
+		reports.event_key = $1 AND
+		reports.deleted_at IS NULL AND
+		reports.realm_id IS NOT NULL AND
+		` + visibleToRealm("reports.realm_id", "reports.event_key", "$2")
 
 	reports := []Report{}
 	return reports, s.db.SelectContext(ctx, &reports, query, eventKey, realmID)
 }
 
-// GetEventTeamReportsForRealm retrieves all reports for a specific team and event, filtering to only retrieve reports for realms
-// that are sharing reports or have a matching realm ID.
+// GetEventTeamReportsForRealm retrieves all reports for a specific team and event that are visible to the
+// realm (see visibleToRealm). Reports that don't belong to a realm are left out.
 func (s *Service) GetEventTeamReportsForRealm(ctx context.Context, eventKey string, teamKey string, realmID *int64) (reports []Report, err error) {
-	const query = `
+	query := `
 	SELECT reports.*
 	FROM reports
-	INNER JOIN realms
-		ON realms.id = reports.realm_id AND
-		(realms.share_reports = true OR realms.id = $3)
 	WHERE
 		reports.event_key = $1 AND
-		reports.team_key = $2`
+		reports.team_key = $2 AND
+		reports.deleted_at IS NULL AND
+		reports.realm_id IS NOT NULL AND
+		` + visibleToRealm("reports.realm_id", "reports.event_key", "$3")
 
 	reports = make([]Report, 0)
 	return reports, s.db.SelectContext(ctx, &reports, query, eventKey, teamKey, realmID)
 }
 
-// GetMatchTeamReportsForRealm retrieves all reports for a specific match, team, and event, filtering to only retrieve reports for realms
-// that are sharing reports or have a matching realm ID.
+// GetMatchTeamReportsForRealm retrieves all reports for a specific match, team, and event that are visible
+// to the realm (see visibleToRealm). Reports that don't belong to a realm are left out.
 func (s *Service) GetMatchTeamReportsForRealm(ctx context.Context, eventKey, matchKey string, teamKey string, realmID *int64) (reports []Report, err error) {
-	const query = `
+	query := `
 	SELECT reports.*
 	FROM reports
-	INNER JOIN realms
-		ON realms.id = reports.realm_id AND
-		(realms.share_reports = true OR realms.id = $4)
 	WHERE
 		reports.event_key = $1 AND
 		reports.match_key = $2 AND
-		reports.team_key = $3`
+		reports.team_key = $3 AND
+		reports.deleted_at IS NULL AND
+		reports.realm_id IS NOT NULL AND
+		` + visibleToRealm("reports.realm_id", "reports.event_key", "$4")
 
 	reports = make([]Report, 0)
 	return reports, s.db.SelectContext(ctx, &reports, query, eventKey, matchKey, teamKey, realmID)
 }
 
-// DeleteReportTx deletes specified report from the database using the given transaction.
-func (s *Service) DeleteReportTx(ctx context.Context, tx *sqlx.Tx, id int64) error {
-	_, err := tx.ExecContext(ctx, "DELETE FROM reports WHERE id = $1", id)
+// DeleteReportTx marks the specified report as deleted by the given user using
+// the given transaction. It can be restored until it's purged.
+func (s *Service) DeleteReportTx(ctx context.Context, tx *sqlx.Tx, id int64, deletedBy *int64) error {
+	_, err := tx.ExecContext(ctx, `
+	UPDATE reports
+		SET deleted_at = now(), deleted_by = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id, deletedBy)
 	if err != nil {
 		return fmt.Errorf("unable to delete report: %w", err)
 	}
@@ -318,6 +344,65 @@ func (s *Service) DeleteReportTx(ctx context.Context, tx *sqlx.Tx, id int64) err
 	return nil
 }
 
+// GetDeletedReport retrieves a deleted report. If the report doesn't exist or
+// isn't deleted ErrNoResults is returned.
+func (s *Service) GetDeletedReport(ctx context.Context, id int64) (Report, error) {
+	var report Report
+
+	err := s.db.GetContext(ctx, &report, "SELECT * FROM reports WHERE id = $1 AND deleted_at IS NOT NULL", id)
+	if err == sql.ErrNoRows {
+		return report, ErrNoResults{fmt.Errorf("deleted report with ID %d does not exist", id)}
+	} else if err != nil {
+		return report, fmt.Errorf("unable to retrieve deleted report: %w", err)
+	}
+
+	return report, nil
+}
+
+// GetDeletedReports retrieves the deleted reports stored in a realm, most
+// recently deleted first. If reporterID is given only that reporter's reports
+// are returned.
+func (s *Service) GetDeletedReports(ctx context.Context, realmID int64, reporterID *int64) ([]Report, error) {
+	reports := make([]Report, 0)
+
+	err := s.db.SelectContext(ctx, &reports, `
+	SELECT *
+	FROM reports
+	WHERE
+		realm_id = $1 AND
+		deleted_at IS NOT NULL AND
+		(CAST($2 AS INTEGER) IS NULL OR reporter_id = $2)
+	ORDER BY deleted_at DESC
+	`, realmID, reporterID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get deleted reports: %w", err)
+	}
+
+	return reports, nil
+}
+
+// RestoreReportTx restores a deleted report and returns it. If the report
+// doesn't exist or isn't deleted ErrNoResults is returned. Deleted reports
+// still count towards the realm's reports per day quota, so restoring one never
+// exceeds it.
+func (s *Service) RestoreReportTx(ctx context.Context, tx *sqlx.Tx, id int64) (Report, error) {
+	var report Report
+	err := tx.GetContext(ctx, &report, "SELECT * FROM reports WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE", id)
+	if err == sql.ErrNoRows {
+		return report, ErrNoResults{fmt.Errorf("deleted report with ID %d does not exist", id)}
+	} else if err != nil {
+		return report, fmt.Errorf("unable to lock deleted report: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE reports SET deleted_at = NULL, deleted_by = NULL WHERE id = $1", id)
+	if err != nil {
+		return report, fmt.Errorf("unable to restore report: %w", err)
+	}
+
+	report.DeletedAt, report.DeletedBy = nil, nil
+	return report, nil
+}
+
 // GetLeaderboardForRealm retrieves leaderboard information from the reports and users table for users
 // in the given realm. Specify year to filter for reports for events in the given year. Leave unspecified
 // for all years.
@@ -329,11 +414,12 @@ func (s *Service) GetLeaderboardForRealm(ctx context.Context, realmID int64, yea
 		users.id AS reporter_id, COUNT(reports.reporter_id) AS num_reports
 	FROM users
 	LEFT JOIN reports
-		ON (users.id = reports.reporter_id)
+		ON (users.id = reports.reporter_id AND reports.deleted_at IS NULL)
 	LEFT JOIN events
 		ON (reports.event_key = events.key)
 	WHERE
 		users.realm_id = $1 AND
+		users.deleted_at IS NULL AND
 		(EXTRACT(YEAR FROM events.start_date) = $2 OR $2 IS NULL)
 	GROUP BY users.id
 	ORDER BY num_reports DESC;
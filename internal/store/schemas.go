@@ -64,24 +64,30 @@ func (sd *SchemaFields) Scan(src interface{}) error {
 	return json.Unmarshal(j, sd)
 }
 
-// CreateSchema creates a new schema
-func (s *Service) CreateSchema(ctx context.Context, schema Schema) error {
-	return s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
-		_, err := tx.NamedExecContext(ctx, `
-		INSERT
-			INTO
-				schemas (year, realm_id, schema)
-			VALUES (:year, :realm_id, :schema)
-		`, schema)
-
-		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == pgExists {
-			return &ErrExists{fmt.Errorf("schema already exists: %v", err.Error())}
-		} else if err != nil {
-			return fmt.Errorf("unable to insert schema: %w", err)
-		}
-
-		return nil
-	})
+// CreateSchemaTx creates a new schema and returns its ID.
+func (s *Service) CreateSchemaTx(ctx context.Context, tx *sqlx.Tx, schema Schema) (int64, error) {
+	var id int64
+
+	stmt, err := tx.PrepareNamedContext(ctx, `
+	INSERT
+		INTO
+			schemas (year, realm_id, schema)
+		VALUES (:year, :realm_id, :schema)
+		RETURNING id
+	`)
+	if err != nil {
+		return id, fmt.Errorf("unable to prepare schema insert statement: %w", err)
+	}
+
+	err = stmt.GetContext(ctx, &id, schema)
+
+	if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == pgExists {
+		return id, &ErrExists{fmt.Errorf("schema already exists: %v", err.Error())}
+	} else if err != nil {
+		return id, fmt.Errorf("unable to insert schema: %w", err)
+	}
+
+	return id, nil
 }
 
 // GetSchemaByID retrieves a schema given its ID
@@ -112,22 +118,17 @@ func (s *Service) GetSchemaByYear(ctx context.Context, year int) (Schema, error)
 	return schema, nil
 }
 
-// GetSchemasForRealm retrieves schemas from the database frm a specific realm,
-// from realms with public events, and standard FRC schemas. If the realm ID is
-// nil, no private realms' schemas will be retrieved.
+// GetSchemasForRealm retrieves standard FRC schemas and the schemas of realms
+// whose reports are visible to the given realm. If the realm ID is nil, no
+// private realms' schemas will be retrieved.
 func (s *Service) GetSchemasForRealm(ctx context.Context, realmID *int64) ([]Schema, error) {
 	schemas := []Schema{}
 
 	err := s.db.SelectContext(ctx, &schemas, `
 	SELECT schemas.*
 	FROM schemas
-	LEFT JOIN realms
-		ON realms.id = schemas.realm_id
 	WHERE
-		schemas.year IS NULL OR
-		realms.id = NULL OR
-		(realms.share_reports = true OR realms.id = $1)
-	`, realmID)
+		`+visibleToRealm("schemas.realm_id", "", "$1"), realmID)
 	if err != nil {
 		return schemas, fmt.Errorf("unable to retrieve schemas: %w", err)
 	}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Reasons a session was revoked.
+const (
+	RevokedLogout = "logout"
+	RevokedAdmin  = "revoked"
+	RevokedReuse  = "reuse"
+)
+
+// Session is a logged in device. Every time its refresh token is exchanged
+// the generation is bumped, so an old refresh token being used again can be
+// detected.
+type Session struct {
+	ID            int64      `json:"id" db:"id"`
+	UserID        int64      `json:"userId" db:"user_id"`
+	Generation    int64      `json:"-" db:"generation"`
+	UserAgent     string     `json:"userAgent" db:"user_agent"`
+	CreatedAt     time.Time  `json:"createdAt" db:"created_at"`
+	LastUsedAt    time.Time  `json:"lastUsedAt" db:"last_used_at"`
+	ExpiresAt     time.Time  `json:"expiresAt" db:"expires_at"`
+	RevokedAt     *time.Time `json:"revokedAt,omitempty" db:"revoked_at"`
+	RevokedReason *string    `json:"revokedReason,omitempty" db:"revoked_reason"`
+}
+
+// CreateSession creates a new session for a user and returns it.
+func (s *Service) CreateSession(ctx context.Context, userID int64, userAgent string, expiresAt time.Time) (Session, error) {
+	var session Session
+	err := s.db.GetContext(ctx, &session, `
+		INSERT INTO sessions (user_id, user_agent, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING *
+	`, userID, userAgent, expiresAt)
+	if err != nil {
+		return session, fmt.Errorf("unable to create session: %w", err)
+	}
+
+	return session, nil
+}
+
+// RotateSession exchanges the given generation of a session's refresh token
+// for the next one, and pushes back the session's expiry. If the session
+// doesn't exist, has expired, or was revoked ErrNoResults is returned. If the
+// generation is stale the refresh token was already exchanged, so the session
+// is revoked and ErrReused is returned.
+func (s *Service) RotateSession(ctx context.Context, id, generation int64, expiresAt time.Time) (Session, error) {
+	var session Session
+
+	err := s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(ctx, &session, `
+			SELECT *
+			FROM sessions
+			WHERE id = $1 AND revoked_at IS NULL AND expires_at > now()
+			FOR UPDATE
+		`, id)
+		if err == sql.ErrNoRows {
+			return ErrNoResults{fmt.Errorf("no active session with id %d", id)}
+		} else if err != nil {
+			return fmt.Errorf("unable to get session: %w", err)
+		}
+
+		if session.Generation != generation {
+			return ErrReused{fmt.Errorf("refresh token generation %d reused for session %d", generation, id)}
+		}
+
+		err = tx.GetContext(ctx, &session, `
+			UPDATE sessions
+				SET
+					generation = generation + 1,
+					last_used_at = now(),
+					expires_at = $2
+				WHERE id = $1
+				RETURNING *
+		`, id, expiresAt)
+		if err != nil {
+			return fmt.Errorf("unable to rotate session: %w", err)
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, ErrReused{}) {
+		if revokeErr := s.RevokeSession(ctx, session.UserID, id, RevokedReuse); revokeErr != nil {
+			return session, fmt.Errorf("unable to revoke reused session: %w", revokeErr)
+		}
+	}
+
+	return session, err
+}
+
+// SessionActive returns whether a session exists and has not expired or been
+// revoked.
+func (s *Service) SessionActive(ctx context.Context, id int64) (bool, error) {
+	var active bool
+	err := s.db.GetContext(ctx, &active, `
+		SELECT EXISTS(
+			SELECT FROM sessions
+			WHERE id = $1 AND revoked_at IS NULL AND expires_at > now()
+		)
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("unable to check session: %w", err)
+	}
+
+	return active, nil
+}
+
+// GetUserSessions returns all of a user's sessions that have not expired or
+// been revoked, most recently used first.
+func (s *Service) GetUserSessions(ctx context.Context, userID int64) ([]Session, error) {
+	sessions := make([]Session, 0)
+	err := s.db.SelectContext(ctx, &sessions, `
+		SELECT *
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes a single session belonging to a user. If the user has
+// no such active session ErrNoResults is returned.
+func (s *Service) RevokeSession(ctx context.Context, userID, id int64, reason string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE sessions
+			SET
+				revoked_at = now(),
+				revoked_reason = $3
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, id, userID, reason)
+	if err != nil {
+		return fmt.Errorf("unable to revoke session: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no active session with id %d for user %d", id, userID)}
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes every active session belonging to a user.
+func (s *Service) RevokeUserSessions(ctx context.Context, userID int64, reason string) error {
+	return revokeUserSessions(ctx, s.db, userID, reason)
+}
+
+func revokeUserSessions(ctx context.Context, e sqlx.ExecerContext, userID int64, reason string) error {
+	_, err := e.ExecContext(ctx, `
+		UPDATE sessions
+			SET
+				revoked_at = now(),
+				revoked_reason = $2
+			WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, reason)
+	if err != nil {
+		return fmt.Errorf("unable to revoke sessions: %w", err)
+	}
+
+	return nil
+}
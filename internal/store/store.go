@@ -44,6 +44,18 @@ func (err ErrFKeyViolation) Is(target error) bool {
 	return ok
 }
 
+// ErrReused is returned if a refresh token that has already been exchanged is
+// used again, which means it was probably stolen.
+type ErrReused struct {
+	error
+}
+
+// Is returns whether the target is an ErrReused.
+func (err ErrReused) Is(target error) bool {
+	_, ok := target.(ErrReused)
+	return ok
+}
+
 const (
 	pgExists        = "23505"
 	pgFKeyViolation = "23503"
@@ -53,6 +65,9 @@ const (
 type Service struct {
 	db     *sqlx.DB
 	logger *logrus.Logger
+
+	// DefaultQuotas apply to realms that don't have their own quotas.
+	DefaultQuotas RealmQuotas
 }
 
 // New creates a new store service from a dataSourceName. The logger is used to
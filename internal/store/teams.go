@@ -3,17 +3,63 @@ package store
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 // EventTeam holds data about a single FRC team at a specific event.
 type EventTeam struct {
-	Key          string   `json:"team" db:"key"`
-	EventKey     string   `json:"-" db:"event_key"`
-	Rank         *int     `json:"rank,omitempty" db:"rank"`
-	RankingScore *float64 `json:"rankingScore,omitempty" db:"ranking_score"`
+	Key           string     `json:"team" db:"key"`
+	EventKey      string     `json:"-" db:"event_key"`
+	Rank          *int       `json:"rank,omitempty" db:"rank"`
+	RankingScore  *float64   `json:"rankingScore,omitempty" db:"ranking_score"`
+	Wins          *int       `json:"wins,omitempty" db:"wins"`
+	Losses        *int       `json:"losses,omitempty" db:"losses"`
+	Ties          *int       `json:"ties,omitempty" db:"ties"`
+	DQs           *int       `json:"dqs,omitempty" db:"dqs"`
+	MatchesPlayed *int       `json:"matchesPlayed,omitempty" db:"matches_played"`
+	SortOrders    SortOrders `json:"sortOrders,omitempty" db:"sort_orders"`
+}
+
+// SortOrder is a single named ranking tiebreaker, e.g. "Ranking Score" or
+// "Cargo Points". The names and order of these change year to year.
+type SortOrder struct {
+	Name      string  `json:"name"`
+	Value     float64 `json:"value"`
+	Precision int     `json:"precision"`
+}
+
+// SortOrders holds the ordered ranking tiebreakers for a team at an event.
+// Earlier sort orders take precedence over later ones.
+type SortOrders []SortOrder
+
+// Value implements driver.Valuer to return JSON for the DB from SortOrders.
+func (so SortOrders) Value() (driver.Value, error) {
+	if so == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(so)
+}
+
+// Scan implements sql.Scanner to scan JSON from the DB into SortOrders.
+func (so *SortOrders) Scan(src interface{}) error {
+	if src == nil {
+		*so = nil
+		return nil
+	}
+
+	j, ok := src.([]byte)
+	if !ok {
+		return errors.New("got invalid type for SortOrders")
+	}
+
+	return json.Unmarshal(j, so)
 }
 
 // Team holds non-event-specific team info.
@@ -42,7 +88,8 @@ func (s *Service) GetEventTeamForRealm(ctx context.Context, teamKey string, even
 	WHERE
 		teams.key = $1 AND
 		event_key = $2 AND
-		(events.realm_id IS NULL OR events.realm_id = $3)`, teamKey, eventKey, realmID)
+		(events.realm_id IS NULL OR events.realm_id = $3) AND
+		events.deleted_at IS NULL`, teamKey, eventKey, realmID)
 	if err == sql.ErrNoRows {
 		return t, ErrNoResults{fmt.Errorf("team %s at event %s does not exist: %w", teamKey, eventKey, err)}
 	}
@@ -51,15 +98,26 @@ func (s *Service) GetEventTeamForRealm(ctx context.Context, teamKey string, even
 
 // GetEventTeamsForRealm retrieves all teams from an event specified by eventKey with a null or matching realm ID.
 func (s *Service) GetEventTeamsForRealm(ctx context.Context, eventKey string, realmID *int64) ([]EventTeam, error) {
+	return s.getEventTeamsForRealm(ctx, s.db, eventKey, realmID)
+}
+
+// GetEventTeamsForRealmTx is GetEventTeamsForRealm using the given transaction.
+func (s *Service) GetEventTeamsForRealmTx(ctx context.Context, tx *sqlx.Tx, eventKey string, realmID *int64) ([]EventTeam, error) {
+	return s.getEventTeamsForRealm(ctx, tx, eventKey, realmID)
+}
+
+func (s *Service) getEventTeamsForRealm(ctx context.Context, q sqlx.QueryerContext, eventKey string, realmID *int64) ([]EventTeam, error) {
 	teams := []EventTeam{}
-	return teams, s.db.SelectContext(ctx, &teams, `SELECT teams.*
+	return teams, sqlx.SelectContext(ctx, q, &teams, `SELECT teams.*
 	FROM teams
 	LEFT JOIN
 		events
 			ON events.key = teams.event_key
 	WHERE
 		event_key = $1 AND
-		(events.realm_id IS NULL OR events.realm_id = $2)`, eventKey, realmID)
+		(events.realm_id IS NULL OR events.realm_id = $2) AND
+		events.deleted_at IS NULL
+	ORDER BY teams.rank NULLS LAST, teams.key`, eventKey, realmID)
 }
 
 // GetTeam retrieves general team info for a specific team
@@ -78,38 +136,85 @@ func (s *Service) GetTeam(ctx context.Context, teamKey string) (Team, error) {
 // EventTeamsUpsert upserts multiple teams for a specific event into the database.
 func (s *Service) EventTeamsUpsert(ctx context.Context, teams []EventTeam) error {
 	return s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
-		allTeamsStmt, err := tx.PrepareNamedContext(ctx, allTeamsKeyUpsert)
-		if err != nil {
-			return fmt.Errorf("unable to prepare all_teams upsert statement: %w", err)
-		}
-		defer allTeamsStmt.Close()
+		return s.EventTeamsUpsertTx(ctx, tx, teams)
+	})
+}
 
-		for _, team := range teams {
-			if _, err = allTeamsStmt.ExecContext(ctx, team); err != nil {
-				return fmt.Errorf("unable to upsert into all_teams: %w", err)
-			}
-		}
+// EventTeamsUpsertTx is EventTeamsUpsert using the given transaction.
+func (s *Service) EventTeamsUpsertTx(ctx context.Context, tx *sqlx.Tx, teams []EventTeam) error {
+	allTeamsStmt, err := tx.PrepareNamedContext(ctx, allTeamsKeyUpsert)
+	if err != nil {
+		return fmt.Errorf("unable to prepare all_teams upsert statement: %w", err)
+	}
+	defer allTeamsStmt.Close()
 
-		stmt, err := tx.PrepareNamedContext(ctx, `
-		INSERT INTO teams (key, event_key, rank, ranking_score)
-		VALUES (:key, :event_key, :rank, :ranking_score)
-		ON CONFLICT (key, event_key)
-			DO UPDATE
-				SET rank = $3, ranking_score = $4
-		`)
-		if err != nil {
-			return fmt.Errorf("unable to prepare teams upsert statement: %w", err)
+	for _, team := range teams {
+		if _, err = allTeamsStmt.ExecContext(ctx, team); err != nil {
+			return fmt.Errorf("unable to upsert into all_teams: %w", err)
 		}
-		defer stmt.Close()
+	}
 
-		for _, team := range teams {
-			if _, err = stmt.ExecContext(ctx, team); err != nil {
-				return fmt.Errorf("unable to upsert into teams: %w", err)
-			}
+	stmt, err := tx.PrepareNamedContext(ctx, `
+	INSERT INTO teams (key, event_key, rank, ranking_score, wins, losses, ties, dqs, matches_played, sort_orders)
+	VALUES (:key, :event_key, :rank, :ranking_score, :wins, :losses, :ties, :dqs, :matches_played, :sort_orders)
+	ON CONFLICT (key, event_key)
+		DO UPDATE
+			SET
+				rank = :rank,
+				ranking_score = :ranking_score,
+				wins = :wins,
+				losses = :losses,
+				ties = :ties,
+				dqs = :dqs,
+				matches_played = :matches_played,
+				sort_orders = :sort_orders
+	`)
+	if err != nil {
+		return fmt.Errorf("unable to prepare teams upsert statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, team := range teams {
+		if _, err = stmt.ExecContext(ctx, team); err != nil {
+			return fmt.Errorf("unable to upsert into teams: %w", err)
 		}
+	}
 
-		return nil
-	})
+	return nil
+}
+
+// SetEventRankingsTx replaces the rankings of an event's teams in the given
+// transaction. The given teams are upserted, and every other team at the event
+// has its rank and record cleared, so teams that no longer play any ranked
+// matches don't keep a stale rank.
+func (s *Service) SetEventRankingsTx(ctx context.Context, tx *sqlx.Tx, eventKey string, teams []EventTeam) error {
+	if err := s.EventTeamsUpsertTx(ctx, tx, teams); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(teams))
+	for _, team := range teams {
+		keys = append(keys, team.Key)
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		UPDATE teams
+			SET
+				rank = NULL,
+				ranking_score = NULL,
+				wins = NULL,
+				losses = NULL,
+				ties = NULL,
+				dqs = NULL,
+				matches_played = NULL,
+				sort_orders = NULL
+			WHERE event_key = $1 AND key != ALL($2)
+	`, eventKey, pq.Array(keys))
+	if err != nil {
+		return fmt.Errorf("unable to clear stale rankings: %w", err)
+	}
+
+	return nil
 }
 
 // TeamsUpsert upserts multiple teams into the database.
@@ -174,3 +279,28 @@ func (s *Service) EventTeamKeysUpsertTx(ctx context.Context, tx *sqlx.Tx, eventK
 
 	return nil
 }
+
+// SetEventTeamKeysTx sets the teams at an event to exactly the given keys in the
+// given transaction. Teams that are in one of the event's matches are kept even
+// if they're left out, so the roster always covers the schedule.
+func (s *Service) SetEventTeamKeysTx(ctx context.Context, tx *sqlx.Tx, eventKey string, keys []string) error {
+	if err := s.EventTeamKeysUpsertTx(ctx, tx, eventKey, keys); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM teams
+		WHERE
+			event_key = $1 AND
+			key != ALL($2) AND
+			NOT EXISTS(
+				SELECT FROM alliances
+				WHERE alliances.event_key = teams.event_key AND teams.key = ANY(alliances.team_keys)
+			)
+	`, eventKey, pq.Array(keys))
+	if err != nil {
+		return fmt.Errorf("unable to remove teams from event: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// UserIdentity links a user to their account with an external OpenID Connect
+// provider, so they can log in with it.
+type UserIdentity struct {
+	ID        int64     `json:"id" db:"id"`
+	UserID    int64     `json:"userId" db:"user_id"`
+	Provider  string    `json:"provider" db:"provider"`
+	Subject   string    `json:"subject" db:"subject"`
+	Email     *string   `json:"email,omitempty" db:"email"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// GetUserByIdentity retrieves the user linked to an external identity. If no
+// user is linked to it ErrNoResults is returned.
+func (s *Service) GetUserByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	var userID int64
+	err := s.db.GetContext(ctx, &userID, `
+		SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2
+	`, provider, subject)
+	if err == sql.ErrNoRows {
+		return User{}, ErrNoResults{fmt.Errorf("no user linked to identity %q from %q: %w", subject, provider, err)}
+	} else if err != nil {
+		return User{}, fmt.Errorf("unable to get user identity: %w", err)
+	}
+
+	return s.GetUserByID(ctx, userID)
+}
+
+// CreateUserWithIdentityTx creates a user linked to an external identity
+// using the given transaction, and returns the user's ID. If the username or
+// identity is already taken ErrExists is returned.
+func (s *Service) CreateUserWithIdentityTx(ctx context.Context, tx *sqlx.Tx, u User, identity UserIdentity) (int64, error) {
+	if err := s.createUserTx(ctx, tx, &u); err != nil {
+		return 0, err
+	}
+
+	identity.UserID = u.ID
+	_, err := createUserIdentityTx(ctx, tx, identity)
+	return u.ID, err
+}
+
+// CreateUserIdentity links an external identity to a user. If the identity is
+// already linked to a user ErrExists is returned.
+func (s *Service) CreateUserIdentity(ctx context.Context, identity UserIdentity) (UserIdentity, error) {
+	var created UserIdentity
+	err := s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		created, err = createUserIdentityTx(ctx, tx, identity)
+		return err
+	})
+
+	return created, err
+}
+
+func createUserIdentityTx(ctx context.Context, tx *sqlx.Tx, identity UserIdentity) (UserIdentity, error) {
+	var created UserIdentity
+	err := tx.GetContext(ctx, &created, `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING *
+	`, identity.UserID, identity.Provider, identity.Subject, identity.Email)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pgExists {
+		return created, ErrExists{fmt.Errorf("identity %q from %q is already linked: %w", identity.Subject, identity.Provider, err)}
+	} else if ok && pqErr.Code == pgFKeyViolation {
+		return created, ErrFKeyViolation{fmt.Errorf("user identity fk violation on user ID %d: %w", identity.UserID, err)}
+	} else if err != nil {
+		return created, fmt.Errorf("unable to create user identity: %w", err)
+	}
+
+	return created, nil
+}
+
+// GetUserIdentities retrieves the external identities linked to a user.
+func (s *Service) GetUserIdentities(ctx context.Context, userID int64) ([]UserIdentity, error) {
+	identities := []UserIdentity{}
+	err := s.db.SelectContext(ctx, &identities, `
+		SELECT * FROM user_identities WHERE user_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get user identities: %w", err)
+	}
+
+	return identities, nil
+}
+
+// DeleteUserIdentity unlinks an external identity from a user. If the user
+// has no identity with the given ID ErrNoResults is returned.
+func (s *Service) DeleteUserIdentity(ctx context.Context, userID, id int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM user_identities WHERE user_id = $1 AND id = $2
+	`, userID, id)
+	if err != nil {
+		return fmt.Errorf("unable to delete user identity: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("user %d has no identity %d", userID, id)}
+	}
+
+	return nil
+}
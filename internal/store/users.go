@@ -13,8 +13,8 @@ import (
 	"github.com/lib/pq"
 )
 
-// Roles holds information about a users roles and permissions such as whether
-// they are an administrator.
+// Roles holds a user's built-in roles, such as whether they are an
+// administrator. See NewPermissionSet for the permissions each grants.
 type Roles struct {
 	IsSuperAdmin bool `json:"isSuperAdmin" yaml:"isSuperAdmin"`
 	IsAdmin      bool `json:"isAdmin" yaml:"isAdmin"`
@@ -47,8 +47,20 @@ type User struct {
 	RealmID         int64          `json:"realmId" db:"realm_id"`
 	FirstName       string         `json:"firstName" db:"first_name"`
 	LastName        string         `json:"lastName" db:"last_name"`
+	Email           *string        `json:"email,omitempty" db:"email"`
 	Roles           Roles          `json:"roles" db:"roles"`
+	RoleID          *int64         `json:"roleId" db:"role_id"`
+	RolePermissions pq.StringArray `json:"-" db:"role_permissions"`
+	Pending         bool           `json:"pending" db:"pending"`
 	Stars           pq.StringArray `json:"stars" db:"stars"`
+	DeletedAt       *time.Time     `json:"deletedAt,omitempty" db:"deleted_at"`
+	DeletedBy       *int64         `json:"deletedBy,omitempty" db:"deleted_by"`
+}
+
+// Permissions returns every permission the user has through their built-in
+// roles and their realm role.
+func (u User) Permissions() PermissionSet {
+	return NewPermissionSet(u.Roles, u.RolePermissions)
 }
 
 // PatchUser is like User but with all nullable fields (besides id and realmID) for patching.
@@ -59,7 +71,9 @@ type PatchUser struct {
 	PasswordChanged *time.Time     `json:"-" db:"password_changed"`
 	FirstName       *string        `json:"firstName" db:"first_name"`
 	LastName        *string        `json:"lastName" db:"last_name"`
+	Email           *string        `json:"email" db:"email"`
 	Roles           *Roles         `json:"roles" db:"roles"`
+	RoleID          *int64         `json:"roleId" db:"role_id"` // 0 removes the user's realm role
 	Stars           pq.StringArray `json:"stars"`
 }
 
@@ -68,7 +82,17 @@ type PatchUser struct {
 func (s *Service) GetUserByUsername(ctx context.Context, username string) (User, error) {
 	var u User
 
-	err := s.db.GetContext(ctx, &u, "SELECT * FROM users WHERE username = $1", username)
+	err := s.db.GetContext(ctx, &u, `
+	SELECT
+		users.*,
+		COALESCE(realm_roles.permissions, '{}') AS role_permissions
+	FROM users
+	LEFT JOIN
+		realm_roles
+	ON
+		realm_roles.id = users.role_id
+	WHERE username = $1 AND users.deleted_at IS NULL
+	`, username)
 	if err == sql.ErrNoRows {
 		return u, ErrNoResults{fmt.Errorf("user %d does not exist: %w", u.ID, err)}
 	} else if err != nil {
@@ -78,51 +102,88 @@ func (s *Service) GetUserByUsername(ctx context.Context, username string) (User,
 	return u, nil
 }
 
-// CreateUser creates a given user.
-func (s *Service) CreateUser(ctx context.Context, u User) error {
-	return s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
-		u.PasswordChanged = time.Now()
-
-		userStmt, err := tx.PrepareNamedContext(ctx, `
-		INSERT
-			INTO
-				users (username, hashed_password, password_changed, realm_id, first_name, last_name, roles)
-			VALUES (:username, :hashed_password, :password_changed, :realm_id, :first_name, :last_name, :roles)
-			RETURNING id
-		`)
-		if err != nil {
-			return fmt.Errorf("unable to prepare user insert statement: %w", err)
-		}
+// CreateUserTx creates a given user and returns it with its ID set.
+func (s *Service) CreateUserTx(ctx context.Context, tx *sqlx.Tx, u User) (User, error) {
+	err := s.createUserTx(ctx, tx, &u)
+	return u, err
+}
 
-		err = userStmt.GetContext(ctx, &u.ID, u)
-		if err != nil {
-			if err, ok := err.(*pq.Error); ok {
-				if err.Code == pgExists {
-					return ErrExists{fmt.Errorf("username %q already exists: %w", u.Username, err)}
-				}
-				if err.Code == pgFKeyViolation {
-					return ErrFKeyViolation{fmt.Errorf("user fk violation on realm ID %d: %w", u.RealmID, err)}
-				}
+// CreateUserWithInviteTx uses an invitation code and creates the given user as
+// a verified member of the invite's realm. If the code doesn't exist, has
+// expired, been revoked, or used up, ErrNoResults is returned. The created user
+// is returned with its ID set.
+func (s *Service) CreateUserWithInviteTx(ctx context.Context, tx *sqlx.Tx, u User, code string) (User, error) {
+	invite, err := s.useRealmInviteTx(ctx, tx, code)
+	if err != nil {
+		return u, err
+	}
+
+	u.RealmID = invite.RealmID
+	u.Roles = Roles{IsVerified: true}
+	u.RoleID = nil
+	u.Pending = false
+
+	err = s.createUserTx(ctx, tx, &u)
+	return u, err
+}
+
+// createUserTx inserts the user and sets its ID. If the realm doesn't exist or
+// is archived ErrFKeyViolation is returned, and if it's full ErrQuotaExceeded
+// is returned.
+func (s *Service) createUserTx(ctx context.Context, tx *sqlx.Tx, u *User) error {
+	u.PasswordChanged = time.Now()
+
+	var archived bool
+	err := tx.GetContext(ctx, &archived, "SELECT archived_at IS NOT NULL FROM realms WHERE id = $1", u.RealmID)
+	if err == sql.ErrNoRows || archived {
+		return ErrFKeyViolation{fmt.Errorf("realm %d does not exist or is archived", u.RealmID)}
+	} else if err != nil {
+		return fmt.Errorf("unable to check user realm: %w", err)
+	}
+
+	if err := s.checkRealmQuotaTx(ctx, tx, u.RealmID, QuotaUsers); err != nil {
+		return err
+	}
+
+	userStmt, err := tx.PrepareNamedContext(ctx, `
+	INSERT
+		INTO
+			users (username, hashed_password, password_changed, realm_id, first_name, last_name, email, roles, role_id, pending)
+		VALUES (:username, :hashed_password, :password_changed, :realm_id, :first_name, :last_name, :email, :roles, :role_id, :pending)
+		RETURNING id
+	`)
+	if err != nil {
+		return fmt.Errorf("unable to prepare user insert statement: %w", err)
+	}
+
+	err = userStmt.GetContext(ctx, &u.ID, u)
+	if err != nil {
+		if err, ok := err.(*pq.Error); ok {
+			if err.Code == pgExists {
+				return ErrExists{fmt.Errorf("username %q already exists: %w", u.Username, err)}
+			}
+			if err.Code == pgFKeyViolation {
+				return ErrFKeyViolation{fmt.Errorf("user fk violation on realm ID %d: %w", u.RealmID, err)}
 			}
-			return fmt.Errorf("unable to insert user: %w", err)
 		}
+		return fmt.Errorf("unable to insert user: %w", err)
+	}
 
-		starsStmt, err := tx.PrepareContext(ctx, "INSERT INTO stars (user_id, event_key) VALUES ($1, $2)")
-		if err != nil {
-			return fmt.Errorf("unable to prepare stars insert statement: %w", err)
-		}
+	starsStmt, err := tx.PrepareContext(ctx, "INSERT INTO stars (user_id, event_key) VALUES ($1, $2)")
+	if err != nil {
+		return fmt.Errorf("unable to prepare stars insert statement: %w", err)
+	}
 
-		for _, star := range u.Stars {
-			if _, err := starsStmt.ExecContext(ctx, u.ID, star); err != nil {
-				if err, ok := err.(*pq.Error); ok && err.Code == pgFKeyViolation {
-					return ErrFKeyViolation{fmt.Errorf("user stars event key fk violation: %v", err)}
-				}
-				return fmt.Errorf("unable to insert star for user: %w", err)
+	for _, star := range u.Stars {
+		if _, err := starsStmt.ExecContext(ctx, u.ID, star); err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == pgFKeyViolation {
+				return ErrFKeyViolation{fmt.Errorf("user stars event key fk violation: %v", err)}
 			}
+			return fmt.Errorf("unable to insert star for user: %w", err)
 		}
+	}
 
-		return nil
-	})
+	return nil
 }
 
 // GetUsers retrieves all users.
@@ -138,13 +199,17 @@ func (s *Service) GetUsers(ctx context.Context) ([]User, error) {
 		realm_id,
 		first_name,
 		last_name,
+		email,
 		roles,
+		role_id,
+		pending,
 		array_remove(array_agg(stars.event_key), NULL) AS stars
 	FROM users
 	LEFT JOIN
 		stars
 	ON
 		stars.user_id = users.id
+	WHERE deleted_at IS NULL
 	GROUP BY users.id
 	`)
 	if err != nil {
@@ -167,14 +232,17 @@ func (s *Service) GetUsersByRealm(ctx context.Context, realmID int64) ([]User, e
 		realm_id,
 		first_name,
 		last_name,
+		email,
 		roles,
+		role_id,
+		pending,
 		array_remove(array_agg(stars.event_key), NULL) AS stars
 	FROM users
 	LEFT JOIN
 		stars
 	ON
 		stars.user_id = users.id
-	WHERE realm_id = $1
+	WHERE realm_id = $1 AND deleted_at IS NULL
 	GROUP BY users.id
 	`, realmID)
 	if err != nil {
@@ -186,9 +254,18 @@ func (s *Service) GetUsersByRealm(ctx context.Context, realmID int64) ([]User, e
 
 // GetUserByID retrieves a user from the database by id.
 func (s *Service) GetUserByID(ctx context.Context, id int64) (User, error) {
+	return s.getUserByID(ctx, s.db, id)
+}
+
+// GetUserByIDTx is GetUserByID using the given transaction.
+func (s *Service) GetUserByIDTx(ctx context.Context, tx *sqlx.Tx, id int64) (User, error) {
+	return s.getUserByID(ctx, tx, id)
+}
+
+func (s *Service) getUserByID(ctx context.Context, q sqlx.QueryerContext, id int64) (User, error) {
 	var u User
 
-	err := s.db.GetContext(ctx, &u, `
+	err := sqlx.GetContext(ctx, q, &u, `
 	SELECT
 		id,
 		username,
@@ -197,14 +274,18 @@ func (s *Service) GetUserByID(ctx context.Context, id int64) (User, error) {
 		realm_id,
 		first_name,
 		last_name,
+		email,
 		roles,
+		role_id,
+		pending,
+		COALESCE((SELECT permissions FROM realm_roles WHERE realm_roles.id = users.role_id), '{}') AS role_permissions,
 		array_remove(array_agg(stars.event_key), NULL) AS stars
 	FROM users
 	LEFT JOIN
 		stars
 	ON
 		stars.user_id = users.id
-	WHERE id = $1
+	WHERE id = $1 AND deleted_at IS NULL
 	GROUP BY users.id
 	`, id)
 	if err == sql.ErrNoRows {
@@ -223,7 +304,7 @@ func (s *Service) LockUser(ctx context.Context, tx *sqlx.Tx, id int64) (User, er
 	err := tx.GetContext(ctx, &u, `
 	SELECT *
 	FROM users
-	WHERE id = $1
+	WHERE id = $1 AND deleted_at IS NULL
 	FOR UPDATE
 	`, id)
 	if err == sql.ErrNoRows {
@@ -235,62 +316,68 @@ func (s *Service) LockUser(ctx context.Context, tx *sqlx.Tx, id int64) (User, er
 	return u, nil
 }
 
-// PatchUser updates a user by their ID.
-func (s *Service) PatchUser(ctx context.Context, pu PatchUser) error {
-	return s.DoTransaction(ctx, func(tx *sqlx.Tx) error {
-		if pu.HashedPassword != nil {
-			now := time.Now()
-			pu.PasswordChanged = &now
-		}
-
-		result, err := tx.NamedExecContext(ctx, `
-		UPDATE users
-			SET
-				username = COALESCE(:username, username),
-				hashed_password = COALESCE(:hashed_password, hashed_password),
-				password_changed = COALESCE(:password_changed, password_changed),
-				first_name = COALESCE(:first_name, first_name),
-				last_name = COALESCE(:last_name, last_name),
-				roles = COALESCE(:roles, roles)
-			WHERE
-				id = :id
-		`, pu)
-		if err != nil {
-			return fmt.Errorf("unable to patch user: %w", err)
-		}
+// PatchUserTx updates a user by their ID.
+func (s *Service) PatchUserTx(ctx context.Context, tx *sqlx.Tx, pu PatchUser) error {
+	if pu.HashedPassword != nil {
+		now := time.Now()
+		pu.PasswordChanged = &now
+	}
 
-		if count, err := result.RowsAffected(); err != nil || count == 0 {
-			return ErrNoResults{fmt.Errorf("user ID %d not found: %w", pu.ID, err)}
-		}
+	result, err := tx.NamedExecContext(ctx, `
+	UPDATE users
+		SET
+			username = COALESCE(:username, username),
+			hashed_password = COALESCE(:hashed_password, hashed_password),
+			password_changed = COALESCE(:password_changed, password_changed),
+			first_name = COALESCE(:first_name, first_name),
+			last_name = COALESCE(:last_name, last_name),
+			email = COALESCE(:email, email),
+			roles = COALESCE(:roles, roles),
+			role_id = NULLIF(COALESCE(:role_id, role_id), 0)
+		WHERE
+			id = :id AND deleted_at IS NULL
+	`, pu)
+	if err != nil {
+		return fmt.Errorf("unable to patch user: %w", err)
+	}
 
-		if pu.Stars != nil {
-			if _, err := tx.ExecContext(ctx, "DELETE FROM stars WHERE user_id = $1", pu.ID); err != nil {
-				return fmt.Errorf("unable to remove user stars: %w", err)
-			}
+	if count, err := result.RowsAffected(); err != nil || count == 0 {
+		return ErrNoResults{fmt.Errorf("user ID %d not found: %w", pu.ID, err)}
+	}
 
-			starsStmt, err := tx.PrepareContext(ctx, "INSERT INTO stars (user_id, event_key) VALUES ($1, $2)")
-			if err != nil {
-				return fmt.Errorf("unable to prepare stars insert statement: %w", err)
-			}
+	if pu.Stars != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM stars WHERE user_id = $1", pu.ID); err != nil {
+			return fmt.Errorf("unable to remove user stars: %w", err)
+		}
 
-			for _, star := range pu.Stars {
-				if _, err := starsStmt.ExecContext(ctx, pu.ID, star); err != nil {
-					if err, ok := err.(*pq.Error); ok && err.Code == pgFKeyViolation {
-						return ErrFKeyViolation{fmt.Errorf("user stars event key fk violation: %w", err)}
-					}
+		starsStmt, err := tx.PrepareContext(ctx, "INSERT INTO stars (user_id, event_key) VALUES ($1, $2)")
+		if err != nil {
+			return fmt.Errorf("unable to prepare stars insert statement: %w", err)
+		}
 
-					return fmt.Errorf("unable to insert star for user")
+		for _, star := range pu.Stars {
+			if _, err := starsStmt.ExecContext(ctx, pu.ID, star); err != nil {
+				if err, ok := err.(*pq.Error); ok && err.Code == pgFKeyViolation {
+					return ErrFKeyViolation{fmt.Errorf("user stars event key fk violation: %w", err)}
 				}
+
+				return fmt.Errorf("unable to insert star for user")
 			}
 		}
+	}
 
-		return nil
-	})
+	return nil
 }
 
-// DeleteUserByID deletes a specific user from the database.
-func (s *Service) DeleteUserByID(ctx context.Context, id int64) error {
-	res, err := s.db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+// DeleteUserByIDTx marks a specific user as deleted by the given user and
+// revokes their sessions. They can't log in, and they can be restored until
+// they're purged.
+func (s *Service) DeleteUserByIDTx(ctx context.Context, tx *sqlx.Tx, id int64, deletedBy *int64) error {
+	res, err := tx.ExecContext(ctx, `
+	UPDATE users
+		SET deleted_at = now(), deleted_by = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id, deletedBy)
 	if err != nil {
 		return fmt.Errorf("unable to delete user %d: %w", id, err)
 	}
@@ -299,12 +386,17 @@ func (s *Service) DeleteUserByID(ctx context.Context, id int64) error {
 		return ErrNoResults{errors.New("got 0 affected rows")}
 	}
 
-	return nil
+	return revokeUserSessions(ctx, tx, id, RevokedAdmin)
 }
 
-// DeleteUserByIDRealmTx deletes a specific user from the database.
-func (s *Service) DeleteUserByIDRealmTx(ctx context.Context, tx *sqlx.Tx, id, realmID int64) error {
-	res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1 AND realm_id = $2", id, realmID)
+// DeleteUserByIDRealmTx marks a specific user in a realm as deleted by the given
+// user and revokes their sessions.
+func (s *Service) DeleteUserByIDRealmTx(ctx context.Context, tx *sqlx.Tx, id, realmID int64, deletedBy *int64) error {
+	res, err := tx.ExecContext(ctx, `
+	UPDATE users
+		SET deleted_at = now(), deleted_by = $3
+		WHERE id = $1 AND realm_id = $2 AND deleted_at IS NULL
+	`, id, realmID, deletedBy)
 	if err != nil {
 		return fmt.Errorf("unable to delete user %d: %w", id, err)
 	}
@@ -313,12 +405,80 @@ func (s *Service) DeleteUserByIDRealmTx(ctx context.Context, tx *sqlx.Tx, id, re
 		return ErrNoResults{errors.New("got 0 affected rows")}
 	}
 
+	return revokeUserSessions(ctx, tx, id, RevokedAdmin)
+}
+
+// GetDeletedUser retrieves a deleted user. If the user doesn't exist or isn't
+// deleted ErrNoResults is returned.
+func (s *Service) GetDeletedUser(ctx context.Context, id int64) (User, error) {
+	var u User
+
+	err := s.db.GetContext(ctx, &u, `
+	SELECT
+		users.*,
+		COALESCE(realm_roles.permissions, '{}') AS role_permissions
+	FROM users
+	LEFT JOIN
+		realm_roles
+	ON
+		realm_roles.id = users.role_id
+	WHERE users.id = $1 AND users.deleted_at IS NOT NULL
+	`, id)
+	if err == sql.ErrNoRows {
+		return u, ErrNoResults{fmt.Errorf("deleted user %d does not exist: %w", id, err)}
+	} else if err != nil {
+		return u, fmt.Errorf("unable to select deleted user: %w", err)
+	}
+
+	return u, nil
+}
+
+// GetDeletedUsers retrieves the deleted users whose home realm is the given
+// realm, most recently deleted first.
+func (s *Service) GetDeletedUsers(ctx context.Context, realmID int64) ([]User, error) {
+	users := []User{}
+
+	err := s.db.SelectContext(ctx, &users, `
+	SELECT *
+	FROM users
+	WHERE realm_id = $1 AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`, realmID)
+	if err != nil {
+		return users, fmt.Errorf("unable to fetch deleted users: %w", err)
+	}
+
+	return users, nil
+}
+
+// RestoreUserTx restores a deleted user. If the user doesn't exist or isn't
+// deleted ErrNoResults is returned, and if their realm is full
+// ErrQuotaExceeded is returned.
+func (s *Service) RestoreUserTx(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	var realmID int64
+	err := tx.GetContext(ctx, &realmID, "SELECT realm_id FROM users WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE", id)
+	if err == sql.ErrNoRows {
+		return ErrNoResults{fmt.Errorf("deleted user %d does not exist: %w", id, err)}
+	} else if err != nil {
+		return fmt.Errorf("unable to lock deleted user: %w", err)
+	}
+
+	if err := s.checkRealmQuotaTx(ctx, tx, realmID, QuotaUsers); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET deleted_at = NULL, deleted_by = NULL WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("unable to restore user %d: %w", id, err)
+	}
+
 	return nil
 }
 
 // CheckSimilarUsernameExists checks whether a user with (case insensitive) the
 // same username exists. It returns an ErrExists if a similar user exists.
-// If an id is given, it will ignore the user with that id.
+// If an id is given, it will ignore the user with that id. Deleted users keep
+// their username until they're purged, so they can still be restored.
 func (s *Service) CheckSimilarUsernameExists(ctx context.Context, username string, id *int64) error {
 	var ok bool
 	var err error
@@ -354,3 +514,57 @@ func (s *Service) ExclusiveLockUsersTx(ctx context.Context, tx *sqlx.Tx) error {
 
 	return nil
 }
+
+// GetPendingUsers retrieves all users in a realm who registered themselves and
+// are waiting to be approved.
+func (s *Service) GetPendingUsers(ctx context.Context, realmID int64) ([]User, error) {
+	users := []User{}
+
+	err := s.db.SelectContext(ctx, &users, `
+	SELECT *
+	FROM users
+	WHERE realm_id = $1 AND pending AND deleted_at IS NULL AND NOT (roles->>'isVerified')::boolean
+	ORDER BY id
+	`, realmID)
+	if err != nil {
+		return users, fmt.Errorf("unable to fetch pending users: %w", err)
+	}
+
+	return users, nil
+}
+
+// ApproveUserTx verifies a user who is waiting to be approved. If the user
+// doesn't exist or isn't waiting to be approved ErrNoResults is returned.
+func (s *Service) ApproveUserTx(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	res, err := tx.ExecContext(ctx, `
+	UPDATE users
+		SET
+			pending = false,
+			roles = jsonb_set(roles, '{isVerified}', 'true')
+		WHERE id = $1 AND pending AND deleted_at IS NULL
+	`, id)
+	if err != nil {
+		return fmt.Errorf("unable to approve user %d: %w", id, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no pending user with id %d", id)}
+	}
+
+	return nil
+}
+
+// RejectUserTx deletes a user who is waiting to be approved. If the user doesn't
+// exist or isn't waiting to be approved ErrNoResults is returned.
+func (s *Service) RejectUserTx(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1 AND pending AND deleted_at IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("unable to reject user %d: %w", id, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoResults{fmt.Errorf("no pending user with id %d", id)}
+	}
+
+	return nil
+}
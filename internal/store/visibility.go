@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// visibleToRealm returns a SQL condition that is true when data owned by the
+// realm in ownerColumn can be seen by the realm in the realmParam placeholder,
+// which may be NULL for users who aren't logged in. Data is visible if it
+// doesn't belong to a realm, belongs to the realm itself, belongs to a realm
+// that shares its reports with everyone, or belongs to a realm with an
+// accepted sharing agreement with the realm.
+//
+// Sharing agreements can be scoped to an event or a year. If eventKeyColumn is
+// set, scoped agreements only count for data from that event or year.
+// Otherwise any agreement counts, e.g. for schemas, which are needed to read
+// the shared reports.
+//
+// Every query that returns reports, or data derived from them, should filter
+// with this condition so the rule only lives in one place. The per-event
+// report queries also leave out reports that don't belong to a realm.
+func visibleToRealm(ownerColumn, eventKeyColumn, realmParam string) string {
+	scope := "true"
+	if eventKeyColumn != "" {
+		scope = fmt.Sprintf(`
+			(realm_shares.event_key IS NULL OR realm_shares.event_key = %[1]s) AND
+			(realm_shares.year IS NULL OR realm_shares.year = (
+				SELECT EXTRACT(YEAR FROM events.start_date) FROM events WHERE events.key = %[1]s
+			))`, eventKeyColumn)
+	}
+
+	return fmt.Sprintf(`(
+		%[1]s IS NULL OR
+		%[1]s = %[2]s OR
+		EXISTS (SELECT FROM realms WHERE realms.id = %[1]s AND realms.share_reports) OR
+		EXISTS (
+			SELECT FROM realm_shares
+			WHERE
+				realm_shares.accepted_at IS NOT NULL AND
+				(
+					(realm_shares.realm_id = %[1]s AND realm_shares.partner_realm_id = %[2]s) OR
+					(realm_shares.partner_realm_id = %[1]s AND realm_shares.realm_id = %[2]s)
+				) AND
+				%[3]s
+		)
+	)`, ownerColumn, realmParam, scope)
+}
+
+// RealmVisibleToRealm returns whether data owned by the realm ownerID, such as
+// its schemas, can be seen by the realm realmID (see visibleToRealm). Either
+// may be nil.
+func (s *Service) RealmVisibleToRealm(ctx context.Context, ownerID, realmID *int64) (bool, error) {
+	var visible bool
+
+	err := s.db.GetContext(ctx, &visible, `SELECT `+visibleToRealm("CAST($1 AS INTEGER)", "", "CAST($2 AS INTEGER)"), ownerID, realmID)
+	if err != nil {
+		return false, fmt.Errorf("unable to check realm visibility: %w", err)
+	}
+
+	return visible, nil
+}
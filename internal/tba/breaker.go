@@ -0,0 +1,144 @@
+package tba
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is returned when a request is not sent to TBA because too
+// many recent requests have failed.
+type ErrCircuitOpen struct {
+	error
+}
+
+// Is returns whether the given target error is an ErrCircuitOpen error.
+func (co ErrCircuitOpen) Is(target error) bool {
+	_, ok := target.(ErrCircuitOpen)
+	return ok
+}
+
+// BreakerStatus describes the current state of the TBA circuit breaker.
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	RetryAt             *time.Time `json:"retryAt,omitempty"`
+}
+
+// breaker is a circuit breaker. After threshold consecutive failures it opens
+// and rejects all requests until cooldown has passed, at which point a single
+// trial request is let through (half-open). If the trial succeeds the breaker
+// closes, otherwise it opens again.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     BreakerClosed,
+	}
+}
+
+// allow returns an ErrCircuitOpen if a request should not be made right now.
+// A breaker with a non-positive threshold never opens.
+func (b *breaker) allow() error {
+	if b == nil || b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		retryAt := b.openedAt.Add(b.cooldown)
+		if b.now().Before(retryAt) {
+			return ErrCircuitOpen{fmt.Errorf("TBA circuit breaker open until %s", retryAt.Format(time.RFC3339))}
+		}
+
+		b.state = BreakerHalfOpen
+		return nil
+	case BreakerHalfOpen:
+		return ErrCircuitOpen{fmt.Errorf("TBA circuit breaker waiting on trial request")}
+	}
+
+	return nil
+}
+
+func (b *breaker) success() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+func (b *breaker) failure() {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// abort is called when a request was allowed but never completed (e.g. its
+// context was cancelled), so a trial request doesn't leave the breaker
+// half-open forever.
+func (b *breaker) abort() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+		b.openedAt = b.now().Add(-b.cooldown)
+	}
+}
+
+func (b *breaker) status() BreakerStatus {
+	if b == nil {
+		return BreakerStatus{State: BreakerClosed}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{State: b.state, ConsecutiveFailures: b.failures}
+	if b.state == BreakerOpen {
+		retryAt := b.openedAt.Add(b.cooldown)
+		status.RetryAt = &retryAt
+	}
+
+	return status
+}
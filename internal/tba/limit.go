@@ -0,0 +1,111 @@
+package tba
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	backoffBase = time.Millisecond * 500
+	backoffMax  = time.Second * 30
+)
+
+// limiter is a token bucket rate limiter. Tokens are added at rate per second
+// up to burst, and each request consumes a single token.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available or the context is done. A nil limiter
+// or a limiter with a non-positive rate never blocks.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil || l.rate <= 0 {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// shouldRetry returns whether a response with the given status code is worth
+// retrying: TBA is either throttling us or having issues.
+func shouldRetry(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// backoff returns how long to wait before retry number attempt (starting at
+// zero). It uses exponential backoff with full jitter, but will wait at least
+// as long as TBA asked us to with a Retry-After header.
+func backoff(attempt int, resp *http.Response) time.Duration {
+	ceiling := backoffBase * time.Duration(1<<uint(attempt))
+	if ceiling > backoffMax || ceiling <= 0 {
+		ceiling = backoffMax
+	}
+
+	delay := time.Duration(rand.Int63n(int64(ceiling)))
+
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			if retryAfter := time.Duration(seconds) * time.Second; retryAfter > delay {
+				delay = retryAfter
+			}
+		}
+	}
+
+	return delay
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,168 @@
+package tba
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1558050528, 0)
+
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected closed breaker to allow request, got: %v", err)
+	}
+
+	b.failure()
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected breaker under threshold to allow request, got: %v", err)
+	}
+
+	b.failure()
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen{}) {
+		t.Fatalf("expected open breaker to reject request, got: %v", err)
+	}
+
+	if status := b.status(); status.State != BreakerOpen || status.RetryAt == nil || !status.RetryAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("got unexpected open breaker status: %+v", status)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected breaker to allow trial request after cooldown, got: %v", err)
+	}
+
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen{}) {
+		t.Fatalf("expected half-open breaker to reject a second request, got: %v", err)
+	}
+
+	b.failure()
+	if status := b.status(); status.State != BreakerOpen {
+		t.Fatalf("expected failed trial request to re-open breaker, got state %q", status.State)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected breaker to allow trial request after cooldown, got: %v", err)
+	}
+
+	b.success()
+	if status := b.status(); status.State != BreakerClosed || status.ConsecutiveFailures != 0 {
+		t.Errorf("expected successful trial request to close breaker, got: %+v", status)
+	}
+}
+
+func TestMakeRequestRetries(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	s := Service{URL: server.URL, MaxRetries: 2, BreakerThreshold: 1, BreakerCooldown: time.Minute}
+
+	resp, err := s.makeRequest(context.Background(), "/foo")
+	if err != nil {
+		t.Fatalf("did not expect error but got: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected 3 requests but got %d", n)
+	}
+
+	if status := s.BreakerStatus(); status.State != BreakerClosed {
+		t.Errorf("expected breaker to be closed but got %q", status.State)
+	}
+
+	atomic.StoreInt32(&requests, -10)
+
+	resp, err = s.makeRequest(context.Background(), "/foo")
+	if err != nil {
+		t.Fatalf("did not expect error but got: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d after exhausting retries but got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	if _, err := s.makeRequest(context.Background(), "/foo"); !errors.Is(err, ErrCircuitOpen{}) {
+		t.Errorf("expected circuit open error but got: %v", err)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1000, 2)
+
+	for i := 0; i < 2; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatalf("did not expect error waiting for burst token: %v", err)
+		}
+	}
+
+	slow := newLimiter(0.001, 1)
+	if err := slow.wait(context.Background()); err != nil {
+		t.Fatalf("did not expect error waiting for first token: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	if err := slow.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded waiting on empty bucket, got: %v", err)
+	}
+}
+
+func TestWithoutCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == "v1" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", "v1")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	s := Service{URL: server.URL}
+
+	resp, err := s.makeRequest(context.Background(), "/foo")
+	if err != nil {
+		t.Fatalf("did not expect error but got: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = s.makeRequest(context.Background(), "/foo")
+	if !errors.Is(err, ErrNotModified{}) {
+		t.Errorf("expected not modified error but got: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = s.makeRequest(WithoutCache(context.Background()), "/foo")
+	if err != nil {
+		t.Fatalf("did not expect error without cache but got: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d without cache but got %d", http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,226 @@
+package tba
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recording is a single TBA response captured by RecordingTransport. The
+// request's headers (including the API key) are intentionally not recorded.
+type recording struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	RecordedAt time.Time   `json:"recordedAt"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// recordingKey returns the directory name recordings for the given request
+// are stored under.
+func recordingKey(u *url.URL) string {
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+
+	return url.PathEscape(key)
+}
+
+// recordingFiles returns the sorted paths of all recordings in a key directory.
+func recordingFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// RecordingTransport is an http.RoundTripper that sends requests using
+// Transport and writes every response to Dir so it can be replayed later with
+// ReplayTransport. Responses for the same URL are numbered in the order they
+// were received, so a whole event day can be captured and played back.
+type RecordingTransport struct {
+	Dir       string
+	Transport http.RoundTripper
+
+	mu       sync.Mutex
+	sequence map[string]int
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := rt.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response body for recording: %w", err)
+	}
+	resp.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+
+	rec := recording{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		RecordedAt: time.Now(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       string(body),
+	}
+
+	if err := rt.write(recordingKey(req.URL), rec); err != nil {
+		return nil, fmt.Errorf("unable to write recording: %w", err)
+	}
+
+	return resp, nil
+}
+
+func (rt *RecordingTransport) write(key string, rec recording) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	dir := filepath.Join(rt.Dir, key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if rt.sequence == nil {
+		rt.sequence = make(map[string]int)
+	}
+
+	seq, ok := rt.sequence[key]
+	if !ok {
+		// continue numbering after any recordings from a previous run
+		files, err := recordingFiles(dir)
+		if err != nil {
+			return err
+		}
+		seq = len(files)
+	}
+	rt.sequence[key] = seq + 1
+
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%06d.json", seq)), b, 0644)
+}
+
+// ReplayTransport is an http.RoundTripper that serves responses previously
+// captured by RecordingTransport from Dir without touching the network. Each
+// request for a URL is answered with the next recording for that URL, and the
+// last recording is repeated once they run out. ETags are honored, so a request
+// with a matching If-None-Match header gets a 304 just like it would from TBA.
+type ReplayTransport struct {
+	Dir string
+
+	mu     sync.Mutex
+	cursor map[string]int
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec, err := rt.next(recordingKey(req.URL))
+	if err != nil {
+		return nil, err
+	}
+
+	if rec == nil {
+		return replayResponse(req, http.StatusNotFound, http.Header{}, ""), nil
+	}
+
+	etag := rec.Header.Get("Etag")
+	if etag != "" && req.Header.Get("If-None-Match") == etag {
+		return replayResponse(req, http.StatusNotModified, rec.Header, ""), nil
+	}
+
+	return replayResponse(req, rec.StatusCode, rec.Header, rec.Body), nil
+}
+
+// next returns the next recording for a key, or nil if there are none. A
+// recorded 304 is resolved to the most recent full response before it.
+func (rt *ReplayTransport) next(key string) (*recording, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	files, err := recordingFiles(filepath.Join(rt.Dir, key))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	if rt.cursor == nil {
+		rt.cursor = make(map[string]int)
+	}
+
+	i := rt.cursor[key]
+	if i >= len(files) {
+		i = len(files) - 1
+	}
+	rt.cursor[key] = i + 1
+
+	for ; i >= 0; i-- {
+		rec, err := readRecording(files[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if rec.StatusCode != http.StatusNotModified {
+			return &rec, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func readRecording(path string) (recording, error) {
+	var rec recording
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rec, fmt.Errorf("unable to read recording: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return rec, fmt.Errorf("unable to unmarshal recording %q: %w", path, err)
+	}
+
+	return rec, nil
+}
+
+func replayResponse(req *http.Request, statusCode int, header http.Header, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
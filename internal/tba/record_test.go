@@ -0,0 +1,93 @@
+package tba
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peregrine-tba-recordings")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bodies := []string{
+		`[{"key": "2018abca_qm1", "alliances": {"red": {"score": -1, "team_keys": ["frc1"]}, "blue": {"score": -1, "team_keys": ["frc2"]}}}]`,
+		`[{"key": "2018abca_qm1", "alliances": {"red": {"score": 50, "team_keys": ["frc1"]}, "blue": {"score": 40, "team_keys": ["frc2"]}}}]`,
+	}
+	etags := []string{`"a"`, `"b"`}
+	version := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etags[version] {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", etags[version])
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(bodies[version]))
+	}))
+	defer server.Close()
+
+	type result struct {
+		NotModified bool
+		RedScores   []int
+	}
+
+	fetch := func(s *Service) result {
+		matches, err := s.GetMatches(context.Background(), "2018abca")
+		if errors.Is(err, ErrNotModified{}) {
+			return result{NotModified: true}
+		} else if err != nil {
+			t.Fatalf("did not expect error getting matches: %v", err)
+		}
+
+		res := result{RedScores: []int{}}
+		for _, m := range matches {
+			if m.RedScore != nil {
+				res.RedScores = append(res.RedScores, *m.RedScore)
+			}
+		}
+
+		return res
+	}
+
+	recorder := &Service{URL: server.URL, APIKey: "notARealKey", Transport: &RecordingTransport{Dir: dir}}
+
+	var recorded []result
+	recorded = append(recorded, fetch(recorder), fetch(recorder))
+	version = 1
+	recorded = append(recorded, fetch(recorder))
+
+	expected := []result{{RedScores: []int{}}, {NotModified: true}, {RedScores: []int{50}}}
+	if !cmp.Equal(recorded, expected) {
+		t.Errorf("unexpected recorded responses, got diff: %s", cmp.Diff(expected, recorded))
+	}
+
+	// Turn off the server to be sure nothing in replay mode touches the network.
+	server.Close()
+
+	replayer := &Service{URL: server.URL, Transport: &ReplayTransport{Dir: dir}}
+
+	var replayed []result
+	for range recorded {
+		replayed = append(replayed, fetch(replayer))
+	}
+
+	if !cmp.Equal(replayed, recorded) {
+		t.Errorf("expected replayed responses to match recorded responses, got diff: %s", cmp.Diff(recorded, replayed))
+	}
+
+	if _, err := replayer.GetMatches(context.Background(), "2018nope"); err == nil {
+		t.Errorf("expected error replaying a request that was never recorded")
+	}
+}
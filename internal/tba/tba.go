@@ -17,9 +17,33 @@ import (
 // Service provides methods for retrieving data from
 // The Blue Alliance API
 type Service struct {
-	URL       string
-	APIKey    string
+	URL    string
+	APIKey string
+
+	// RequestsPerSecond and Burst configure a token bucket for limiting
+	// requests to TBA. A zero RequestsPerSecond disables limiting.
+	RequestsPerSecond float64
+	Burst             int
+
+	// MaxRetries is how many times to retry requests that got a 429 or a 5xx,
+	// with exponential backoff and jitter between attempts.
+	MaxRetries int
+
+	// BreakerThreshold is how many consecutive failed requests will open the
+	// circuit breaker, pausing all requests to TBA for BreakerCooldown. A zero
+	// BreakerThreshold disables the circuit breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Transport is used to send requests to TBA, e.g. a RecordingTransport or
+	// ReplayTransport. If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	once      sync.Once
+	client    *http.Client
 	etagStore *sync.Map
+	limiter   *limiter
+	breaker   *breaker
 }
 
 type district struct {
@@ -82,22 +106,30 @@ type rankings struct {
 }
 
 type rank struct {
-	Rank       int       `json:"rank"`
-	TeamKey    string    `json:"team_key"`
-	SortOrders []float64 `json:"sort_orders"`
+	Rank          int       `json:"rank"`
+	TeamKey       string    `json:"team_key"`
+	SortOrders    []float64 `json:"sort_orders"`
+	Record        *record   `json:"record"`
+	DQ            *int      `json:"dq"`
+	MatchesPlayed *int      `json:"matches_played"`
+}
+
+type record struct {
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Ties   int `json:"ties"`
 }
 
 type sortOrderInfo struct {
-	Name string `json:"name"`
+	Name      string `json:"name"`
+	Precision int    `json:"precision"`
 }
 
 // Maximum size of response from the TBA API to read. This value is about 4x the
 // size of a typical /events/{year} response from TBA.
 const maxResponseSize int64 = 1.2e+6
 
-var tbaClient = &http.Client{
-	Timeout: time.Second * 10,
-}
+const clientTimeout = time.Second * 10
 
 // ErrNotModified is returned when a resource has not been modified since it was
 // last retrieved from TBA.
@@ -111,6 +143,26 @@ func (nm ErrNotModified) Is(target error) bool {
 	return ok
 }
 
+// ErrNotFound is returned when TBA has no resource at the requested path.
+type ErrNotFound struct {
+	error
+}
+
+// Is returns whether the given target error is an ErrNotFound error.
+func (nf ErrNotFound) Is(target error) bool {
+	_, ok := target.(ErrNotFound)
+	return ok
+}
+
+type noCacheKey struct{}
+
+// WithoutCache returns a context that makes requests to TBA ignore any cached
+// ETags, so the full resource is always fetched even if TBA thinks we already
+// have it.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
 func trimMatchKey(tbaKey string) (string, error) {
 	parts := strings.Split(tbaKey, "_")
 	if len(parts) != 2 {
@@ -120,26 +172,66 @@ func trimMatchKey(tbaKey string) (string, error) {
 	return parts[1], nil
 }
 
+func (s *Service) init() {
+	s.once.Do(func() {
+		s.client = &http.Client{Timeout: clientTimeout, Transport: s.Transport}
+		s.etagStore = new(sync.Map)
+		s.limiter = newLimiter(s.RequestsPerSecond, s.Burst)
+		s.breaker = newBreaker(s.BreakerThreshold, s.BreakerCooldown)
+	})
+}
+
+// BreakerStatus returns the current state of the circuit breaker guarding
+// requests to TBA.
+func (s *Service) BreakerStatus() BreakerStatus {
+	s.init()
+	return s.breaker.status()
+}
+
+// makeRequest sends a GET request for path to TBA, waiting on the rate limiter
+// and retrying with backoff if TBA is throttling us or erroring. If the
+// resource hasn't changed since we last retrieved it, ErrNotModified is returned.
 func (s *Service) makeRequest(ctx context.Context, path string) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodGet, s.URL+path, nil)
-	if err != nil {
+	s.init()
+
+	if err := s.breaker.allow(); err != nil {
 		return nil, err
 	}
-	req = req.WithContext(ctx)
 
-	if s.etagStore == nil {
-		s.etagStore = new(sync.Map)
-	}
+	var resp *http.Response
+	var err error
 
-	if v, ok := s.etagStore.Load(path); ok {
-		req.Header.Set("If-None-Match", v.(string))
-	}
+	for attempt := 0; ; attempt++ {
+		if err := s.limiter.wait(ctx); err != nil {
+			s.breaker.abort()
+			return nil, err
+		}
 
-	req.Header.Set("X-TBA-Auth-Key", s.APIKey)
+		resp, err = s.doRequest(ctx, path)
+		if ctx.Err() != nil {
+			s.breaker.abort()
+			return resp, err
+		}
 
-	resp, err := tbaClient.Do(req)
-	if err != nil {
-		return resp, err
+		if err == nil && !shouldRetry(resp.StatusCode) {
+			s.breaker.success()
+			break
+		}
+
+		if attempt >= s.MaxRetries {
+			s.breaker.failure()
+			return resp, err
+		}
+
+		delay := backoff(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			s.breaker.abort()
+			return nil, err
+		}
 	}
 
 	if resp.StatusCode == http.StatusNotModified {
@@ -153,6 +245,24 @@ func (s *Service) makeRequest(ctx context.Context, path string) (*http.Response,
 	return resp, nil
 }
 
+func (s *Service) doRequest(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, s.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if noCache, _ := ctx.Value(noCacheKey{}).(bool); !noCache {
+		if v, ok := s.etagStore.Load(path); ok {
+			req.Header.Set("If-None-Match", v.(string))
+		}
+	}
+
+	req.Header.Set("X-TBA-Auth-Key", s.APIKey)
+
+	return s.client.Do(req)
+}
+
 func webcastURL(webcastType, channel string) (string, error) {
 	switch webcastType {
 	case "twitch":
@@ -175,16 +285,19 @@ func videoURL(videoType, key string) (string, error) {
 
 // Ping pings the TBA /status endpoint
 func (s *Service) Ping(ctx context.Context) error {
+	s.init()
+
 	req, err := http.NewRequest(http.MethodGet, s.URL+"/status", nil)
 	if err != nil {
 		return fmt.Errorf("making new request: %w", err)
 	}
 	req = req.WithContext(ctx)
 
-	_, err = tbaClient.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("doing request: %w", err)
 	}
+	resp.Body.Close()
 
 	return nil
 }
@@ -209,59 +322,93 @@ func (s *Service) GetEvents(ctx context.Context, year int) ([]store.Event, error
 
 	var events []store.Event
 	for _, tbaEvent := range tbaEvents {
-		var districtAbbreviation, districtFullName *string
-		if tbaEvent.District != nil {
-			districtAbbreviation = &tbaEvent.District.Abbreviation
-			districtFullName = &tbaEvent.District.FullName
-		}
-
-		timeZone, err := time.LoadLocation(tbaEvent.Timezone)
+		e, err := convertEvent(tbaEvent)
 		if err != nil {
 			return nil, err
 		}
 
-		startDate, err := time.ParseInLocation("2006-01-02", tbaEvent.StartDate, timeZone)
-		if err != nil {
-			return nil, err
-		}
-		startDate = startDate.Add(time.Hour * 12) // assume events start at noon
+		events = append(events, e)
+	}
 
-		endDate, err := time.ParseInLocation("2006-01-02", tbaEvent.EndDate, timeZone)
-		if err != nil {
-			return nil, err
-		}
-		endDate = endDate.Add(time.Hour * (12 + 7)) // assume events end at 7pm
+	return events, nil
+}
 
-		webcasts := make([]string, 0)
-		for _, webcast := range tbaEvent.Webcasts {
-			url, err := webcastURL(webcast.Type, webcast.Channel)
-			if err == nil {
-				webcasts = append(webcasts, url)
-			}
-		}
+// GetEvent retrieves a single event by key. If TBA doesn't know about the
+// event ErrNotFound is returned.
+func (s *Service) GetEvent(ctx context.Context, eventKey string) (store.Event, error) {
+	path := fmt.Sprintf("/event/%s", eventKey)
+
+	response, err := s.makeRequest(ctx, path)
+	if err != nil {
+		return store.Event{}, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return store.Event{}, ErrNotFound{fmt.Errorf("got not found for url %q", response.Request.URL)}
+	} else if response.StatusCode != http.StatusOK {
+		return store.Event{}, fmt.Errorf("got unexpected status for url %q: %d", response.Request.URL, response.StatusCode)
+	}
 
-		name := tbaEvent.ShortName
-		if name == "" {
-			name = tbaEvent.Name
+	var tbaEvent event
+	if err := json.NewDecoder(io.LimitReader(response.Body, maxResponseSize)).Decode(&tbaEvent); err != nil {
+		return store.Event{}, err
+	}
+
+	return convertEvent(tbaEvent)
+}
+
+func convertEvent(tbaEvent event) (store.Event, error) {
+	var districtAbbreviation, districtFullName *string
+	if tbaEvent.District != nil {
+		districtAbbreviation = &tbaEvent.District.Abbreviation
+		districtFullName = &tbaEvent.District.FullName
+	}
+
+	timeZone, err := time.LoadLocation(tbaEvent.Timezone)
+	if err != nil {
+		return store.Event{}, err
+	}
+
+	startDate, err := time.ParseInLocation("2006-01-02", tbaEvent.StartDate, timeZone)
+	if err != nil {
+		return store.Event{}, err
+	}
+	startDate = startDate.Add(time.Hour * 12) // assume events start at noon
+
+	endDate, err := time.ParseInLocation("2006-01-02", tbaEvent.EndDate, timeZone)
+	if err != nil {
+		return store.Event{}, err
+	}
+	endDate = endDate.Add(time.Hour * (12 + 7)) // assume events end at 7pm
+
+	webcasts := make([]string, 0)
+	for _, webcast := range tbaEvent.Webcasts {
+		url, err := webcastURL(webcast.Type, webcast.Channel)
+		if err == nil {
+			webcasts = append(webcasts, url)
 		}
+	}
 
-		events = append(events, store.Event{
-			Key:          tbaEvent.Key,
-			Name:         name,
-			District:     districtAbbreviation,
-			FullDistrict: districtFullName,
-			Week:         tbaEvent.Week,
-			StartDate:    startDate,
-			EndDate:      endDate,
-			Webcasts:     webcasts,
-			Lat:          tbaEvent.Lat,
-			Lon:          tbaEvent.Lng,
-			GMapsURL:     tbaEvent.GMapsURL,
-			LocationName: tbaEvent.LocationName,
-		})
+	name := tbaEvent.ShortName
+	if name == "" {
+		name = tbaEvent.Name
 	}
 
-	return events, nil
+	return store.Event{
+		Key:          tbaEvent.Key,
+		Name:         name,
+		District:     districtAbbreviation,
+		FullDistrict: districtFullName,
+		Week:         tbaEvent.Week,
+		StartDate:    startDate,
+		EndDate:      endDate,
+		Webcasts:     webcasts,
+		Lat:          tbaEvent.Lat,
+		Lon:          tbaEvent.Lng,
+		GMapsURL:     tbaEvent.GMapsURL,
+		LocationName: tbaEvent.LocationName,
+	}, nil
 }
 
 const tbaURL = "https://www.thebluealliance.com"
@@ -416,13 +563,36 @@ func (s *Service) GetTeamRankings(ctx context.Context, eventKey string) ([]store
 			rankingScore = &teamRank.SortOrders[rankingScoreIndex]
 		}
 
+		var sortOrders store.SortOrders
+		for i, info := range teamRankings.SortOrderInfo {
+			if i >= len(teamRank.SortOrders) {
+				break
+			}
+
+			sortOrders = append(sortOrders, store.SortOrder{
+				Name:      info.Name,
+				Value:     teamRank.SortOrders[i],
+				Precision: info.Precision,
+			})
+		}
+
 		rank := teamRank.Rank
 		team := store.EventTeam{
-			Key:          teamRank.TeamKey,
-			EventKey:     eventKey,
-			Rank:         &rank,
-			RankingScore: rankingScore,
+			Key:           teamRank.TeamKey,
+			EventKey:      eventKey,
+			Rank:          &rank,
+			RankingScore:  rankingScore,
+			DQs:           teamRank.DQ,
+			MatchesPlayed: teamRank.MatchesPlayed,
+			SortOrders:    sortOrders,
 		}
+
+		if teamRank.Record != nil {
+			team.Wins = &teamRank.Record.Wins
+			team.Losses = &teamRank.Record.Losses
+			team.Ties = &teamRank.Record.Ties
+		}
+
 		teams = append(teams, team)
 	}
 
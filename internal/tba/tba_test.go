@@ -722,7 +722,14 @@ func TestGetTeamRankings(t *testing.T) {
 							"sort_orders": [
 								3243,
 								5.25
-							]
+							],
+							"record": {
+								"wins": 7,
+								"losses": 1,
+								"ties": 0
+							},
+							"dq": 0,
+							"matches_played": 8
 						},
 						{
 							"rank": 2,
@@ -752,16 +759,29 @@ func TestGetTeamRankings(t *testing.T) {
 			},
 			teams: []store.EventTeam{
 				{
-					Key:          "frc2733",
-					EventKey:     "2018abca",
-					Rank:         newInt(1),
-					RankingScore: newFloat64(5.25),
+					Key:           "frc2733",
+					EventKey:      "2018abca",
+					Rank:          newInt(1),
+					RankingScore:  newFloat64(5.25),
+					Wins:          newInt(7),
+					Losses:        newInt(1),
+					Ties:          newInt(0),
+					DQs:           newInt(0),
+					MatchesPlayed: newInt(8),
+					SortOrders: store.SortOrders{
+						{Name: "Irrelevant Score", Value: 3243, Precision: 0},
+						{Name: "Ranking Score", Value: 5.25, Precision: 2},
+					},
 				},
 				{
 					Key:          "frc254",
 					EventKey:     "2018abca",
 					Rank:         newInt(2),
 					RankingScore: newFloat64(2.00),
+					SortOrders: store.SortOrders{
+						{Name: "Irrelevant Score", Value: 2453, Precision: 0},
+						{Name: "Ranking Score", Value: 2.00, Precision: 2},
+					},
 				},
 			},
 			expectErr: false,
@@ -832,18 +852,30 @@ func TestGetTeamRankings(t *testing.T) {
 					EventKey:     "2018abca",
 					Rank:         newInt(1),
 					RankingScore: nil,
+					SortOrders: store.SortOrders{
+						{Name: "Irrelevant Score", Value: 3243, Precision: 0},
+						{Name: "Random Score", Value: 5.25, Precision: 12},
+					},
 				},
 				{
 					Key:          "frc254",
 					EventKey:     "2018abca",
 					Rank:         newInt(2),
 					RankingScore: nil,
+					SortOrders: store.SortOrders{
+						{Name: "Irrelevant Score", Value: 23, Precision: 0},
+						{Name: "Random Score", Value: 2.0001, Precision: 12},
+					},
 				},
 				{
 					Key:          "frc24",
 					EventKey:     "2018abca",
 					Rank:         newInt(12),
 					RankingScore: nil,
+					SortOrders: store.SortOrders{
+						{Name: "Irrelevant Score", Value: 0, Precision: 0},
+						{Name: "Random Score", Value: 2.000001, Precision: 12},
+					},
 				},
 			},
 			expectErr: false,
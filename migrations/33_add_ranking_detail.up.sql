@@ -0,0 +1,7 @@
+ALTER TABLE teams
+    ADD COLUMN wins INTEGER,
+    ADD COLUMN losses INTEGER,
+    ADD COLUMN ties INTEGER,
+    ADD COLUMN dqs INTEGER,
+    ADD COLUMN matches_played INTEGER,
+    ADD COLUMN sort_orders JSONB;
@@ -0,0 +1,7 @@
+ALTER TABLE teams
+    DROP COLUMN wins,
+    DROP COLUMN losses,
+    DROP COLUMN ties,
+    DROP COLUMN dqs,
+    DROP COLUMN matches_played,
+    DROP COLUMN sort_orders;
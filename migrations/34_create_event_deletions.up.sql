@@ -0,0 +1,11 @@
+ALTER TABLE events
+    ADD COLUMN tba_missing_count INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN tba_delete_exempt BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS event_deletions (
+    id SERIAL PRIMARY KEY,
+    event_key TEXT NOT NULL REFERENCES events ON DELETE CASCADE,
+    marked_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    undone_at TIMESTAMPTZ,
+    undone_by INTEGER REFERENCES users ON DELETE SET NULL
+);
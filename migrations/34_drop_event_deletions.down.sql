@@ -0,0 +1,5 @@
+DROP TABLE event_deletions;
+
+ALTER TABLE events
+    DROP COLUMN tba_missing_count,
+    DROP COLUMN tba_delete_exempt;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS match_history (
+    id SERIAL PRIMARY KEY,
+    match_key TEXT NOT NULL,
+    event_key TEXT NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    source TEXT NOT NULL,
+    predicted_time TIMESTAMPTZ,
+    scheduled_time TIMESTAMPTZ,
+    actual_time TIMESTAMPTZ,
+    red_score INTEGER,
+    blue_score INTEGER,
+    red_alliance TEXT[] NOT NULL,
+    blue_alliance TEXT[] NOT NULL,
+    red_score_breakdown JSONB,
+    blue_score_breakdown JSONB,
+
+    FOREIGN KEY(event_key, match_key) REFERENCES matches(event_key, key) ON DELETE CASCADE
+);
+
+CREATE INDEX match_history_match_idx ON match_history (event_key, match_key, id);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS realm_roles (
+    id SERIAL PRIMARY KEY,
+    realm_id INTEGER NOT NULL REFERENCES realms ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    permissions TEXT[] NOT NULL DEFAULT '{}',
+    UNIQUE (realm_id, name)
+);
+
+ALTER TABLE users ADD COLUMN role_id INTEGER REFERENCES realm_roles ON DELETE SET NULL;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS realm_invites (
+    id SERIAL PRIMARY KEY,
+    realm_id INTEGER NOT NULL REFERENCES realms ON DELETE CASCADE,
+    code TEXT UNIQUE NOT NULL,
+    created_by INTEGER REFERENCES users ON DELETE SET NULL,
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX realm_invites_realm_id_idx ON realm_invites (realm_id);
+
+ALTER TABLE users ADD COLUMN pending BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN pending;
+
+DROP TABLE realm_invites;
@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN email TEXT;
+
+CREATE TABLE IF NOT EXISTS password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    token_hash TEXT UNIQUE NOT NULL,
+    created_by INTEGER REFERENCES users ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS realm_shares (
+    id SERIAL PRIMARY KEY,
+    realm_id INTEGER NOT NULL REFERENCES realms ON DELETE CASCADE,
+    partner_realm_id INTEGER NOT NULL REFERENCES realms ON DELETE CASCADE,
+    event_key TEXT REFERENCES events ON DELETE CASCADE,
+    year INTEGER,
+    created_by INTEGER REFERENCES users ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    accepted_at TIMESTAMPTZ,
+    CHECK (realm_id <> partner_realm_id),
+    CHECK (event_key IS NULL OR year IS NULL)
+);
+
+CREATE UNIQUE INDEX realm_shares_unique_idx ON realm_shares (
+    LEAST(realm_id, partner_realm_id),
+    GREATEST(realm_id, partner_realm_id),
+    COALESCE(event_key, ''),
+    COALESCE(year, 0)
+);
+
+CREATE INDEX realm_shares_partner_realm_id_idx ON realm_shares (partner_realm_id);
@@ -0,0 +1 @@
+DROP TABLE realm_shares;
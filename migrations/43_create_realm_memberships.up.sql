@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS realm_memberships (
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    realm_id INTEGER NOT NULL REFERENCES realms ON DELETE CASCADE,
+    roles JSONB NOT NULL DEFAULT '{}',
+    role_id INTEGER REFERENCES realm_roles ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, realm_id)
+);
+
+CREATE INDEX realm_memberships_realm_id_idx ON realm_memberships (realm_id);
+
+CREATE TABLE IF NOT EXISTS realm_transfers (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    from_realm_id INTEGER NOT NULL REFERENCES realms ON DELETE CASCADE,
+    to_realm_id INTEGER NOT NULL REFERENCES realms ON DELETE CASCADE,
+    requested_by INTEGER REFERENCES users ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    from_approved_by INTEGER REFERENCES users ON DELETE SET NULL,
+    from_approved_at TIMESTAMPTZ,
+    to_approved_by INTEGER REFERENCES users ON DELETE SET NULL,
+    to_approved_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    CHECK (from_realm_id <> to_realm_id)
+);
+
+CREATE UNIQUE INDEX realm_transfers_pending_user_id_idx ON realm_transfers (user_id) WHERE completed_at IS NULL;
+CREATE INDEX realm_transfers_from_realm_id_idx ON realm_transfers (from_realm_id);
+CREATE INDEX realm_transfers_to_realm_id_idx ON realm_transfers (to_realm_id);
+
+ALTER TABLE realms ADD COLUMN archived_at TIMESTAMPTZ;
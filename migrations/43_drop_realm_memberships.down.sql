@@ -0,0 +1,3 @@
+ALTER TABLE realms DROP COLUMN archived_at;
+DROP TABLE realm_transfers;
+DROP TABLE realm_memberships;
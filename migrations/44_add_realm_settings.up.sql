@@ -0,0 +1 @@
+ALTER TABLE realms ADD COLUMN settings JSONB NOT NULL DEFAULT '{}';
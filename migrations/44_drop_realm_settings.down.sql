@@ -0,0 +1 @@
+ALTER TABLE realms DROP COLUMN settings;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS realm_quotas (
+    realm_id INTEGER PRIMARY KEY REFERENCES realms ON DELETE CASCADE,
+    users INTEGER CHECK (users >= 0),
+    custom_events INTEGER CHECK (custom_events >= 0),
+    reports_per_day INTEGER CHECK (reports_per_day >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS realm_tokens (
+    id SERIAL PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    note TEXT NOT NULL DEFAULT '',
+    created_by INTEGER REFERENCES users ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    realm_id INTEGER REFERENCES realms ON DELETE SET NULL
+);
+
+-- Reports from before this migration don't count towards daily quotas.
+ALTER TABLE reports ADD COLUMN created_at TIMESTAMPTZ;
+ALTER TABLE reports ALTER COLUMN created_at SET DEFAULT now();
+
+CREATE INDEX reports_realm_id_created_at_idx ON reports (realm_id, created_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS reports_realm_id_created_at_idx;
+ALTER TABLE reports DROP COLUMN created_at;
+
+DROP TABLE IF EXISTS realm_tokens;
+DROP TABLE IF EXISTS realm_quotas;
@@ -0,0 +1,18 @@
+-- The audit log outlives what it refers to, so none of its ids are foreign
+-- keys.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor_id INTEGER,
+    api_key_id INTEGER,
+    realm_id INTEGER,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    before JSONB,
+    after JSONB
+);
+
+CREATE INDEX audit_log_realm_idx ON audit_log (realm_id, created_at);
+CREATE INDEX audit_log_actor_idx ON audit_log (actor_id, created_at);
+CREATE INDEX audit_log_target_idx ON audit_log (target_type, target_id);
@@ -0,0 +1,15 @@
+ALTER TABLE reports
+    ADD COLUMN deleted_at TIMESTAMPTZ,
+    ADD COLUMN deleted_by INTEGER REFERENCES users ON DELETE SET NULL;
+
+ALTER TABLE users
+    ADD COLUMN deleted_at TIMESTAMPTZ,
+    ADD COLUMN deleted_by INTEGER REFERENCES users ON DELETE SET NULL;
+
+ALTER TABLE events
+    ADD COLUMN deleted_at TIMESTAMPTZ,
+    ADD COLUMN deleted_by INTEGER REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX reports_deleted_at_idx ON reports (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX events_deleted_at_idx ON events (deleted_at) WHERE deleted_at IS NOT NULL;
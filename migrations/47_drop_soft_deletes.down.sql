@@ -0,0 +1,3 @@
+ALTER TABLE events DROP COLUMN deleted_at, DROP COLUMN deleted_by;
+ALTER TABLE users DROP COLUMN deleted_at, DROP COLUMN deleted_by;
+ALTER TABLE reports DROP COLUMN deleted_at, DROP COLUMN deleted_by;